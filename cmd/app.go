@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/azure/spin-aks-plugin/pkg/azure"
+	"github.com/azure/spin-aks-plugin/pkg/config"
+	"github.com/azure/spin-aks-plugin/pkg/k8s"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/spin"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// appClient returns the configured spin manifest and a client for the configured cluster. Commands that read the
+// application's objects on the cluster use it so they resolve the cluster and namespace the same way.
+func appClient(ctx context.Context) (spin.Manifest, kubernetes.Interface, error) {
+	c := config.Get()
+	if c.Cluster.Name == "" {
+		err := errors.New("cluster not configured")
+		return spin.Manifest{}, nil, usererror.New(err, "No cluster is configured. Run 'spin aks init' first.")
+	}
+	if c.SpinManifest == "" {
+		err := errors.New("spin manifest not configured")
+		return spin.Manifest{}, nil, usererror.New(err, "No spin manifest is configured. Run 'spin aks init' first.")
+	}
+
+	m, err := spin.Load(c.SpinManifest)
+	if err != nil {
+		return spin.Manifest{}, nil, fmt.Errorf("loading spin manifest: %w", err)
+	}
+
+	kube, err := k8s.Client(ctx, c.Cluster.Subscription, c.Cluster.ResourceGroup, c.Cluster.Name)
+	if err != nil {
+		return spin.Manifest{}, nil, fmt.Errorf("getting kubernetes client: %w", err)
+	}
+
+	return m, kube, nil
+}
+
+// appDynamicClient returns a dynamic client for the configured cluster
+func appDynamicClient(ctx context.Context) (dynamic.Interface, error) {
+	c := config.Get()
+	dyn, err := k8s.DynamicClient(ctx, c.Cluster.Subscription, c.Cluster.ResourceGroup, c.Cluster.Name)
+	if err != nil {
+		return nil, fmt.Errorf("getting dynamic kubernetes client: %w", err)
+	}
+
+	return dyn, nil
+}
+
+// deployer returns the object id of the signed-in principal recorded on releases
+func deployer(ctx context.Context) string {
+	id, err := azure.ProviderFromContext(ctx).SignedInObjectId(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Debug("getting signed-in object id: " + err.Error())
+		return "unknown"
+	}
+
+	return id
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/azure/spin-aks-plugin/pkg/attest"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/oci"
+	"github.com/azure/spin-aks-plugin/pkg/spin"
+	"github.com/azure/spin-aks-plugin/pkg/utils"
+)
+
+// attachAttestations attaches the SBOM and provenance of the application to the pushed image as referrers
+func attachAttestations(ctx context.Context, client *oci.Client, spinManifest string, m spin.Manifest, repo string, image oci.Descriptor) error {
+	lgr := logger.FromContext(ctx)
+	lgr.Debug("attaching attestations")
+
+	dir := filepath.Dir(spinManifest)
+	in := attest.Inputs{
+		ManifestPath: spinManifest,
+		Manifest:     m,
+		Toolchains:   spin.Toolchains(ctx, spinManifest, m),
+		ToolVersion:  rootCmd.Version,
+		Time:         time.Now(),
+	}
+
+	if v, err := spin.CLIVersion(ctx); err == nil {
+		in.SpinVersion = v
+	}
+
+	if commit, dirty, err := utils.GitCommit(ctx, dir); err == nil {
+		in.Git = attest.Git{Commit: commit, Dirty: dirty}
+		if remote, err := utils.GitRemote(ctx, dir); err == nil {
+			in.Git.Remote = remote
+		}
+	} else {
+		lgr.Debug("not recording git commit in provenance", "error", err)
+	}
+
+	sbom, err := attest.SBOM(in)
+	if err != nil {
+		return fmt.Errorf("generating sbom: %w", err)
+	}
+	if _, err := oci.Attach(ctx, client, repo, image, attest.MediaTypeSPDX, sbom); err != nil {
+		return fmt.Errorf("attaching sbom: %w", err)
+	}
+
+	provenance, err := attest.Provenance(in, attest.Subject{Name: client.Registry() + "/" + repo, Digest: image.Digest})
+	if err != nil {
+		return fmt.Errorf("generating provenance: %w", err)
+	}
+	if _, err := oci.Attach(ctx, client, repo, image, attest.MediaTypeInToto, provenance); err != nil {
+		return fmt.Errorf("attaching provenance: %w", err)
+	}
+
+	lgr.Debug("finished attaching attestations")
+	return nil
+}
@@ -1,24 +1,34 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"os/exec"
 
 	"github.com/azure/spin-aks-plugin/pkg/config"
+	"github.com/azure/spin-aks-plugin/pkg/generate"
 	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/spin"
 	"github.com/azure/spin-aks-plugin/pkg/usererror"
+	"github.com/azure/spin-aks-plugin/pkg/wasm"
 	"github.com/spf13/cobra"
 )
 
+var (
+	buildForce bool
+	buildJobs  int
+)
+
 func init() {
 	rootCmd.AddCommand(buildCmd)
+	buildCmd.Flags().BoolVar(&buildForce, "force", false, "rebuild every component even if its inputs and output are unchanged")
+	buildCmd.Flags().IntVarP(&buildJobs, "jobs", "j", 0, "number of components to build at once (defaults to the number of CPUs)")
 }
 
 var buildCmd = &cobra.Command{
 	Use:   "build",
 	Short: "Builds the Spin application",
-	Long:  "Builds the Spin application to WASM",
+	Long:  "Builds the Spin application to WASM. Components whose inputs and output wasm are unchanged since their last build are skipped. Components are built in parallel and their output is prefixed with the component id. The first failure cancels the remaining builds.",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := cmd.Context()
 		lgr := logger.FromContext(ctx)
@@ -29,22 +39,41 @@ var buildCmd = &cobra.Command{
 			return usererror.New(errors.New("spin manifest not set in config"), "Spin manifest not set in config. Try running `spin aks init`.")
 		}
 
-		c := exec.Command("spin", "build", "-f", spinManifest)
-		lgr.Debug("running command " + c.String())
+		if err := spin.CheckCompatibility(ctx, spinManifest, generate.ShimVersion); err != nil {
+			return fmt.Errorf("checking spin compatibility: %w", err)
+		}
 
-		if log, err := c.Output(); err != nil {
-			if len(log) != 0 {
-				lgr.Info(string(log))
-			}
+		m, err := spin.Load(spinManifest)
+		if err != nil {
+			return fmt.Errorf("loading spin manifest: %w", err)
+		}
 
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				return fmt.Errorf("running spin build: %s", exitErr.Stderr)
-			}
+		if buildJobs < 0 {
+			return usererror.New(errors.New("negative jobs"), "--jobs can't be negative. Use 0 to build as many components at once as there are CPUs.")
+		}
 
-			return fmt.Errorf("running spin build: %w", err)
+		if err := buildApp(ctx, spinManifest, m, spin.BuildOpts{Force: buildForce, Jobs: buildJobs}); err != nil {
+			return err
 		}
 
 		lgr.Debug("finished build command")
 		return nil
 	},
 }
+
+// buildApp builds the components of the spin manifest, prints the build summary, and validates the built wasm
+func buildApp(ctx context.Context, spinManifest string, m spin.Manifest, opts spin.BuildOpts) error {
+	results, err := spin.Build(ctx, spinManifest, m, opts)
+	if results != nil {
+		spin.PrintBuildSummary(ctx, results)
+	}
+	if err != nil {
+		return fmt.Errorf("building spin application: %w", err)
+	}
+
+	if err := wasm.Validate(ctx, spinManifest, m, generate.ShimVersion); err != nil {
+		return fmt.Errorf("validating wasm modules: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/azure/spin-aks-plugin/pkg/config"
+	"github.com/azure/spin-aks-plugin/pkg/generate"
+	"github.com/azure/spin-aks-plugin/pkg/k8s"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/oci"
+	"github.com/azure/spin-aks-plugin/pkg/release"
+	"github.com/azure/spin-aks-plugin/pkg/state"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	deployImage  string
+	deployFormat string
+)
+
+func init() {
+	rootCmd.AddCommand(deployCmd)
+	deployCmd.Flags().StringVar(&deployImage, "image", "", "image to deploy. Defaults to the image last pushed")
+	deployCmd.Flags().StringVar(&deployFormat, "format", "", "format of the image, image or spin-oci. Defaults to the format of the image last pushed or image with --image")
+}
+
+var deployCmd = &cobra.Command{
+	Use:   "deploy",
+	Short: "Deploys your application to the cluster",
+	Long:  "Applies your application's Kubernetes manifests to the cluster and records the deploy as a release that can be listed with 'spin aks history' and restored with 'spin aks rollback'.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		lgr := logger.FromContext(ctx)
+		lgr.Debug("starting deploy command")
+
+		m, kube, err := appClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		dyn, err := appDynamicClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		image, format := deployImage, oci.Format(deployFormat)
+		if image == "" {
+			st, err := state.Load(ctx)
+			if err != nil {
+				return fmt.Errorf("loading state: %w", err)
+			}
+
+			pushed, ok := st.Pushed[m.Name]
+			if !ok {
+				return usererror.New(errors.New("no pushed image in state"), "No image has been pushed for your application. Push one or pass --image.")
+			}
+
+			image = pushed.Image
+			if format == "" {
+				format = oci.Format(pushed.Format)
+			}
+		}
+		if format == "" {
+			format = oci.FormatImage
+		}
+		if err := checkFormat(format); err != nil {
+			return err
+		}
+
+		if format == oci.FormatSpinOCI {
+			if err := checkSpinOCIRuntime(ctx, kube, image); err != nil {
+				return err
+			}
+		}
+
+		if config.Get().RequireSignedImages {
+			image, err = verifyImage(ctx, image)
+			if err != nil {
+				return fmt.Errorf("verifying image signature: %w", err)
+			}
+		}
+
+		m, manifests, err := renderManifests(image, format)
+		if err != nil {
+			return err
+		}
+
+		if err := k8s.Apply(ctx, kube, dyn, manifests); err != nil {
+			return fmt.Errorf("applying manifests: %w", err)
+		}
+
+		r, err := release.Record(ctx, kube, m.Name, release.Release{
+			Image:       image,
+			SpinVersion: m.Version,
+			User:        deployer(ctx),
+			Manifests:   manifests,
+		})
+		if err != nil {
+			return fmt.Errorf("recording release: %w", err)
+		}
+
+		lgr.Info(fmt.Sprintf("Deployed %s as revision %d", m.Name, r.Revision))
+		lgr.Debug("finished deploy command")
+		return nil
+	},
+}
+
+// checkSpinOCIRuntime returns a user error if the cluster can't run Spin OCI artifacts. containerd-shim-spin on the
+// AKS Wasm node pool is too old to run them so they need the RuntimeClass of a newer shim installed separately.
+func checkSpinOCIRuntime(ctx context.Context, kube kubernetes.Interface, image string) error {
+	_, err := kube.NodeV1().RuntimeClasses().Get(ctx, generate.SpinOCIRuntimeClassName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		err := fmt.Errorf("RuntimeClass %s not found", generate.SpinOCIRuntimeClassName)
+		return usererror.New(err, fmt.Sprintf("%s is a Spin OCI artifact but the cluster has no RuntimeClass %s to run it. Spin OCI artifacts need containerd-shim-spin %s or later and the AKS Wasm node pool runs %s. Install a newer shim with a RuntimeClass named %s, for example with spin-operator, or push with `spin aks push --format image`.", image, generate.SpinOCIRuntimeClassName, generate.SpinOCIShimVersion, generate.ShimVersion, generate.SpinOCIRuntimeClassName))
+	}
+	if err != nil {
+		return fmt.Errorf("getting RuntimeClass %s: %w", generate.SpinOCIRuntimeClassName, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/azure/spin-aks-plugin/pkg/config"
+	"github.com/azure/spin-aks-plugin/pkg/doctor"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+	"github.com/spf13/cobra"
+)
+
+var doctorFix bool
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().BoolVar(&doctorFix, "fix", false, "apply safe fixes like creating the RuntimeClass, enabling the KeyVault CSI add-on, and granting AcrPull and KeyVault access")
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Checks everything your application needs to run on AKS",
+	Long:  "Checks the spin CLI, spin manifest, cluster, container registry, and KeyVault your application needs and prints a hint for each problem found. Safe fixes are applied with --fix.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		lgr := logger.FromContext(ctx)
+		lgr.Debug("starting doctor command")
+
+		c := config.Get()
+		results := doctor.Run(ctx, doctor.Options{
+			Cluster:           c.Cluster.ResourceId,
+			ContainerRegistry: c.ContainerRegistry.ResourceId,
+			KeyVault:          c.KeyVault.ResourceId,
+			WorkloadIdentity:  c.WorkloadIdentity,
+			SpinManifest:      c.SpinManifest,
+			Fix:               doctorFix,
+		})
+
+		if err := doctor.Print(cmd.OutOrStdout(), results); err != nil {
+			return fmt.Errorf("printing results: %w", err)
+		}
+
+		if failed := doctor.Failed(results); failed > 0 {
+			err := fmt.Errorf("%d doctor checks failed", failed)
+			return usererror.New(err, fmt.Sprintf("%d checks failed. Follow the hints above to fix them.", failed))
+		}
+
+		lgr.Debug("finished doctor command")
+		return nil
+	},
+}
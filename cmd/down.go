@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/azure/spin-aks-plugin/pkg/config"
+	"github.com/azure/spin-aks-plugin/pkg/down"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/prompt"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+	"github.com/spf13/cobra"
+)
+
+var (
+	downInfra bool
+	downYes   bool
+)
+
+func init() {
+	rootCmd.AddCommand(downCmd)
+	downCmd.Flags().BoolVar(&downInfra, "infra", false, "also delete the cluster, container registry, KeyVault, identity, and resource groups the plugin created")
+	downCmd.Flags().BoolVarP(&downYes, "yes", "y", false, "delete without asking for confirmation")
+}
+
+var downCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Removes your application from the cluster",
+	Long:  "Removes your application's Deployment, Service, SecretProviderClass, ServiceAccount, releases, and namespace from the cluster. With --infra, the Azure resources the plugin created are deleted too. Resources that existed before the plugin used them are never deleted.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		lgr := logger.FromContext(ctx)
+		lgr.Debug("starting down command")
+
+		m, kube, err := appClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		dyn, err := appDynamicClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		c := config.Get()
+		items, err := down.Plan(ctx, kube, dyn, down.Options{
+			App:               m.Name,
+			Infra:             downInfra,
+			Cluster:           c.Cluster.ResourceId,
+			ContainerRegistry: c.ContainerRegistry.ResourceId,
+			KeyVault:          c.KeyVault.ResourceId,
+			WorkloadIdentity:  c.WorkloadIdentity.ResourceId,
+		})
+		if err != nil {
+			return fmt.Errorf("planning down: %w", err)
+		}
+
+		if len(items) == 0 {
+			lgr.Info("Nothing to remove")
+			return nil
+		}
+
+		if _, dryRun := dryrun.FromContext(ctx); !dryRun && !downYes {
+			var list strings.Builder
+			for _, item := range items {
+				list.WriteString("\n  - " + item.String())
+			}
+			lgr.Info("The following will be deleted:" + list.String())
+
+			ok, err := prompt.Confirm(ctx, fmt.Sprintf("Delete these %d items", len(items)))
+			if err != nil {
+				return fmt.Errorf("confirming: %w", err)
+			}
+			if !ok {
+				return usererror.New(errors.New("down not confirmed"), "Nothing was deleted.")
+			}
+		}
+
+		if err := down.Run(ctx, items); err != nil {
+			return fmt.Errorf("running down: %w", err)
+		}
+
+		lgr.Debug("finished down command")
+		return nil
+	},
+}
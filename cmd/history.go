@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/release"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Lists the releases of your application",
+	Long:  fmt.Sprintf("Lists the last %d releases recorded on the cluster by 'spin aks deploy' and 'spin aks rollback'.", release.MaxHistory),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		lgr := logger.FromContext(ctx)
+		lgr.Debug("starting history command")
+
+		m, kube, err := appClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		releases, err := release.History(ctx, kube, m.Name)
+		if err != nil {
+			return fmt.Errorf("getting release history: %w", err)
+		}
+
+		if len(releases) == 0 {
+			lgr.Info(fmt.Sprintf("No releases of %s found. Run 'spin aks deploy' first.", m.Name))
+			return nil
+		}
+
+		if err := release.Print(cmd.OutOrStdout(), releases); err != nil {
+			return fmt.Errorf("printing releases: %w", err)
+		}
+
+		lgr.Debug("finished history command")
+		return nil
+	},
+}
@@ -8,8 +8,11 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var workloadIdentity bool
+
 func init() {
 	rootCmd.AddCommand(initCmd)
+	initCmd.Flags().BoolVar(&workloadIdentity, "workload-identity", false, "grant KeyVault access to a managed identity for the application instead of the cluster's identity")
 }
 
 var initCmd = &cobra.Command{
@@ -21,11 +24,15 @@ var initCmd = &cobra.Command{
 		lgr := logger.FromContext(ctx)
 		lgr.Debug("starting init command")
 
+		if workloadIdentity {
+			config.EnableWorkloadIdentity()
+		}
+
 		if err := config.EnsureValid(ctx); err != nil {
 			return fmt.Errorf("ensuring config: %w", err)
 		}
 
-		if err := config.Write(); err != nil {
+		if err := config.Write(ctx); err != nil {
 			return fmt.Errorf("writing config: %w", err)
 		}
 
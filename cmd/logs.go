@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/logs"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var (
+	logsFollow    bool
+	logsSince     time.Duration
+	logsComponent string
+)
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "keep streaming logs, including from pods created during rollouts")
+	logsCmd.Flags().DurationVar(&logsSince, "since", 0, "only show logs newer than a duration like 5m or 1h")
+	logsCmd.Flags().StringVar(&logsComponent, "component", "", "only show logs of the spin component with this id")
+}
+
+var logsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Streams logs from every pod of your application",
+	Long:  "Streams logs from every pod of your application's Deployment with each line prefixed by the pod name. With --follow, pods created during rollouts are picked up as they start.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		lgr := logger.FromContext(ctx)
+		lgr.Debug("starting logs command")
+
+		m, kube, err := appClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		// the check is all the filtering needed since a component's logs can only be told apart when it's the only one
+		if logsComponent != "" {
+			if err := logs.CheckComponent(m.Components, logsComponent); err != nil {
+				return err
+			}
+		}
+
+		out := cmd.OutOrStdout()
+		color := false
+		if f, ok := out.(*os.File); ok {
+			color = term.IsTerminal(int(f.Fd()))
+		}
+
+		if err := logs.Stream(ctx, kube, m.Name, out, logs.Options{
+			Follow: logsFollow,
+			Since:  logsSince,
+			Color:  color,
+		}); err != nil {
+			return fmt.Errorf("streaming logs: %w", err)
+		}
+
+		lgr.Debug("finished logs command")
+		return nil
+	},
+}
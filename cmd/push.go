@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/azure/spin-aks-plugin/pkg/azure"
+	"github.com/azure/spin-aks-plugin/pkg/config"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	"github.com/azure/spin-aks-plugin/pkg/generate"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/oci"
+	"github.com/azure/spin-aks-plugin/pkg/spin"
+	"github.com/azure/spin-aks-plugin/pkg/state"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+	"github.com/azure/spin-aks-plugin/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pushJobs   int
+	pushFormat string
+)
+
+func init() {
+	rootCmd.AddCommand(pushCmd)
+	pushCmd.Flags().IntVarP(&pushJobs, "jobs", "j", 0, "number of components to build at once (defaults to the number of CPUs)")
+	pushCmd.Flags().StringVar(&pushFormat, "format", string(oci.FormatImage), "format to push, image for a container image or spin-oci for the Spin OCI artifact `spin registry push` pushes")
+}
+
+var pushCmd = &cobra.Command{
+	Use:   "push",
+	Short: "Builds and pushes your application's image",
+	Long:  "Builds your application and pushes its image to the configured container registry. The image is tagged with a hash of spin.toml and every component's sources so pushing unchanged sources skips both the build and the upload. The image is also tagged with the git commit or the manifest's version.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		lgr := logger.FromContext(ctx)
+		lgr.Debug("starting push command")
+
+		spinManifest := config.Get().SpinManifest
+		if spinManifest == "" {
+			return usererror.New(errors.New("spin manifest not set in config"), "Spin manifest not set in config. Try running `spin aks init`.")
+		}
+
+		registry := config.Get().ContainerRegistry
+		if registry.Name == "" {
+			return usererror.New(errors.New("container registry not set in config"), "Container registry not set in config. Try running `spin aks init`.")
+		}
+
+		if pushJobs < 0 {
+			return usererror.New(errors.New("negative jobs"), "--jobs can't be negative. Use 0 to build as many components at once as there are CPUs.")
+		}
+
+		format := oci.Format(pushFormat)
+		if err := checkFormat(format); err != nil {
+			return err
+		}
+
+		signer, err := imageSigner(ctx)
+		if err != nil {
+			return fmt.Errorf("getting image signer: %w", err)
+		}
+		if signer == nil && config.Get().RequireSignedImages {
+			return usererror.New(errors.New("signing key not set in config"), "require_signed_images is set but no signing key is, so deploy would refuse the pushed image. Set signing.key or signing.keyvault_key in config.")
+		}
+
+		if err := spin.CheckCompatibility(ctx, spinManifest, generate.ShimVersion); err != nil {
+			return fmt.Errorf("checking spin compatibility: %w", err)
+		}
+
+		m, err := spin.Load(spinManifest)
+		if err != nil {
+			return fmt.Errorf("loading spin manifest: %w", err)
+		}
+		if m.Name == "" {
+			return usererror.New(errors.New("name not set in spin manifest"), "Name not set in spin manifest. Add a name to your spin manifest and try again.")
+		}
+
+		hash, err := spin.SourceHash(ctx, spinManifest, m)
+		if err != nil {
+			return fmt.Errorf("hashing sources: %w", err)
+		}
+
+		loginServer := azure.LoginServer(registry.Name)
+		username, password, err := azure.ProviderFromContext(ctx).RegistryCredential(ctx, loginServer)
+		if err != nil {
+			return fmt.Errorf("getting container registry credential: %w", err)
+		}
+
+		client := oci.NewClient(loginServer, oci.Credential{Username: username, Password: password})
+		ref := oci.Reference{Registry: loginServer, Repository: strings.ToLower(m.Name), Tag: formatTag(sourceTag(hash), format)}
+		alias := formatTag(aliasTag(ctx, spinManifest, m), format)
+		desc, err := pushApp(ctx, client, spinManifest, m, format, ref, alias)
+		if err != nil {
+			return err
+		}
+
+		if signer != nil {
+			if err := oci.Sign(ctx, client, ref.Repository, desc.Digest, signer); err != nil {
+				return fmt.Errorf("signing image: %w", err)
+			}
+			lgr.Info(fmt.Sprintf("Signed %s@%s", ref.Registry+"/"+ref.Repository, desc.Digest))
+		}
+
+		if _, ok := dryrun.FromContext(ctx); !ok {
+			err := state.Update(ctx, func(s *state.State) error {
+				s.Pushed[m.Name] = state.PushedImage{Image: ref.String(), Format: string(format)}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("setting pushed image: %w", err)
+			}
+		}
+
+		lgr.Debug("finished push command")
+		return nil
+	},
+}
+
+// pushApp builds and pushes the application in the format as ref unless the registry already has it and points the
+// alias tag at it. It returns the descriptor of the pushed manifest.
+func pushApp(ctx context.Context, client *oci.Client, spinManifest string, m spin.Manifest, format oci.Format, ref oci.Reference, alias string) (oci.Descriptor, error) {
+	lgr := logger.FromContext(ctx)
+
+	existing, err := client.Resolve(ctx, ref.Repository, ref.Tag)
+	switch {
+	case err == nil:
+		lgr.Info(fmt.Sprintf("%s already exists, skipping build and push", ref))
+		if alias != "" {
+			if err := oci.TagManifest(ctx, client, ref.Repository, ref.Tag, alias); err != nil {
+				return oci.Descriptor{}, fmt.Errorf("tagging image: %w", err)
+			}
+		}
+		return existing, nil
+	case !errors.Is(err, oci.NotFoundErr):
+		return oci.Descriptor{}, fmt.Errorf("checking for existing image: %w", err)
+	}
+
+	if err := buildApp(ctx, spinManifest, m, spin.BuildOpts{Jobs: pushJobs}); err != nil {
+		return oci.Descriptor{}, err
+	}
+
+	img, err := format.Build(spinManifest, m)
+	if err != nil {
+		return oci.Descriptor{}, fmt.Errorf("building %s: %w", format, err)
+	}
+
+	tags := []string{ref.Tag}
+	if alias != "" {
+		tags = append(tags, alias)
+	}
+
+	desc, err := oci.Push(ctx, client, ref.Repository, img, tags...)
+	if err != nil {
+		return oci.Descriptor{}, fmt.Errorf("pushing image: %w", err)
+	}
+
+	lgr.Info(fmt.Sprintf("Pushed %s (%s)", ref, desc.Digest))
+
+	if err := attachAttestations(ctx, client, spinManifest, m, ref.Repository, desc); err != nil {
+		return oci.Descriptor{}, fmt.Errorf("attaching attestations: %w", err)
+	}
+
+	return desc, nil
+}
+
+// checkFormat returns a user error if the format isn't valid
+func checkFormat(format oci.Format) error {
+	for _, f := range oci.Formats {
+		if f == format {
+			return nil
+		}
+	}
+
+	return usererror.New(fmt.Errorf("invalid format %q", format), fmt.Sprintf("Format %q isn't valid. Use image or spin-oci.", format))
+}
+
+// formatTag suffixes tags of Spin OCI artifacts with -spin so they don't replace the container image of the same
+// sources
+func formatTag(tag string, format oci.Format) string {
+	if tag == "" || format != oci.FormatSpinOCI {
+		return tag
+	}
+
+	return tag + "-spin"
+}
+
+// sourceTag returns the content-addressed tag of the sources' hash
+func sourceTag(hash string) string {
+	return "src-" + hash[:16]
+}
+
+// aliasTag returns a human-friendly tag of the application. It's the short git commit, suffixed with -dirty if there
+// are uncommitted changes, or the manifest's version outside of git repositories.
+func aliasTag(ctx context.Context, spinManifest string, m spin.Manifest) string {
+	commit, dirty, err := utils.GitCommit(ctx, filepath.Dir(spinManifest))
+	if err != nil {
+		logger.FromContext(ctx).Debug("not using git commit as tag", "error", err)
+		return oci.Tag(m.Version)
+	}
+
+	tag := commit[:7]
+	if dirty {
+		tag += "-dirty"
+	}
+
+	return tag
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/azure/spin-aks-plugin/pkg/config"
+	"github.com/azure/spin-aks-plugin/pkg/k8s"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/release"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [revision]",
+	Short: "Rolls your application back to a previous release",
+	Long:  "Reapplies the manifests of a previous release, the one before the current release by default, and records the rollback as a new release. See 'spin aks history' for revisions.",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		lgr := logger.FromContext(ctx)
+		lgr.Debug("starting rollback command")
+
+		revision := 0
+		if len(args) == 1 {
+			var err error
+			revision, err = strconv.Atoi(args[0])
+			if err != nil || revision < 1 {
+				err := fmt.Errorf("invalid revision %q", args[0])
+				return usererror.New(err, fmt.Sprintf("Revision %q isn't a release number. See 'spin aks history' for revisions.", args[0]))
+			}
+		}
+
+		m, kube, err := appClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		dyn, err := appDynamicClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		target, err := release.Get(ctx, kube, m.Name, revision)
+		if errors.Is(err, release.NotFoundErr) {
+			return usererror.New(err, "That release doesn't exist. See 'spin aks history' for revisions.")
+		}
+		if err != nil {
+			return fmt.Errorf("getting release: %w", err)
+		}
+
+		// the release may predate requiring signed images or the signature may have been removed since
+		if config.Get().RequireSignedImages {
+			if _, err := verifyImage(ctx, target.Image); err != nil {
+				return fmt.Errorf("verifying image signature of revision %d: %w", target.Revision, err)
+			}
+		}
+
+		if err := k8s.Apply(ctx, kube, dyn, target.Manifests); err != nil {
+			return fmt.Errorf("applying manifests of revision %d: %w", target.Revision, err)
+		}
+
+		r, err := release.Record(ctx, kube, m.Name, release.Release{
+			Image:       target.Image,
+			ConfigHash:  target.ConfigHash,
+			SpinVersion: target.SpinVersion,
+			User:        deployer(ctx),
+			RollbackOf:  target.Revision,
+			Manifests:   target.Manifests,
+		})
+		if err != nil {
+			return fmt.Errorf("recording release: %w", err)
+		}
+
+		lgr.Info(fmt.Sprintf("Rolled %s back to revision %d as revision %d", m.Name, target.Revision, r.Revision))
+		lgr.Debug("finished rollback command")
+		return nil
+	},
+}
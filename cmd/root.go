@@ -6,8 +6,11 @@ import (
 	"os"
 	"strings"
 
+	"github.com/azure/spin-aks-plugin/pkg/azure"
 	"github.com/azure/spin-aks-plugin/pkg/config"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
 	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/prompt"
 	"github.com/azure/spin-aks-plugin/pkg/usererror"
 	"github.com/spf13/cobra"
 )
@@ -47,10 +50,16 @@ func Execute(c Config) {
 
 	// set global flags
 	var verbose bool
+	var dryRun bool
+	var answers string
+	var cloud string
 	var spinAksConfig string
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "print additional information typically useful for debugging")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "print the changes that would be made without making them")
 	rootCmd.PersistentFlags().StringVarP(&spinAksConfig, "config", "c", "", "path to the spin aks config toml file")
-	rootCmd.PersistentPreRunE = func(_ *cobra.Command, _ []string) error {
+	rootCmd.PersistentFlags().StringVar(&cloud, "cloud", "", "Azure cloud to use: AzurePublic, AzureGovernment, AzureChina, or the path to a custom cloud endpoint metadata file")
+	rootCmd.PersistentFlags().StringVar(&answers, "answers", "", "path to a yaml or json file answering prompts so commands can run without a terminal")
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, _ []string) error {
 		logger.SetVerbose(verbose)
 		if err := config.Load(config.Opts{
 			Path: spinAksConfig,
@@ -58,6 +67,49 @@ func Execute(c Config) {
 			return fmt.Errorf("loading config: %w", err)
 		}
 
+		if cloud != "" {
+			config.SetCloud(cloud)
+		}
+		if name := config.Get().Cloud; name != "" {
+			c, err := azure.ParseCloud(name)
+			if err != nil {
+				return usererror.New(fmt.Errorf("parsing cloud: %w", err), err.Error())
+			}
+
+			azure.SetCloud(c)
+		}
+		if tenant := config.Get().TenantID; tenant != "" {
+			azure.SetTenant(tenant)
+		}
+
+		if dryRun {
+			cmd.SetContext(dryrun.WithContext(cmd.Context(), &dryrun.Plan{}))
+		}
+
+		if answers != "" {
+			p, err := prompt.LoadAnswers(answers)
+			if err != nil {
+				return fmt.Errorf("loading answers: %w", err)
+			}
+
+			cmd.SetContext(prompt.WithPrompter(cmd.Context(), p))
+		}
+
+		return nil
+	}
+	rootCmd.PersistentPostRunE = func(cmd *cobra.Command, _ []string) error {
+		if p, ok := prompt.FromContext(cmd.Context()).(*prompt.Scripted); ok {
+			for _, a := range p.Unused() {
+				logger.FromContext(cmd.Context()).Warn(fmt.Sprintf("answer for prompt %q was never used", a.Label))
+			}
+		}
+
+		if plan, ok := dryrun.FromContext(cmd.Context()); ok {
+			if err := plan.Print(cmd.OutOrStdout()); err != nil {
+				return fmt.Errorf("printing dry run plan: %w", err)
+			}
+		}
+
 		return nil
 	}
 
@@ -1,14 +1,19 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 
+	"github.com/azure/spin-aks-plugin/pkg/azure"
 	"github.com/azure/spin-aks-plugin/pkg/config"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
 	"github.com/azure/spin-aks-plugin/pkg/generate"
 	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/oci"
 	"github.com/azure/spin-aks-plugin/pkg/spin"
 	"github.com/azure/spin-aks-plugin/pkg/usererror"
 	"github.com/spf13/cobra"
@@ -36,6 +41,27 @@ func addOverrideFlag(cmd *cobra.Command) {
 	f.BoolVar(&override, "override", false, "override existing files")
 }
 
+// planWrite records writing the file on the dry run plan and prints the difference to the existing file.
+// It returns false if the context isn't a dry run in which case the caller should write the file.
+func planWrite(ctx context.Context, dest string, content []byte) (bool, error) {
+	plan, ok := dryrun.FromContext(ctx)
+	if !ok {
+		return false, nil
+	}
+
+	existing, err := os.ReadFile(dest)
+	if err != nil && !os.IsNotExist(err) {
+		return true, fmt.Errorf("reading existing file: %w", err)
+	}
+
+	plan.Record(dryrun.Change{Action: dryrun.Write, Kind: "File", Target: dest})
+	if diff := dryrun.Diff(dest, existing, content); diff != "" {
+		logger.FromContext(ctx).Info(diff)
+	}
+
+	return true, nil
+}
+
 var scaffoldCmd = &cobra.Command{
 	Use:   "scaffold",
 	Short: "Generates required files",
@@ -47,7 +73,8 @@ var dockerfileCmd = &cobra.Command{
 	Short: "Generates Dockerfile",
 	Long:  "Creates Dockerfile required to run your application on AKS",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		lgr := logger.FromContext(cmd.Context())
+		ctx := cmd.Context()
+		lgr := logger.FromContext(ctx)
 		lgr.Debug("starting dockerfile command")
 
 		spinManifest := config.Get().SpinManifest
@@ -109,6 +136,10 @@ var dockerfileCmd = &cobra.Command{
 			return fmt.Errorf("generating Dockerfile: %w", err)
 		}
 
+		if ok, err := planWrite(ctx, dockerDest, dockerfile); err != nil || ok {
+			return err
+		}
+
 		if _, err := os.Stat(dockerDest); err == nil && !override {
 			return usererror.New(
 				errors.New("file exists"),
@@ -143,27 +174,17 @@ var k8sCmd = &cobra.Command{
 	Short: "Generates Kubernetes manifests",
 	Long:  "Creates Kubernetes manifests required to run your application on AKS",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		lgr := logger.FromContext(cmd.Context())
+		ctx := cmd.Context()
+		lgr := logger.FromContext(ctx)
 		lgr.Info("starting k8s command")
 
-		spinManifest := config.Get().SpinManifest
-		if spinManifest == "" {
-			return usererror.New(errors.New("spin manifest not set in config"), "Spin manifest not set in config. Try running `spin aks init`.")
-		}
-
-		manifest, err := spin.Load(spinManifest)
+		_, manifests, err := renderManifests("placeholderimagefornow:latest", oci.FormatImage)
 		if err != nil {
-			return fmt.Errorf("loading spin manifest: %w", err)
-		}
-
-		name := manifest.Name
-		if name == "" {
-			return usererror.New(errors.New("name not set in spin manifest"), "Name not set in spin manifest. Add a name to your spin manifest and try again.")
+			return err
 		}
 
-		manifests, err := generate.Manifests(name, "placeholderimagefornow:latest")
-		if err != nil {
-			return fmt.Errorf("generating manifests: %w", err)
+		if ok, err := planWrite(ctx, k8sDest, manifests); err != nil || ok {
+			return err
 		}
 
 		if _, err := os.Stat(k8sDest); err == nil && !override {
@@ -192,3 +213,53 @@ var k8sCmd = &cobra.Command{
 		return nil
 	},
 }
+
+// renderManifests generates the Kubernetes manifests of the configured application running the image in the format
+func renderManifests(image string, format oci.Format) (spin.Manifest, []byte, error) {
+	spinManifest := config.Get().SpinManifest
+	if spinManifest == "" {
+		return spin.Manifest{}, nil, usererror.New(errors.New("spin manifest not set in config"), "Spin manifest not set in config. Try running `spin aks init`.")
+	}
+
+	manifest, err := spin.Load(spinManifest)
+	if err != nil {
+		return spin.Manifest{}, nil, fmt.Errorf("loading spin manifest: %w", err)
+	}
+
+	name := manifest.Name
+	if name == "" {
+		return spin.Manifest{}, nil, usererror.New(errors.New("name not set in spin manifest"), "Name not set in spin manifest. Add a name to your spin manifest and try again.")
+	}
+
+	var secrets []string
+	for variable, v := range manifest.Variables {
+		if v.Secret {
+			secrets = append(secrets, variable)
+		}
+	}
+	sort.Strings(secrets)
+
+	c := config.Get()
+	opts := generate.ManifestOpts{
+		Name:         name,
+		Image:        image,
+		ImageFormat:  format,
+		Secrets:      secrets,
+		KeyVaultName: c.KeyVault.Name,
+		TenantID:     c.TenantID,
+		CloudName:    azure.CurrentCloud().KeyVaultCSICloudName,
+	}
+	if c.WorkloadIdentity.Enabled {
+		opts.WorkloadIdentityClientID = c.WorkloadIdentity.ClientID
+	}
+	if len(secrets) > 0 && opts.KeyVaultName == "" {
+		return spin.Manifest{}, nil, usererror.New(errors.New("keyvault not set in config"), "Your spin manifest has secret variables but no KeyVault is set in config. Try running `spin aks init`.")
+	}
+
+	manifests, err := generate.Manifests(opts)
+	if err != nil {
+		return spin.Manifest{}, nil, fmt.Errorf("generating manifests: %w", err)
+	}
+
+	return manifest, manifests, nil
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/azure/spin-aks-plugin/pkg/azure"
+	"github.com/azure/spin-aks-plugin/pkg/config"
+	"github.com/azure/spin-aks-plugin/pkg/oci"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+)
+
+// keyVaultSigner signs with a key in the configured KeyVault
+type keyVaultSigner struct {
+	kv  *azure.Akv
+	key string
+}
+
+func (s keyVaultSigner) Sign(ctx context.Context, payload []byte) ([]byte, error) {
+	digest := sha256.Sum256(payload)
+	return azure.ProviderFromContext(ctx).SignWithKey(ctx, s.kv, s.key, digest[:])
+}
+
+// imageSigner returns the signer of the signing key in the config. It's nil if no signing key is configured.
+func imageSigner(ctx context.Context) (oci.Signer, error) {
+	signing := config.Get().Signing
+	switch {
+	case signing.Key != "" && signing.KeyVaultKey != "":
+		return nil, usererror.New(errors.New("multiple signing keys"), "Both signing.key and signing.keyvault_key are set in config. Set only one of them.")
+	case signing.Key != "":
+		key, err := oci.LoadPrivateKey(signing.Key)
+		if err != nil {
+			return nil, fmt.Errorf("loading signing key: %w", err)
+		}
+
+		return oci.KeySigner{Key: key}, nil
+	case signing.KeyVaultKey != "":
+		kv, err := signingKeyVault(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return keyVaultSigner{kv: kv, key: signing.KeyVaultKey}, nil
+	}
+
+	return nil, nil
+}
+
+// verificationKey returns the public key of the signing key in the config
+func verificationKey(ctx context.Context) (*ecdsa.PublicKey, error) {
+	signing := config.Get().Signing
+	switch {
+	case signing.PublicKey != "":
+		return oci.LoadPublicKey(signing.PublicKey)
+	case signing.Key != "":
+		key, err := oci.LoadPrivateKey(signing.Key)
+		if err != nil {
+			return nil, fmt.Errorf("loading signing key: %w", err)
+		}
+
+		return &key.PublicKey, nil
+	case signing.KeyVaultKey != "":
+		kv, err := signingKeyVault(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return azure.ProviderFromContext(ctx).GetPublicKey(ctx, kv, signing.KeyVaultKey)
+	}
+
+	return nil, usererror.New(errors.New("signing key not set in config"), "require_signed_images is set but no signing key is. Set signing.key, signing.public_key, or signing.keyvault_key in config.")
+}
+
+func signingKeyVault(ctx context.Context) (*azure.Akv, error) {
+	kv := config.Get().KeyVault
+	if kv.Name == "" {
+		return nil, usererror.New(errors.New("keyvault not set in config"), "signing.keyvault_key is set but the KeyVault isn't. Try running `spin aks init`.")
+	}
+
+	akv, err := azure.ProviderFromContext(ctx).GetKeyVault(ctx, kv.Subscription, kv.ResourceGroup, kv.Name)
+	if err != nil {
+		return nil, fmt.Errorf("getting keyvault: %w", err)
+	}
+
+	return akv, nil
+}
+
+// verifyImage checks the image is signed by the signing key in the config and returns it pinned to the digest that
+// was verified, so the cluster runs exactly the signed manifest even if the tag moves afterwards
+func verifyImage(ctx context.Context, image string) (string, error) {
+	pub, err := verificationKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	ref, err := oci.ParseReference(image)
+	if err != nil {
+		return "", usererror.New(err, fmt.Sprintf("Couldn't parse image %q to verify its signature. Use a reference like myregistry.azurecr.io/app:v1.", image))
+	}
+
+	client, err := registryClient(ctx, ref.Registry)
+	if err != nil {
+		return "", err
+	}
+
+	desc, err := client.Resolve(ctx, ref.Repository, ref.Ref())
+	if errors.Is(err, oci.NotFoundErr) {
+		return "", usererror.New(err, fmt.Sprintf("%s doesn't exist in the registry.", image))
+	}
+	if err != nil {
+		return "", fmt.Errorf("resolving image: %w", err)
+	}
+	if ref.Digest != "" && ref.Digest != desc.Digest {
+		err := fmt.Errorf("image digest %s doesn't match %s", desc.Digest, ref.Digest)
+		return "", usererror.New(err, fmt.Sprintf("The registry returned a manifest with digest %s for %s.", desc.Digest, image))
+	}
+
+	if err := oci.Verify(ctx, client, ref.Repository, desc.Digest, pub); err != nil {
+		return "", err
+	}
+
+	ref.Digest = desc.Digest
+	return ref.String(), nil
+}
+
+// registryClient returns a client of the registry. The configured container registry is called with the signed-in
+// user's credential and other registries anonymously.
+func registryClient(ctx context.Context, registry string) (*oci.Client, error) {
+	name := config.Get().ContainerRegistry.Name
+	if name == "" || registry != azure.LoginServer(name) {
+		return oci.NewClient(registry, oci.Credential{}), nil
+	}
+
+	username, password, err := azure.ProviderFromContext(ctx).RegistryCredential(ctx, registry)
+	if err != nil {
+		return nil, fmt.Errorf("getting container registry credential: %w", err)
+	}
+
+	return oci.NewClient(registry, oci.Credential{Username: username, Password: password}), nil
+}
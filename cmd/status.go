@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/state"
+	"github.com/azure/spin-aks-plugin/pkg/status"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statusWatch  bool
+	statusOutput string
+)
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().BoolVarP(&statusWatch, "watch", "w", false, "keep printing the status whenever it changes")
+	statusCmd.Flags().StringVarP(&statusOutput, "output", "o", "text", "output format: text or json")
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Shows the live state of your application on the cluster",
+	Long:  "Shows the Deployment's ready replicas, pod phases and restarts, recent warning events, the application's endpoints, and whether the running image is the one last pushed.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		lgr := logger.FromContext(ctx)
+		lgr.Debug("starting status command")
+
+		if statusOutput != "text" && statusOutput != "json" {
+			err := fmt.Errorf("invalid output format %q", statusOutput)
+			return usererror.New(err, fmt.Sprintf("Output format %q isn't supported. Use text or json.", statusOutput))
+		}
+
+		m, kube, err := appClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		st, err := state.Load(ctx)
+		if err != nil {
+			return fmt.Errorf("loading state: %w", err)
+		}
+		pushed := st.Pushed[m.Name].Image
+
+		w := cmd.OutOrStdout()
+		print := func(s *status.Status) error {
+			s.PushedImage = pushed
+			return printStatus(w, s)
+		}
+
+		if statusWatch {
+			if err := status.Watch(ctx, kube, m.Name, print); err != nil {
+				return fmt.Errorf("watching status: %w", err)
+			}
+
+			lgr.Debug("finished status command")
+			return nil
+		}
+
+		s, err := status.Get(ctx, kube, m.Name)
+		if err != nil {
+			return fmt.Errorf("getting status: %w", err)
+		}
+
+		if err := print(s); err != nil {
+			return err
+		}
+
+		lgr.Debug("finished status command")
+		return nil
+	},
+}
+
+// printStatus writes the status in the selected output format. Json is written one object per line so --watch
+// output can be streamed into other tools.
+func printStatus(w io.Writer, s *status.Status) error {
+	if statusOutput == "json" {
+		if err := json.NewEncoder(w).Encode(s); err != nil {
+			return fmt.Errorf("encoding status: %w", err)
+		}
+
+		return nil
+	}
+
+	if statusWatch {
+		fmt.Fprintln(w, "---")
+	}
+
+	if err := status.Print(w, s); err != nil {
+		return fmt.Errorf("printing status: %w", err)
+	}
+
+	return nil
+}
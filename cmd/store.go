@@ -3,10 +3,8 @@ package cmd
 import (
 	"fmt"
 
-	"github.com/azure/spin-aks-plugin/pkg/azure"
 	"github.com/azure/spin-aks-plugin/pkg/config"
 	"github.com/azure/spin-aks-plugin/pkg/logger"
-	"github.com/azure/spin-aks-plugin/pkg/usererror"
 	"github.com/spf13/cobra"
 )
 
@@ -41,27 +39,11 @@ var storeCmd = &cobra.Command{
 			return fmt.Errorf("secret value cannot be empty")
 		}
 
-		kv := config.GetKeyVault()
-
-		if kv.Name == "" || kv.Subscription == "" || kv.ResourceGroup == "" {
-			return usererror.New(fmt.Errorf("no keyvault found in config"), "no keyvault found in config, try running `spin aks init` first")
-		}
-
 		lgr.Info("starting to store variable in keyvault")
-		akv, err := azure.GetKeyVault(ctx, kv.Subscription, kv.ResourceGroup, kv.Name)
-		if err != nil {
-			return fmt.Errorf("getting keyvault: %w", err)
-		}
-		if akv == nil {
-			return fmt.Errorf("getting keyvault: keyvault was nil")
-		}
-
-		lgr.Info("starting to put variable")
-		defer lgr.Info("finished putting variable")
+		defer lgr.Info("finished storing variable in keyvault")
 
-		err = akv.PutSecretIfNewValue(ctx, secretName, secretValue)
-		if err != nil {
-			return fmt.Errorf("putting secret: %w", err)
+		if err := config.StoreSecret(ctx, secretName, secretValue); err != nil {
+			return fmt.Errorf("storing secret: %w", err)
 		}
 
 		return nil
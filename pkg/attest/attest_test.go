@@ -0,0 +1,143 @@
+package attest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/azure/spin-aks-plugin/pkg/spin"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+	. "github.com/onsi/gomega"
+)
+
+// testInputs writes an application with a local and a URL component
+func testInputs(t *testing.T) Inputs {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"spin.toml":         "spin_manifest_version = \"1\"\nname = \"app\"\n",
+		"target/hello.wasm": "hello wasm",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("creating directory: %s", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("writing %s: %s", name, err)
+		}
+	}
+
+	hello := spin.Component{Id: "hello", Source: spin.ComponentSource{StringSource: "target/hello.wasm"}}
+	hello.Build.Command = "cargo build --release"
+	return Inputs{
+		ManifestPath: filepath.Join(dir, "spin.toml"),
+		Manifest: spin.Manifest{Name: "app", Version: "1.0.0", Components: []spin.Component{
+			hello,
+			{Id: "fileserver", Source: spin.ComponentSource{URLSource: spin.ComponentSourceURL{
+				Url:    "https://example.com/fileserver.wasm",
+				Digest: "sha256:abc123",
+			}}},
+		}},
+		Toolchains:  map[string]spin.Toolchain{"hello": {Name: "cargo", Version: "1.72.0"}},
+		SpinVersion: "1.5.1",
+		ToolVersion: "0.1.0",
+		Git:         Git{Remote: "https://github.com/example/app", Commit: "0123456789abcdef", Dirty: true},
+		Time:        time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func sha(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestSBOM(t *testing.T) {
+	g := NewWithT(t)
+
+	out, err := SBOM(testInputs(t))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	var doc spdxDocument
+	g.Expect(json.Unmarshal(out, &doc)).To(Succeed())
+	g.Expect(doc.SPDXVersion).To(Equal("SPDX-2.3"))
+	g.Expect(doc.CreationInfo.Created).To(Equal("2023-10-01T12:00:00Z"))
+	g.Expect(doc.CreationInfo.Creators).To(Equal([]string{"Tool: spin-aks-plugin-0.1.0", "Tool: spin-1.5.1"}))
+
+	g.Expect(doc.Files).To(Equal([]spdxFile{
+		{SPDXID: "SPDXRef-File-spin.toml", FileName: "./spin.toml", Checksums: []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: sha("spin_manifest_version = \"1\"\nname = \"app\"\n")}}},
+		{SPDXID: "SPDXRef-File-target-hello.wasm", FileName: "./target/hello.wasm", Checksums: []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: sha("hello wasm")}}},
+	}))
+
+	packages := map[string]spdxPackage{}
+	for _, p := range doc.Packages {
+		packages[p.SPDXID] = p
+	}
+	g.Expect(packages["SPDXRef-Package-app"].DownloadLocation).To(Equal("git+https://github.com/example/app@0123456789abcdef"))
+	g.Expect(packages["SPDXRef-Package-component-fileserver"].DownloadLocation).To(Equal("https://example.com/fileserver.wasm"))
+	g.Expect(packages["SPDXRef-Package-component-fileserver"].Checksums).To(Equal([]spdxChecksum{{Algorithm: "SHA256", ChecksumValue: "abc123"}}))
+	g.Expect(packages["SPDXRef-Package-toolchain-cargo-1.72.0"].VersionInfo).To(Equal("1.72.0"))
+
+	g.Expect(doc.Relationships).To(ContainElements(
+		spdxRelationship{SPDXElementID: "SPDXRef-DOCUMENT", RelationshipType: "DESCRIBES", RelatedSPDXElement: "SPDXRef-Package-app"},
+		spdxRelationship{SPDXElementID: "SPDXRef-Package-app", RelationshipType: "DEPENDS_ON", RelatedSPDXElement: "SPDXRef-Package-component-fileserver"},
+		spdxRelationship{SPDXElementID: "SPDXRef-Package-component-hello", RelationshipType: "CONTAINS", RelatedSPDXElement: "SPDXRef-File-target-hello.wasm"},
+		spdxRelationship{SPDXElementID: "SPDXRef-Package-toolchain-cargo-1.72.0", RelationshipType: "BUILD_TOOL_OF", RelatedSPDXElement: "SPDXRef-Package-component-hello"},
+	))
+}
+
+func TestSBOMSharedWasm(t *testing.T) {
+	g := NewWithT(t)
+
+	in := testInputs(t)
+	in.Manifest.Components = append(in.Manifest.Components, spin.Component{Id: "hello-again", Source: spin.ComponentSource{StringSource: "target/hello.wasm"}})
+	out, err := SBOM(in)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	var doc spdxDocument
+	g.Expect(json.Unmarshal(out, &doc)).To(Succeed())
+	g.Expect(doc.Files).To(HaveLen(2))
+	g.Expect(doc.Relationships).To(ContainElements(
+		spdxRelationship{SPDXElementID: "SPDXRef-Package-component-hello", RelationshipType: "CONTAINS", RelatedSPDXElement: "SPDXRef-File-target-hello.wasm"},
+		spdxRelationship{SPDXElementID: "SPDXRef-Package-component-hello-again", RelationshipType: "CONTAINS", RelatedSPDXElement: "SPDXRef-File-target-hello.wasm"},
+	))
+}
+
+func TestSBOMMissingWasm(t *testing.T) {
+	g := NewWithT(t)
+
+	in := testInputs(t)
+	g.Expect(os.Remove(filepath.Join(filepath.Dir(in.ManifestPath), "target", "hello.wasm"))).To(Succeed())
+	_, err := SBOM(in)
+	_, ok := usererror.Is(err)
+	g.Expect(ok).To(BeTrue())
+}
+
+func TestProvenance(t *testing.T) {
+	g := NewWithT(t)
+
+	out, err := Provenance(testInputs(t), Subject{Name: "myregistry.azurecr.io/app", Digest: "sha256:def456"})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	var s statement
+	g.Expect(json.Unmarshal(out, &s)).To(Succeed())
+	g.Expect(s.Type).To(Equal("https://in-toto.io/Statement/v1"))
+	g.Expect(s.PredicateType).To(Equal("https://slsa.dev/provenance/v1"))
+	g.Expect(s.Subject).To(Equal([]statementSubject{{Name: "myregistry.azurecr.io/app", Digest: map[string]string{"sha256": "def456"}}}))
+
+	def := s.Predicate.BuildDefinition
+	g.Expect(def.ExternalParameters.Components).To(Equal([]componentBuild{
+		{Id: "hello", Command: "cargo build --release"},
+		{Id: "fileserver"},
+	}))
+	g.Expect(def.ResolvedDependencies).To(Equal([]resourceDescriptor{
+		{URI: "git+https://github.com/example/app@0123456789abcdef", Digest: map[string]string{"gitCommit": "0123456789abcdef"}},
+		{Name: "fileserver", URI: "https://example.com/fileserver.wasm", Digest: map[string]string{"sha256": "abc123"}},
+	}))
+	g.Expect(def.InternalParameters).To(HaveKeyWithValue("uncommittedChanges", true))
+	g.Expect(s.Predicate.RunDetails.Builder.Version).To(Equal(map[string]string{"spin-aks-plugin": "0.1.0", "spin": "1.5.1", "cargo": "1.72.0"}))
+}
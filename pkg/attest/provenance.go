@@ -0,0 +1,148 @@
+package attest
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// MediaTypeInToto is the media type and artifact type of in-toto statements
+	MediaTypeInToto = "application/vnd.in-toto+json"
+
+	statementType      = "https://in-toto.io/Statement/v1"
+	slsaProvenanceType = "https://slsa.dev/provenance/v1"
+	buildType          = "https://github.com/azure/spin-aks-plugin/push/v1"
+	builderId          = "https://github.com/azure/spin-aks-plugin"
+)
+
+// Subject is what a statement is about, the pushed image
+type Subject struct {
+	// Name is the image without a tag like myregistry.azurecr.io/app
+	Name string
+	// Digest is the digest of the image's manifest like sha256:abc...
+	Digest string
+}
+
+type statement struct {
+	Type          string             `json:"_type"`
+	Subject       []statementSubject `json:"subject"`
+	PredicateType string             `json:"predicateType"`
+	Predicate     provenance         `json:"predicate"`
+}
+
+type statementSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type provenance struct {
+	BuildDefinition buildDefinition `json:"buildDefinition"`
+	RunDetails      runDetails      `json:"runDetails"`
+}
+
+type buildDefinition struct {
+	BuildType            string               `json:"buildType"`
+	ExternalParameters   externalParameters   `json:"externalParameters"`
+	InternalParameters   map[string]any       `json:"internalParameters,omitempty"`
+	ResolvedDependencies []resourceDescriptor `json:"resolvedDependencies"`
+}
+
+type externalParameters struct {
+	// Manifest is the spin manifest path relative to the source
+	Manifest   string           `json:"manifest"`
+	Components []componentBuild `json:"components"`
+}
+
+type componentBuild struct {
+	Id      string `json:"id"`
+	Command string `json:"command,omitempty"`
+	Workdir string `json:"workdir,omitempty"`
+}
+
+type resourceDescriptor struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+type runDetails struct {
+	Builder  builder  `json:"builder"`
+	Metadata metadata `json:"metadata"`
+}
+
+type builder struct {
+	Id      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+type metadata struct {
+	FinishedOn string `json:"finishedOn"`
+}
+
+// Provenance returns the in-toto statement with SLSA provenance of the image. It records the git commit the
+// application was built from, every component's build command, and the URL components it downloaded.
+func Provenance(in Inputs, subject Subject) ([]byte, error) {
+	algorithm, sum, _ := strings.Cut(subject.Digest, ":")
+
+	def := buildDefinition{
+		BuildType: buildType,
+		ExternalParameters: externalParameters{
+			Manifest:   filepath.ToSlash(filepath.Base(in.ManifestPath)),
+			Components: []componentBuild{},
+		},
+		ResolvedDependencies: []resourceDescriptor{},
+	}
+
+	if in.Git.Commit != "" {
+		uri := "git+" + in.Git.Remote
+		if in.Git.Remote == "" {
+			uri = "git"
+		}
+		def.ResolvedDependencies = append(def.ResolvedDependencies, resourceDescriptor{
+			URI:    uri + "@" + in.Git.Commit,
+			Digest: map[string]string{"gitCommit": in.Git.Commit},
+		})
+		if in.Git.Dirty {
+			def.InternalParameters = map[string]any{"uncommittedChanges": true}
+		}
+	}
+
+	for _, c := range in.Manifest.Components {
+		def.ExternalParameters.Components = append(def.ExternalParameters.Components, componentBuild{
+			Id:      c.Id,
+			Command: c.Build.Command,
+			Workdir: c.Build.Workdir,
+		})
+
+		if u := c.Source.URLSource; u.Url != "" {
+			dep := resourceDescriptor{Name: c.Id, URI: u.Url}
+			if algorithm, sum, ok := strings.Cut(u.Digest, ":"); ok {
+				dep.Digest = map[string]string{algorithm: sum}
+			}
+			def.ResolvedDependencies = append(def.ResolvedDependencies, dep)
+		}
+	}
+
+	versions := map[string]string{"spin-aks-plugin": versionOrUnknown(in.ToolVersion)}
+	if in.SpinVersion != "" {
+		versions["spin"] = in.SpinVersion
+	}
+	for _, t := range in.Toolchains {
+		versions[t.Name] = t.Version
+	}
+
+	return json.MarshalIndent(statement{
+		Type:          statementType,
+		Subject:       []statementSubject{{Name: subject.Name, Digest: map[string]string{algorithm: sum}}},
+		PredicateType: slsaProvenanceType,
+		Predicate: provenance{
+			BuildDefinition: def,
+			RunDetails: runDetails{
+				Builder:  builder{Id: builderId, Version: versions},
+				Metadata: metadata{FinishedOn: in.Time.UTC().Format(time.RFC3339)},
+			},
+		},
+	}, "", "  ")
+}
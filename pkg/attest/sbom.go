@@ -0,0 +1,238 @@
+// Package attest describes what a pushed application was built from. It generates an SPDX SBOM of the spin manifest,
+// component wasm, and toolchains and an in-toto provenance statement of the source and build commands.
+package attest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/azure/spin-aks-plugin/pkg/spin"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+)
+
+const (
+	// MediaTypeSPDX is the media type and artifact type of SPDX JSON documents
+	MediaTypeSPDX = "application/spdx+json"
+
+	noAssertion = "NOASSERTION"
+)
+
+// invalidSPDXIdChars matches characters SPDX identifiers can't have
+var invalidSPDXIdChars = regexp.MustCompile(`[^a-zA-Z0-9.-]`)
+
+// Inputs are what an application was built from and with
+type Inputs struct {
+	ManifestPath string
+	Manifest     spin.Manifest
+	// Toolchains are the toolchains that built the components by component id
+	Toolchains map[string]spin.Toolchain
+	// SpinVersion is the version of the spin CLI
+	SpinVersion string
+	// ToolVersion is the version of this plugin
+	ToolVersion string
+	// Git is the source the application was built from. It's empty outside of git repositories.
+	Git Git
+	// Time is when the application was built
+	Time time.Time
+}
+
+// Git is a git checkout
+type Git struct {
+	Remote string
+	Commit string
+	// Dirty is true if the working tree had uncommitted changes
+	Dirty bool
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Files             []spdxFile         `json:"files"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID                string         `json:"SPDXID"`
+	Name                  string         `json:"name"`
+	VersionInfo           string         `json:"versionInfo,omitempty"`
+	DownloadLocation      string         `json:"downloadLocation"`
+	FilesAnalyzed         bool           `json:"filesAnalyzed"`
+	Checksums             []spdxChecksum `json:"checksums,omitempty"`
+	PrimaryPackagePurpose string         `json:"primaryPackagePurpose,omitempty"`
+	Comment               string         `json:"comment,omitempty"`
+}
+
+type spdxFile struct {
+	SPDXID    string         `json:"SPDXID"`
+	FileName  string         `json:"fileName"`
+	Checksums []spdxChecksum `json:"checksums"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// SBOM returns the SPDX 2.3 JSON document of the application. The application is a package containing spin.toml and
+// depending on a package per component. Local components contain their wasm file and URL components are downloaded
+// from their URL with their digest. Toolchains are packages related to the components they built.
+func SBOM(in Inputs) ([]byte, error) {
+	manifestDir := filepath.Dir(in.ManifestPath)
+	appId := spdxId("Package", in.Manifest.Name)
+
+	manifestSum, err := fileSha256(in.ManifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("hashing spin manifest: %w", err)
+	}
+
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              in.Manifest.Name,
+		DocumentNamespace: fmt.Sprintf("https://spin.kubernetes.azure.com/spdx/%s-%s", in.Manifest.Name, manifestSum),
+		CreationInfo: spdxCreationInfo{
+			Created:  in.Time.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: spin-aks-plugin-" + versionOrUnknown(in.ToolVersion)},
+		},
+		Packages: []spdxPackage{{
+			SPDXID:                appId,
+			Name:                  in.Manifest.Name,
+			VersionInfo:           in.Manifest.Version,
+			DownloadLocation:      downloadLocation(in.Git),
+			FilesAnalyzed:         true,
+			PrimaryPackagePurpose: "APPLICATION",
+		}},
+		Files: []spdxFile{{
+			SPDXID:    spdxId("File", "spin.toml"),
+			FileName:  "./" + filepath.ToSlash(filepath.Base(in.ManifestPath)),
+			Checksums: []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: manifestSum}},
+		}},
+		Relationships: []spdxRelationship{
+			{SPDXElementID: "SPDXRef-DOCUMENT", RelationshipType: "DESCRIBES", RelatedSPDXElement: appId},
+			{SPDXElementID: appId, RelationshipType: "CONTAINS", RelatedSPDXElement: spdxId("File", "spin.toml")},
+		},
+	}
+	if in.SpinVersion != "" {
+		doc.CreationInfo.Creators = append(doc.CreationInfo.Creators, "Tool: spin-"+in.SpinVersion)
+	}
+
+	toolchainIds := map[string]bool{}
+	// components can share a wasm file but the document lists each file once
+	fileIds := map[string]bool{}
+	for _, c := range in.Manifest.Components {
+		componentId := spdxId("Package-component", c.Id)
+		pkg := spdxPackage{SPDXID: componentId, Name: c.Id, DownloadLocation: noAssertion, PrimaryPackagePurpose: "LIBRARY"}
+		doc.Relationships = append(doc.Relationships, spdxRelationship{SPDXElementID: appId, RelationshipType: "DEPENDS_ON", RelatedSPDXElement: componentId})
+
+		if u := c.Source.URLSource; u.Url != "" {
+			pkg.DownloadLocation = u.Url
+			if algorithm, sum, ok := strings.Cut(u.Digest, ":"); ok {
+				pkg.Checksums = []spdxChecksum{{Algorithm: strings.ToUpper(algorithm), ChecksumValue: sum}}
+			}
+		} else {
+			source := filepath.Clean(string(c.Source.StringSource))
+			sum, err := fileSha256(filepath.Join(manifestDir, source))
+			if err != nil {
+				return nil, fmt.Errorf("hashing wasm of component %s: %w", c.Id, err)
+			}
+
+			pkg.FilesAnalyzed = true
+			fileId := spdxId("File", source)
+			if !fileIds[fileId] {
+				fileIds[fileId] = true
+				doc.Files = append(doc.Files, spdxFile{
+					SPDXID:    fileId,
+					FileName:  "./" + filepath.ToSlash(source),
+					Checksums: []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: sum}},
+				})
+			}
+			doc.Relationships = append(doc.Relationships, spdxRelationship{SPDXElementID: componentId, RelationshipType: "CONTAINS", RelatedSPDXElement: fileId})
+		}
+		doc.Packages = append(doc.Packages, pkg)
+
+		t, ok := in.Toolchains[c.Id]
+		if !ok {
+			continue
+		}
+
+		toolchainId := spdxId("Package-toolchain", t.Name+"-"+t.Version)
+		if !toolchainIds[toolchainId] {
+			toolchainIds[toolchainId] = true
+			doc.Packages = append(doc.Packages, spdxPackage{
+				SPDXID:                toolchainId,
+				Name:                  t.Name,
+				VersionInfo:           t.Version,
+				DownloadLocation:      noAssertion,
+				PrimaryPackagePurpose: "INSTALL",
+				Comment:               "Toolchain found on the PATH when building",
+			})
+		}
+		doc.Relationships = append(doc.Relationships, spdxRelationship{SPDXElementID: toolchainId, RelationshipType: "BUILD_TOOL_OF", RelatedSPDXElement: componentId})
+	}
+
+	sort.SliceStable(doc.Files, func(i, j int) bool { return doc.Files[i].FileName < doc.Files[j].FileName })
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// spdxId returns a valid SPDX identifier of the kind and name like SPDXRef-File-target-app.wasm
+func spdxId(kind, name string) string {
+	return "SPDXRef-" + kind + "-" + invalidSPDXIdChars.ReplaceAllString(name, "-")
+}
+
+// downloadLocation returns the SPDX download location of the git checkout
+func downloadLocation(g Git) string {
+	if g.Remote == "" || g.Commit == "" {
+		return noAssertion
+	}
+
+	return "git+" + g.Remote + "@" + g.Commit
+}
+
+func versionOrUnknown(v string) string {
+	if v == "" {
+		return "unknown"
+	}
+
+	return v
+}
+
+// fileSha256 returns the hex sha256 of the file's content
+func fileSha256(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", usererror.New(err, fmt.Sprintf("%s doesn't exist. Run `spin aks build` first.", path))
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
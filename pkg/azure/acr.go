@@ -7,6 +7,7 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerregistry/armcontainerregistry"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
 	"github.com/azure/spin-aks-plugin/pkg/logger"
 )
 
@@ -26,6 +27,9 @@ var (
 		Name: acrPullRoleName,
 		ID:   fmt.Sprintf("/providers/Microsoft.Authorization/roleDefinitions/%s", acrPullRoleDefinition),
 	}
+
+	// MissingAcrPullErr is returned when the cluster isn't assigned AcrPull on the registry
+	MissingAcrPullErr = errors.New("cluster does not have AcrPull permission")
 )
 
 func acrFactory(subscriptionId string) (*armcontainerregistry.ClientFactory, error) {
@@ -34,7 +38,7 @@ func acrFactory(subscriptionId string) (*armcontainerregistry.ClientFactory, err
 		return nil, fmt.Errorf("getting credential: %w", err)
 	}
 
-	factory, err := armcontainerregistry.NewClientFactory(subscriptionId, cred, nil)
+	factory, err := armcontainerregistry.NewClientFactory(subscriptionId, cred, armOptions())
 	if err != nil {
 		return nil, fmt.Errorf("creating factory: %w", err)
 	}
@@ -47,6 +51,11 @@ func ListContainerRegistries(ctx context.Context, subscriptionId, resourceGroup
 	ctx = logger.WithContext(ctx, lgr)
 	lgr.Debug("listing ACRs")
 
+	if isPlanned(ctx, resourceGroupId(subscriptionId, resourceGroup)) {
+		lgr.Debug("resource group is only planned, no ACRs exist")
+		return nil, nil
+	}
+
 	client, err := acrFactory(subscriptionId)
 	if err != nil {
 		return nil, fmt.Errorf("getting container registry client: %w", err)
@@ -78,15 +87,36 @@ func NewContainerRegistry(ctx context.Context, subscriptionId, resourceGroup, na
 	ctx = logger.WithContext(ctx, lgr)
 	lgr.Debug("creating new container registry")
 
+	if planned(ctx, dryrun.Change{
+		Action: dryrun.Create,
+		Kind:   "Container Registry",
+		Target: acrId(subscriptionId, resourceGroup, name),
+		Detail: "location " + location + ", sku Basic",
+	}) {
+		return nil
+	}
+
 	factory, err := acrFactory(subscriptionId)
 	if err != nil {
 		return fmt.Errorf("getting acr factory: %w", err)
 	}
 
+	// a registry that already exists is left unchanged so it isn't tagged as created by the plugin
+	_, err = factory.NewRegistriesClient().Get(ctx, resourceGroup, name, nil)
+	switch {
+	case err == nil:
+		lgr.Debug("container registry already exists")
+		return nil
+	case isNotFound(err):
+	default:
+		return fmt.Errorf("getting container registry: %w", err)
+	}
+
 	lgr.Info("creating new Container Registry")
 	poll, err := factory.NewRegistriesClient().BeginCreate(ctx, resourceGroup, name, armcontainerregistry.Registry{
 		Name:     &name,
 		Location: &location,
+		Tags:     createdByTags(),
 		SKU: &armcontainerregistry.SKU{
 			Name: to.Ptr(armcontainerregistry.SKUNameBasic),
 		},
@@ -99,46 +129,45 @@ func NewContainerRegistry(ctx context.Context, subscriptionId, resourceGroup, na
 		return fmt.Errorf("creating container registry: %w", err)
 	}
 
+	if err := RecordCreated(ctx, acrId(subscriptionId, resourceGroup, name)); err != nil {
+		return fmt.Errorf("recording created container registry: %w", err)
+	}
+
 	return nil
 }
 
-func CheckACRPullAccess(ctx context.Context, subscriptionId, resourceGroup, registryName, clusterName string) error {
-	lgr := logger.FromContext(ctx).With("subscription", subscriptionId, "resource group", resourceGroup, "registry", registryName)
+// CheckACRPullAccess returns an error if the cluster can't pull images from the registry. MissingAcrPullErr is returned
+// if the cluster isn't assigned AcrPull on the registry.
+func CheckACRPullAccess(ctx context.Context, subscriptionId, clusterResourceGroup, clusterName, acrSubscriptionId, acrResourceGroup, acrName string) error {
+	lgr := logger.FromContext(ctx).With("subscription", subscriptionId, "resource group", clusterResourceGroup, "cluster name", clusterName,
+		"acr subscription", acrSubscriptionId, "acr name", acrName)
 	ctx = logger.WithContext(ctx, lgr)
 	lgr.Debug("checking cluster's acr pull access")
 
-	roles, err := ListRoleAssignments(ctx, subscriptionId, resourceGroup)
+	mc, err := GetCluster(ctx, subscriptionId, clusterResourceGroup, clusterName)
 	if err != nil {
-		return fmt.Errorf("listing role assignments: %w", err)
+		return fmt.Errorf("get mc by name: %w", err)
 	}
 
-	// retrieve specific registry by name
-	client, err := acrFactory(subscriptionId)
-	acr, err := client.NewRegistriesClient().Get(ctx, resourceGroup, registryName, nil)
+	principalId, err := acrPullPrincipalId(ctx, mc)
 	if err != nil {
-		return fmt.Errorf("get acr by name: %w", err)
+		return fmt.Errorf("getting cluster principal: %w", err)
 	}
 
-	// retrieve specific cluster by name
-	mc, err := GetCluster(ctx, subscriptionId, resourceGroup, clusterName)
+	client, err := createRoleAssignmentClient(acrSubscriptionId)
 	if err != nil {
-		return fmt.Errorf("get mc by name: %w", err)
+		return fmt.Errorf("creating role assignment client: %w", err)
 	}
 
-	scope := acr.ID
-	kubeletId := mc.Identity.PrincipalID
-	for _, role := range roles {
-		if (*role.Name == AcrPullRole.Name) && (*role.ID == AcrPullRole.ID) && (*scope == *role.Properties.Scope) && (*kubeletId == *role.Properties.PrincipalID) {
-			// tbarnes94: success case
-			// checking that cluster has permissions to pull from acr
-			// matching up the mc's kubelet id (principalId) to the role's principalId (role.Properties.PrincipalID)
-			// matching up the scope from the role (role.Properties.Scope) to the scope in the acr (registry id)
-
-			return nil
-		}
+	exists, err := client.hasRoleAssignment(ctx, principalId, acrPullRoleDefinition, acrId(acrSubscriptionId, acrResourceGroup, acrName))
+	if err != nil {
+		return fmt.Errorf("checking for existing role assignment: %w", err)
+	}
+	if !exists {
+		return MissingAcrPullErr
 	}
 
-	return errors.New("cluster does not have AcrPull permission")
+	return nil
 }
 
 func ListRoleAssignments(ctx context.Context, subscriptionId, resourceGroup string) ([]armauthorization.RoleAssignment, error) {
@@ -151,7 +180,7 @@ func ListRoleAssignments(ctx context.Context, subscriptionId, resourceGroup stri
 		return nil, fmt.Errorf("getting az credentials: %w", err)
 	}
 
-	client, err := armauthorization.NewRoleAssignmentsClient(subscriptionId, cred, nil)
+	client, err := armauthorization.NewRoleAssignmentsClient(subscriptionId, cred, armOptions())
 	pager := client.NewListForResourceGroupPager(resourceGroup, nil)
 
 	var roles []armauthorization.RoleAssignment
@@ -2,15 +2,19 @@ package azure
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
 	"github.com/azure/spin-aks-plugin/pkg/logger"
-	"github.com/google/uuid"
 )
 
 func aksFactory(subscriptionId string) (*armcontainerservice.ClientFactory, error) {
@@ -19,7 +23,7 @@ func aksFactory(subscriptionId string) (*armcontainerservice.ClientFactory, erro
 		return nil, fmt.Errorf("getting credential: %w", err)
 	}
 
-	factory, err := armcontainerservice.NewClientFactory(subscriptionId, cred, nil)
+	factory, err := armcontainerservice.NewClientFactory(subscriptionId, cred, armOptions())
 	if err != nil {
 		return nil, fmt.Errorf("creating factory: %w", err)
 	}
@@ -32,6 +36,11 @@ func ListClusters(ctx context.Context, subscriptionId, resourceGroup string) ([]
 	ctx = logger.WithContext(ctx, lgr)
 	lgr.Debug("listing AKS clusters")
 
+	if isPlanned(ctx, resourceGroupId(subscriptionId, resourceGroup)) {
+		lgr.Debug("resource group is only planned, no clusters exist")
+		return nil, nil
+	}
+
 	client, err := aksFactory(subscriptionId)
 	if err != nil {
 		return nil, fmt.Errorf("getting aks client: %w", err)
@@ -58,56 +67,61 @@ func ListClusters(ctx context.Context, subscriptionId, resourceGroup string) ([]
 	return clusters, nil
 }
 
-func LinkAcr(ctx context.Context, subscriptionId, clusterResourceGroup, clusterName, acrResourceGroup, acrName string) error {
+func LinkAcr(ctx context.Context, subscriptionId, clusterResourceGroup, clusterName, acrSubscriptionId, acrResourceGroup, acrName string) error {
 	lgr := logger.FromContext(ctx).With("subscription", subscriptionId, "resource group", clusterResourceGroup, "cluster name", clusterName,
-		"acr name", acrName)
+		"acr subscription", acrSubscriptionId, "acr name", acrName)
 	ctx = logger.WithContext(ctx, lgr)
 	lgr.Debug("linking ACR")
 
-	// add validation for acr?
-
-	client, err := aksFactory(subscriptionId)
+	lgr.Debug("getting cluster information")
+	cluster, err := GetCluster(ctx, subscriptionId, clusterResourceGroup, clusterName)
 	if err != nil {
-		return fmt.Errorf("getting aks client: %w", err)
+		return fmt.Errorf("getting cluster information: %w", err)
 	}
 
-	lgr.Debug("getting cluster information")
-	cluster, err := client.NewManagedClustersClient().Get(ctx, clusterResourceGroup, clusterName, nil)
-
+	assigneeId, err := acrPullPrincipalId(ctx, cluster)
 	if err != nil {
-		return fmt.Errorf("getting cluster information: %w", err)
+		return fmt.Errorf("getting cluster principal: %w", err)
 	}
 
-	var assigneeId *string
+	// the role assignment is made in the registry's subscription, which can differ from the cluster's
+	scope := acrId(acrSubscriptionId, acrResourceGroup, acrName)
+	return ensureRoleAssignment(ctx, acrSubscriptionId, assigneeId, AcrPullRole, scope, dryrun.Change{
+		Action: dryrun.Grant,
+		Kind:   "Role Assignment",
+		Target: scope,
+		Detail: fmt.Sprintf("%s to cluster %s", AcrPullRole.Name, clusterName),
+	})
+}
 
+// acrPullPrincipalId returns the object id of the principal the cluster pulls images with
+func acrPullPrincipalId(ctx context.Context, cluster *armcontainerservice.ManagedCluster) (string, error) {
+	lgr := logger.FromContext(ctx)
+
+	var assigneeId *string
 	if cluster.Identity == nil {
-		return fmt.Errorf("serviceprincipal clusters are not supported at this time")
-		//lgr.Debug("detected service principal cluster")
-		//clientId := cluster.ManagedCluster.Properties.ServicePrincipalProfile.ClientID
-		//
-		//if clientId == nil {
-		//	return fmt.Errorf("client id for sp is nil")
-		//}
-		//
-		//tenant, err := ListTenants(ctx)
-		//if err != nil {
-		//	return fmt.Errorf("listing tenants: %w", err)
-		//}
-		//tenantId := *tenant[0].TenantID
-		//
-		//aadClient, err := NewAadClient(ctx, tenantId)
-		//if err != nil {
-		//	return fmt.Errorf("creating aad client: %w", err)
-		//}
-		//
-		//spObjId, err := aadClient.getObjectIdFromClientId(ctx, *clientId)
-		//if err != nil {
-		//	return fmt.Errorf("getting object id from client id: %w", err)
-		//}
-		//
-		//assigneeId = spObjId
+		lgr.Debug("detected service principal cluster")
+		if cluster.Properties == nil || cluster.Properties.ServicePrincipalProfile == nil || cluster.Properties.ServicePrincipalProfile.ClientID == nil {
+			return "", errors.New("missing service principal client id for cluster without managed identity")
+		}
+		clientId := *cluster.Properties.ServicePrincipalProfile.ClientID
 
+		cred, err := getCred()
+		if err != nil {
+			return "", fmt.Errorf("getting credential: %w", err)
+		}
+
+		spObjId, err := newGraphClient(cred).servicePrincipalObjectId(ctx, clientId)
+		if err != nil {
+			return "", fmt.Errorf("getting object id from client id: %w", err)
+		}
+
+		assigneeId = &spObjId
 	} else {
+		if cluster.Identity.Type == nil {
+			return "", errors.New("missing cluster identity type")
+		}
+
 		switch *cluster.Identity.Type {
 		case armcontainerservice.ResourceIdentityTypeSystemAssigned:
 			lgr.Debug("detected system-assigned identity cluster")
@@ -115,30 +129,23 @@ func LinkAcr(ctx context.Context, subscriptionId, clusterResourceGroup, clusterN
 		case armcontainerservice.ResourceIdentityTypeUserAssigned:
 			lgr.Debug("detected user-assigned identity cluster")
 			// https://github.com/Azure/azure-cli/blob/8f91d71e8c3af9ab10024e12c51a0dab573df9f2/src/azure-cli/azure/cli/command_modules/acs/managed_cluster_decorator.py#L6177
+			if cluster.Properties == nil {
+				return "", errors.New("missing properties on User Assigned Identity cluster")
+			}
 			msiInfo, ok := cluster.Properties.IdentityProfile["kubeletidentity"]
-			fmt.Println(cluster.Properties.IdentityProfile)
-			if !ok {
-				return errors.New("missing kubeletidentity on User Assigned Identity cluster")
+			if !ok || msiInfo == nil {
+				return "", errors.New("missing kubeletidentity on User Assigned Identity cluster")
 			}
 			assigneeId = msiInfo.ObjectID
 		default:
-			return fmt.Errorf("unknown cluster identity type")
+			return "", fmt.Errorf("unknown cluster identity type")
 		}
 	}
 	if assigneeId == nil {
-		return errors.New("missing principal id for cluster")
-	}
-
-	raClient, err := createRoleAssignmentClient(subscriptionId)
-	if err != nil {
-		return fmt.Errorf("creating role assignment client: %w", err)
+		return "", errors.New("missing principal id for cluster")
 	}
 
-	scope := fmt.Sprintf(acrResourceIdTemplate, subscriptionId, acrResourceGroup, acrName)
-
-	raUid := uuid.New().String()
-	err = raClient.createRoleAssignment(ctx, *assigneeId, acrPullRoleDefinition, scope, raUid)
-	return err
+	return *assigneeId, nil
 }
 
 func GetManagedCluster(ctx context.Context, subscriptionId, resourceGroup, name string) (armcontainerservice.ManagedCluster, error) {
@@ -147,6 +154,11 @@ func GetManagedCluster(ctx context.Context, subscriptionId, resourceGroup, name
 	lgr.Debug("getting AKS clusters")
 	c := armcontainerservice.ManagedCluster{}
 
+	if isPlanned(ctx, clusterId(subscriptionId, resourceGroup, name)) {
+		lgr.Debug("cluster is only planned, returning placeholder")
+		return plannedCluster(name), nil
+	}
+
 	client, err := aksFactory(subscriptionId)
 	if err != nil {
 		return c, fmt.Errorf("getting aks client: %w", err)
@@ -167,16 +179,37 @@ func NewCluster(ctx context.Context, subscriptionId, resourceGroup, name, locati
 	ctx = logger.WithContext(ctx, lgr)
 	lgr.Debug("creating AKS cluster")
 
+	if planned(ctx, dryrun.Change{
+		Action: dryrun.Create,
+		Kind:   "Managed Cluster",
+		Target: clusterId(subscriptionId, resourceGroup, name),
+		Detail: "location " + location + ", 2-10 Standard_DS2_v2 nodes",
+	}) {
+		return nil
+	}
+
 	client, err := aksFactory(subscriptionId)
 	if err != nil {
 		return fmt.Errorf("getting aks client: %w", err)
 	}
 
+	// a cluster that already exists is left unchanged so it isn't tagged as created by the plugin
+	_, err = client.NewManagedClustersClient().Get(ctx, resourceGroup, name, nil)
+	switch {
+	case err == nil:
+		lgr.Debug("cluster already exists")
+		return nil
+	case isNotFound(err):
+	default:
+		return fmt.Errorf("getting cluster: %w", err)
+	}
+
 	lgr.Info("creating new Managed Cluster")
 	poll, err := client.NewManagedClustersClient().BeginCreateOrUpdate(ctx, resourceGroup, name, armcontainerservice.ManagedCluster{
 		// matches dev/test preset cluster configuration
 		Name:     &name,
 		Location: &location,
+		Tags:     createdByTags(),
 		Identity: &armcontainerservice.ManagedClusterIdentity{
 			Type: to.Ptr(armcontainerservice.ResourceIdentityTypeSystemAssigned),
 		},
@@ -203,6 +236,10 @@ func NewCluster(ctx context.Context, subscriptionId, resourceGroup, name, locati
 		return fmt.Errorf("creating cluster: %w", err)
 	}
 
+	if err := RecordCreated(ctx, clusterId(subscriptionId, resourceGroup, name)); err != nil {
+		return fmt.Errorf("recording created cluster: %w", err)
+	}
+
 	return nil
 }
 
@@ -233,6 +270,12 @@ func GetCluster(ctx context.Context, subscriptionId, resourceGroup, clusterName
 	ctx = logger.WithContext(ctx, lgr)
 	lgr.Debug("getting AKS cluster")
 
+	if isPlanned(ctx, clusterId(subscriptionId, resourceGroup, clusterName)) {
+		lgr.Debug("cluster is only planned, returning placeholder")
+		mc := plannedCluster(clusterName)
+		return &mc, nil
+	}
+
 	client, err := aksFactory(subscriptionId)
 	if err != nil {
 		return nil, fmt.Errorf("getting aks client: %w", err)
@@ -247,18 +290,64 @@ func GetCluster(ctx context.Context, subscriptionId, resourceGroup, clusterName
 	return &res.ManagedCluster, nil
 }
 
+// GetKubeconfig returns a kubeconfig with the signed-in user's credentials for the cluster
+func GetKubeconfig(ctx context.Context, subscriptionId, resourceGroup, name string) ([]byte, error) {
+	lgr := logger.FromContext(ctx).With("subscription", subscriptionId, "resource group", resourceGroup, "cluster name", name)
+	ctx = logger.WithContext(ctx, lgr)
+	lgr.Debug("getting cluster user credentials")
+
+	client, err := aksFactory(subscriptionId)
+	if err != nil {
+		return nil, fmt.Errorf("getting aks client: %w", err)
+	}
+
+	resp, err := client.NewManagedClustersClient().ListClusterUserCredentials(ctx, resourceGroup, name, nil)
+	if err != nil {
+		return nil, fmt.Errorf("listing cluster user credentials: %w", err)
+	}
+
+	for _, kubeconfig := range resp.Kubeconfigs {
+		if kubeconfig != nil && len(kubeconfig.Value) > 0 {
+			lgr.Debug("finished getting cluster user credentials")
+			return kubeconfig.Value, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no user credentials returned for cluster %s", name)
+}
+
 func EnableKeyvaultCSIDriver(ctx context.Context, subscriptionId, resourceGroup, name string) error {
 	lgr := logger.FromContext(ctx)
 	ctx = logger.WithContext(ctx, lgr)
 	lgr.Debug("enabling keyvault CSI driver for cluster")
 
 	mc, err := GetCluster(ctx, subscriptionId, resourceGroup, name)
+	if err != nil {
+		return fmt.Errorf("getting cluster: %w", err)
+	}
 	lgr = lgr.With("cluster", mc)
 
 	if mc == nil {
 		return errors.New("managed cluster cannot be nil to install keyvault csi driver")
 	}
 
+	// the cluster is only put when the add-on needs enabling since it's put at the SDK's older API version
+	if mc.Properties != nil {
+		if addon, ok := mc.Properties.AddonProfiles["azureKeyvaultSecretsProvider"]; ok && addon != nil && addon.Enabled != nil && *addon.Enabled {
+			lgr.Debug("keyvault CSI driver already enabled")
+			return nil
+		}
+	}
+
+	if planned(ctx, dryrun.Change{
+		Action: dryrun.Update,
+		Kind:   "Managed Cluster",
+		Target: clusterId(subscriptionId, resourceGroup, name),
+		Detail: "enable azureKeyvaultSecretsProvider add-on",
+	}) {
+		return nil
+	}
+
 	if mc.Properties == nil {
 		mc.Properties = &armcontainerservice.ManagedClusterProperties{}
 	}
@@ -313,6 +402,167 @@ func pollWithLog[T any](ctx context.Context, p *runtime.Poller[T], msg string) (
 	}
 }
 
+// workloadIdentityApiVersion is a managed clusters API version with the workload identity security profile, which the
+// API version of the armcontainerservice SDK predates
+const workloadIdentityApiVersion = "2023-06-01"
+
+// workloadIdentityCluster is the part of a managed cluster that describes its workload identity
+type workloadIdentityCluster struct {
+	Properties struct {
+		OidcIssuerProfile struct {
+			Enabled   bool   `json:"enabled"`
+			IssuerURL string `json:"issuerURL"`
+		} `json:"oidcIssuerProfile"`
+		SecurityProfile struct {
+			WorkloadIdentity struct {
+				Enabled bool `json:"enabled"`
+			} `json:"workloadIdentity"`
+		} `json:"securityProfile"`
+	} `json:"properties"`
+}
+
+// EnableWorkloadIdentity enables the OIDC issuer of the cluster so its service account tokens can be federated with
+// Azure AD and the workload identity webhook that injects those tokens into labeled pods. It returns the issuer url.
+func EnableWorkloadIdentity(ctx context.Context, subscriptionId, resourceGroup, name string) (string, error) {
+	lgr := logger.FromContext(ctx).With("subscription", subscriptionId, "resource group", resourceGroup, "cluster name", name)
+	ctx = logger.WithContext(ctx, lgr)
+	lgr.Debug("enabling workload identity for cluster")
+
+	id := clusterId(subscriptionId, resourceGroup, name)
+	change := dryrun.Change{
+		Action: dryrun.Update,
+		Kind:   "Managed Cluster",
+		Target: id,
+		Detail: "enable OIDC issuer and workload identity",
+	}
+	if isPlanned(ctx, id) && planned(ctx, change) {
+		return plannedOidcIssuer, nil
+	}
+
+	cred, err := getCred()
+	if err != nil {
+		return "", fmt.Errorf("getting az credentials: %w", err)
+	}
+
+	client, err := arm.NewClient("spin-aks-plugin", "v0.0.0", cred, armOptions())
+	if err != nil {
+		return "", fmt.Errorf("creating arm client: %w", err)
+	}
+
+	// the cluster is updated as raw JSON so properties the SDK doesn't know are kept
+	u := runtime.JoinPaths(client.Endpoint(), id)
+	req, err := clusterRequest(ctx, http.MethodGet, u)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Pipeline().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("getting cluster: %w", err)
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return "", fmt.Errorf("getting cluster: %w", runtime.NewResponseError(resp))
+	}
+
+	body, err := runtime.Payload(resp)
+	if err != nil {
+		return "", fmt.Errorf("reading cluster: %w", err)
+	}
+
+	var current workloadIdentityCluster
+	if err := json.Unmarshal(body, &current); err != nil {
+		return "", fmt.Errorf("decoding cluster: %w", err)
+	}
+	oidc := current.Properties.OidcIssuerProfile
+	if oidc.Enabled && oidc.IssuerURL != "" && current.Properties.SecurityProfile.WorkloadIdentity.Enabled {
+		lgr.Debug("workload identity already enabled")
+		return oidc.IssuerURL, nil
+	}
+
+	if planned(ctx, change) {
+		return plannedOidcIssuer, nil
+	}
+
+	var mc map[string]any
+	if err := json.Unmarshal(body, &mc); err != nil {
+		return "", fmt.Errorf("decoding cluster: %w", err)
+	}
+	props := jsonObject(mc, "properties")
+	jsonObject(props, "oidcIssuerProfile")["enabled"] = true
+	jsonObject(jsonObject(props, "securityProfile"), "workloadIdentity")["enabled"] = true
+
+	req, err = clusterRequest(ctx, http.MethodPut, u)
+	if err != nil {
+		return "", err
+	}
+	if err := runtime.MarshalAsJSON(req, mc); err != nil {
+		return "", fmt.Errorf("encoding cluster: %w", err)
+	}
+
+	resp, err = client.Pipeline().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("putting cluster: %w", err)
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK, http.StatusCreated) {
+		return "", fmt.Errorf("putting cluster: %w", runtime.NewResponseError(resp))
+	}
+
+	poll, err := runtime.NewPoller[workloadIdentityCluster](resp, client.Pipeline(), nil)
+	if err != nil {
+		return "", fmt.Errorf("starting to poll cluster update: %w", err)
+	}
+
+	updated, err := pollWithLog(ctx, poll, "still enabling workload identity")
+	if err != nil {
+		return "", fmt.Errorf("enabling workload identity: %w", err)
+	}
+
+	issuer := updated.Properties.OidcIssuerProfile.IssuerURL
+	if issuer == "" {
+		return "", errors.New("cluster has no OIDC issuer url after enabling it")
+	}
+
+	lgr.Debug("finished enabling workload identity for cluster")
+	return issuer, nil
+}
+
+// clusterRequest returns a request to the managed cluster at u with the workload identity API version
+func clusterRequest(ctx context.Context, method, u string) (*policy.Request, error) {
+	req, err := runtime.NewRequest(ctx, method, u)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	q := req.Raw().URL.Query()
+	q.Set("api-version", workloadIdentityApiVersion)
+	req.Raw().URL.RawQuery = q.Encode()
+	req.Raw().Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+// jsonObject returns the object at key in parent, adding an empty one if there's none
+func jsonObject(parent map[string]any, key string) map[string]any {
+	child, ok := parent[key].(map[string]any)
+	if !ok {
+		child = map[string]any{}
+		parent[key] = child
+	}
+
+	return child
+}
+
+// plannedCluster returns a placeholder for a cluster that only exists in a dry run plan
+func plannedCluster(name string) armcontainerservice.ManagedCluster {
+	return armcontainerservice.ManagedCluster{
+		Name: to.Ptr(name),
+		Identity: &armcontainerservice.ManagedClusterIdentity{
+			Type:        to.Ptr(armcontainerservice.ResourceIdentityTypeSystemAssigned),
+			PrincipalID: to.Ptr(plannedPrincipalId),
+		},
+		Properties: &armcontainerservice.ManagedClusterProperties{},
+	}
+}
+
 type errorCh[T any] struct {
 	err  error
 	data T
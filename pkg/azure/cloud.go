@@ -0,0 +1,187 @@
+package azure
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+)
+
+// Cloud describes the endpoints of an Azure cloud
+type Cloud struct {
+	// Name is the name of the cloud like AzurePublic
+	Name string
+	// Configuration is the Azure SDK configuration used for authentication and Azure Resource Manager
+	Configuration cloud.Configuration
+	// KeyVaultDNSSuffix is the DNS suffix of keyvaults like vault.azure.net
+	KeyVaultDNSSuffix string
+	// KeyVaultAudience is the audience of tokens for the keyvault data plane like https://vault.azure.net
+	KeyVaultAudience string
+	// ACRLoginServerSuffix is the domain of container registry login servers like azurecr.io
+	ACRLoginServerSuffix string
+	// GraphEndpoint is the base URL of Microsoft Graph
+	GraphEndpoint string
+	// KeyVaultCSICloudName is the cloudName the Azure Key Vault provider of the Secrets Store CSI driver knows the
+	// cloud by like AzurePublicCloud
+	KeyVaultCSICloudName string
+}
+
+// The Azure SDK configurations carry the authority host and the Azure Resource Manager endpoint, which the arm package
+// registers when it's initialized. Only the endpoints the SDK doesn't know about are added here.
+var (
+	AzurePublic = Cloud{
+		Name:                 "AzurePublic",
+		Configuration:        cloud.AzurePublic,
+		KeyVaultDNSSuffix:    "vault.azure.net",
+		KeyVaultAudience:     "https://vault.azure.net",
+		ACRLoginServerSuffix: "azurecr.io",
+		GraphEndpoint:        "https://graph.microsoft.com",
+		KeyVaultCSICloudName: "AzurePublicCloud",
+	}
+	AzureGovernment = Cloud{
+		Name:                 "AzureGovernment",
+		Configuration:        cloud.AzureGovernment,
+		KeyVaultDNSSuffix:    "vault.usgovcloudapi.net",
+		KeyVaultAudience:     "https://vault.usgovcloudapi.net",
+		ACRLoginServerSuffix: "azurecr.us",
+		GraphEndpoint:        "https://graph.microsoft.us",
+		KeyVaultCSICloudName: "AzureUSGovernmentCloud",
+	}
+	AzureChina = Cloud{
+		Name:                 "AzureChina",
+		Configuration:        cloud.AzureChina,
+		KeyVaultDNSSuffix:    "vault.azure.cn",
+		KeyVaultAudience:     "https://vault.azure.cn",
+		ACRLoginServerSuffix: "azurecr.cn",
+		GraphEndpoint:        "https://microsoftgraph.chinacloudapi.cn",
+		KeyVaultCSICloudName: "AzureChinaCloud",
+	}
+
+	// current is the cloud every Azure call is made against
+	current = AzurePublic
+)
+
+// customCloud is the format of a custom cloud endpoint metadata file like one used for Azure Stack
+type customCloud struct {
+	Name                         string `json:"name"`
+	ActiveDirectoryAuthorityHost string `json:"activeDirectoryAuthorityHost"`
+	ResourceManagerEndpoint      string `json:"resourceManagerEndpoint"`
+	ResourceManagerAudience      string `json:"resourceManagerAudience"`
+	KeyVaultDNSSuffix            string `json:"keyVaultDNSSuffix"`
+	ACRLoginServerSuffix         string `json:"acrLoginServerSuffix"`
+	GraphEndpoint                string `json:"microsoftGraphEndpoint"`
+}
+
+// ParseCloud returns the cloud for name which is AzurePublic, AzureGovernment, AzureChina, or the path to a
+// custom cloud endpoint metadata file
+func ParseCloud(name string) (Cloud, error) {
+	for _, c := range []Cloud{AzurePublic, AzureGovernment, AzureChina} {
+		if strings.EqualFold(name, c.Name) {
+			return c, nil
+		}
+	}
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Cloud{}, fmt.Errorf("cloud %s should be AzurePublic, AzureGovernment, AzureChina, or the path to an endpoint metadata file", name)
+		}
+
+		return Cloud{}, fmt.Errorf("reading cloud endpoint metadata file: %w", err)
+	}
+
+	var cc customCloud
+	if err := json.Unmarshal(b, &cc); err != nil {
+		return Cloud{}, fmt.Errorf("decoding cloud endpoint metadata file %s: %w", name, err)
+	}
+
+	required := map[string]string{
+		"activeDirectoryAuthorityHost": cc.ActiveDirectoryAuthorityHost,
+		"resourceManagerEndpoint":      cc.ResourceManagerEndpoint,
+		"resourceManagerAudience":      cc.ResourceManagerAudience,
+		"keyVaultDNSSuffix":            cc.KeyVaultDNSSuffix,
+		"acrLoginServerSuffix":         cc.ACRLoginServerSuffix,
+		"microsoftGraphEndpoint":       cc.GraphEndpoint,
+	}
+	for field, value := range required {
+		if value == "" {
+			return Cloud{}, fmt.Errorf("cloud endpoint metadata file %s is missing %s", name, field)
+		}
+	}
+
+	if cc.Name == "" {
+		cc.Name = name
+	}
+
+	return Cloud{
+		Name: cc.Name,
+		Configuration: cloud.Configuration{
+			ActiveDirectoryAuthorityHost: cc.ActiveDirectoryAuthorityHost,
+			Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+				cloud.ResourceManager: {
+					Audience: cc.ResourceManagerAudience,
+					Endpoint: cc.ResourceManagerEndpoint,
+				},
+			},
+		},
+		KeyVaultDNSSuffix:    strings.TrimPrefix(cc.KeyVaultDNSSuffix, "."),
+		KeyVaultAudience:     "https://" + strings.TrimPrefix(cc.KeyVaultDNSSuffix, "."),
+		ACRLoginServerSuffix: strings.TrimPrefix(cc.ACRLoginServerSuffix, "."),
+		GraphEndpoint:        strings.TrimSuffix(cc.GraphEndpoint, "/"),
+		// the provider reads the endpoints of custom clouds from a file on the nodes like it does for Azure Stack
+		KeyVaultCSICloudName: "AzureStackCloud",
+	}, nil
+}
+
+// SetCloud sets the cloud every Azure call is made against. Credentials created for a previous cloud are discarded.
+func SetCloud(c Cloud) {
+	current = c
+	cred = nil
+}
+
+// CurrentCloud returns the cloud every Azure call is made against
+func CurrentCloud() Cloud {
+	return current
+}
+
+// LoginServer returns the login server of the container registry
+func LoginServer(registry string) string {
+	return strings.ToLower(registry) + "." + current.ACRLoginServerSuffix
+}
+
+// VaultUri returns the uri of the keyvault
+func VaultUri(name string) string {
+	return fmt.Sprintf("https://%s.%s/", name, current.KeyVaultDNSSuffix)
+}
+
+// armOptions returns the client options every Azure Resource Manager client should use
+func armOptions() *arm.ClientOptions {
+	return &arm.ClientOptions{
+		ClientOptions: policy.ClientOptions{
+			Cloud: current.Configuration,
+		},
+	}
+}
+
+// armScope returns the token scope for Azure Resource Manager
+func armScope() string {
+	return strings.TrimSuffix(current.Configuration.Services[cloud.ResourceManager].Audience, "/") + "/.default"
+}
+
+// keyVaultScope returns the token scope for the keyvault data plane
+func keyVaultScope() string {
+	return strings.TrimSuffix(current.KeyVaultAudience, "/") + "/.default"
+}
+
+// secretsOptions returns the client options every keyvault secrets client should use
+func secretsOptions() *azsecrets.ClientOptions {
+	return &azsecrets.ClientOptions{
+		ClientOptions: armOptions().ClientOptions,
+	}
+}
@@ -0,0 +1,67 @@
+package azure
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	. "github.com/onsi/gomega"
+)
+
+func TestParseCloud(t *testing.T) {
+	g := NewWithT(t)
+
+	c, err := ParseCloud("azuregovernment")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.Name).To(Equal("AzureGovernment"))
+
+	_, err = ParseCloud("AzureMoon")
+	g.Expect(err).To(MatchError(ContainSubstring("should be AzurePublic, AzureGovernment, AzureChina")))
+}
+
+func TestSovereignClouds(t *testing.T) {
+	g := NewWithT(t)
+	t.Cleanup(func() { SetCloud(AzurePublic) })
+
+	SetCloud(AzureGovernment)
+	g.Expect(armOptions().Cloud.ActiveDirectoryAuthorityHost).To(Equal(cloud.AzureGovernment.ActiveDirectoryAuthorityHost))
+	g.Expect(armScope()).To(Equal("https://management.core.usgovcloudapi.net/.default"))
+	g.Expect(keyVaultScope()).To(Equal("https://vault.usgovcloudapi.net/.default"))
+	g.Expect(secretsOptions().Cloud.Services[cloud.ResourceManager].Endpoint).To(Equal("https://management.usgovcloudapi.net"))
+
+	SetCloud(AzureChina)
+	g.Expect(armScope()).To(Equal("https://management.core.chinacloudapi.cn/.default"))
+	g.Expect(keyVaultScope()).To(Equal("https://vault.azure.cn/.default"))
+	g.Expect(secretsOptions().Cloud.ActiveDirectoryAuthorityHost).To(Equal("https://login.chinacloudapi.cn/"))
+}
+
+func TestParseCloudCustom(t *testing.T) {
+	g := NewWithT(t)
+	path := filepath.Join(t.TempDir(), "stack.json")
+	g.Expect(os.WriteFile(path, []byte(`{
+	"name": "AzureStack",
+	"activeDirectoryAuthorityHost": "https://login.stack.example/",
+	"resourceManagerEndpoint": "https://management.stack.example",
+	"resourceManagerAudience": "https://management.stack.example/",
+	"keyVaultDNSSuffix": ".vault.stack.example",
+	"acrLoginServerSuffix": "acr.stack.example",
+	"microsoftGraphEndpoint": "https://graph.stack.example/"
+}`), 0644)).To(Succeed())
+
+	c, err := ParseCloud(path)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	t.Cleanup(func() { SetCloud(AzurePublic) })
+	SetCloud(c)
+	g.Expect(CurrentCloud().Name).To(Equal("AzureStack"))
+	g.Expect(LoginServer("MyAcr")).To(Equal("myacr.acr.stack.example"))
+	g.Expect(VaultUri("kv")).To(Equal("https://kv.vault.stack.example/"))
+	g.Expect(armScope()).To(Equal("https://management.stack.example/.default"))
+	g.Expect(keyVaultScope()).To(Equal("https://vault.stack.example/.default"))
+	g.Expect(CurrentCloud().GraphEndpoint).To(Equal("https://graph.stack.example"))
+
+	g.Expect(os.WriteFile(path, []byte(`{"name": "AzureStack"}`), 0644)).To(Succeed())
+	_, err = ParseCloud(path)
+	g.Expect(err).To(MatchError(ContainSubstring("is missing")))
+}
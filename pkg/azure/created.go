@@ -0,0 +1,266 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/keyvault/armkeyvault"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/state"
+)
+
+const (
+	// CreatedByTag is the tag on every Azure resource the plugin creates
+	CreatedByTag = "created-by"
+	// CreatedByValue is the value of CreatedByTag
+	CreatedByValue = "aks-spin-plugin"
+)
+
+// ErrNotFound is wrapped by errors of providers for resources that don't exist
+var ErrNotFound = errors.New("resource not found")
+
+func createdByTags() map[string]*string {
+	return map[string]*string{
+		CreatedByTag: to.Ptr(CreatedByValue),
+	}
+}
+
+// CreatedResources returns the ids of the Azure resources the plugin created, recorded in state as they're created
+func CreatedResources(ctx context.Context) ([]string, error) {
+	s, err := state.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("loading state: %w", err)
+	}
+
+	return s.Created, nil
+}
+
+// WasCreated returns true if the resource is recorded as created by the plugin
+func WasCreated(ctx context.Context, id string) (bool, error) {
+	ids, err := CreatedResources(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return containsId(ids, id), nil
+}
+
+// RecordCreated records the resource as created by the plugin
+func RecordCreated(ctx context.Context, id string) error {
+	err := state.Update(ctx, func(s *state.State) error {
+		if !containsId(s.Created, id) {
+			s.Created = append(s.Created, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("recording created resource: %w", err)
+	}
+
+	return nil
+}
+
+// ForgetCreated removes the resource from the resources recorded as created by the plugin
+func ForgetCreated(ctx context.Context, id string) error {
+	err := state.Update(ctx, func(s *state.State) error {
+		kept := make([]string, 0, len(s.Created))
+		for _, created := range s.Created {
+			if !strings.EqualFold(created, id) {
+				kept = append(kept, created)
+			}
+		}
+		s.Created = kept
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("forgetting created resource: %w", err)
+	}
+
+	return nil
+}
+
+// containsId returns true if the ids contain the id. Azure resource ids are case-insensitive.
+func containsId(ids []string, id string) bool {
+	for _, i := range ids {
+		if strings.EqualFold(i, id) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isNotFound returns true if the error is Azure Resource Manager responding that the resource doesn't exist
+func isNotFound(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
+
+// GetTags returns the tags of a resource group or resource. The error wraps ErrNotFound if it doesn't exist.
+func GetTags(ctx context.Context, id string) (map[string]string, error) {
+	parsed, err := arm.ParseResourceID(id)
+	if err != nil {
+		return nil, fmt.Errorf("parsing resource id: %w", err)
+	}
+
+	cred, err := getCred()
+	if err != nil {
+		return nil, fmt.Errorf("getting az credentials: %w", err)
+	}
+
+	client, err := armresources.NewTagsClient(parsed.SubscriptionID, cred, armOptions())
+	if err != nil {
+		return nil, fmt.Errorf("creating tags client: %w", err)
+	}
+
+	resp, err := client.GetAtScope(ctx, id, nil)
+	if isNotFound(err) {
+		return nil, fmt.Errorf("getting tags of %s: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting tags: %w", err)
+	}
+
+	tags := map[string]string{}
+	if resp.Properties != nil {
+		for k, v := range resp.Properties.Tags {
+			if v != nil {
+				tags[k] = *v
+			}
+		}
+	}
+
+	return tags, nil
+}
+
+// ListResourceIds returns the ids of every resource in the resource group
+func ListResourceIds(ctx context.Context, subscriptionId, resourceGroup string) ([]string, error) {
+	cred, err := getCred()
+	if err != nil {
+		return nil, fmt.Errorf("getting az credentials: %w", err)
+	}
+
+	client, err := armresources.NewClient(subscriptionId, cred, armOptions())
+	if err != nil {
+		return nil, fmt.Errorf("creating resources client: %w", err)
+	}
+
+	var ids []string
+	pager := client.NewListByResourceGroupPager(resourceGroup, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing resources page: %w", err)
+		}
+
+		for _, r := range page.Value {
+			if r != nil && r.ID != nil {
+				ids = append(ids, *r.ID)
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+// DeleteResource deletes a resource group, cluster, container registry, key vault, or managed identity and forgets
+// that the plugin created it
+func DeleteResource(ctx context.Context, id string) error {
+	lgr := logger.FromContext(ctx).With("id", id)
+	ctx = logger.WithContext(ctx, lgr)
+	lgr.Debug("deleting Azure resource")
+
+	parsed, err := arm.ParseResourceID(id)
+	if err != nil {
+		return fmt.Errorf("parsing resource id: %w", err)
+	}
+
+	resourceType := strings.ToLower(parsed.ResourceType.String())
+	if planned(ctx, dryrun.Change{Action: dryrun.Delete, Kind: parsed.ResourceType.String(), Target: id}) {
+		return nil
+	}
+
+	cred, err := getCred()
+	if err != nil {
+		return fmt.Errorf("getting az credentials: %w", err)
+	}
+
+	sub, rg, name := parsed.SubscriptionID, parsed.ResourceGroupName, parsed.Name
+	switch resourceType {
+	case "microsoft.resources/resourcegroups":
+		client, err := armresources.NewResourceGroupsClient(sub, cred, armOptions())
+		if err != nil {
+			return fmt.Errorf("creating resource groups client: %w", err)
+		}
+
+		poll, err := client.BeginDelete(ctx, name, nil)
+		if err != nil {
+			return fmt.Errorf("starting to delete resource group: %w", err)
+		}
+		if _, err := pollWithLog(ctx, poll, "still deleting resource group"); err != nil {
+			return fmt.Errorf("deleting resource group: %w", err)
+		}
+	case "microsoft.containerservice/managedclusters":
+		factory, err := aksFactory(sub)
+		if err != nil {
+			return fmt.Errorf("getting aks client: %w", err)
+		}
+
+		poll, err := factory.NewManagedClustersClient().BeginDelete(ctx, rg, name, nil)
+		if err != nil {
+			return fmt.Errorf("starting to delete cluster: %w", err)
+		}
+		if _, err := pollWithLog(ctx, poll, "still deleting managed cluster"); err != nil {
+			return fmt.Errorf("deleting cluster: %w", err)
+		}
+	case "microsoft.containerregistry/registries":
+		factory, err := acrFactory(sub)
+		if err != nil {
+			return fmt.Errorf("getting acr factory: %w", err)
+		}
+
+		poll, err := factory.NewRegistriesClient().BeginDelete(ctx, rg, name, nil)
+		if err != nil {
+			return fmt.Errorf("starting to delete container registry: %w", err)
+		}
+		if _, err := pollWithLog(ctx, poll, "still deleting container registry"); err != nil {
+			return fmt.Errorf("deleting container registry: %w", err)
+		}
+	case "microsoft.keyvault/vaults":
+		factory, err := armkeyvault.NewClientFactory(sub, cred, armOptions())
+		if err != nil {
+			return fmt.Errorf("creating client factory: %w", err)
+		}
+
+		// the vault is soft deleted and can be recovered until it's purged
+		if _, err := factory.NewVaultsClient().Delete(ctx, rg, name, nil); err != nil {
+			return fmt.Errorf("deleting key vault: %w", err)
+		}
+	case "microsoft.managedidentity/userassignedidentities":
+		factory, err := identityFactory(sub)
+		if err != nil {
+			return fmt.Errorf("getting identity client: %w", err)
+		}
+
+		if _, err := factory.NewUserAssignedIdentitiesClient().Delete(ctx, rg, name, nil); err != nil {
+			return fmt.Errorf("deleting identity: %w", err)
+		}
+	default:
+		return fmt.Errorf("deleting resources of type %s isn't supported", parsed.ResourceType)
+	}
+
+	if err := ForgetCreated(ctx, id); err != nil {
+		return fmt.Errorf("forgetting created resource: %w", err)
+	}
+
+	lgr.Debug("finished deleting Azure resource")
+	return nil
+}
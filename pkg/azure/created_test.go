@@ -0,0 +1,41 @@
+package azure
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRecordCreated(t *testing.T) {
+	g := NewWithT(t)
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_DATA_HOME", dir)
+	ctx := context.Background()
+
+	rg := ResourceGroupScope("sub", "rg")
+	cluster := ClusterScope("sub", "rg", "cluster")
+
+	created, err := WasCreated(ctx, rg)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(created).To(BeFalse())
+
+	g.Expect(RecordCreated(ctx, rg)).To(Succeed())
+	g.Expect(RecordCreated(ctx, cluster)).To(Succeed())
+	g.Expect(RecordCreated(ctx, cluster)).To(Succeed())
+
+	ids, err := CreatedResources(ctx)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ids).To(Equal([]string{rg, cluster}))
+
+	// resource ids are case insensitive
+	created, err = WasCreated(ctx, "/SUBSCRIPTIONS/sub/resourceGroups/RG")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(created).To(BeTrue())
+
+	g.Expect(ForgetCreated(ctx, rg)).To(Succeed())
+	ids, err = CreatedResources(ctx)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ids).To(Equal([]string{cluster}))
+}
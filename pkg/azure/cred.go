@@ -5,7 +5,6 @@ import (
 	"fmt"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/azure/spin-aks-plugin/pkg/usererror"
@@ -22,7 +21,12 @@ func getCred() (*azidentity.DefaultAzureCredential, error) {
 	}
 
 	var err error
-	cred, err = azidentity.NewDefaultAzureCredential(nil)
+	cred, err = azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+		ClientOptions: azcore.ClientOptions{
+			Cloud: current.Configuration,
+		},
+		TenantID: tenantId,
+	})
 	if err != nil {
 		return nil, usererror.New(fmt.Errorf("authenticating to Azure: %w", err), "Unable to authenticate to Azure. Try running \"az login\".")
 	}
@@ -33,8 +37,7 @@ func getCred() (*azidentity.DefaultAzureCredential, error) {
 // adapted from https://stackoverflow.com/a/75658185
 func getObjectId(ctx context.Context, cred azcore.TokenCredential) (string, error) {
 	token, err := cred.GetToken(ctx, policy.TokenRequestOptions{
-		// okay to hardcode to PublicCloud since we should never deploy to anything else in public OSS repo
-		Scopes: []string{cloud.AzurePublic.Services[cloud.ResourceManager].Endpoint + "/.default"},
+		Scopes: []string{armScope()},
 	})
 	if err != nil {
 		return "", fmt.Errorf("getting token: %w", err)
@@ -0,0 +1,65 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+)
+
+const (
+	resourceGroupIdTemplate    = "/subscriptions/%s/resourceGroups/%s"
+	clusterResourceIdTemplate  = "/subscriptions/%s/resourceGroups/%s/providers/Microsoft.ContainerService/managedClusters/%s"
+	keyVaultResourceIdTemplate = "/subscriptions/%s/resourceGroups/%s/providers/Microsoft.KeyVault/vaults/%s"
+
+	// plannedPrincipalId is used in place of the principal id of a cluster that only exists in a dry run plan
+	plannedPrincipalId = "<principal id of new cluster>"
+	// plannedOidcIssuer is used in place of the OIDC issuer url of a cluster that only has it enabled in a dry run plan
+	plannedOidcIssuer = "<oidc issuer of cluster>"
+)
+
+// planned records the change on the dry run plan if the context is a dry run. Mutating functions should
+// return early without calling Azure when planned returns true.
+func planned(ctx context.Context, c dryrun.Change) bool {
+	p, ok := dryrun.FromContext(ctx)
+	if !ok {
+		return false
+	}
+
+	logger.FromContext(ctx).Debug("dry run, recording change instead of applying it", "change", c.String())
+	p.Record(c)
+	return true
+}
+
+// isPlanned returns true if the resource is only created in the dry run plan and doesn't exist yet
+func isPlanned(ctx context.Context, id string) bool {
+	p, ok := dryrun.FromContext(ctx)
+	if !ok {
+		return false
+	}
+
+	return p.Creates(id)
+}
+
+// isPlannedPrincipal returns true if the object id is a placeholder for a principal that's only created in the dry
+// run plan
+func isPlannedPrincipal(objectId string) bool {
+	return objectId == plannedPrincipalId || objectId == plannedIdentityPrincipalId
+}
+
+func resourceGroupId(subscriptionId, resourceGroup string) string {
+	return fmt.Sprintf(resourceGroupIdTemplate, subscriptionId, resourceGroup)
+}
+
+func clusterId(subscriptionId, resourceGroup, name string) string {
+	return fmt.Sprintf(clusterResourceIdTemplate, subscriptionId, resourceGroup, name)
+}
+
+func acrId(subscriptionId, resourceGroup, name string) string {
+	return fmt.Sprintf(acrResourceIdTemplate, subscriptionId, resourceGroup, name)
+}
+
+func keyVaultId(subscriptionId, resourceGroup, name string) string {
+	return fmt.Sprintf(keyVaultResourceIdTemplate, subscriptionId, resourceGroup, name)
+}
@@ -0,0 +1,1044 @@
+// Package fake provides an in-memory azure.Provider so flows that configure Azure can be tested offline.
+// Resources are seeded with the Add functions and everything created through the Provider is stored in memory.
+package fake
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerregistry/armcontainerregistry"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/keyvault/armkeyvault"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/subscription/armsubscription"
+	"github.com/azure/spin-aks-plugin/pkg/azure"
+)
+
+const (
+	// ObjectId is the object id of the signed-in user
+	ObjectId = "00000000-0000-0000-0000-00000000user"
+	// TenantId is the id of the home tenant of the signed-in user. It's the only tenant unless more are added.
+	TenantId = "00000000-0000-0000-0000-000000tenant"
+	// RegistryPassword is the password of every container registry
+	RegistryPassword = "fake-registry-refresh-token"
+	// TenantName is the display name of the home tenant
+	TenantName = "Home Tenant"
+
+	acrPullRoleDefinition = "/providers/Microsoft.Authorization/roleDefinitions/7f951dda-4ed3-4680-a7ca-43fe172d538d"
+)
+
+// ErrNotFound is returned when a resource doesn't exist in the fake
+var ErrNotFound = azure.ErrNotFound
+
+// FederatedCredential is a federated identity credential that was added to an identity
+type FederatedCredential struct {
+	Name    string
+	Issuer  string
+	Subject string
+}
+
+// AccessPolicy is an access policy that was added to a keyvault
+type AccessPolicy struct {
+	ObjectId    string
+	Permissions armkeyvault.Permissions
+}
+
+// Provider is an in-memory azure.Provider. It's safe for concurrent use.
+type Provider struct {
+	mu sync.Mutex
+
+	subscriptions   []armsubscription.Subscription
+	subTenants      map[string]string
+	tenants         []armsubscriptions.TenantIDDescription
+	locations       []armsubscriptions.Location
+	resourceGroups  map[string]armresources.ResourceGroup
+	clusters        map[string]armcontainerservice.ManagedCluster
+	registries      map[string]armcontainerregistry.Registry
+	keyVaults       map[string]azure.Akv
+	accessPolicies  map[string][]AccessPolicy
+	identities      map[string]azure.Identity
+	federated       map[string][]FederatedCredential
+	oidcIssuers     map[string]string
+	workloadIds     map[string]bool
+	roleAssignments []armauthorization.RoleAssignment
+	denied          map[string][]string
+	tags            map[string]map[string]string
+	keys            map[string]*ecdsa.PrivateKey
+	secrets         map[string]string
+}
+
+var _ azure.Provider = &Provider{}
+
+// New returns an empty Provider with a single home tenant and the eastus location
+func New() *Provider {
+	return &Provider{
+		subTenants: map[string]string{},
+		tenants: []armsubscriptions.TenantIDDescription{
+			{
+				ID:             to.Ptr("/tenants/" + TenantId),
+				TenantID:       to.Ptr(TenantId),
+				DisplayName:    to.Ptr(TenantName),
+				TenantCategory: to.Ptr(armsubscriptions.TenantCategoryHome),
+			},
+		},
+		locations: []armsubscriptions.Location{
+			{
+				Name:        to.Ptr("eastus"),
+				DisplayName: to.Ptr("East US"),
+			},
+		},
+		resourceGroups: map[string]armresources.ResourceGroup{},
+		clusters:       map[string]armcontainerservice.ManagedCluster{},
+		registries:     map[string]armcontainerregistry.Registry{},
+		keyVaults:      map[string]azure.Akv{},
+		accessPolicies: map[string][]AccessPolicy{},
+		identities:     map[string]azure.Identity{},
+		federated:      map[string][]FederatedCredential{},
+		oidcIssuers:    map[string]string{},
+		workloadIds:    map[string]bool{},
+		denied:         map[string][]string{},
+		tags:           map[string]map[string]string{},
+		keys:           map[string]*ecdsa.PrivateKey{},
+		secrets:        map[string]string{},
+	}
+}
+
+// AddTenant seeds a tenant the signed-in user is a guest in
+func (p *Provider) AddTenant(id, displayName string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.tenants = append(p.tenants, armsubscriptions.TenantIDDescription{
+		ID:             to.Ptr("/tenants/" + id),
+		TenantID:       to.Ptr(id),
+		DisplayName:    to.Ptr(displayName),
+		TenantCategory: to.Ptr(armsubscriptions.TenantCategoryProjectedBy),
+	})
+}
+
+// AddSubscription seeds a subscription in the home tenant
+func (p *Provider) AddSubscription(id, displayName string) {
+	p.AddTenantSubscription(TenantId, id, displayName)
+}
+
+// AddTenantSubscription seeds a subscription in a tenant
+func (p *Provider) AddTenantSubscription(tenantId, id, displayName string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.subscriptions = append(p.subscriptions, armsubscription.Subscription{
+		ID:             to.Ptr("/subscriptions/" + id),
+		SubscriptionID: to.Ptr(id),
+		DisplayName:    to.Ptr(displayName),
+	})
+	p.subTenants[id] = tenantId
+}
+
+// AddResourceGroup seeds a resource group
+func (p *Provider) AddResourceGroup(subscriptionId, name, location string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.addResourceGroup(subscriptionId, name, location)
+}
+
+// AddCluster seeds a cluster with a system-assigned identity. The principal id of the identity is ClusterPrincipalId(name).
+func (p *Provider) AddCluster(subscriptionId, resourceGroup, name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.addCluster(subscriptionId, resourceGroup, name, "eastus")
+}
+
+// AddContainerRegistry seeds a container registry
+func (p *Provider) AddContainerRegistry(subscriptionId, resourceGroup, name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.addContainerRegistry(subscriptionId, resourceGroup, name, "eastus")
+}
+
+// AddKeyVault seeds a keyvault using access policies in the tenant of the subscription
+func (p *Provider) AddKeyVault(subscriptionId, resourceGroup, name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.addKeyVault(p.subscriptionTenant(subscriptionId), subscriptionId, resourceGroup, name, false)
+}
+
+// AddKeyVaultKey seeds a P-256 EC key in a seeded keyvault and returns its public key
+func (p *Provider) AddKeyVaultKey(subscriptionId, resourceGroup, vaultName, keyName string) *ecdsa.PublicKey {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("generating key: %s", err))
+	}
+
+	p.keys[strings.ToLower(KeyVaultId(subscriptionId, resourceGroup, vaultName)+"/keys/"+keyName)] = key
+	return &key.PublicKey
+}
+
+// AddRbacKeyVault seeds a keyvault using Azure RBAC in the tenant of the subscription
+func (p *Provider) AddRbacKeyVault(subscriptionId, resourceGroup, name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.addKeyVault(p.subscriptionTenant(subscriptionId), subscriptionId, resourceGroup, name, true)
+}
+
+// OidcIssuer returns the OIDC issuer url of a cluster in the fake once it's enabled
+func OidcIssuer(clusterName string) string {
+	return "https://oidc.example.com/" + clusterName + "/"
+}
+
+// WorkloadIdentityEnabled returns true if the workload identity webhook of the cluster with the resource id is enabled
+func (p *Provider) WorkloadIdentityEnabled(clusterId string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.workloadIds[strings.ToLower(clusterId)]
+}
+
+// IdentityClientId returns the client id of an identity created in the fake
+func IdentityClientId(name string) string {
+	return "client-" + name
+}
+
+// IdentityPrincipalId returns the principal id of an identity created in the fake
+func IdentityPrincipalId(name string) string {
+	return "principal-identity-" + name
+}
+
+// IdentityId returns the resource id of a user-assigned managed identity
+func IdentityId(subscriptionId, resourceGroup, name string) string {
+	return ResourceGroupId(subscriptionId, resourceGroup) + "/providers/Microsoft.ManagedIdentity/userAssignedIdentities/" + name
+}
+
+// FederatedCredentials returns the federated identity credentials added to the identity with the resource id
+func (p *Provider) FederatedCredentials(identityId string) []FederatedCredential {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ret := make([]FederatedCredential, len(p.federated[strings.ToLower(identityId)]))
+	copy(ret, p.federated[strings.ToLower(identityId)])
+	return ret
+}
+
+// ClusterPrincipalId returns the principal id of the identity of a cluster created in the fake
+func ClusterPrincipalId(name string) string {
+	return "principal-" + name
+}
+
+// Exists returns true if a resource group, cluster, container registry, keyvault, or identity with the resource id exists
+func (p *Provider) Exists(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.exists(id)
+}
+
+// AccessPolicies returns the access policies added to the keyvault with the resource id
+func (p *Provider) AccessPolicies(keyVaultId string) []AccessPolicy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ret := make([]AccessPolicy, len(p.accessPolicies[strings.ToLower(keyVaultId)]))
+	copy(ret, p.accessPolicies[strings.ToLower(keyVaultId)])
+	return ret
+}
+
+// RoleAssignments returns the role assignments with exactly the scope
+func (p *Provider) RoleAssignments(scope string) []armauthorization.RoleAssignment {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var ret []armauthorization.RoleAssignment
+	for _, ra := range p.roleAssignments {
+		if strings.EqualFold(*ra.Properties.Scope, scope) {
+			ret = append(ret, ra)
+		}
+	}
+
+	return ret
+}
+
+// ResourceGroupId returns the resource id of a resource group
+func ResourceGroupId(subscriptionId, resourceGroup string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", subscriptionId, resourceGroup)
+}
+
+// ClusterId returns the resource id of a cluster
+func ClusterId(subscriptionId, resourceGroup, name string) string {
+	return ResourceGroupId(subscriptionId, resourceGroup) + "/providers/Microsoft.ContainerService/managedClusters/" + name
+}
+
+// ContainerRegistryId returns the resource id of a container registry
+func ContainerRegistryId(subscriptionId, resourceGroup, name string) string {
+	return ResourceGroupId(subscriptionId, resourceGroup) + "/providers/Microsoft.ContainerRegistry/registries/" + name
+}
+
+// KeyVaultId returns the resource id of a keyvault
+func KeyVaultId(subscriptionId, resourceGroup, name string) string {
+	return ResourceGroupId(subscriptionId, resourceGroup) + "/providers/Microsoft.KeyVault/vaults/" + name
+}
+
+func (p *Provider) ListSubscriptions(_ context.Context) ([]armsubscription.Subscription, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ret := make([]armsubscription.Subscription, len(p.subscriptions))
+	copy(ret, p.subscriptions)
+	return ret, nil
+}
+
+func (p *Provider) GetSubscription(_ context.Context, subscriptionId string) (*armsubscriptions.Subscription, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, s := range p.subscriptions {
+		if *s.SubscriptionID == subscriptionId {
+			return &armsubscriptions.Subscription{
+				ID:             s.ID,
+				SubscriptionID: s.SubscriptionID,
+				DisplayName:    s.DisplayName,
+				TenantID:       to.Ptr(p.subTenants[subscriptionId]),
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("subscription %s: %w", subscriptionId, ErrNotFound)
+}
+
+func (p *Provider) ListTenants(_ context.Context) ([]armsubscriptions.TenantIDDescription, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ret := make([]armsubscriptions.TenantIDDescription, len(p.tenants))
+	copy(ret, p.tenants)
+	return ret, nil
+}
+
+func (p *Provider) ListLocations(_ context.Context, _ string) ([]armsubscriptions.Location, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ret := make([]armsubscriptions.Location, len(p.locations))
+	copy(ret, p.locations)
+	return ret, nil
+}
+
+func (p *Provider) ListResourceGroups(_ context.Context, subscriptionId string) ([]armresources.ResourceGroup, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return list(p.resourceGroups, "/subscriptions/"+subscriptionId+"/resourcegroups/"), nil
+}
+
+func (p *Provider) NewResourceGroup(ctx context.Context, subscriptionId, name, location string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// like Azure, existing resources are left unchanged and aren't recorded as created
+	if _, ok := p.resourceGroups[strings.ToLower(ResourceGroupId(subscriptionId, name))]; ok {
+		return nil
+	}
+
+	p.addResourceGroup(subscriptionId, name, location)
+	return p.created(ctx, ResourceGroupId(subscriptionId, name))
+}
+
+func (p *Provider) ListClusters(_ context.Context, subscriptionId, resourceGroup string) ([]armcontainerservice.ManagedCluster, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return list(p.clusters, ResourceGroupId(subscriptionId, resourceGroup)+"/providers/"), nil
+}
+
+func (p *Provider) GetManagedCluster(_ context.Context, subscriptionId, resourceGroup, name string) (armcontainerservice.ManagedCluster, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	mc, ok := p.clusters[strings.ToLower(ClusterId(subscriptionId, resourceGroup, name))]
+	if !ok {
+		return mc, fmt.Errorf("getting managed cluster %s: %w", name, ErrNotFound)
+	}
+
+	return mc, nil
+}
+
+func (p *Provider) NewCluster(ctx context.Context, subscriptionId, resourceGroup, name, location string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.resourceGroups[strings.ToLower(ResourceGroupId(subscriptionId, resourceGroup))]; !ok {
+		return fmt.Errorf("creating cluster in resource group %s: %w", resourceGroup, ErrNotFound)
+	}
+
+	if _, ok := p.clusters[strings.ToLower(ClusterId(subscriptionId, resourceGroup, name))]; ok {
+		return nil
+	}
+
+	p.addCluster(subscriptionId, resourceGroup, name, location)
+	return p.created(ctx, ClusterId(subscriptionId, resourceGroup, name))
+}
+
+func (p *Provider) EnableKeyvaultCSIDriver(_ context.Context, subscriptionId, resourceGroup, name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := strings.ToLower(ClusterId(subscriptionId, resourceGroup, name))
+	mc, ok := p.clusters[key]
+	if !ok {
+		return fmt.Errorf("enabling csi driver on cluster %s: %w", name, ErrNotFound)
+	}
+
+	if mc.Properties.AddonProfiles == nil {
+		mc.Properties.AddonProfiles = map[string]*armcontainerservice.ManagedClusterAddonProfile{}
+	}
+	mc.Properties.AddonProfiles["azureKeyvaultSecretsProvider"] = &armcontainerservice.ManagedClusterAddonProfile{
+		Enabled: to.Ptr(true),
+	}
+	p.clusters[key] = mc
+	return nil
+}
+
+func (p *Provider) LinkAcr(_ context.Context, subscriptionId, clusterResourceGroup, clusterName, acrSubscriptionId, acrResourceGroup, acrName string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	mc, ok := p.clusters[strings.ToLower(ClusterId(subscriptionId, clusterResourceGroup, clusterName))]
+	if !ok {
+		return fmt.Errorf("linking acr to cluster %s: %w", clusterName, ErrNotFound)
+	}
+
+	scope := ContainerRegistryId(acrSubscriptionId, acrResourceGroup, acrName)
+	for _, ra := range p.roleAssignments {
+		if strings.EqualFold(*ra.Properties.Scope, scope) && *ra.Properties.PrincipalID == *mc.Identity.PrincipalID &&
+			strings.HasSuffix(*ra.Properties.RoleDefinitionID, acrPullRoleDefinition) {
+			return nil
+		}
+	}
+
+	p.roleAssignments = append(p.roleAssignments, armauthorization.RoleAssignment{
+		Properties: &armauthorization.RoleAssignmentProperties{
+			PrincipalID:      mc.Identity.PrincipalID,
+			RoleDefinitionID: to.Ptr(acrPullRoleDefinition),
+			Scope:            to.Ptr(scope),
+		},
+	})
+	return nil
+}
+
+func (p *Provider) EnableWorkloadIdentity(_ context.Context, subscriptionId, resourceGroup, name string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := strings.ToLower(ClusterId(subscriptionId, resourceGroup, name))
+	if _, ok := p.clusters[key]; !ok {
+		return "", fmt.Errorf("enabling workload identity of cluster %s: %w", name, ErrNotFound)
+	}
+
+	p.workloadIds[key] = true
+
+	p.oidcIssuers[key] = OidcIssuer(name)
+	return p.oidcIssuers[key], nil
+}
+
+func (p *Provider) GetKubeconfig(_ context.Context, subscriptionId, resourceGroup, name string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.clusters[strings.ToLower(ClusterId(subscriptionId, resourceGroup, name))]; !ok {
+		return nil, fmt.Errorf("getting kubeconfig of cluster %s: %w", name, ErrNotFound)
+	}
+
+	return []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: %[1]s
+  cluster:
+    server: https://%[1]s.hcp.example.com
+contexts:
+- name: %[1]s
+  context:
+    cluster: %[1]s
+    user: user
+current-context: %[1]s
+users:
+- name: user
+  user:
+    token: token
+`, name)), nil
+}
+
+func (p *Provider) GetIdentity(_ context.Context, subscriptionId, resourceGroup, name string) (*azure.Identity, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	identity, ok := p.identities[strings.ToLower(IdentityId(subscriptionId, resourceGroup, name))]
+	if !ok {
+		return nil, fmt.Errorf("getting identity %s: %w", name, ErrNotFound)
+	}
+
+	return &identity, nil
+}
+
+func (p *Provider) NewIdentity(ctx context.Context, subscriptionId, resourceGroup, name, _ string) (*azure.Identity, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.resourceGroups[strings.ToLower(ResourceGroupId(subscriptionId, resourceGroup))]; !ok {
+		return nil, fmt.Errorf("creating identity in resource group %s: %w", resourceGroup, ErrNotFound)
+	}
+
+	id := IdentityId(subscriptionId, resourceGroup, name)
+	identity, ok := p.identities[strings.ToLower(id)]
+	if !ok {
+		identity = azure.Identity{
+			Id:             id,
+			Name:           name,
+			SubscriptionId: subscriptionId,
+			ResourceGroup:  resourceGroup,
+			ClientId:       IdentityClientId(name),
+			PrincipalId:    IdentityPrincipalId(name),
+		}
+		p.identities[strings.ToLower(id)] = identity
+		if err := p.created(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	return &identity, nil
+}
+
+func (p *Provider) AddFederatedCredential(_ context.Context, identity *azure.Identity, name, issuer, subject string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := strings.ToLower(identity.Id)
+	if _, ok := p.identities[key]; !ok {
+		return fmt.Errorf("adding federated credential to identity %s: %w", identity.Name, ErrNotFound)
+	}
+
+	creds := p.federated[key]
+	for i, c := range creds {
+		if c.Name == name {
+			creds[i] = FederatedCredential{Name: name, Issuer: issuer, Subject: subject}
+			return nil
+		}
+	}
+
+	p.federated[key] = append(creds, FederatedCredential{Name: name, Issuer: issuer, Subject: subject})
+	return nil
+}
+
+func (p *Provider) ListContainerRegistries(_ context.Context, subscriptionId, resourceGroup string) ([]armcontainerregistry.Registry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return list(p.registries, ResourceGroupId(subscriptionId, resourceGroup)+"/providers/"), nil
+}
+
+func (p *Provider) NewContainerRegistry(ctx context.Context, subscriptionId, resourceGroup, name, location string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.resourceGroups[strings.ToLower(ResourceGroupId(subscriptionId, resourceGroup))]; !ok {
+		return fmt.Errorf("creating container registry in resource group %s: %w", resourceGroup, ErrNotFound)
+	}
+
+	if p.exists(ContainerRegistryId(subscriptionId, resourceGroup, name)) {
+		return nil
+	}
+
+	p.addContainerRegistry(subscriptionId, resourceGroup, name, location)
+	return p.created(ctx, ContainerRegistryId(subscriptionId, resourceGroup, name))
+}
+
+func (p *Provider) CheckACRPullAccess(_ context.Context, subscriptionId, clusterResourceGroup, clusterName, acrSubscriptionId, acrResourceGroup, acrName string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	mc, ok := p.clusters[strings.ToLower(ClusterId(subscriptionId, clusterResourceGroup, clusterName))]
+	if !ok {
+		return fmt.Errorf("checking acr pull access of cluster %s: %w", clusterName, ErrNotFound)
+	}
+
+	scope := ContainerRegistryId(acrSubscriptionId, acrResourceGroup, acrName)
+	if !p.exists(scope) {
+		return fmt.Errorf("checking acr pull access on container registry %s: %w", acrName, ErrNotFound)
+	}
+
+	for _, ra := range p.roleAssignments {
+		if *ra.Properties.RoleDefinitionID == acrPullRoleDefinition &&
+			strings.EqualFold(*ra.Properties.Scope, scope) &&
+			*ra.Properties.PrincipalID == *mc.Identity.PrincipalID {
+			return nil
+		}
+	}
+
+	return azure.MissingAcrPullErr
+}
+
+func (p *Provider) ListKeyVaults(_ context.Context, subscriptionId, resourceGroup string) ([]azure.Akv, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return list(p.keyVaults, ResourceGroupId(subscriptionId, resourceGroup)+"/providers/"), nil
+}
+
+func (p *Provider) GetKeyVault(_ context.Context, subscriptionId, resourceGroup, name string) (*azure.Akv, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	kv, ok := p.keyVaults[strings.ToLower(KeyVaultId(subscriptionId, resourceGroup, name))]
+	if !ok {
+		return nil, fmt.Errorf("getting keyvault %s: %w", name, ErrNotFound)
+	}
+
+	return &kv, nil
+}
+
+func (p *Provider) NewAkv(ctx context.Context, tenantId, subscriptionId, resourceGroup, name, _ string) (*azure.Akv, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.resourceGroups[strings.ToLower(ResourceGroupId(subscriptionId, resourceGroup))]; !ok {
+		return nil, fmt.Errorf("creating keyvault in resource group %s: %w", resourceGroup, ErrNotFound)
+	}
+
+	if kv, ok := p.keyVaults[strings.ToLower(KeyVaultId(subscriptionId, resourceGroup, name))]; ok {
+		return &kv, nil
+	}
+
+	kv := p.addKeyVault(tenantId, subscriptionId, resourceGroup, name, true)
+	if err := p.created(ctx, kv.Id); err != nil {
+		return nil, err
+	}
+
+	return &kv, nil
+}
+
+func (p *Provider) AddAccessPolicy(_ context.Context, kv *azure.Akv, objectId string, permissions armkeyvault.Permissions) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := strings.ToLower(kv.Id)
+	stored, ok := p.keyVaults[key]
+	if !ok {
+		return fmt.Errorf("adding access policy to keyvault %s: %w", kv.Name, ErrNotFound)
+	}
+	if objectId == "" {
+		return errors.New("object id is empty")
+	}
+	if stored.RbacAuthorization {
+		return fmt.Errorf("keyvault %s uses rbac authorization instead of access policies", kv.Name)
+	}
+
+	// like Azure, only the permissions the principal doesn't already have are added to its access policy
+	for i, existing := range p.accessPolicies[key] {
+		if !strings.EqualFold(existing.ObjectId, objectId) {
+			continue
+		}
+
+		missing := azure.MissingPermissions([]*armkeyvault.AccessPolicyEntry{{
+			TenantID:    to.Ptr(kv.TenantId),
+			ObjectID:    to.Ptr(existing.ObjectId),
+			Permissions: &existing.Permissions,
+		}}, kv.TenantId, objectId, permissions)
+		merged := existing.Permissions
+		merged.Secrets = append(merged.Secrets, missing.Secrets...)
+		merged.Keys = append(merged.Keys, missing.Keys...)
+		merged.Certificates = append(merged.Certificates, missing.Certificates...)
+		merged.Storage = append(merged.Storage, missing.Storage...)
+		p.accessPolicies[key][i].Permissions = merged
+		return nil
+	}
+
+	p.accessPolicies[key] = append(p.accessPolicies[key], AccessPolicy{
+		ObjectId:    objectId,
+		Permissions: permissions,
+	})
+	return nil
+}
+
+func (p *Provider) AddUserAccessPolicy(ctx context.Context, kv *azure.Akv, permissions armkeyvault.Permissions) error {
+	return p.AddAccessPolicy(ctx, kv, ObjectId, permissions)
+}
+
+func (p *Provider) AddRoleAssignment(_ context.Context, kv *azure.Akv, objectId string, role azure.Role) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stored, ok := p.keyVaults[strings.ToLower(kv.Id)]
+	if !ok {
+		return fmt.Errorf("adding role assignment to keyvault %s: %w", kv.Name, ErrNotFound)
+	}
+	if objectId == "" {
+		return errors.New("object id is empty")
+	}
+	if !stored.RbacAuthorization {
+		return fmt.Errorf("keyvault %s uses access policies instead of rbac authorization", kv.Name)
+	}
+
+	for _, ra := range p.roleAssignments {
+		if *ra.Properties.PrincipalID == objectId && *ra.Properties.RoleDefinitionID == role.ID && strings.EqualFold(*ra.Properties.Scope, kv.Id) {
+			return nil
+		}
+	}
+
+	p.roleAssignments = append(p.roleAssignments, armauthorization.RoleAssignment{
+		Properties: &armauthorization.RoleAssignmentProperties{
+			PrincipalID:      to.Ptr(objectId),
+			RoleDefinitionID: to.Ptr(role.ID),
+			Scope:            to.Ptr(kv.Id),
+		},
+	})
+	return nil
+}
+
+func (p *Provider) AddUserRoleAssignment(ctx context.Context, kv *azure.Akv, role azure.Role) error {
+	return p.AddRoleAssignment(ctx, kv, ObjectId, role)
+}
+
+func (p *Provider) ListRoleAssignments(_ context.Context, subscriptionId, resourceGroup string) ([]armauthorization.RoleAssignment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	prefix := strings.ToLower(ResourceGroupId(subscriptionId, resourceGroup))
+	var ret []armauthorization.RoleAssignment
+	for _, ra := range p.roleAssignments {
+		if strings.HasPrefix(strings.ToLower(*ra.Properties.Scope), prefix) {
+			ret = append(ret, ra)
+		}
+	}
+
+	return ret, nil
+}
+
+func (p *Provider) HasSecretAccess(_ context.Context, kv *azure.Akv, objectId string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := strings.ToLower(kv.Id)
+	stored, ok := p.keyVaults[key]
+	if !ok {
+		return false, fmt.Errorf("checking secret access to keyvault %s: %w", kv.Name, ErrNotFound)
+	}
+
+	if stored.RbacAuthorization {
+		for _, ra := range p.roleAssignments {
+			if strings.EqualFold(*ra.Properties.Scope, kv.Id) && *ra.Properties.PrincipalID == objectId &&
+				(*ra.Properties.RoleDefinitionID == azure.KeyVaultSecretsUserRole.ID || *ra.Properties.RoleDefinitionID == azure.KeyVaultSecretsOfficerRole.ID) {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	}
+
+	for _, policy := range p.accessPolicies[key] {
+		if !strings.EqualFold(policy.ObjectId, objectId) {
+			continue
+		}
+
+		missing := azure.MissingPermissions([]*armkeyvault.AccessPolicyEntry{{
+			ObjectID:    to.Ptr(policy.ObjectId),
+			Permissions: &policy.Permissions,
+		}}, kv.TenantId, objectId, armkeyvault.Permissions{
+			Secrets: []*armkeyvault.SecretPermissions{to.Ptr(armkeyvault.SecretPermissionsGet)},
+		})
+		if len(missing.Secrets) == 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (p *Provider) PutSecretIfNewValue(_ context.Context, kv *azure.Akv, name, value string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.keyVaults[strings.ToLower(kv.Id)]; !ok {
+		return fmt.Errorf("putting secret in keyvault %s: %w", kv.Name, ErrNotFound)
+	}
+
+	p.secrets[strings.ToLower(kv.Id+"/secrets/"+name)] = value
+	return nil
+}
+
+// Secret returns the value of the secret in the keyvault. The bool is false if the secret was never put.
+func (p *Provider) Secret(keyVaultId, name string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	value, ok := p.secrets[strings.ToLower(keyVaultId+"/secrets/"+name)]
+	return value, ok
+}
+
+func (p *Provider) GetPublicKey(_ context.Context, kv *azure.Akv, keyName string) (*ecdsa.PublicKey, error) {
+	key, err := p.key(kv, keyName)
+	if err != nil {
+		return nil, fmt.Errorf("getting public key: %w", err)
+	}
+
+	return &key.PublicKey, nil
+}
+
+func (p *Provider) SignWithKey(_ context.Context, kv *azure.Akv, keyName string, digest []byte) ([]byte, error) {
+	key, err := p.key(kv, keyName)
+	if err != nil {
+		return nil, fmt.Errorf("signing: %w", err)
+	}
+
+	return ecdsa.SignASN1(rand.Reader, key, digest)
+}
+
+func (p *Provider) key(kv *azure.Akv, keyName string) (*ecdsa.PrivateKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, ok := p.keys[strings.ToLower(kv.Id+"/keys/"+keyName)]
+	if !ok {
+		return nil, fmt.Errorf("key %s in keyvault %s: %w", keyName, kv.Name, ErrNotFound)
+	}
+
+	return key, nil
+}
+
+// DenyAccess makes the signed-in user miss the action on the scope and every scope below it
+func (p *Provider) DenyAccess(scope, action string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := strings.ToLower(scope)
+	p.denied[key] = append(p.denied[key], action)
+}
+
+func (p *Provider) MissingAccess(_ context.Context, checks []azure.AccessCheck) ([]azure.AccessCheck, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var missing []azure.AccessCheck
+	for _, check := range checks {
+		if p.isDenied(check) {
+			missing = append(missing, check)
+		}
+	}
+
+	return missing, nil
+}
+
+func (p *Provider) isDenied(check azure.AccessCheck) bool {
+	scope := strings.ToLower(check.Scope)
+	for deniedScope, actions := range p.denied {
+		if scope != deniedScope && !strings.HasPrefix(scope, deniedScope+"/") {
+			continue
+		}
+
+		for _, denied := range actions {
+			for _, action := range check.Actions {
+				if strings.EqualFold(denied, action) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func (p *Provider) RegistryCredential(_ context.Context, _ string) (string, string, error) {
+	return azure.RegistryUsername, RegistryPassword, nil
+}
+
+func (p *Provider) SignedInObjectId(_ context.Context) (string, error) {
+	return ObjectId, nil
+}
+
+// SetTags replaces the tags of a seeded resource group or resource
+func (p *Provider) SetTags(id string, tags map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.tags[strings.ToLower(id)] = tags
+}
+
+func (p *Provider) GetTags(_ context.Context, id string) (map[string]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.exists(id) {
+		return nil, fmt.Errorf("getting tags of %s: %w", id, ErrNotFound)
+	}
+
+	tags := map[string]string{}
+	for k, v := range p.tags[strings.ToLower(id)] {
+		tags[k] = v
+	}
+
+	return tags, nil
+}
+
+func (p *Provider) ListResourceIds(_ context.Context, subscriptionId, resourceGroup string) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	prefix := ResourceGroupId(subscriptionId, resourceGroup) + "/providers/"
+	var ids []string
+	for _, c := range list(p.clusters, prefix) {
+		ids = append(ids, *c.ID)
+	}
+	for _, r := range list(p.registries, prefix) {
+		ids = append(ids, *r.ID)
+	}
+	for _, kv := range list(p.keyVaults, prefix) {
+		ids = append(ids, kv.Id)
+	}
+	for _, i := range list(p.identities, prefix) {
+		ids = append(ids, i.Id)
+	}
+
+	return ids, nil
+}
+
+func (p *Provider) DeleteResource(ctx context.Context, id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.exists(id) {
+		return fmt.Errorf("deleting %s: %w", id, ErrNotFound)
+	}
+
+	key := strings.ToLower(id)
+	if _, ok := p.resourceGroups[key]; ok {
+		// deleting a resource group deletes everything in it
+		prefix := key + "/providers/"
+		deletePrefix(p.clusters, prefix)
+		deletePrefix(p.registries, prefix)
+		deletePrefix(p.keyVaults, prefix)
+		deletePrefix(p.identities, prefix)
+	}
+
+	delete(p.resourceGroups, key)
+	delete(p.clusters, key)
+	delete(p.registries, key)
+	delete(p.keyVaults, key)
+	delete(p.identities, key)
+	delete(p.tags, key)
+
+	return azure.ForgetCreated(ctx, id)
+}
+
+// created tags the resource as created by the plugin and records it in state like the Azure SDK provider does
+func (p *Provider) created(ctx context.Context, id string) error {
+	p.tags[strings.ToLower(id)] = map[string]string{azure.CreatedByTag: azure.CreatedByValue}
+	return azure.RecordCreated(ctx, id)
+}
+
+func (p *Provider) exists(id string) bool {
+	key := strings.ToLower(id)
+	_, rg := p.resourceGroups[key]
+	_, cluster := p.clusters[key]
+	_, acr := p.registries[key]
+	_, kv := p.keyVaults[key]
+	_, identity := p.identities[key]
+	return rg || cluster || acr || kv || identity
+}
+
+func deletePrefix[T any](m map[string]T, prefix string) {
+	for k := range m {
+		if strings.HasPrefix(k, prefix) {
+			delete(m, k)
+		}
+	}
+}
+
+func (p *Provider) addResourceGroup(subscriptionId, name, location string) {
+	id := ResourceGroupId(subscriptionId, name)
+	p.resourceGroups[strings.ToLower(id)] = armresources.ResourceGroup{
+		ID:       to.Ptr(id),
+		Name:     to.Ptr(name),
+		Location: to.Ptr(location),
+	}
+}
+
+func (p *Provider) addCluster(subscriptionId, resourceGroup, name, location string) {
+	id := ClusterId(subscriptionId, resourceGroup, name)
+	p.clusters[strings.ToLower(id)] = armcontainerservice.ManagedCluster{
+		ID:       to.Ptr(id),
+		Name:     to.Ptr(name),
+		Location: to.Ptr(location),
+		Identity: &armcontainerservice.ManagedClusterIdentity{
+			Type:        to.Ptr(armcontainerservice.ResourceIdentityTypeSystemAssigned),
+			PrincipalID: to.Ptr(ClusterPrincipalId(name)),
+		},
+		Properties: &armcontainerservice.ManagedClusterProperties{},
+	}
+}
+
+func (p *Provider) addContainerRegistry(subscriptionId, resourceGroup, name, location string) {
+	id := ContainerRegistryId(subscriptionId, resourceGroup, name)
+	p.registries[strings.ToLower(id)] = armcontainerregistry.Registry{
+		ID:       to.Ptr(id),
+		Name:     to.Ptr(name),
+		Location: to.Ptr(location),
+	}
+}
+
+// subscriptionTenant returns the tenant of a seeded subscription or the home tenant
+func (p *Provider) subscriptionTenant(subscriptionId string) string {
+	if tenantId, ok := p.subTenants[subscriptionId]; ok {
+		return tenantId
+	}
+
+	return TenantId
+}
+
+func (p *Provider) addKeyVault(tenantId, subscriptionId, resourceGroup, name string, rbac bool) azure.Akv {
+	id := KeyVaultId(subscriptionId, resourceGroup, name)
+	kv := azure.Akv{
+		Uri:               azure.VaultUri(name),
+		Id:                id,
+		TenantId:          tenantId,
+		SubscriptionId:    subscriptionId,
+		ResourceGroup:     resourceGroup,
+		Name:              name,
+		RbacAuthorization: rbac,
+	}
+	p.keyVaults[strings.ToLower(id)] = kv
+	return kv
+}
+
+// list returns the values with keys under the lowercase prefix sorted by key so results are deterministic
+func list[T any](m map[string]T, prefix string) []T {
+	prefix = strings.ToLower(prefix)
+
+	var keys []string
+	for k := range m {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	ret := make([]T, 0, len(keys))
+	for _, k := range keys {
+		ret = append(ret, m[k])
+	}
+
+	return ret
+}
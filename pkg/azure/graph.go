@@ -0,0 +1,126 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+)
+
+var (
+	// ErrServicePrincipalNotFound is returned when no service principal exists for an application id
+	ErrServicePrincipalNotFound = errors.New("service principal not found")
+
+	errGraphNotFound = errors.New("not found in Microsoft Graph")
+)
+
+// graphClient makes requests to Microsoft Graph. Tokens are only ever put in the Authorization header
+// and must never be logged or included in errors.
+type graphClient struct {
+	// endpoint is the base URL of Microsoft Graph like https://graph.microsoft.com
+	endpoint string
+	cred     azcore.TokenCredential
+	http     *http.Client
+}
+
+func newGraphClient(cred azcore.TokenCredential) *graphClient {
+	return &graphClient{
+		endpoint: current.GraphEndpoint,
+		cred:     cred,
+		http:     http.DefaultClient,
+	}
+}
+
+// graphError is the error body returned by Microsoft Graph
+type graphError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// servicePrincipalObjectId returns the object id of the service principal for the application (client) id
+func (g *graphClient) servicePrincipalObjectId(ctx context.Context, appId string) (string, error) {
+	lgr := logger.FromContext(ctx).With("appId", appId)
+	lgr.Debug("getting service principal object id from Microsoft Graph")
+
+	if appId == "" {
+		return "", errors.New("app id is empty")
+	}
+
+	// single quotes are escaped by doubling them in OData string literals
+	key := url.PathEscape(strings.ReplaceAll(appId, "'", "''"))
+	u := fmt.Sprintf("%s/v1.0/servicePrincipals(appId='%s')?$select=id", strings.TrimSuffix(g.endpoint, "/"), key)
+
+	var sp struct {
+		Id string `json:"id"`
+	}
+	if err := g.get(ctx, u, &sp); err != nil {
+		if errors.Is(err, errGraphNotFound) {
+			return "", fmt.Errorf("app id %s: %w", appId, ErrServicePrincipalNotFound)
+		}
+
+		return "", fmt.Errorf("getting service principal for app id %s: %w", appId, err)
+	}
+
+	if sp.Id == "" {
+		return "", errors.New("service principal object id is empty")
+	}
+
+	lgr.Debug("finished getting service principal object id from Microsoft Graph", "objectId", sp.Id)
+	return sp.Id, nil
+}
+
+// get sends an authenticated GET request to Microsoft Graph and decodes the json response into v
+func (g *graphClient) get(ctx context.Context, u string, v any) error {
+	token, err := g.cred.GetToken(ctx, policy.TokenRequestOptions{
+		Scopes: []string{strings.TrimSuffix(g.endpoint, "/") + "/.default"},
+	})
+	if err != nil {
+		return fmt.Errorf("getting Microsoft Graph token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := g.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errGraphNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		var gErr graphError
+		if err := json.Unmarshal(body, &gErr); err == nil && gErr.Error.Code != "" {
+			return fmt.Errorf("unexpected status %d: %s: %s", resp.StatusCode, gErr.Error.Code, gErr.Error.Message)
+		}
+
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+
+	return nil
+}
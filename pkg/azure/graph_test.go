@@ -0,0 +1,81 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	. "github.com/onsi/gomega"
+)
+
+const testToken = "secret-test-token"
+
+type staticCred struct {
+	scopes []string
+}
+
+func (s *staticCred) GetToken(_ context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	s.scopes = opts.Scopes
+	return azcore.AccessToken{Token: testToken}, nil
+}
+
+func newTestGraphClient(t *testing.T, handler http.HandlerFunc) (*graphClient, *staticCred) {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	cred := &staticCred{}
+	return &graphClient{
+		endpoint: srv.URL,
+		cred:     cred,
+		http:     srv.Client(),
+	}, cred
+}
+
+func TestServicePrincipalObjectId(t *testing.T) {
+	g := NewWithT(t)
+	client, cred := newTestGraphClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/v1.0/servicePrincipals(appId='client-id')" || r.URL.Query().Get("$select") != "id" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Write([]byte(`{"id": "object-id"}`))
+	})
+
+	id, err := client.servicePrincipalObjectId(context.Background(), "client-id")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(id).To(Equal("object-id"))
+	g.Expect(cred.scopes).To(Equal([]string{client.endpoint + "/.default"}))
+}
+
+func TestServicePrincipalObjectIdNotFound(t *testing.T) {
+	g := NewWithT(t)
+	client, _ := newTestGraphClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": {"code": "Request_ResourceNotFound", "message": "not found"}}`))
+	})
+
+	_, err := client.servicePrincipalObjectId(context.Background(), "client-id")
+	g.Expect(err).To(MatchError(ErrServicePrincipalNotFound))
+}
+
+func TestServicePrincipalObjectIdError(t *testing.T) {
+	g := NewWithT(t)
+	client, _ := newTestGraphClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error": {"code": "Authorization_RequestDenied", "message": "Insufficient privileges"}}`))
+	})
+
+	_, err := client.servicePrincipalObjectId(context.Background(), "client-id")
+	g.Expect(err).To(MatchError(ContainSubstring("403: Authorization_RequestDenied: Insufficient privileges")))
+	g.Expect(err.Error()).ToNot(ContainSubstring(testToken))
+}
@@ -0,0 +1,227 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/msi/armmsi"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+)
+
+const (
+	identityResourceIdTemplate = "/subscriptions/%s/resourceGroups/%s/providers/Microsoft.ManagedIdentity/userAssignedIdentities/%s"
+
+	// workloadIdentityAudience is the audience of service account tokens exchanged for Azure AD tokens
+	workloadIdentityAudience = "api://AzureADTokenExchange"
+
+	plannedIdentityClientId    = "<client id of new identity>"
+	plannedIdentityPrincipalId = "<principal id of new identity>"
+)
+
+// Identity is a user-assigned managed identity
+type Identity struct {
+	Id             string
+	Name           string
+	SubscriptionId string
+	ResourceGroup  string
+	ClientId       string
+	PrincipalId    string
+}
+
+// ServiceAccountSubject returns the subject of tokens issued to the Kubernetes ServiceAccount
+func ServiceAccountSubject(namespace, serviceAccount string) string {
+	return fmt.Sprintf("system:serviceaccount:%s:%s", namespace, serviceAccount)
+}
+
+func identityFactory(subscriptionId string) (*armmsi.ClientFactory, error) {
+	cred, err := getCred()
+	if err != nil {
+		return nil, fmt.Errorf("getting credential: %w", err)
+	}
+
+	factory, err := armmsi.NewClientFactory(subscriptionId, cred, armOptions())
+	if err != nil {
+		return nil, fmt.Errorf("creating factory: %w", err)
+	}
+
+	return factory, nil
+}
+
+// NewIdentity creates the user-assigned managed identity or returns it if it already exists
+func NewIdentity(ctx context.Context, subscriptionId, resourceGroup, name, location string) (*Identity, error) {
+	lgr := logger.FromContext(ctx).With("subscription", subscriptionId, "resource group", resourceGroup, "name", name)
+	ctx = logger.WithContext(ctx, lgr)
+	lgr.Debug("creating user-assigned managed identity")
+
+	id := fmt.Sprintf(identityResourceIdTemplate, subscriptionId, resourceGroup, name)
+	change := dryrun.Change{
+		Action: dryrun.Create,
+		Kind:   "Managed Identity",
+		Target: id,
+		Detail: "location " + location,
+	}
+	// a resource group that's only planned can't be read so the identity in it is planned too
+	if isPlanned(ctx, resourceGroupId(subscriptionId, resourceGroup)) && planned(ctx, change) {
+		return plannedIdentity(id, subscriptionId, resourceGroup, name), nil
+	}
+
+	factory, err := identityFactory(subscriptionId)
+	if err != nil {
+		return nil, fmt.Errorf("getting identity client: %w", err)
+	}
+
+	// an identity that already exists is returned unchanged so it isn't tagged as created by the plugin
+	client := factory.NewUserAssignedIdentitiesClient()
+	existing, err := client.Get(ctx, resourceGroup, name, nil)
+	switch {
+	case err == nil:
+		lgr.Debug("identity already exists")
+		if existing.Properties == nil || existing.Properties.ClientID == nil || existing.Properties.PrincipalID == nil {
+			return nil, fmt.Errorf("identity %s is missing client or principal id", name)
+		}
+
+		return &Identity{
+			Id:             *existing.ID,
+			Name:           name,
+			SubscriptionId: subscriptionId,
+			ResourceGroup:  resourceGroup,
+			ClientId:       *existing.Properties.ClientID,
+			PrincipalId:    *existing.Properties.PrincipalID,
+		}, nil
+	case isNotFound(err):
+	default:
+		return nil, fmt.Errorf("getting identity: %w", err)
+	}
+
+	if planned(ctx, change) {
+		return plannedIdentity(id, subscriptionId, resourceGroup, name), nil
+	}
+
+	resp, err := client.CreateOrUpdate(ctx, resourceGroup, name, armmsi.Identity{
+		Location: to.Ptr(location),
+		Tags:     createdByTags(),
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating identity: %w", err)
+	}
+
+	if resp.Properties == nil || resp.Properties.ClientID == nil || resp.Properties.PrincipalID == nil {
+		return nil, fmt.Errorf("identity %s is missing client or principal id", name)
+	}
+
+	if err := RecordCreated(ctx, *resp.ID); err != nil {
+		return nil, fmt.Errorf("recording created identity: %w", err)
+	}
+
+	lgr.Debug("finished creating user-assigned managed identity")
+	return &Identity{
+		Id:             *resp.ID,
+		Name:           name,
+		SubscriptionId: subscriptionId,
+		ResourceGroup:  resourceGroup,
+		ClientId:       *resp.Properties.ClientID,
+		PrincipalId:    *resp.Properties.PrincipalID,
+	}, nil
+}
+
+// GetIdentity returns the user-assigned managed identity
+func GetIdentity(ctx context.Context, subscriptionId, resourceGroup, name string) (*Identity, error) {
+	lgr := logger.FromContext(ctx).With("subscription", subscriptionId, "resource group", resourceGroup, "name", name)
+	ctx = logger.WithContext(ctx, lgr)
+	lgr.Debug("getting user-assigned managed identity")
+
+	factory, err := identityFactory(subscriptionId)
+	if err != nil {
+		return nil, fmt.Errorf("getting identity client: %w", err)
+	}
+
+	resp, err := factory.NewUserAssignedIdentitiesClient().Get(ctx, resourceGroup, name, nil)
+	if err != nil {
+		return nil, fmt.Errorf("getting identity: %w", err)
+	}
+
+	if resp.Properties == nil || resp.Properties.ClientID == nil || resp.Properties.PrincipalID == nil {
+		return nil, fmt.Errorf("identity %s is missing client or principal id", name)
+	}
+
+	lgr.Debug("finished getting user-assigned managed identity")
+	return &Identity{
+		Id:             *resp.ID,
+		Name:           name,
+		SubscriptionId: subscriptionId,
+		ResourceGroup:  resourceGroup,
+		ClientId:       *resp.Properties.ClientID,
+		PrincipalId:    *resp.Properties.PrincipalID,
+	}, nil
+}
+
+// AddFederatedCredential lets tokens from the issuer with the subject authenticate as the identity. A credential
+// that already exists is left alone if it matches and updated otherwise.
+func AddFederatedCredential(ctx context.Context, identity *Identity, name, issuer, subject string) error {
+	lgr := logger.FromContext(ctx).With("identity", identity.Name, "name", name, "issuer", issuer, "subject", subject)
+	ctx = logger.WithContext(ctx, lgr)
+	lgr.Debug("adding federated identity credential")
+
+	change := dryrun.Change{
+		Action: dryrun.Create,
+		Kind:   "Federated Identity Credential",
+		Target: identity.Id + "/federatedIdentityCredentials/" + name,
+		Detail: "subject " + subject,
+	}
+	// a planned identity doesn't exist yet so it has no credentials to read
+	if isPlanned(ctx, identity.Id) && planned(ctx, change) {
+		return nil
+	}
+
+	factory, err := identityFactory(identity.SubscriptionId)
+	if err != nil {
+		return fmt.Errorf("getting identity client: %w", err)
+	}
+
+	client := factory.NewFederatedIdentityCredentialsClient()
+	existing, err := client.Get(ctx, identity.ResourceGroup, identity.Name, name, nil)
+	switch {
+	case err == nil:
+		if p := existing.Properties; p != nil && p.Issuer != nil && *p.Issuer == issuer && p.Subject != nil && *p.Subject == subject {
+			lgr.Debug("federated identity credential already exists")
+			return nil
+		}
+
+		change.Action = dryrun.Update
+	case isNotFound(err):
+	default:
+		return fmt.Errorf("getting federated identity credential: %w", err)
+	}
+
+	if planned(ctx, change) {
+		return nil
+	}
+
+	_, err = client.CreateOrUpdate(ctx, identity.ResourceGroup, identity.Name, name, armmsi.FederatedIdentityCredential{
+		Properties: &armmsi.FederatedIdentityCredentialProperties{
+			Audiences: []*string{to.Ptr(workloadIdentityAudience)},
+			Issuer:    to.Ptr(issuer),
+			Subject:   to.Ptr(subject),
+		},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("creating federated identity credential: %w", err)
+	}
+
+	lgr.Debug("finished adding federated identity credential")
+	return nil
+}
+
+// plannedIdentity returns the identity that a dry run plans to create
+func plannedIdentity(id, subscriptionId, resourceGroup, name string) *Identity {
+	return &Identity{
+		Id:             id,
+		Name:           name,
+		SubscriptionId: subscriptionId,
+		ResourceGroup:  resourceGroup,
+		ClientId:       plannedIdentityClientId,
+		PrincipalId:    plannedIdentityPrincipalId,
+	}
+}
@@ -0,0 +1,148 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+)
+
+const keyVaultApiVersion = "7.4"
+
+// jsonWebKey is the public part of a keyvault key
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// GetPublicKey returns the public key of the keyvault's P-256 EC key
+func (a *Akv) GetPublicKey(ctx context.Context, keyName string) (*ecdsa.PublicKey, error) {
+	lgr := logger.FromContext(ctx).With("key", keyName, "name", a.Name)
+	lgr.Debug("getting keyvault public key")
+
+	jwk, err := a.getKey(ctx, keyName)
+	if err != nil {
+		return nil, err
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return nil, fmt.Errorf("decoding x: %w", err)
+	}
+	y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if err != nil {
+		return nil, fmt.Errorf("decoding y: %w", err)
+	}
+
+	lgr.Debug("finished getting keyvault public key")
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
+
+// SignWithKey signs the SHA-256 digest with the keyvault's P-256 EC key and returns the ASN.1 DER signature. The
+// private key never leaves the keyvault.
+func (a *Akv) SignWithKey(ctx context.Context, keyName string, digest []byte) ([]byte, error) {
+	lgr := logger.FromContext(ctx).With("key", keyName, "name", a.Name)
+	lgr.Debug("signing with keyvault key")
+
+	jwk, err := a.getKey(ctx, keyName)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(map[string]string{"alg": "ES256", "value": base64.RawURLEncoding.EncodeToString(digest)})
+	if err != nil {
+		return nil, fmt.Errorf("marshalling sign request: %w", err)
+	}
+
+	// the key id includes the version so the signature is from the same key version as checked above
+	var signed struct {
+		Value string `json:"value"`
+	}
+	if err := keyVaultRequest(ctx, http.MethodPost, jwk.Kid+"/sign", body, &signed); err != nil {
+		return nil, fmt.Errorf("signing: %w", err)
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(signed.Value)
+	if err != nil {
+		return nil, fmt.Errorf("decoding signature: %w", err)
+	}
+	if len(raw) != 64 {
+		return nil, fmt.Errorf("unexpected ES256 signature length %d", len(raw))
+	}
+
+	// keyvault returns r and s concatenated while ecdsa verifiers expect them ASN.1 encoded
+	sig, err := asn1.Marshal(struct{ R, S *big.Int }{new(big.Int).SetBytes(raw[:32]), new(big.Int).SetBytes(raw[32:])})
+	if err != nil {
+		return nil, fmt.Errorf("encoding signature: %w", err)
+	}
+
+	lgr.Debug("finished signing with keyvault key")
+	return sig, nil
+}
+
+// getKey returns the latest version of the key and checks it's a P-256 EC key
+func (a *Akv) getKey(ctx context.Context, keyName string) (jsonWebKey, error) {
+	var key struct {
+		Key jsonWebKey `json:"key"`
+	}
+	if err := keyVaultRequest(ctx, http.MethodGet, strings.TrimSuffix(a.Uri, "/")+"/keys/"+keyName, nil, &key); err != nil {
+		return jsonWebKey{}, fmt.Errorf("getting key %s: %w", keyName, err)
+	}
+
+	if !strings.HasPrefix(key.Key.Kty, "EC") || key.Key.Crv != "P-256" {
+		return jsonWebKey{}, fmt.Errorf("key %s is a %s %s key, expected an EC P-256 key", keyName, key.Key.Kty, key.Key.Crv)
+	}
+
+	return key.Key, nil
+}
+
+// keyVaultRequest calls the keyvault data plane as the signed-in user and decodes the response into out
+func keyVaultRequest(ctx context.Context, method, u string, body []byte, out any) error {
+	cred, err := getCred()
+	if err != nil {
+		return fmt.Errorf("getting credential: %w", err)
+	}
+
+	token, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{keyVaultScope()}})
+	if err != nil {
+		return fmt.Errorf("getting token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u+"?api-version="+keyVaultApiVersion, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("calling keyvault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, msg)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+
+	return nil
+}
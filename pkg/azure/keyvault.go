@@ -5,15 +5,35 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/keyvault/armkeyvault"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
 	"github.com/azure/spin-aks-plugin/pkg/logger"
 )
 
+const (
+	keyVaultSecretsUserRoleDefinition    = "4633458b-17de-408a-b874-0445c86b69e6"
+	keyVaultSecretsOfficerRoleDefinition = "b86a8fe4-44ce-4948-aee5-eccb2c155cd7"
+)
+
+var (
+	// KeyVaultSecretsUserRole can read secret contents of RBAC keyvaults
+	KeyVaultSecretsUserRole = Role{
+		Name: "Key Vault Secrets User",
+		ID:   fmt.Sprintf("/providers/Microsoft.Authorization/roleDefinitions/%s", keyVaultSecretsUserRoleDefinition),
+	}
+	// KeyVaultSecretsOfficerRole can read and write secrets of RBAC keyvaults
+	KeyVaultSecretsOfficerRole = Role{
+		Name: "Key Vault Secrets Officer",
+		ID:   fmt.Sprintf("/providers/Microsoft.Authorization/roleDefinitions/%s", keyVaultSecretsOfficerRoleDefinition),
+	}
+)
+
 type Akv struct {
 	Uri            string
 	Id             string
@@ -21,6 +41,8 @@ type Akv struct {
 	SubscriptionId string
 	ResourceGroup  string
 	Name           string
+	// RbacAuthorization is true if the keyvault uses Azure RBAC instead of access policies for data plane permissions
+	RbacAuthorization bool
 }
 
 func LoadAkv(id arm.ResourceID) *Akv {
@@ -38,12 +60,17 @@ func GetKeyVault(ctx context.Context, subscriptionId, resourceGroup, name string
 	lgr.Info("starting to get keyvault")
 	defer lgr.Info("finished getting keyvault")
 
+	if id := keyVaultId(subscriptionId, resourceGroup, name); isPlanned(ctx, id) {
+		lgr.Debug("keyvault is only planned, returning placeholder")
+		return plannedAkv(id, subscriptionId, resourceGroup, name, CurrentTenant()), nil
+	}
+
 	cred, err := getCred()
 	if err != nil {
 		return nil, fmt.Errorf("getting az credentials: %w", err)
 	}
 
-	vaultsClient, err := armkeyvault.NewVaultsClient(subscriptionId, cred, nil)
+	vaultsClient, err := armkeyvault.NewVaultsClient(subscriptionId, cred, armOptions())
 	if err != nil {
 		return nil, fmt.Errorf("creating client: %w", err)
 	}
@@ -60,14 +87,9 @@ func GetKeyVault(ctx context.Context, subscriptionId, resourceGroup, name string
 		return nil, fmt.Errorf("parsing resource id: %w", err)
 	}
 	newAkv := LoadAkv(*kvId)
-
-	tenant, err := GetTenant(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("getting tenant: %w", err)
-	}
-	tenantId := tenant.TenantID
-	newAkv.TenantId = *tenantId
+	newAkv.TenantId = *kv.Properties.TenantID
 	newAkv.Uri = *kv.Properties.VaultURI
+	newAkv.RbacAuthorization = kv.Properties.EnableRbacAuthorization != nil && *kv.Properties.EnableRbacAuthorization
 
 	return newAkv, nil
 }
@@ -78,12 +100,17 @@ func ListKeyVaults(ctx context.Context, subscriptionId, resourceGroup string) ([
 	lgr.Info("starting to list keyvaults")
 	defer lgr.Info("finished listing keyvaults")
 
+	if isPlanned(ctx, resourceGroupId(subscriptionId, resourceGroup)) {
+		lgr.Debug("resource group is only planned, no keyvaults exist")
+		return nil, nil
+	}
+
 	cred, err := getCred()
 	if err != nil {
 		return nil, fmt.Errorf("getting az credentials: %w", err)
 	}
 
-	client, err := armkeyvault.NewVaultsClient(subscriptionId, cred, nil)
+	client, err := armkeyvault.NewVaultsClient(subscriptionId, cred, armOptions())
 	if err != nil {
 		return nil, fmt.Errorf("creating client: %w", err)
 	}
@@ -106,7 +133,9 @@ func ListKeyVaults(ctx context.Context, subscriptionId, resourceGroup string) ([
 				return nil, fmt.Errorf("parsing resource id: %w", err)
 			}
 			newAkv := LoadAkv(*id)
+			newAkv.TenantId = *kv.Properties.TenantID
 			newAkv.Uri = *kv.Properties.VaultURI
+			newAkv.RbacAuthorization = kv.Properties.EnableRbacAuthorization != nil && *kv.Properties.EnableRbacAuthorization
 
 			akvs = append(akvs, *newAkv)
 			lgr.Info("keyvault", "name", kv.Name)
@@ -123,38 +152,43 @@ func NewAkv(ctx context.Context, tenantId, subscriptionId, resourceGroup, name,
 	lgr.Info("starting to create akv")
 	defer lgr.Info("finished creating akv")
 
+	if id := keyVaultId(subscriptionId, resourceGroup, name); planned(ctx, dryrun.Change{
+		Action: dryrun.Create,
+		Kind:   "Key Vault",
+		Target: id,
+		Detail: "location " + location + ", sku standard, rbac authorization",
+	}) {
+		return plannedAkv(id, subscriptionId, resourceGroup, name, tenantId), nil
+	}
+
 	cred, err := getCred()
 	if err != nil {
 		return nil, fmt.Errorf("getting az credentials: %w", err)
 	}
 
-	factory, err := armkeyvault.NewClientFactory(subscriptionId, cred, nil)
+	factory, err := armkeyvault.NewClientFactory(subscriptionId, cred, armOptions())
 	if err != nil {
 		return nil, fmt.Errorf("creating client factory: %w", err)
 	}
 
-	clientObjectId, err := getObjectId(ctx, cred)
-	if err != nil {
-		return nil, fmt.Errorf("getting client object id: %w", err)
+	// a vault that already exists is returned unchanged so it isn't tagged as created by the plugin
+	_, err = factory.NewVaultsClient().Get(ctx, resourceGroup, name, nil)
+	switch {
+	case err == nil:
+		lgr.Info("keyvault already exists")
+		return GetKeyVault(ctx, subscriptionId, resourceGroup, name)
+	case isNotFound(err):
+	default:
+		return nil, fmt.Errorf("getting vault: %w", err)
 	}
 
+	// new vaults use Azure RBAC, callers grant data plane roles with AddRoleAssignment and AddUserRoleAssignment
 	v := &armkeyvault.VaultCreateOrUpdateParameters{
 		Location: to.Ptr(location),
+		Tags:     createdByTags(),
 		Properties: &armkeyvault.VaultProperties{
-			AccessPolicies: []*armkeyvault.AccessPolicyEntry{
-				{
-					ObjectID: to.Ptr(clientObjectId),
-					Permissions: &armkeyvault.Permissions{
-						Secrets: []*armkeyvault.SecretPermissions{
-							to.Ptr(armkeyvault.SecretPermissionsGet),
-							to.Ptr(armkeyvault.SecretPermissionsSet),
-						},
-					},
-					TenantID:      to.Ptr(tenantId),
-					ApplicationID: nil,
-				},
-			},
-			TenantID: to.Ptr(tenantId),
+			EnableRbacAuthorization: to.Ptr(true),
+			TenantID:                to.Ptr(tenantId),
 			SKU: &armkeyvault.SKU{
 				Name: to.Ptr(armkeyvault.SKUNameStandard),
 			},
@@ -170,28 +204,43 @@ func NewAkv(ctx context.Context, tenantId, subscriptionId, resourceGroup, name,
 		return nil, fmt.Errorf("waiting for vault creation to complete: %w", err)
 	}
 
+	if err := RecordCreated(ctx, *result.ID); err != nil {
+		return nil, fmt.Errorf("recording created vault: %w", err)
+	}
+
 	return &Akv{
-		Uri:            *result.Properties.VaultURI,
-		Id:             *result.ID,
-		ResourceGroup:  resourceGroup,
-		Name:           *result.Name,
-		SubscriptionId: subscriptionId,
-		TenantId:       tenantId,
+		Uri:               *result.Properties.VaultURI,
+		Id:                *result.ID,
+		ResourceGroup:     resourceGroup,
+		Name:              *result.Name,
+		SubscriptionId:    subscriptionId,
+		TenantId:          tenantId,
+		RbacAuthorization: true,
 	}, nil
 }
 
+// PutSecretIfNewValue sets the secret unless it already has the value
 func (a *Akv) PutSecretIfNewValue(ctx context.Context, name, value string) error {
 	lgr := logger.FromContext(ctx).With("name", name, "resourceGroup", a.ResourceGroup, "subscriptionId", a.SubscriptionId)
 	ctx = logger.WithContext(ctx, lgr)
 	lgr.Info("starting to put secret")
 	defer lgr.Info("finished putting secret")
 
+	// a planned keyvault doesn't exist yet so its uri can't be called
+	if planned(ctx, dryrun.Change{
+		Action: dryrun.Update,
+		Kind:   "Key Vault Secret",
+		Target: a.SecretUri(name),
+	}) {
+		return nil
+	}
+
 	cred, err := getCred()
 	if err != nil {
 		return fmt.Errorf("getting az credentials: %w", err)
 	}
 
-	secretClient, err := azsecrets.NewClient(a.Uri, cred, nil)
+	secretClient, err := azsecrets.NewClient(a.Uri, cred, secretsOptions())
 	if err != nil {
 		return fmt.Errorf("creating client: %w", err)
 	}
@@ -227,21 +276,67 @@ func (a *Akv) PutSecretIfNewValue(ctx context.Context, name, value string) error
 	return nil
 }
 
+// SecretUri returns the uri of the secret in the keyvault
+func (a *Akv) SecretUri(name string) string {
+	return strings.TrimSuffix(a.Uri, "/") + "/secrets/" + name
+}
+
 func (a *Akv) GetId() string {
 	return a.Id
 }
 
+// AddAccessPolicy grants the principal the permissions on the keyvault. Only permissions the principal doesn't
+// already have are added.
 func (a *Akv) AddAccessPolicy(ctx context.Context, objectId string, permissions armkeyvault.Permissions) error {
 	lgr := logger.FromContext(ctx).With("objectId", objectId, "name", a.Name, "resourceGroup", a.ResourceGroup, "subscriptionId", a.SubscriptionId)
 	ctx = logger.WithContext(ctx, lgr)
 	lgr.Info("starting to add access policy")
 	defer lgr.Info("finished adding access policy")
 
+	if objectId == "" {
+		return fmt.Errorf("object id is empty")
+	}
+
+	return a.addAccessPolicy(ctx, objectId, "object "+objectId, permissions)
+}
+
+// AddUserAccessPolicy grants the signed-in user the permissions on the keyvault. Only permissions the user doesn't
+// already have are added.
+func (a *Akv) AddUserAccessPolicy(ctx context.Context, permissions armkeyvault.Permissions) error {
+	lgr := logger.FromContext(ctx).With("name", a.Name, "resourceGroup", a.ResourceGroup, "subscriptionId", a.SubscriptionId)
+	ctx = logger.WithContext(ctx, lgr)
+	lgr.Info("starting to add user access policy")
+	defer lgr.Info("finished adding user access policy")
+
+	objectId, err := a.signedInObjectId(ctx)
+	if err != nil {
+		return err
+	}
+
+	return a.addAccessPolicy(ctx, objectId, "signed-in user", permissions)
+}
+
+func (a *Akv) addAccessPolicy(ctx context.Context, objectId, assignee string, permissions armkeyvault.Permissions) error {
+	lgr := logger.FromContext(ctx)
+
 	if a.TenantId == "" {
 		return fmt.Errorf("tenant id is empty")
 	}
-	if objectId == "" {
-		return fmt.Errorf("object id is empty")
+	if a.RbacAuthorization {
+		return fmt.Errorf("keyvault %s uses rbac authorization instead of access policies", a.Name)
+	}
+
+	change := dryrun.Change{
+		Action: dryrun.Grant,
+		Kind:   "Key Vault Access Policy",
+		Target: a.Id,
+		Detail: fmt.Sprintf("%s to %s", describePermissions(permissions), assignee),
+	}
+
+	// a keyvault that's only planned can't have existing access policies
+	if isPlanned(ctx, a.Id) {
+		planned(ctx, change)
+		return nil
 	}
 
 	cred, err := getCred()
@@ -249,18 +344,39 @@ func (a *Akv) AddAccessPolicy(ctx context.Context, objectId string, permissions
 		return fmt.Errorf("getting az credentials: %w", err)
 	}
 
-	client, err := armkeyvault.NewVaultsClient(a.SubscriptionId, cred, nil)
+	client, err := armkeyvault.NewVaultsClient(a.SubscriptionId, cred, armOptions())
 	if err != nil {
 		return fmt.Errorf("creating client: %w", err)
 	}
 
+	resp, err := client.Get(ctx, a.ResourceGroup, a.Name, nil)
+	if err != nil {
+		return fmt.Errorf("getting keyvault: %w", err)
+	}
+
+	var existing []*armkeyvault.AccessPolicyEntry
+	if resp.Properties != nil {
+		existing = resp.Properties.AccessPolicies
+	}
+
+	missing := MissingPermissions(existing, a.TenantId, objectId, permissions)
+	if isEmptyPermissions(missing) {
+		lgr.Info(fmt.Sprintf("already granted %s to %s on keyvault %s", describePermissions(permissions), assignee, a.Name))
+		return nil
+	}
+
+	change.Detail = fmt.Sprintf("%s to %s", describePermissions(missing), assignee)
+	if planned(ctx, change) {
+		return nil
+	}
+
 	addition := armkeyvault.VaultAccessPolicyParameters{
 		Properties: &armkeyvault.VaultAccessPolicyProperties{
 			AccessPolicies: []*armkeyvault.AccessPolicyEntry{
 				{
 					TenantID:    to.Ptr(a.TenantId),
 					ObjectID:    to.Ptr(objectId),
-					Permissions: &permissions,
+					Permissions: &missing,
 				},
 			},
 		},
@@ -271,40 +387,215 @@ func (a *Akv) AddAccessPolicy(ctx context.Context, objectId string, permissions
 
 	return nil
 }
-func (a *Akv) AddUserAccessPolicy(ctx context.Context, permissions armkeyvault.Permissions) error {
-	lgr := logger.FromContext(ctx).With("objectId", "name", a.Name, "resourceGroup", a.ResourceGroup, "subscriptionId", a.SubscriptionId)
+
+// AddRoleAssignment assigns the role scoped to the keyvault to the principal. The keyvault must use RBAC authorization.
+// Nothing is assigned if the principal already has the role.
+func (a *Akv) AddRoleAssignment(ctx context.Context, objectId string, role Role) error {
+	lgr := logger.FromContext(ctx).With("objectId", objectId, "role", role.Name, "name", a.Name, "resourceGroup", a.ResourceGroup, "subscriptionId", a.SubscriptionId)
 	ctx = logger.WithContext(ctx, lgr)
-	lgr.Info("starting to add user access policy")
-	defer lgr.Info("finished adding user access policy")
+	lgr.Info("starting to add role assignment")
+	defer lgr.Info("finished adding role assignment")
+
+	if objectId == "" {
+		return fmt.Errorf("object id is empty")
+	}
+
+	return a.addRoleAssignment(ctx, objectId, "object "+objectId, role)
+}
+
+// AddUserRoleAssignment assigns the role scoped to the keyvault to the signed-in user. The keyvault must use RBAC
+// authorization. Nothing is assigned if the user already has the role.
+func (a *Akv) AddUserRoleAssignment(ctx context.Context, role Role) error {
+	lgr := logger.FromContext(ctx).With("role", role.Name, "name", a.Name, "resourceGroup", a.ResourceGroup, "subscriptionId", a.SubscriptionId)
+	ctx = logger.WithContext(ctx, lgr)
+	lgr.Info("starting to add user role assignment")
+	defer lgr.Info("finished adding user role assignment")
+
+	objectId, err := a.signedInObjectId(ctx)
+	if err != nil {
+		return err
+	}
+
+	return a.addRoleAssignment(ctx, objectId, "signed-in user", role)
+}
+
+func (a *Akv) addRoleAssignment(ctx context.Context, objectId, assignee string, role Role) error {
+	if !a.RbacAuthorization {
+		return fmt.Errorf("keyvault %s uses access policies instead of rbac authorization", a.Name)
+	}
+
+	return ensureRoleAssignment(ctx, a.SubscriptionId, objectId, role, a.Id, dryrun.Change{
+		Action: dryrun.Grant,
+		Kind:   "Role Assignment",
+		Target: a.Id,
+		Detail: fmt.Sprintf("%s to %s", role.Name, assignee),
+	})
+}
+
+// HasSecretAccess returns true if the principal can read secrets from the keyvault
+func (a *Akv) HasSecretAccess(ctx context.Context, objectId string) (bool, error) {
+	lgr := logger.FromContext(ctx).With("objectId", objectId, "name", a.Name, "resourceGroup", a.ResourceGroup, "subscriptionId", a.SubscriptionId)
+	ctx = logger.WithContext(ctx, lgr)
+	lgr.Debug("checking secret access")
+
+	if a.RbacAuthorization {
+		client, err := createRoleAssignmentClient(a.SubscriptionId)
+		if err != nil {
+			return false, fmt.Errorf("creating role assignment client: %w", err)
+		}
+
+		for _, role := range []Role{KeyVaultSecretsUserRole, KeyVaultSecretsOfficerRole} {
+			roleId := strings.TrimPrefix(role.ID, "/providers/Microsoft.Authorization/roleDefinitions/")
+			exists, err := client.hasRoleAssignment(ctx, objectId, roleId, a.Id)
+			if err != nil {
+				return false, fmt.Errorf("checking for %s role assignment: %w", role.Name, err)
+			}
+			if exists {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	}
 
 	cred, err := getCred()
 	if err != nil {
-		return fmt.Errorf("getting az credentials: %w", err)
+		return false, fmt.Errorf("getting az credentials: %w", err)
 	}
 
-	objectId, err := getObjectId(ctx, cred)
+	client, err := armkeyvault.NewVaultsClient(a.SubscriptionId, cred, armOptions())
 	if err != nil {
-		return fmt.Errorf("getting client object id: %w", err)
+		return false, fmt.Errorf("creating client: %w", err)
 	}
-	client, err := armkeyvault.NewVaultsClient(a.SubscriptionId, cred, nil)
+
+	resp, err := client.Get(ctx, a.ResourceGroup, a.Name, nil)
 	if err != nil {
-		return fmt.Errorf("creating client: %w", err)
+		return false, fmt.Errorf("getting keyvault: %w", err)
 	}
 
-	addition := armkeyvault.VaultAccessPolicyParameters{
-		Properties: &armkeyvault.VaultAccessPolicyProperties{
-			AccessPolicies: []*armkeyvault.AccessPolicyEntry{
-				{
-					TenantID:    to.Ptr(a.TenantId),
-					ObjectID:    to.Ptr(objectId),
-					Permissions: &permissions,
-				},
-			},
-		},
+	var existing []*armkeyvault.AccessPolicyEntry
+	if resp.Properties != nil {
+		existing = resp.Properties.AccessPolicies
 	}
-	if _, err := client.UpdateAccessPolicy(ctx, a.ResourceGroup, a.Name, armkeyvault.AccessPolicyUpdateKindAdd, addition, nil); err != nil {
-		return fmt.Errorf("adding access policy: %w", err)
+
+	missing := MissingPermissions(existing, a.TenantId, objectId, armkeyvault.Permissions{
+		Secrets: []*armkeyvault.SecretPermissions{to.Ptr(armkeyvault.SecretPermissionsGet)},
+	})
+	return isEmptyPermissions(missing), nil
+}
+
+// signedInObjectId returns the object id of the signed-in user. It's empty for keyvaults that are only planned
+// because nothing needs to be looked up for them.
+func (a *Akv) signedInObjectId(ctx context.Context) (string, error) {
+	if isPlanned(ctx, a.Id) {
+		return "", nil
 	}
 
-	return nil
+	cred, err := getCred()
+	if err != nil {
+		return "", fmt.Errorf("getting az credentials: %w", err)
+	}
+
+	objectId, err := getObjectId(ctx, cred)
+	if err != nil {
+		return "", fmt.Errorf("getting client object id: %w", err)
+	}
+
+	return objectId, nil
+}
+
+// MissingPermissions returns the permissions in want that the principal isn't already granted by the access policies
+func MissingPermissions(policies []*armkeyvault.AccessPolicyEntry, tenantId, objectId string, want armkeyvault.Permissions) armkeyvault.Permissions {
+	var have armkeyvault.Permissions
+	for _, p := range policies {
+		if p == nil || p.ObjectID == nil || p.Permissions == nil || p.ApplicationID != nil {
+			continue
+		}
+		if !strings.EqualFold(*p.ObjectID, objectId) || (p.TenantID != nil && !strings.EqualFold(*p.TenantID, tenantId)) {
+			continue
+		}
+
+		have.Secrets = append(have.Secrets, p.Permissions.Secrets...)
+		have.Keys = append(have.Keys, p.Permissions.Keys...)
+		have.Certificates = append(have.Certificates, p.Permissions.Certificates...)
+		have.Storage = append(have.Storage, p.Permissions.Storage...)
+	}
+
+	return armkeyvault.Permissions{
+		Secrets:      missing(have.Secrets, want.Secrets, armkeyvault.SecretPermissionsAll),
+		Keys:         missing(have.Keys, want.Keys, armkeyvault.KeyPermissionsAll),
+		Certificates: missing(have.Certificates, want.Certificates, armkeyvault.CertificatePermissionsAll),
+		Storage:      missing(have.Storage, want.Storage, armkeyvault.StoragePermissionsAll),
+	}
+}
+
+// missing returns the permissions in want that aren't in have. Having the all permission grants everything.
+func missing[T ~string](have, want []*T, all T) []*T {
+	granted := map[T]bool{}
+	for _, h := range have {
+		if h != nil {
+			granted[T(strings.ToLower(string(*h)))] = true
+		}
+	}
+
+	if granted[T(strings.ToLower(string(all)))] {
+		return nil
+	}
+
+	var ret []*T
+	for _, w := range want {
+		if w != nil && !granted[T(strings.ToLower(string(*w)))] {
+			ret = append(ret, w)
+		}
+	}
+
+	return ret
+}
+
+func isEmptyPermissions(p armkeyvault.Permissions) bool {
+	return len(p.Secrets) == 0 && len(p.Keys) == 0 && len(p.Certificates) == 0 && len(p.Storage) == 0
+}
+
+// plannedAkv returns a placeholder for a keyvault that only exists in a dry run plan. New keyvaults use RBAC authorization.
+func plannedAkv(id, subscriptionId, resourceGroup, name, tenantId string) *Akv {
+	return &Akv{
+		Uri:               VaultUri(name),
+		Id:                id,
+		TenantId:          tenantId,
+		SubscriptionId:    subscriptionId,
+		ResourceGroup:     resourceGroup,
+		Name:              name,
+		RbacAuthorization: true,
+	}
+}
+
+// describePermissions returns a short human-readable summary of keyvault permissions like "secrets get/set"
+func describePermissions(p armkeyvault.Permissions) string {
+	var parts []string
+	for _, kind := range []struct {
+		name  string
+		perms []string
+	}{
+		{"secrets", permissionNames(p.Secrets)},
+		{"keys", permissionNames(p.Keys)},
+		{"certificates", permissionNames(p.Certificates)},
+		{"storage", permissionNames(p.Storage)},
+	} {
+		if len(kind.perms) > 0 {
+			parts = append(parts, kind.name+" "+strings.Join(kind.perms, "/"))
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func permissionNames[T ~string](perms []*T) []string {
+	var ret []string
+	for _, p := range perms {
+		if p != nil {
+			ret = append(ret, string(*p))
+		}
+	}
+
+	return ret
 }
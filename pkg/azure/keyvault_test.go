@@ -0,0 +1,69 @@
+package azure
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/keyvault/armkeyvault"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	. "github.com/onsi/gomega"
+)
+
+func TestMissingPermissions(t *testing.T) {
+	g := NewWithT(t)
+	want := armkeyvault.Permissions{
+		Secrets: []*armkeyvault.SecretPermissions{
+			to.Ptr(armkeyvault.SecretPermissionsGet),
+			to.Ptr(armkeyvault.SecretPermissionsSet),
+		},
+	}
+	policies := []*armkeyvault.AccessPolicyEntry{
+		{
+			TenantID: to.Ptr("tenant"),
+			ObjectID: to.Ptr("OBJECT"),
+			Permissions: &armkeyvault.Permissions{
+				Secrets: []*armkeyvault.SecretPermissions{to.Ptr(armkeyvault.SecretPermissions("Get"))},
+			},
+		},
+		{
+			TenantID: to.Ptr("tenant"),
+			ObjectID: to.Ptr("other"),
+			Permissions: &armkeyvault.Permissions{
+				Secrets: []*armkeyvault.SecretPermissions{to.Ptr(armkeyvault.SecretPermissionsAll)},
+			},
+		},
+	}
+
+	missing := MissingPermissions(policies, "tenant", "object", want)
+	g.Expect(missing.Secrets).To(HaveExactElements(HaveValue(Equal(armkeyvault.SecretPermissionsSet))))
+
+	missing = MissingPermissions(policies, "tenant", "other", want)
+	g.Expect(isEmptyPermissions(missing)).To(BeTrue())
+
+	missing = MissingPermissions(policies, "another-tenant", "object", want)
+	g.Expect(missing.Secrets).To(HaveLen(2))
+}
+
+func TestRoleAssignmentName(t *testing.T) {
+	g := NewWithT(t)
+	scope := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.KeyVault/vaults/kv"
+
+	name := roleAssignmentName("object", "role", scope)
+	g.Expect(roleAssignmentName("OBJECT", "role", scope+"/")).To(Equal(name))
+	g.Expect(roleAssignmentName("object", "other-role", scope)).ToNot(Equal(name))
+	g.Expect(roleAssignmentName("other", "role", scope)).ToNot(Equal(name))
+}
+
+func TestPutSecretIfNewValueDryRun(t *testing.T) {
+	g := NewWithT(t)
+	plan := &dryrun.Plan{}
+	ctx := dryrun.WithContext(context.Background(), plan)
+
+	// nothing is called so the planned vault's uri doesn't need to exist
+	kv := &Akv{Uri: "https://kv.vault.azure.net/", Name: "kv"}
+	g.Expect(kv.PutSecretIfNewValue(ctx, "password", "secret")).To(Succeed())
+	g.Expect(plan.Changes()).To(Equal([]dryrun.Change{
+		{Action: dryrun.Update, Kind: "Key Vault Secret", Target: "https://kv.vault.azure.net/secrets/password"},
+	}))
+}
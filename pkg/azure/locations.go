@@ -32,7 +32,7 @@ func ListLocations(ctx context.Context, subscriptionId string) ([]armsubscriptio
 		return nil, fmt.Errorf("getting credentials: %w", err)
 	}
 
-	client, err := armsubscriptions.NewClient(cred, nil)
+	client, err := armsubscriptions.NewClient(cred, armOptions())
 
 	var locations []armsubscriptions.Location
 	pager := client.NewListLocationsPager(subscriptionId, nil)
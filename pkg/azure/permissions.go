@@ -0,0 +1,326 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+)
+
+// Actions the plugin needs on the scopes it changes
+const (
+	ResourceGroupWriteAction       = "Microsoft.Resources/subscriptions/resourceGroups/write"
+	ClusterWriteAction             = "Microsoft.ContainerService/managedClusters/write"
+	ContainerRegistryWriteAction   = "Microsoft.ContainerRegistry/registries/write"
+	KeyVaultWriteAction            = "Microsoft.KeyVault/vaults/write"
+	KeyVaultAccessPolicyAction     = "Microsoft.KeyVault/vaults/accessPolicies/write"
+	RoleAssignmentWriteAction      = "Microsoft.Authorization/roleAssignments/write"
+	IdentityWriteAction            = "Microsoft.ManagedIdentity/userAssignedIdentities/write"
+	FederatedCredentialWriteAction = "Microsoft.ManagedIdentity/userAssignedIdentities/federatedIdentityCredentials/write"
+)
+
+// Built-in roles that grant the actions, used to tell users what to ask for
+const (
+	ContributorRoleName             = "Contributor"
+	ClusterContributorRoleName      = "Azure Kubernetes Service Contributor Role"
+	KeyVaultContributorRoleName     = "Key Vault Contributor"
+	UserAccessAdministratorRoleName = "User Access Administrator"
+	IdentityContributorRoleName     = "Managed Identity Contributor"
+)
+
+// AccessCheck is access the signed-in principal needs on a scope
+type AccessCheck struct {
+	// Scope is the resource id of a subscription, resource group, or resource
+	Scope string
+	// Role is a built-in role that grants the actions
+	Role string
+	// Actions are the control plane actions needed on the scope
+	Actions []string
+}
+
+func (a AccessCheck) String() string {
+	return fmt.Sprintf("%s on %s", a.Role, a.Scope)
+}
+
+// SubscriptionScope returns the scope of the subscription
+func SubscriptionScope(subscriptionId string) string {
+	return "/subscriptions/" + subscriptionId
+}
+
+// ResourceGroupScope returns the scope of the resource group
+func ResourceGroupScope(subscriptionId, resourceGroup string) string {
+	return resourceGroupId(subscriptionId, resourceGroup)
+}
+
+// ClusterScope returns the scope of the cluster
+func ClusterScope(subscriptionId, resourceGroup, name string) string {
+	return clusterId(subscriptionId, resourceGroup, name)
+}
+
+// ContainerRegistryScope returns the scope of the container registry
+func ContainerRegistryScope(subscriptionId, resourceGroup, name string) string {
+	return acrId(subscriptionId, resourceGroup, name)
+}
+
+// KeyVaultScope returns the scope of the key vault
+func KeyVaultScope(subscriptionId, resourceGroup, name string) string {
+	return keyVaultId(subscriptionId, resourceGroup, name)
+}
+
+// IdentityScope returns the scope of the user-assigned managed identity
+func IdentityScope(subscriptionId, resourceGroup, name string) string {
+	return fmt.Sprintf(identityResourceIdTemplate, subscriptionId, resourceGroup, name)
+}
+
+// MissingAccess returns the checks the signed-in principal doesn't pass. Scopes that don't exist yet are checked at
+// their nearest existing parent since that's where new resources inherit access from.
+func MissingAccess(ctx context.Context, checks []AccessCheck) ([]AccessCheck, error) {
+	lgr := logger.FromContext(ctx)
+	lgr.Debug("checking access")
+
+	var missing []AccessCheck
+	for _, check := range checks {
+		allowed, err := hasAccess(ctx, check)
+		if err != nil {
+			return nil, fmt.Errorf("checking %s: %w", check, err)
+		}
+
+		if !allowed {
+			lgr.Debug("missing access", "check", check.String())
+			missing = append(missing, check)
+		}
+	}
+
+	lgr.Debug("finished checking access")
+	return missing, nil
+}
+
+// SignedInObjectId returns the object id of the signed-in principal
+func SignedInObjectId(ctx context.Context) (string, error) {
+	cred, err := getCred()
+	if err != nil {
+		return "", fmt.Errorf("getting az credentials: %w", err)
+	}
+
+	return getObjectId(ctx, cred)
+}
+
+func hasAccess(ctx context.Context, check AccessCheck) (bool, error) {
+	id, err := arm.ParseResourceID(check.Scope)
+	if err != nil {
+		return false, fmt.Errorf("parsing scope: %w", err)
+	}
+
+	cred, err := getCred()
+	if err != nil {
+		return false, fmt.Errorf("getting az credentials: %w", err)
+	}
+
+	permissions, err := permissionsOn(ctx, cred, id)
+	if err != nil {
+		return false, err
+	}
+
+	for _, action := range check.Actions {
+		if !actionAllowed(permissions, action) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// permissionsOn returns the signed-in principal's permissions on the scope. Scopes that don't exist yet, because
+// they're only planned or created later in the same run, are checked at their parent since that's where they inherit
+// access from.
+func permissionsOn(ctx context.Context, cred azcore.TokenCredential, id *arm.ResourceID) ([]*armauthorization.Permission, error) {
+	lgr := logger.FromContext(ctx).With("scope", id.String())
+
+	switch id.ResourceType.String() {
+	case arm.SubscriptionResourceType.String():
+		return subscriptionPermissions(ctx, cred, id.SubscriptionID)
+	case arm.TenantResourceType.String():
+		return nil, fmt.Errorf("checking access on scope %s isn't supported", id.String())
+	}
+
+	if isPlanned(ctx, id.String()) {
+		lgr.Debug("scope is only planned, checking access on its parent")
+		return permissionsOn(ctx, cred, id.Parent)
+	}
+
+	client, err := armauthorization.NewPermissionsClient(id.SubscriptionID, cred, armOptions())
+	if err != nil {
+		return nil, fmt.Errorf("creating permissions client: %w", err)
+	}
+
+	if id.ResourceType.String() == arm.ResourceGroupResourceType.String() {
+		pager := client.NewListForResourceGroupPager(id.ResourceGroupName, nil)
+		permissions, err := listPermissions(ctx, pager, func(page armauthorization.PermissionsClientListForResourceGroupResponse) []*armauthorization.Permission {
+			return page.Value
+		})
+		if isNotFound(err) {
+			lgr.Debug("resource group doesn't exist, checking access on its subscription")
+			return permissionsOn(ctx, cred, id.Parent)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("listing resource group permissions: %w", err)
+		}
+
+		return permissions, nil
+	}
+
+	parent := ""
+	rgId := resourceGroupId(id.SubscriptionID, id.ResourceGroupName)
+	if id.Parent != nil && id.Parent.ResourceType.String() != arm.ResourceGroupResourceType.String() {
+		parent = strings.TrimPrefix(strings.TrimPrefix(id.Parent.String(), rgId), "/providers/"+id.ResourceType.Namespace+"/")
+	}
+
+	resourceType := id.ResourceType.Types[len(id.ResourceType.Types)-1]
+	pager := client.NewListForResourcePager(id.ResourceGroupName, id.ResourceType.Namespace, parent, resourceType, id.Name, nil)
+	permissions, err := listPermissions(ctx, pager, func(page armauthorization.PermissionsClientListForResourceResponse) []*armauthorization.Permission {
+		return page.Value
+	})
+	if isNotFound(err) {
+		lgr.Debug("resource doesn't exist, checking access on its parent")
+		return permissionsOn(ctx, cred, id.Parent)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing resource permissions: %w", err)
+	}
+
+	return permissions, nil
+}
+
+// listPermissions returns the permissions of every page of the pager
+func listPermissions[T any](ctx context.Context, pager *runtime.Pager[T], value func(T) []*armauthorization.Permission) ([]*armauthorization.Permission, error) {
+	var permissions []*armauthorization.Permission
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, value(page)...)
+	}
+
+	return permissions, nil
+}
+
+// subscriptionPermissions returns the permissions of every role assigned to the signed-in principal, directly or
+// through a group, on the subscription or above it. The permissions api only covers resource groups and resources.
+func subscriptionPermissions(ctx context.Context, cred azcore.TokenCredential, subscriptionId string) ([]*armauthorization.Permission, error) {
+	scope := SubscriptionScope(subscriptionId)
+	lgr := logger.FromContext(ctx).With("scope", scope)
+	lgr.Debug("checking access through role assignments")
+
+	objectId, err := getObjectId(ctx, cred)
+	if err != nil {
+		return nil, fmt.Errorf("getting signed-in object id: %w", err)
+	}
+
+	assignments, err := armauthorization.NewRoleAssignmentsClient(subscriptionId, cred, armOptions())
+	if err != nil {
+		return nil, fmt.Errorf("creating role assignments client: %w", err)
+	}
+
+	roleIds := map[string]bool{}
+	pager := assignments.NewListForScopePager(scope, &armauthorization.RoleAssignmentsClientListForScopeOptions{
+		Filter: to.Ptr(fmt.Sprintf("assignedTo('%s')", objectId)),
+	})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing role assignments page: %w", err)
+		}
+
+		for _, ra := range page.Value {
+			if ra == nil || ra.Properties == nil || ra.Properties.RoleDefinitionID == nil || ra.Properties.Scope == nil {
+				continue
+			}
+
+			// assignments below the scope are also returned, they don't grant access on the subscription
+			if strings.HasPrefix(strings.ToLower(*ra.Properties.Scope), strings.ToLower(scope)+"/") {
+				continue
+			}
+
+			roleIds[*ra.Properties.RoleDefinitionID] = true
+		}
+	}
+
+	definitions, err := armauthorization.NewRoleDefinitionsClient(cred, armOptions())
+	if err != nil {
+		return nil, fmt.Errorf("creating role definitions client: %w", err)
+	}
+
+	var permissions []*armauthorization.Permission
+	for roleId := range roleIds {
+		role, err := definitions.GetByID(ctx, roleId, nil)
+		if err != nil {
+			return nil, fmt.Errorf("getting role definition %s: %w", roleId, err)
+		}
+
+		if role.Properties != nil {
+			permissions = append(permissions, role.Properties.Permissions...)
+		}
+	}
+
+	lgr.Debug("finished checking access through role assignments")
+	return permissions, nil
+}
+
+// actionAllowed returns true if any permission allows the action without also excluding it
+func actionAllowed(permissions []*armauthorization.Permission, action string) bool {
+	for _, p := range permissions {
+		if p == nil {
+			continue
+		}
+
+		if matchesAny(p.Actions, action) && !matchesAny(p.NotActions, action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesAny(patterns []*string, action string) bool {
+	for _, pattern := range patterns {
+		if pattern != nil && matchAction(*pattern, action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchAction matches the action against an action pattern where * matches any characters
+func matchAction(pattern, action string) bool {
+	pattern = strings.ToLower(pattern)
+	action = strings.ToLower(action)
+
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == action
+	}
+
+	if !strings.HasPrefix(action, parts[0]) {
+		return false
+	}
+	action = action[len(parts[0]):]
+
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(action, part)
+		if i < 0 {
+			return false
+		}
+		action = action[i+len(part):]
+	}
+
+	return strings.HasSuffix(action, parts[len(parts)-1])
+}
@@ -0,0 +1,42 @@
+package azure
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization"
+	. "github.com/onsi/gomega"
+)
+
+func TestActionAllowed(t *testing.T) {
+	g := NewWithT(t)
+	contributor := []*armauthorization.Permission{
+		{
+			Actions: []*string{to.Ptr("*")},
+			NotActions: []*string{
+				to.Ptr("Microsoft.Authorization/*/Delete"),
+				to.Ptr("Microsoft.Authorization/*/Write"),
+			},
+		},
+	}
+
+	g.Expect(actionAllowed(contributor, KeyVaultWriteAction)).To(BeTrue())
+	g.Expect(actionAllowed(contributor, RoleAssignmentWriteAction)).To(BeFalse())
+
+	userAccessAdministrator := append(contributor, &armauthorization.Permission{
+		Actions: []*string{to.Ptr("Microsoft.Authorization/*")},
+	})
+	g.Expect(actionAllowed(userAccessAdministrator, RoleAssignmentWriteAction)).To(BeTrue())
+
+	g.Expect(actionAllowed(nil, ClusterWriteAction)).To(BeFalse())
+}
+
+func TestMatchAction(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(matchAction("*/read", "Microsoft.KeyVault/vaults/read")).To(BeTrue())
+	g.Expect(matchAction("*/read", "Microsoft.KeyVault/vaults/write")).To(BeFalse())
+	g.Expect(matchAction("Microsoft.KeyVault/vaults/*", "microsoft.keyvault/vaults/accessPolicies/write")).To(BeTrue())
+	g.Expect(matchAction("Microsoft.KeyVault/vaults/write", "Microsoft.KeyVault/vaults/write")).To(BeTrue())
+	g.Expect(matchAction("Microsoft.KeyVault/vaults/write", "Microsoft.KeyVault/vaults/read")).To(BeFalse())
+}
@@ -0,0 +1,237 @@
+package azure
+
+import (
+	"context"
+	"crypto/ecdsa"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerregistry/armcontainerregistry"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/keyvault/armkeyvault"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/subscription/armsubscription"
+)
+
+var providerKey = ctxKey{}
+
+// ctxKey is used to store the provider in the ctx.
+// Using a new type avoids collisions.
+type ctxKey struct{}
+
+// Provider is every Azure operation used to configure the plugin. The default implementation calls Azure
+// through the Azure SDKs. Other implementations, like an in-memory fake for tests, can be set on the context
+// with WithProvider.
+type Provider interface {
+	ListSubscriptions(ctx context.Context) ([]armsubscription.Subscription, error)
+	GetSubscription(ctx context.Context, subscriptionId string) (*armsubscriptions.Subscription, error)
+	ListTenants(ctx context.Context) ([]armsubscriptions.TenantIDDescription, error)
+	ListLocations(ctx context.Context, subscriptionId string) ([]armsubscriptions.Location, error)
+
+	ListResourceGroups(ctx context.Context, subscriptionId string) ([]armresources.ResourceGroup, error)
+	NewResourceGroup(ctx context.Context, subscriptionId, name, location string) error
+
+	ListClusters(ctx context.Context, subscriptionId, resourceGroup string) ([]armcontainerservice.ManagedCluster, error)
+	GetManagedCluster(ctx context.Context, subscriptionId, resourceGroup, name string) (armcontainerservice.ManagedCluster, error)
+	NewCluster(ctx context.Context, subscriptionId, resourceGroup, name, location string) error
+	EnableKeyvaultCSIDriver(ctx context.Context, subscriptionId, resourceGroup, name string) error
+	LinkAcr(ctx context.Context, subscriptionId, clusterResourceGroup, clusterName, acrSubscriptionId, acrResourceGroup, acrName string) error
+	EnableWorkloadIdentity(ctx context.Context, subscriptionId, resourceGroup, name string) (string, error)
+	GetKubeconfig(ctx context.Context, subscriptionId, resourceGroup, name string) ([]byte, error)
+
+	NewIdentity(ctx context.Context, subscriptionId, resourceGroup, name, location string) (*Identity, error)
+	GetIdentity(ctx context.Context, subscriptionId, resourceGroup, name string) (*Identity, error)
+	AddFederatedCredential(ctx context.Context, identity *Identity, name, issuer, subject string) error
+
+	ListContainerRegistries(ctx context.Context, subscriptionId, resourceGroup string) ([]armcontainerregistry.Registry, error)
+	NewContainerRegistry(ctx context.Context, subscriptionId, resourceGroup, name, location string) error
+	CheckACRPullAccess(ctx context.Context, subscriptionId, clusterResourceGroup, clusterName, acrSubscriptionId, acrResourceGroup, acrName string) error
+	RegistryCredential(ctx context.Context, loginServer string) (string, string, error)
+
+	ListKeyVaults(ctx context.Context, subscriptionId, resourceGroup string) ([]Akv, error)
+	GetKeyVault(ctx context.Context, subscriptionId, resourceGroup, name string) (*Akv, error)
+	NewAkv(ctx context.Context, tenantId, subscriptionId, resourceGroup, name, location string) (*Akv, error)
+	AddAccessPolicy(ctx context.Context, kv *Akv, objectId string, permissions armkeyvault.Permissions) error
+	AddUserAccessPolicy(ctx context.Context, kv *Akv, permissions armkeyvault.Permissions) error
+	AddRoleAssignment(ctx context.Context, kv *Akv, objectId string, role Role) error
+	AddUserRoleAssignment(ctx context.Context, kv *Akv, role Role) error
+	HasSecretAccess(ctx context.Context, kv *Akv, objectId string) (bool, error)
+	PutSecretIfNewValue(ctx context.Context, kv *Akv, name, value string) error
+	GetPublicKey(ctx context.Context, kv *Akv, keyName string) (*ecdsa.PublicKey, error)
+	SignWithKey(ctx context.Context, kv *Akv, keyName string, digest []byte) ([]byte, error)
+
+	ListRoleAssignments(ctx context.Context, subscriptionId, resourceGroup string) ([]armauthorization.RoleAssignment, error)
+	MissingAccess(ctx context.Context, checks []AccessCheck) ([]AccessCheck, error)
+	SignedInObjectId(ctx context.Context) (string, error)
+
+	GetTags(ctx context.Context, id string) (map[string]string, error)
+	ListResourceIds(ctx context.Context, subscriptionId, resourceGroup string) ([]string, error)
+	DeleteResource(ctx context.Context, id string) error
+}
+
+// WithProvider sets the provider as the provider for the context
+func WithProvider(ctx context.Context, p Provider) context.Context {
+	return context.WithValue(ctx, providerKey, p)
+}
+
+// ProviderFromContext returns the provider from the context or the default Azure SDK provider if none is set
+func ProviderFromContext(ctx context.Context) Provider {
+	if p, ok := ctx.Value(providerKey).(Provider); ok && p != nil {
+		return p
+	}
+
+	return sdkProvider{}
+}
+
+// sdkProvider implements Provider by calling Azure through the Azure SDKs
+type sdkProvider struct{}
+
+var _ Provider = sdkProvider{}
+
+func (sdkProvider) ListSubscriptions(ctx context.Context) ([]armsubscription.Subscription, error) {
+	return ListSubscriptions(ctx)
+}
+
+func (sdkProvider) GetSubscription(ctx context.Context, subscriptionId string) (*armsubscriptions.Subscription, error) {
+	return GetSubscription(ctx, subscriptionId)
+}
+
+func (sdkProvider) ListTenants(ctx context.Context) ([]armsubscriptions.TenantIDDescription, error) {
+	return ListTenants(ctx)
+}
+
+func (sdkProvider) ListLocations(ctx context.Context, subscriptionId string) ([]armsubscriptions.Location, error) {
+	return ListLocations(ctx, subscriptionId)
+}
+
+func (sdkProvider) ListResourceGroups(ctx context.Context, subscriptionId string) ([]armresources.ResourceGroup, error) {
+	return ListResourceGroups(ctx, subscriptionId)
+}
+
+func (sdkProvider) NewResourceGroup(ctx context.Context, subscriptionId, name, location string) error {
+	return NewResourceGroup(ctx, subscriptionId, name, location)
+}
+
+func (sdkProvider) ListClusters(ctx context.Context, subscriptionId, resourceGroup string) ([]armcontainerservice.ManagedCluster, error) {
+	return ListClusters(ctx, subscriptionId, resourceGroup)
+}
+
+func (sdkProvider) GetManagedCluster(ctx context.Context, subscriptionId, resourceGroup, name string) (armcontainerservice.ManagedCluster, error) {
+	return GetManagedCluster(ctx, subscriptionId, resourceGroup, name)
+}
+
+func (sdkProvider) NewCluster(ctx context.Context, subscriptionId, resourceGroup, name, location string) error {
+	return NewCluster(ctx, subscriptionId, resourceGroup, name, location)
+}
+
+func (sdkProvider) EnableKeyvaultCSIDriver(ctx context.Context, subscriptionId, resourceGroup, name string) error {
+	return EnableKeyvaultCSIDriver(ctx, subscriptionId, resourceGroup, name)
+}
+
+func (sdkProvider) LinkAcr(ctx context.Context, subscriptionId, clusterResourceGroup, clusterName, acrSubscriptionId, acrResourceGroup, acrName string) error {
+	return LinkAcr(ctx, subscriptionId, clusterResourceGroup, clusterName, acrSubscriptionId, acrResourceGroup, acrName)
+}
+
+func (sdkProvider) EnableWorkloadIdentity(ctx context.Context, subscriptionId, resourceGroup, name string) (string, error) {
+	return EnableWorkloadIdentity(ctx, subscriptionId, resourceGroup, name)
+}
+
+func (sdkProvider) NewIdentity(ctx context.Context, subscriptionId, resourceGroup, name, location string) (*Identity, error) {
+	return NewIdentity(ctx, subscriptionId, resourceGroup, name, location)
+}
+
+func (sdkProvider) AddFederatedCredential(ctx context.Context, identity *Identity, name, issuer, subject string) error {
+	return AddFederatedCredential(ctx, identity, name, issuer, subject)
+}
+
+func (sdkProvider) ListContainerRegistries(ctx context.Context, subscriptionId, resourceGroup string) ([]armcontainerregistry.Registry, error) {
+	return ListContainerRegistries(ctx, subscriptionId, resourceGroup)
+}
+
+func (sdkProvider) NewContainerRegistry(ctx context.Context, subscriptionId, resourceGroup, name, location string) error {
+	return NewContainerRegistry(ctx, subscriptionId, resourceGroup, name, location)
+}
+
+func (sdkProvider) CheckACRPullAccess(ctx context.Context, subscriptionId, clusterResourceGroup, clusterName, acrSubscriptionId, acrResourceGroup, acrName string) error {
+	return CheckACRPullAccess(ctx, subscriptionId, clusterResourceGroup, clusterName, acrSubscriptionId, acrResourceGroup, acrName)
+}
+
+func (sdkProvider) RegistryCredential(ctx context.Context, loginServer string) (string, string, error) {
+	return RegistryCredential(ctx, loginServer)
+}
+
+func (sdkProvider) ListKeyVaults(ctx context.Context, subscriptionId, resourceGroup string) ([]Akv, error) {
+	return ListKeyVaults(ctx, subscriptionId, resourceGroup)
+}
+
+func (sdkProvider) GetKeyVault(ctx context.Context, subscriptionId, resourceGroup, name string) (*Akv, error) {
+	return GetKeyVault(ctx, subscriptionId, resourceGroup, name)
+}
+
+func (sdkProvider) NewAkv(ctx context.Context, tenantId, subscriptionId, resourceGroup, name, location string) (*Akv, error) {
+	return NewAkv(ctx, tenantId, subscriptionId, resourceGroup, name, location)
+}
+
+func (sdkProvider) AddAccessPolicy(ctx context.Context, kv *Akv, objectId string, permissions armkeyvault.Permissions) error {
+	return kv.AddAccessPolicy(ctx, objectId, permissions)
+}
+
+func (sdkProvider) AddUserAccessPolicy(ctx context.Context, kv *Akv, permissions armkeyvault.Permissions) error {
+	return kv.AddUserAccessPolicy(ctx, permissions)
+}
+
+func (sdkProvider) AddRoleAssignment(ctx context.Context, kv *Akv, objectId string, role Role) error {
+	return kv.AddRoleAssignment(ctx, objectId, role)
+}
+
+func (sdkProvider) AddUserRoleAssignment(ctx context.Context, kv *Akv, role Role) error {
+	return kv.AddUserRoleAssignment(ctx, role)
+}
+
+func (sdkProvider) GetPublicKey(ctx context.Context, kv *Akv, keyName string) (*ecdsa.PublicKey, error) {
+	return kv.GetPublicKey(ctx, keyName)
+}
+
+func (sdkProvider) SignWithKey(ctx context.Context, kv *Akv, keyName string, digest []byte) ([]byte, error) {
+	return kv.SignWithKey(ctx, keyName, digest)
+}
+
+func (sdkProvider) ListRoleAssignments(ctx context.Context, subscriptionId, resourceGroup string) ([]armauthorization.RoleAssignment, error) {
+	return ListRoleAssignments(ctx, subscriptionId, resourceGroup)
+}
+
+func (sdkProvider) MissingAccess(ctx context.Context, checks []AccessCheck) ([]AccessCheck, error) {
+	return MissingAccess(ctx, checks)
+}
+
+func (sdkProvider) SignedInObjectId(ctx context.Context) (string, error) {
+	return SignedInObjectId(ctx)
+}
+
+func (sdkProvider) GetKubeconfig(ctx context.Context, subscriptionId, resourceGroup, name string) ([]byte, error) {
+	return GetKubeconfig(ctx, subscriptionId, resourceGroup, name)
+}
+
+func (sdkProvider) GetIdentity(ctx context.Context, subscriptionId, resourceGroup, name string) (*Identity, error) {
+	return GetIdentity(ctx, subscriptionId, resourceGroup, name)
+}
+
+func (sdkProvider) HasSecretAccess(ctx context.Context, kv *Akv, objectId string) (bool, error) {
+	return kv.HasSecretAccess(ctx, objectId)
+}
+
+func (sdkProvider) PutSecretIfNewValue(ctx context.Context, kv *Akv, name, value string) error {
+	return kv.PutSecretIfNewValue(ctx, name, value)
+}
+
+func (sdkProvider) GetTags(ctx context.Context, id string) (map[string]string, error) {
+	return GetTags(ctx, id)
+}
+
+func (sdkProvider) ListResourceIds(ctx context.Context, subscriptionId, resourceGroup string) ([]string, error) {
+	return ListResourceIds(ctx, subscriptionId, resourceGroup)
+}
+
+func (sdkProvider) DeleteResource(ctx context.Context, id string) error {
+	return DeleteResource(ctx, id)
+}
@@ -0,0 +1,68 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+)
+
+// RegistryUsername is the username container registries accept with a refresh token as the password
+const RegistryUsername = "00000000-0000-0000-0000-000000000000"
+
+// RegistryCredential exchanges the signed-in user's Azure token for a refresh token of the container registry and
+// returns it with the username registry clients authenticate with
+func RegistryCredential(ctx context.Context, loginServer string) (string, string, error) {
+	lgr := logger.FromContext(ctx).With("login server", loginServer)
+	lgr.Debug("getting container registry credential")
+
+	cred, err := getCred()
+	if err != nil {
+		return "", "", fmt.Errorf("getting credential: %w", err)
+	}
+
+	token, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{armScope()}})
+	if err != nil {
+		return "", "", fmt.Errorf("getting token: %w", err)
+	}
+
+	form := url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {loginServer},
+		"access_token": {token.Token},
+	}
+	if tenantId != "" {
+		form.Set("tenant", tenantId)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+loginServer+"/oauth2/exchange", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", "", fmt.Errorf("creating token exchange request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("exchanging token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("exchanging token: unexpected status %s", resp.Status)
+	}
+
+	var exchanged struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&exchanged); err != nil {
+		return "", "", fmt.Errorf("decoding refresh token: %w", err)
+	}
+
+	lgr.Debug("finished getting container registry credential")
+	return RegistryUsername, exchanged.RefreshToken, nil
+}
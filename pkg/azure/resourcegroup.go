@@ -6,6 +6,7 @@ import (
 	"fmt"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
 	"github.com/azure/spin-aks-plugin/pkg/logger"
 )
 
@@ -19,7 +20,7 @@ func ListResourceGroups(ctx context.Context, sub string) ([]armresources.Resourc
 		return nil, fmt.Errorf("getting credentials: %w", err)
 	}
 
-	client, err := armresources.NewResourceGroupsClient(sub, cred, nil)
+	client, err := armresources.NewResourceGroupsClient(sub, cred, armOptions())
 	if err != nil {
 		return nil, fmt.Errorf("creating resource groups client: %w", err)
 	}
@@ -50,23 +51,48 @@ func NewResourceGroup(ctx context.Context, sub, name, location string) error {
 	ctx = logger.WithContext(ctx, lgr)
 	lgr.Debug("creating Azure resource group")
 
+	if planned(ctx, dryrun.Change{
+		Action: dryrun.Create,
+		Kind:   "Resource Group",
+		Target: resourceGroupId(sub, name),
+		Detail: "location " + location,
+	}) {
+		return nil
+	}
+
 	cred, err := getCred()
 	if err != nil {
 		return fmt.Errorf("getting credentials: %w", err)
 	}
 
-	client, err := armresources.NewResourceGroupsClient(sub, cred, nil)
+	client, err := armresources.NewResourceGroupsClient(sub, cred, armOptions())
 	if err != nil {
 		return fmt.Errorf("creating resource groups client: %w", err)
 	}
 
+	// a resource group that already exists is left unchanged so it isn't tagged as created by the plugin
+	_, err = client.Get(ctx, name, nil)
+	switch {
+	case err == nil:
+		lgr.Debug("resource group already exists")
+		return nil
+	case isNotFound(err):
+	default:
+		return fmt.Errorf("getting resource group: %w", err)
+	}
+
 	if _, err := client.CreateOrUpdate(ctx, name, armresources.ResourceGroup{
 		Name:     &name,
 		Location: &location,
+		Tags:     createdByTags(),
 	}, nil); err != nil {
 		return fmt.Errorf("creating resource group: %w", err)
 	}
 
+	if err := RecordCreated(ctx, resourceGroupId(sub, name)); err != nil {
+		return fmt.Errorf("recording created resource group: %w", err)
+	}
+
 	lgr.Debug("finished creating Azure resource group")
 	return nil
 }
@@ -2,12 +2,66 @@ package azure
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"strings"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/google/uuid"
 )
 
+// roleAssignmentNamespace is the namespace role assignment names are derived in
+var roleAssignmentNamespace = uuid.MustParse("6f2b8a4e-3c1d-4f7a-9b5e-2d8c0a1e7f34")
+
+// roleAssignmentName returns the name of the role assignment of the role to the principal at the scope. The name
+// is the same every time so retrying an assignment can't create a duplicate.
+func roleAssignmentName(objectId, roleId, scope string) string {
+	key := strings.ToLower(strings.Join([]string{objectId, roleId, strings.TrimSuffix(scope, "/")}, "|"))
+	return uuid.NewSHA1(roleAssignmentNamespace, []byte(key)).String()
+}
+
+// ensureRoleAssignment assigns the role at the scope to the principal unless it's already assigned at or above
+// the scope. The change is recorded instead when it's a dry run.
+func ensureRoleAssignment(ctx context.Context, subscriptionId, objectId string, role Role, scope string, change dryrun.Change) error {
+	lgr := logger.FromContext(ctx)
+
+	// planned principals and scopes can't have existing role assignments
+	if objectId == "" || isPlannedPrincipal(objectId) || isPlanned(ctx, scope) {
+		planned(ctx, change)
+		return nil
+	}
+
+	roleId := strings.TrimPrefix(role.ID, "/providers/Microsoft.Authorization/roleDefinitions/")
+
+	client, err := createRoleAssignmentClient(subscriptionId)
+	if err != nil {
+		return fmt.Errorf("creating role assignment client: %w", err)
+	}
+
+	exists, err := client.hasRoleAssignment(ctx, objectId, roleId, scope)
+	if err != nil {
+		return fmt.Errorf("checking for existing role assignment: %w", err)
+	}
+	if exists {
+		lgr.Info(fmt.Sprintf("already granted %s on %s", change.Detail, scope))
+		return nil
+	}
+
+	if planned(ctx, change) {
+		return nil
+	}
+
+	if err := client.createRoleAssignment(ctx, objectId, roleId, scope, roleAssignmentName(objectId, roleId, scope)); err != nil {
+		return fmt.Errorf("creating role assignment: %w", err)
+	}
+
+	return nil
+}
+
 type roleAssignmentClient struct {
 	client *armauthorization.RoleAssignmentsClient
 }
@@ -18,7 +72,7 @@ func createRoleAssignmentClient(subscriptionId string) (*roleAssignmentClient, e
 		return nil, fmt.Errorf("getting credential: %w", err)
 	}
 
-	client, err := armauthorization.NewRoleAssignmentsClient(subscriptionId, credential, nil)
+	client, err := armauthorization.NewRoleAssignmentsClient(subscriptionId, credential, armOptions())
 	if err != nil {
 		return nil, fmt.Errorf("creating role assignments client: %w", err)
 	}
@@ -30,9 +84,9 @@ func (r *roleAssignmentClient) createRoleAssignment(ctx context.Context, objectI
 	lgr := logger.FromContext(ctx).With("objectId", objectId, "role assignment UID", raUid, "scope", scope)
 
 	ctx = logger.WithContext(ctx, lgr)
-	lgr.Debug("linking ACR")
+	lgr.Debug("creating role assignment")
 
-	fullAssignmentId := fmt.Sprintf("/%s/providers/Microsoft.Authorization/roleAssignments/%s", scope, raUid)
+	fullAssignmentId := fmt.Sprintf("/%s/providers/Microsoft.Authorization/roleAssignments/%s", strings.TrimPrefix(scope, "/"), raUid)
 	fulLDefinitionId := fmt.Sprintf("/providers/Microsoft.Authorization/roleDefinitions/%s", roleId)
 
 	params := armauthorization.RoleAssignmentCreateParameters{
@@ -46,8 +100,54 @@ func (r *roleAssignmentClient) createRoleAssignment(ctx context.Context, objectI
 	lgr.Debug("response from create role assignment", "resp", resp)
 
 	if err != nil {
+		// the assignment name is derived from the principal, role, and scope so a conflict means it already exists
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.ErrorCode == "RoleAssignmentExists" {
+			lgr.Debug("role assignment already exists")
+			return nil
+		}
+
 		return fmt.Errorf("creating role assignment: %w", err)
 	}
 
 	return nil
 }
+
+// hasRoleAssignment returns true if the principal is assigned the role at or above the scope
+func (r *roleAssignmentClient) hasRoleAssignment(ctx context.Context, objectId, roleId, scope string) (bool, error) {
+	lgr := logger.FromContext(ctx).With("objectId", objectId, "roleId", roleId, "scope", scope)
+	lgr.Debug("checking for existing role assignment")
+
+	pager := r.client.NewListForScopePager(scope, &armauthorization.RoleAssignmentsClientListForScopeOptions{
+		Filter: to.Ptr(fmt.Sprintf("principalId eq '%s'", objectId)),
+	})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return false, fmt.Errorf("listing role assignments page: %w", err)
+		}
+
+		for _, ra := range page.Value {
+			if ra == nil || ra.Properties == nil {
+				return false, errors.New("nil role assignment")
+			}
+
+			if ra.Properties.RoleDefinitionID == nil || ra.Properties.Scope == nil {
+				continue
+			}
+
+			// assignments below the scope are also returned when filtering by principal. The root scope is "/" so
+			// trailing slashes are trimmed before matching whole path segments.
+			raScope := strings.TrimSuffix(strings.ToLower(*ra.Properties.Scope), "/")
+			lowerScope := strings.ToLower(scope)
+			atOrAbove := raScope == lowerScope || strings.HasPrefix(lowerScope, raScope+"/")
+			if atOrAbove && strings.HasSuffix(strings.ToLower(*ra.Properties.RoleDefinitionID), "/"+strings.ToLower(roleId)) {
+				lgr.Debug("found existing role assignment")
+				return true, nil
+			}
+		}
+	}
+
+	lgr.Debug("no existing role assignment")
+	return false, nil
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/subscription/armsubscription"
 	"github.com/azure/spin-aks-plugin/pkg/logger"
 )
@@ -18,7 +19,7 @@ func ListSubscriptions(ctx context.Context) ([]armsubscription.Subscription, err
 		return nil, fmt.Errorf("getting credentials: %w", err)
 	}
 
-	client, err := armsubscription.NewSubscriptionsClient(cred, nil)
+	client, err := armsubscription.NewSubscriptionsClient(cred, armOptions())
 	if err != nil {
 		return nil, fmt.Errorf("creating subscriptions client: %w", err)
 	}
@@ -43,3 +44,26 @@ func ListSubscriptions(ctx context.Context) ([]armsubscription.Subscription, err
 	lgr.Debug("finished listing Azure subscriptions")
 	return subs, nil
 }
+
+func GetSubscription(ctx context.Context, subscriptionId string) (*armsubscriptions.Subscription, error) {
+	lgr := logger.FromContext(ctx).With("subscriptionId", subscriptionId)
+	lgr.Debug("getting Azure subscription")
+
+	cred, err := getCred()
+	if err != nil {
+		return nil, fmt.Errorf("getting credentials: %w", err)
+	}
+
+	client, err := armsubscriptions.NewClient(cred, armOptions())
+	if err != nil {
+		return nil, fmt.Errorf("creating subscriptions client: %w", err)
+	}
+
+	resp, err := client.Get(ctx, subscriptionId, nil)
+	if err != nil {
+		return nil, fmt.Errorf("getting subscription: %w", err)
+	}
+
+	lgr.Debug("finished getting Azure subscription")
+	return &resp.Subscription, nil
+}
@@ -5,43 +5,52 @@ import (
 	"errors"
 	"fmt"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/subscription/armsubscription"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
 	"github.com/azure/spin-aks-plugin/pkg/logger"
 )
 
-func GetTenant(ctx context.Context) (*armsubscription.TenantIDDescription, error) {
-	lgr := logger.FromContext(ctx)
-	lgr.Debug("getting Azure tenant")
+// tenantId is the tenant credentials are scoped to. Empty means the credential's default tenant.
+var tenantId string
 
-	tenants, err := ListTenants(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("listing tenants: %w", err)
-	}
+// SetTenant scopes credentials to the tenant. Credentials created for a previous tenant are discarded.
+func SetTenant(id string) {
+	tenantId = id
+	cred = nil
+}
 
-	if len(tenants) == 0 {
-		return nil, errors.New("no tenants found")
+// CurrentTenant returns the tenant credentials are scoped to or an empty string if no tenant has been set
+func CurrentTenant() string {
+	return tenantId
+}
+
+// TenantName returns the display name of the tenant or the tenant id if it has no display name
+func TenantName(t armsubscriptions.TenantIDDescription) string {
+	if t.DisplayName != nil && *t.DisplayName != "" {
+		return *t.DisplayName
 	}
-	if len(tenants) > 1 {
-		return nil, errors.New("multiple tenants found")
+
+	if t.TenantID != nil {
+		return *t.TenantID
 	}
-	return &tenants[0], nil
+
+	return ""
 }
 
-func ListTenants(ctx context.Context) ([]armsubscription.TenantIDDescription, error) {
+func ListTenants(ctx context.Context) ([]armsubscriptions.TenantIDDescription, error) {
 	lgr := logger.FromContext(ctx)
-	lgr.Debug("listing Azure subscriptions")
+	lgr.Debug("listing Azure tenants")
 
 	cred, err := getCred()
 	if err != nil {
 		return nil, fmt.Errorf("getting credentials: %w", err)
 	}
 
-	client, err := armsubscription.NewTenantsClient(cred, nil)
+	client, err := armsubscriptions.NewTenantsClient(cred, armOptions())
 	if err != nil {
 		return nil, fmt.Errorf("creating tenants client: %w", err)
 	}
 
-	var tenants []armsubscription.TenantIDDescription
+	var tenants []armsubscriptions.TenantIDDescription
 	pager := client.NewListPager(nil)
 	for pager.More() {
 		page, err := pager.NextPage(ctx)
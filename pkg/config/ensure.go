@@ -19,12 +19,17 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/subscription/armsubscription"
 	"github.com/azure/spin-aks-plugin/pkg/azure"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
 	"github.com/azure/spin-aks-plugin/pkg/logger"
 	"github.com/azure/spin-aks-plugin/pkg/prompt"
 	"github.com/azure/spin-aks-plugin/pkg/spin"
 	"github.com/azure/spin-aks-plugin/pkg/state"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+	"github.com/azure/spin-aks-plugin/pkg/utils"
 )
 
+// keys of defaults in state. Subscriptions and resource groups are remembered per resource kind, like
+// "cluster/subscription", so choosing one for the container registry doesn't change the default for the cluster.
 const (
 	subscriptionKey      = "subscription"
 	resourceGroupKey     = "resourceGroup"
@@ -32,6 +37,7 @@ const (
 	containerRegistryKey = "containerRegistry"
 	spinManifestKey      = "spinManifest"
 	keyVaultKey          = "keyVault"
+	tenantKey            = "tenant"
 )
 
 var (
@@ -41,39 +47,181 @@ var (
 	alphanumRegex                            = regexp.MustCompile("^[a-zA-Z0-9]+$")
 )
 
-// EnsureValid prompts users for all required fields
+// EnsureValid prompts users for all required fields. The spin manifest is ensured first so every other prompt
+// defaults to the last choice made in the same project. Nothing is created or granted until every prompt is answered
+// and the signed-in principal's access to make every change is checked.
 func EnsureValid(ctx context.Context) error {
-	if err := ensureCluster(ctx); err != nil {
+	m, err := ensureSpinManifest(ctx)
+	if err != nil {
+		return fmt.Errorf("ensuring spin manifest: %w", err)
+	}
+
+	project := projectOf(ctx, c.SpinManifest)
+	ch := newChanges()
+
+	if err := ensureTenant(ctx, project); err != nil {
+		return fmt.Errorf("ensuring tenant: %w", err)
+	}
+
+	if err := ensureCluster(ctx, project, ch); err != nil {
 		return fmt.Errorf("ensuring cluster: %w", err)
 	}
 
-	if err := ensureAcr(ctx); err != nil {
+	if err := ensureAcr(ctx, project, ch); err != nil {
 		return fmt.Errorf("ensuring acr: %w", err)
 	}
 
-	m, err := ensureSpinManifest(ctx)
+	if err := ensureKeyVault(ctx, project, m, ch); err != nil {
+		return fmt.Errorf("ensuring keyvault: %w", err)
+	}
+
+	if err := ch.apply(ctx); err != nil {
+		return fmt.Errorf("applying changes: %w", err)
+	}
+
+	return nil
+}
+
+// projectOf returns the project of the spin manifest that defaults are remembered by. That's the git remote of the
+// repository containing it and the manifest's path in the repository, like
+// "https://github.com/org/repo#apps/api/spin.toml", so every clone shares defaults but apps in the same repository
+// don't. Outside of git it's the manifest's absolute path.
+func projectOf(ctx context.Context, manifestPath string) string {
+	abs, err := filepath.Abs(manifestPath)
 	if err != nil {
-		return fmt.Errorf("ensuring spin manifest: %w", err)
+		return manifestPath
 	}
 
-	if err := ensureKeyVault(ctx, m); err != nil {
-		return fmt.Errorf("ensuring keyvault: %w", err)
+	dir := filepath.Dir(abs)
+	remote, err := utils.GitRemote(ctx, dir)
+	if err != nil || remote == "" {
+		return abs
 	}
 
+	root, err := utils.GitRoot(ctx, dir)
+	if err != nil {
+		return abs
+	}
+
+	// git resolves symlinks in the root, like /tmp on macOS
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return abs
+	}
+
+	rel, err := filepath.Rel(root, filepath.Join(resolved, filepath.Base(abs)))
+	if err != nil {
+		return abs
+	}
+
+	return remote + "#" + filepath.ToSlash(rel)
+}
+
+// kindKey returns the key of the default of a resource kind's field like "cluster/subscription"
+func kindKey(kind, key string) string {
+	return kind + "/" + key
+}
+
+// ensureTenant selects the tenant every other resource is in and scopes credentials to it. Users in a single tenant
+// aren't prompted. Otherwise the default is the home tenant of the cluster's subscription if it's already configured.
+func ensureTenant(ctx context.Context, project string) error {
+	lgr := logger.FromContext(ctx)
+	az := azure.ProviderFromContext(ctx)
+	lgr.Debug("starting to ensure tenant config")
+
+	if c.TenantID == "" {
+		tenants, err := az.ListTenants(ctx)
+		if err != nil {
+			return fmt.Errorf("listing tenants: %w", err)
+		}
+
+		switch len(tenants) {
+		case 0:
+			return usererror.New(errors.New("no tenants found"), "No Azure tenants found for the signed-in identity. Try running \"az login\".")
+		case 1:
+			lgr.Debug("found a single tenant, skipping prompt")
+			c.TenantID = *tenants[0].TenantID
+		default:
+			def := defaultTenant(ctx, project, tenants)
+
+			lgr.Debug("prompting for tenant")
+			tenant, err := prompt.Select(ctx, "Select your Tenant", tenants, &prompt.SelectOpt[armsubscriptions.TenantIDDescription]{
+				Field:   azure.TenantName,
+				Default: def,
+			})
+			if err != nil {
+				return fmt.Errorf("selecting tenant: %w", err)
+			}
+			c.TenantID = *tenant.TenantID
+
+			if err := state.SetDefault(ctx, project, tenantKey, azure.TenantName(tenant)); err != nil {
+				// failing to set tenant in state is not worth failing
+				lgr.Debug("failed to set tenant in state: " + err.Error())
+			}
+
+			lgr.Debug("finished prompting for tenant")
+		}
+	}
+
+	azure.SetTenant(c.TenantID)
+
+	lgr.Debug("done ensuring tenant config")
 	return nil
 }
 
-func ensureCluster(ctx context.Context) error {
+// defaultTenant returns the name of the tenant to select by default. That's the home tenant of the cluster's
+// subscription when it's configured, then the most recently selected tenant in the project or any project, then the
+// signed-in identity's home tenant.
+func defaultTenant(ctx context.Context, project string, tenants []armsubscriptions.TenantIDDescription) string {
+	lgr := logger.FromContext(ctx)
+	az := azure.ProviderFromContext(ctx)
+
+	if c.Cluster.Subscription != "" {
+		sub, err := az.GetSubscription(ctx, c.Cluster.Subscription)
+		if err != nil {
+			// the subscription may be in a tenant the credential isn't scoped to, not worth failing
+			lgr.Debug("failed to get cluster subscription: " + err.Error())
+		}
+
+		if sub != nil && sub.TenantID != nil {
+			for _, t := range tenants {
+				if t.TenantID != nil && strings.EqualFold(*t.TenantID, *sub.TenantID) {
+					return azure.TenantName(t)
+				}
+			}
+		}
+	}
+
+	def, err := state.GetDefault(ctx, project, tenantKey)
+	if err == nil {
+		return def
+	}
+	if !errors.Is(err, state.KeyNotFoundErr) {
+		// failing to get tenant from state is not worth failing
+		lgr.Debug("failed to get tenant from state: " + err.Error())
+	}
+
+	for _, t := range tenants {
+		if t.TenantCategory != nil && *t.TenantCategory == armsubscriptions.TenantCategoryHome {
+			return azure.TenantName(t)
+		}
+	}
+
+	return ""
+}
+
+func ensureCluster(ctx context.Context, project string, ch *changes) error {
 	lgr := logger.FromContext(ctx)
+	az := azure.ProviderFromContext(ctx)
 	lgr.Debug("starting to ensure cluster config")
 
 	if c.Cluster.Subscription == "" {
-		subs, err := azure.ListSubscriptions(ctx)
+		subs, err := az.ListSubscriptions(ctx)
 		if err != nil {
 			return fmt.Errorf("listing subscriptions: %w", err)
 		}
 
-		def, err := state.Get(ctx, subscriptionKey)
+		def, err := state.GetDefault(ctx, project, kindKey(clusterKey, subscriptionKey))
 		if err != nil && !errors.Is(err, state.KeyNotFoundErr) {
 			// failing to get subscription from state is not worth failing
 			lgr.Debug("failed to get subscription from state: " + err.Error())
@@ -81,7 +229,7 @@ func ensureCluster(ctx context.Context) error {
 		}
 
 		lgr.Debug("prompting for cluster subscription")
-		sub, err := prompt.Select("Select your Cluster's Subscription", subs, &prompt.SelectOpt[armsubscription.Subscription]{
+		sub, err := prompt.Select(ctx, "Select your Cluster's Subscription", subs, &prompt.SelectOpt[armsubscription.Subscription]{
 			Field: func(t armsubscription.Subscription) string {
 				return *t.DisplayName
 			},
@@ -92,7 +240,7 @@ func ensureCluster(ctx context.Context) error {
 		}
 		c.Cluster.Subscription = *sub.SubscriptionID
 
-		if err := state.Set(ctx, subscriptionKey, *sub.DisplayName); err != nil {
+		if err := state.SetDefault(ctx, project, kindKey(clusterKey, subscriptionKey), *sub.DisplayName); err != nil {
 			// failing to set subscription in state is not worth failing
 			lgr.Debug("failed to set subscription in state: " + err.Error())
 		}
@@ -101,7 +249,7 @@ func ensureCluster(ctx context.Context) error {
 	}
 
 	if c.Cluster.ResourceGroup == "" {
-		rg, err := getResourceGroup(ctx, c.Cluster.Subscription, "Cluster's")
+		rg, err := getResourceGroup(ctx, project, clusterKey, c.Cluster.Subscription, "Cluster's", ch)
 		if err != nil {
 			return fmt.Errorf("getting cluster resource group: %w", err)
 		}
@@ -110,7 +258,7 @@ func ensureCluster(ctx context.Context) error {
 	}
 
 	if c.Cluster.Name == "" {
-		cluster, err := GetClusterName(ctx, c.Cluster.Subscription, c.Cluster.ResourceGroup)
+		cluster, err := GetClusterName(ctx, project, c.Cluster.Subscription, c.Cluster.ResourceGroup, ch)
 		if err != nil {
 			return fmt.Errorf("getting cluster name: %w", err)
 		}
@@ -122,17 +270,18 @@ func ensureCluster(ctx context.Context) error {
 	return nil
 }
 
-func ensureAcr(ctx context.Context) error {
+func ensureAcr(ctx context.Context, project string, ch *changes) error {
 	lgr := logger.FromContext(ctx)
+	az := azure.ProviderFromContext(ctx)
 	lgr.Debug("starting to ensure acr config")
 
 	if c.ContainerRegistry.Subscription == "" {
-		subs, err := azure.ListSubscriptions(ctx)
+		subs, err := az.ListSubscriptions(ctx)
 		if err != nil {
 			return fmt.Errorf("listing subscriptions: %w", err)
 		}
 
-		def, err := state.Get(ctx, subscriptionKey)
+		def, err := state.GetDefault(ctx, project, kindKey(containerRegistryKey, subscriptionKey))
 		if err != nil && !errors.Is(err, state.KeyNotFoundErr) {
 			// failing to get subscription from state is not worth failing
 			lgr.Debug("failed to get subscription from state: " + err.Error())
@@ -140,7 +289,7 @@ func ensureAcr(ctx context.Context) error {
 		}
 
 		lgr.Debug("prompting for acr subscription")
-		sub, err := prompt.Select("Select your Container Registry's Subscription", subs, &prompt.SelectOpt[armsubscription.Subscription]{
+		sub, err := prompt.Select(ctx, "Select your Container Registry's Subscription", subs, &prompt.SelectOpt[armsubscription.Subscription]{
 			Field: func(t armsubscription.Subscription) string {
 				return *t.DisplayName
 			},
@@ -151,7 +300,7 @@ func ensureAcr(ctx context.Context) error {
 		}
 		c.ContainerRegistry.Subscription = *sub.SubscriptionID
 
-		if err := state.Set(ctx, subscriptionKey, *sub.DisplayName); err != nil {
+		if err := state.SetDefault(ctx, project, kindKey(containerRegistryKey, subscriptionKey), *sub.DisplayName); err != nil {
 			// failing to set subscription in state is not worth failing
 			lgr.Debug("failed to set subscription in state: " + err.Error())
 		}
@@ -160,7 +309,7 @@ func ensureAcr(ctx context.Context) error {
 	}
 
 	if c.ContainerRegistry.ResourceGroup == "" {
-		rg, err := getResourceGroup(ctx, c.ContainerRegistry.Subscription, "Container Registry's")
+		rg, err := getResourceGroup(ctx, project, containerRegistryKey, c.ContainerRegistry.Subscription, "Container Registry's", ch)
 		if err != nil {
 			return fmt.Errorf("getting container registry's resource group: %w", err)
 		}
@@ -169,7 +318,7 @@ func ensureAcr(ctx context.Context) error {
 	}
 
 	if c.ContainerRegistry.Name == "" {
-		acr, err := getContainerRegistry(ctx, c.ContainerRegistry.Subscription, c.ContainerRegistry.ResourceGroup)
+		acr, err := getContainerRegistry(ctx, project, c.ContainerRegistry.Subscription, c.ContainerRegistry.ResourceGroup, ch)
 		if err != nil {
 			return fmt.Errorf("getting container registry's name: %w", err)
 		}
@@ -202,7 +351,7 @@ func ensureSpinManifest(ctx context.Context) (spin.Manifest, error) {
 			}
 		}
 
-		manifest, err := prompt.Input("Input your spin manifest location", &prompt.InputOpt{
+		manifest, err := prompt.Input(ctx, "Input your spin manifest location", &prompt.InputOpt{
 			Validate: prompt.FileExists,
 			Default:  guess,
 		})
@@ -229,8 +378,9 @@ func ensureSpinManifest(ctx context.Context) (spin.Manifest, error) {
 	return m, nil
 }
 
-func ensureKeyVault(ctx context.Context, m spin.Manifest) error {
+func ensureKeyVault(ctx context.Context, project string, m spin.Manifest, ch *changes) error {
 	lgr := logger.FromContext(ctx)
+	az := azure.ProviderFromContext(ctx)
 	lgr.Debug("starting to ensure keyvault config")
 
 	lgr.Debug(fmt.Sprintf("found %d variables", len(m.Variables)))
@@ -242,37 +392,39 @@ func ensureKeyVault(ctx context.Context, m spin.Manifest) error {
 	if hasSecretVariable {
 		lgr.Debug("found at least one secret variable, prompting for keyvault")
 
-		subs, err := azure.ListSubscriptions(ctx)
-		if err != nil {
-			return fmt.Errorf("listing subscriptions: %w", err)
-		}
+		if c.KeyVault.Subscription == "" {
+			subs, err := az.ListSubscriptions(ctx)
+			if err != nil {
+				return fmt.Errorf("listing subscriptions: %w", err)
+			}
 
-		def, err := state.Get(ctx, subscriptionKey)
-		if err != nil && !errors.Is(err, state.KeyNotFoundErr) {
-			// failing to get subscription from state is not worth failing
-			lgr.Debug("failed to get subscription from state: " + err.Error())
-			def = ""
-		}
+			def, err := state.GetDefault(ctx, project, kindKey(keyVaultKey, subscriptionKey))
+			if err != nil && !errors.Is(err, state.KeyNotFoundErr) {
+				// failing to get subscription from state is not worth failing
+				lgr.Debug("failed to get subscription from state: " + err.Error())
+				def = ""
+			}
 
-		lgr.Debug("prompting for keyvault subscription")
-		sub, err := prompt.Select("Select your KeyVault's Subscription", subs, &prompt.SelectOpt[armsubscription.Subscription]{
-			Field: func(t armsubscription.Subscription) string {
-				return *t.DisplayName
-			},
-			Default: def,
-		})
-		if err != nil {
-			return fmt.Errorf("selecting subscription: %w", err)
-		}
-		c.KeyVault.Subscription = *sub.SubscriptionID
+			lgr.Debug("prompting for keyvault subscription")
+			sub, err := prompt.Select(ctx, "Select your KeyVault's Subscription", subs, &prompt.SelectOpt[armsubscription.Subscription]{
+				Field: func(t armsubscription.Subscription) string {
+					return *t.DisplayName
+				},
+				Default: def,
+			})
+			if err != nil {
+				return fmt.Errorf("selecting subscription: %w", err)
+			}
+			c.KeyVault.Subscription = *sub.SubscriptionID
 
-		if err := state.Set(ctx, subscriptionKey, *sub.DisplayName); err != nil {
-			// failing to set subscription in state is not worth failing
-			lgr.Debug("failed to set subscription in state: " + err.Error())
+			if err := state.SetDefault(ctx, project, kindKey(keyVaultKey, subscriptionKey), *sub.DisplayName); err != nil {
+				// failing to set subscription in state is not worth failing
+				lgr.Debug("failed to set subscription in state: " + err.Error())
+			}
 		}
 
 		if c.KeyVault.ResourceGroup == "" {
-			rg, err := getResourceGroup(ctx, c.KeyVault.Subscription, "KeyVault's")
+			rg, err := getResourceGroup(ctx, project, keyVaultKey, c.KeyVault.Subscription, "KeyVault's", ch)
 			if err != nil {
 				return fmt.Errorf("getting keyvault's resource group: %w", err)
 			}
@@ -280,7 +432,7 @@ func ensureKeyVault(ctx context.Context, m spin.Manifest) error {
 			c.KeyVault.ResourceGroup = rg
 		}
 		if c.KeyVault.Name == "" {
-			kv, err := getKeyVault(ctx, c.KeyVault.Subscription, c.KeyVault.ResourceGroup)
+			kv, err := getKeyVault(ctx, project, c.KeyVault.Subscription, c.KeyVault.ResourceGroup, ch)
 			if err != nil {
 				return fmt.Errorf("getting keyvault's name: %w", err)
 			}
@@ -288,24 +440,89 @@ func ensureKeyVault(ctx context.Context, m spin.Manifest) error {
 			c.KeyVault.Name = kv
 		}
 
-		// TODO check keyvault access policy for existing keyvaults to see if we need to add the cluster's identity or user put/get permissions
+		// a new keyvault's access is checked at its resource group when it's chosen
+		if !ch.isNew(azure.KeyVaultScope(c.KeyVault.Subscription, c.KeyVault.ResourceGroup, c.KeyVault.Name)) {
+			akv, err := az.GetKeyVault(ctx, c.KeyVault.Subscription, c.KeyVault.ResourceGroup, c.KeyVault.Name)
+			if err != nil {
+				return fmt.Errorf("getting keyvault: %w", err)
+			}
+			if akv.TenantId != "" && !strings.EqualFold(akv.TenantId, c.TenantID) {
+				err := fmt.Errorf("keyvault tenant %s doesn't match selected tenant %s", akv.TenantId, c.TenantID)
+				return usererror.New(err, fmt.Sprintf("KeyVault %s is in tenant %s but tenant %s is selected. Select a KeyVault in the selected tenant or change the tenant_id in your config.", akv.Name, akv.TenantId, c.TenantID))
+			}
+
+			ch.require(keyVaultAccessChecks(akv)...)
+		}
+
+		if c.WorkloadIdentity.Enabled {
+			if err := defaultWorkloadIdentity(m); err != nil {
+				return fmt.Errorf("defaulting workload identity: %w", err)
+			}
+
+			ch.require(workloadIdentityAccessChecks()...)
+		}
+
+		ch.then(func(ctx context.Context) error {
+			return grantKeyVaultAccess(ctx, m)
+		})
+	} else {
+		lgr.Debug("no secret variables found, skipping keyvault")
+	}
+
+	return nil
+
+}
+
+// grantKeyVaultAccess grants the cluster's identity, or the application's identity with workload identity, access to
+// read the keyvault's secrets and the signed-in user access to read and write them
+func grantKeyVaultAccess(ctx context.Context, m spin.Manifest) error {
+	lgr := logger.FromContext(ctx)
+	az := azure.ProviderFromContext(ctx)
+	lgr.Debug("starting to grant keyvault access")
+
+	akv, err := az.GetKeyVault(ctx, c.KeyVault.Subscription, c.KeyVault.ResourceGroup, c.KeyVault.Name)
+	if err != nil {
+		return fmt.Errorf("getting keyvault: %w", err)
+	}
+
+	cluster, err := az.GetManagedCluster(ctx, c.Cluster.Subscription, c.Cluster.ResourceGroup, c.Cluster.Name)
+	if err != nil {
+		return fmt.Errorf("getting cluster: %w", err)
+	}
 
-		akv, err := azure.GetKeyVault(ctx, c.KeyVault.Subscription, c.KeyVault.ResourceGroup, c.KeyVault.Name)
-		c.TenantID = akv.TenantId
+	var principalId string
+	if c.WorkloadIdentity.Enabled {
+		lgr.Debug("workload identity enabled, granting keyvault access to the application's identity")
+		principalId, err = ensureWorkloadIdentity(ctx, m, cluster)
 		if err != nil {
-			return fmt.Errorf("getting keyvault: %w", err)
+			return fmt.Errorf("ensuring workload identity: %w", err)
 		}
+	} else {
+		if cluster.Identity == nil || cluster.Identity.PrincipalID == nil {
+			return errors.New("cluster has no managed identity principal")
+		}
+		principalId = *cluster.Identity.PrincipalID
+	}
 
-		cluster, err := azure.GetManagedCluster(ctx, c.Cluster.Subscription, c.Cluster.ResourceGroup, c.Cluster.Name)
-		clusterId := *cluster.Identity.PrincipalID
-		err = akv.AddAccessPolicy(ctx, clusterId, armkeyvault.Permissions{
+	if akv.RbacAuthorization {
+		lgr.Debug("keyvault uses rbac authorization, adding role assignments")
+		if err := az.AddRoleAssignment(ctx, akv, principalId, azure.KeyVaultSecretsUserRole); err != nil {
+			return fmt.Errorf("adding keyvault role assignment for cluster: %w", err)
+		}
+
+		if err := az.AddUserRoleAssignment(ctx, akv, azure.KeyVaultSecretsOfficerRole); err != nil {
+			return fmt.Errorf("adding keyvault role assignment for user: %w", err)
+		}
+	} else {
+		lgr.Debug("keyvault uses access policies, adding access policies")
+		err = az.AddAccessPolicy(ctx, akv, principalId, armkeyvault.Permissions{
 			Secrets: []*armkeyvault.SecretPermissions{to.Ptr(armkeyvault.SecretPermissionsGet)},
 		})
 		if err != nil {
 			return fmt.Errorf("adding keyvault access policy for cluster: %w", err)
 		}
 
-		err = akv.AddUserAccessPolicy(ctx, armkeyvault.Permissions{
+		err = az.AddUserAccessPolicy(ctx, akv, armkeyvault.Permissions{
 			Secrets: []*armkeyvault.SecretPermissions{
 				to.Ptr(armkeyvault.SecretPermissionsGet),
 				to.Ptr(armkeyvault.SecretPermissionsSet),
@@ -314,31 +531,128 @@ func ensureKeyVault(ctx context.Context, m spin.Manifest) error {
 		if err != nil {
 			return fmt.Errorf("adding keyvault access policy for user: %w", err)
 		}
-	} else {
-		lgr.Debug("no secret variables found, skipping keyvault")
 	}
 
+	lgr.Debug("done granting keyvault access")
 	return nil
+}
+
+// ensureWorkloadIdentity creates the application's user-assigned managed identity, which must already be defaulted
+// with defaultWorkloadIdentity, and federates it with the application's ServiceAccount through the cluster's OIDC
+// issuer. It returns the principal id of the identity.
+func ensureWorkloadIdentity(ctx context.Context, m spin.Manifest, cluster armcontainerservice.ManagedCluster) (string, error) {
+	lgr := logger.FromContext(ctx)
+	az := azure.ProviderFromContext(ctx)
+	lgr.Debug("starting to ensure workload identity")
+
+	location := ""
+	if cluster.Location != nil {
+		location = *cluster.Location
+	}
+
+	issuer, err := az.EnableWorkloadIdentity(ctx, c.Cluster.Subscription, c.Cluster.ResourceGroup, c.Cluster.Name)
+	if err != nil {
+		return "", fmt.Errorf("enabling cluster workload identity: %w", err)
+	}
+
+	identity, err := az.NewIdentity(ctx, c.WorkloadIdentity.Subscription, c.WorkloadIdentity.ResourceGroup, c.WorkloadIdentity.Name, location)
+	if err != nil {
+		return "", fmt.Errorf("creating identity: %w", err)
+	}
 
+	// the generated manifests put the ServiceAccount in a namespace with the same name as the application
+	subject := azure.ServiceAccountSubject(m.Name, m.Name)
+	if err := az.AddFederatedCredential(ctx, identity, m.Name, issuer, subject); err != nil {
+		return "", fmt.Errorf("adding federated credential: %w", err)
+	}
+
+	c.WorkloadIdentity.ClientID = identity.ClientId
+
+	lgr.Debug("done ensuring workload identity")
+	return identity.PrincipalId, nil
+}
+
+// defaultWorkloadIdentity defaults the application's identity to one named after the application next to the cluster
+func defaultWorkloadIdentity(m spin.Manifest) error {
+	if m.Name == "" {
+		return usererror.New(errors.New("name not set in spin manifest"), "Name not set in spin manifest. Add a name to your spin manifest and try again.")
+	}
+
+	if c.WorkloadIdentity.Subscription == "" {
+		c.WorkloadIdentity.Subscription = c.Cluster.Subscription
+	}
+	if c.WorkloadIdentity.ResourceGroup == "" {
+		c.WorkloadIdentity.ResourceGroup = c.Cluster.ResourceGroup
+	}
+	if c.WorkloadIdentity.Name == "" {
+		c.WorkloadIdentity.Name = m.Name + "-identity"
+	}
+
+	return nil
+}
+
+// keyVaultAccessChecks returns the access needed to grant access to the keyvault
+func keyVaultAccessChecks(akv *azure.Akv) []azure.AccessCheck {
+	var checks []azure.AccessCheck
+	if akv.RbacAuthorization {
+		checks = append(checks, azure.AccessCheck{
+			Scope:   akv.Id,
+			Role:    azure.UserAccessAdministratorRoleName,
+			Actions: []string{azure.RoleAssignmentWriteAction},
+		})
+	} else {
+		checks = append(checks, azure.AccessCheck{
+			Scope:   akv.Id,
+			Role:    azure.KeyVaultContributorRoleName,
+			Actions: []string{azure.KeyVaultAccessPolicyAction},
+		})
+	}
+
+	return checks
+}
+
+// workloadIdentityAccessChecks returns the access needed to create and federate the application's identity
+func workloadIdentityAccessChecks() []azure.AccessCheck {
+	return []azure.AccessCheck{
+		{
+			Scope:   azure.ResourceGroupScope(c.WorkloadIdentity.Subscription, c.WorkloadIdentity.ResourceGroup),
+			Role:    azure.IdentityContributorRoleName,
+			Actions: []string{azure.IdentityWriteAction, azure.FederatedCredentialWriteAction},
+		},
+		{
+			Scope:   azure.ClusterScope(c.Cluster.Subscription, c.Cluster.ResourceGroup, c.Cluster.Name),
+			Role:    azure.ClusterContributorRoleName,
+			Actions: []string{azure.ClusterWriteAction},
+		},
+	}
 }
 
 // getResourceGroup goes through steps of prompting user for a resource group. Possessive is the possessive
 // form of what the resource group would be used for. For example "Cluster's" would be passed in as possessive
-// if we are getting the resource group for the cluster
-func getResourceGroup(ctx context.Context, subscriptionId, possessive string) (string, error) {
+// if we are getting the resource group for the cluster. Kind is the state key of what it's used for, like clusterKey.
+// A new resource group is added to ch to be created later.
+func getResourceGroup(ctx context.Context, project, kind, subscriptionId, possessive string, ch *changes) (string, error) {
 	lgr := logger.FromContext(ctx)
+	az := azure.ProviderFromContext(ctx)
 	lgr.Debug(fmt.Sprintf("starting to get %s resource group", possessive))
 
 	if subscriptionId == "" {
 		return "", errors.New("subscriptionId is empty")
 	}
 
-	rgs, err := azure.ListResourceGroups(ctx, subscriptionId)
+	rgs, err := az.ListResourceGroups(ctx, subscriptionId)
 	if err != nil {
 		return "", fmt.Errorf("listing resource groups: %w", err)
 	}
 
-	def, err := state.Get(ctx, resourceGroupKey)
+	// resource groups that will be created for other resources can be selected too
+	for _, rg := range ch.resourceGroups {
+		if rg.Subscription == subscriptionId {
+			rgs = append(rgs, armresources.ResourceGroup{Name: to.Ptr(rg.ResourceGroup)})
+		}
+	}
+
+	def, err := state.GetDefault(ctx, project, kindKey(kind, resourceGroupKey))
 	if err != nil && !errors.Is(err, state.KeyNotFoundErr) {
 		// failing to get resource group from state is not worth failing
 		lgr.Debug("failed to get resource group from state: " + err.Error())
@@ -347,7 +661,7 @@ func getResourceGroup(ctx context.Context, subscriptionId, possessive string) (s
 
 	rgsWithNew := withNew(rgs)
 	lgr.Debug(fmt.Sprintf("prompting for %s resource group", possessive))
-	selection, err := prompt.Select(fmt.Sprintf("Select your %s Resource Group", possessive), rgsWithNew, &prompt.SelectOpt[newish[armresources.ResourceGroup]]{
+	selection, err := prompt.Select(ctx, fmt.Sprintf("Select your %s Resource Group", possessive), rgsWithNew, &prompt.SelectOpt[newish[armresources.ResourceGroup]]{
 		Field: func(t newish[armresources.ResourceGroup]) string {
 			if t.IsNew {
 				return "New Resource Group"
@@ -362,7 +676,7 @@ func getResourceGroup(ctx context.Context, subscriptionId, possessive string) (s
 	}
 
 	if !selection.IsNew {
-		if err := state.Set(ctx, resourceGroupKey, *selection.Data.Name); err != nil {
+		if err := state.SetDefault(ctx, project, kindKey(kind, resourceGroupKey), *selection.Data.Name); err != nil {
 			// failing to set resource group in state is not worth failing
 			lgr.Debug("failed to set resource group in state: " + err.Error())
 		}
@@ -371,19 +685,19 @@ func getResourceGroup(ctx context.Context, subscriptionId, possessive string) (s
 		return *selection.Data.Name, nil
 	}
 
-	name, err := prompt.Input("Input your new Resource Group name", &prompt.InputOpt{
-		Validate: validateResourceGroup,
+	name, err := prompt.Input(ctx, "Input your new Resource Group name", &prompt.InputOpt{
+		Validate: validateNew(validateResourceGroup, rgs, func(rg armresources.ResourceGroup) string { return *rg.Name }),
 	})
 	if err != nil {
 		return "", fmt.Errorf("inputting new resource group name: %w", err)
 	}
 
-	locations, err := azure.ListLocations(ctx, subscriptionId)
+	locations, err := az.ListLocations(ctx, subscriptionId)
 	if err != nil {
 		return "", fmt.Errorf("listing locations: %w", err)
 	}
 
-	location, err := prompt.Select("Input your new Resource Group location", locations, &prompt.SelectOpt[armsubscriptions.Location]{
+	location, err := prompt.Select(ctx, "Input your new Resource Group location", locations, &prompt.SelectOpt[armsubscriptions.Location]{
 		Field: func(t armsubscriptions.Location) string {
 			return *t.DisplayName
 		},
@@ -392,22 +706,29 @@ func getResourceGroup(ctx context.Context, subscriptionId, possessive string) (s
 		return "", fmt.Errorf("selecting new resource group location: %w", err)
 	}
 
-	if err := azure.NewResourceGroup(ctx, subscriptionId, name, *location.Name); err != nil {
-		return "", fmt.Errorf("creating new resource group: %w", err)
-	}
-	lgr.Info("created Resource Group " + name)
-
-	if err := state.Set(ctx, resourceGroupKey, name); err != nil {
-		// failing to set resource group in state is not worth failing
-		lgr.Debug("failed to set resource group in state: " + err.Error())
-	}
+	ch.require(azure.AccessCheck{
+		Scope:   azure.SubscriptionScope(subscriptionId),
+		Role:    azure.ContributorRoleName,
+		Actions: []string{azure.ResourceGroupWriteAction},
+	})
+	ch.resourceGroups = append(ch.resourceGroups, ResourceId{Subscription: subscriptionId, ResourceGroup: name})
+	ch.create(azure.ResourceGroupScope(subscriptionId, name), func(ctx context.Context) error {
+		if err := az.NewResourceGroup(ctx, subscriptionId, name, *location.Name); err != nil {
+			return fmt.Errorf("creating new resource group: %w", err)
+		}
+		lgr.Info("created Resource Group " + name)
+		setCreatedDefault(ctx, project, kindKey(kind, resourceGroupKey), name)
+		return nil
+	})
 
 	lgr.Debug(fmt.Sprintf("finished getting %s resource group", possessive))
 	return name, nil
 }
 
-func GetClusterName(ctx context.Context, subscriptionId, resourceGroup string) (string, error) {
+// GetClusterName goes through steps of prompting user for a cluster. A new cluster is added to ch to be created later.
+func GetClusterName(ctx context.Context, project, subscriptionId, resourceGroup string, ch *changes) (string, error) {
 	lgr := logger.FromContext(ctx)
+	az := azure.ProviderFromContext(ctx)
 	lgr.Debug("starting to get cluster")
 
 	if subscriptionId == "" {
@@ -417,12 +738,17 @@ func GetClusterName(ctx context.Context, subscriptionId, resourceGroup string) (
 		return "", errors.New("resourceGroup is empty")
 	}
 
-	clusters, err := azure.ListClusters(ctx, c.Cluster.Subscription, c.Cluster.ResourceGroup)
-	if err != nil {
-		return "", fmt.Errorf("listing clusters: %w", err)
+	// a resource group that's created later has nothing to list yet
+	var clusters []armcontainerservice.ManagedCluster
+	if !ch.isNew(azure.ResourceGroupScope(subscriptionId, resourceGroup)) {
+		var err error
+		clusters, err = az.ListClusters(ctx, subscriptionId, resourceGroup)
+		if err != nil {
+			return "", fmt.Errorf("listing clusters: %w", err)
+		}
 	}
 
-	def, err := state.Get(ctx, clusterKey)
+	def, err := state.GetDefault(ctx, project, clusterKey)
 	if err != nil && !errors.Is(err, state.KeyNotFoundErr) {
 		// failing to get cluster from state is not worth failing
 		lgr.Debug("failed to get cluster from state: " + err.Error())
@@ -430,7 +756,7 @@ func GetClusterName(ctx context.Context, subscriptionId, resourceGroup string) (
 	}
 
 	clustersWithNew := withNew(clusters)
-	selection, err := prompt.Select("Select your Cluster", clustersWithNew, &prompt.SelectOpt[newish[armcontainerservice.ManagedCluster]]{
+	selection, err := prompt.Select(ctx, "Select your Cluster", clustersWithNew, &prompt.SelectOpt[newish[armcontainerservice.ManagedCluster]]{
 		Field: func(t newish[armcontainerservice.ManagedCluster]) string {
 			if t.IsNew {
 				return "New Managed Cluster"
@@ -445,7 +771,7 @@ func GetClusterName(ctx context.Context, subscriptionId, resourceGroup string) (
 	}
 
 	if !selection.IsNew {
-		if err := state.Set(ctx, clusterKey, *selection.Data.Name); err != nil {
+		if err := state.SetDefault(ctx, project, clusterKey, *selection.Data.Name); err != nil {
 			// failing to set cluster in state is not worth failing
 			lgr.Debug("failed to set cluster in state: " + err.Error())
 		}
@@ -454,19 +780,19 @@ func GetClusterName(ctx context.Context, subscriptionId, resourceGroup string) (
 		return *selection.Data.Name, nil
 	}
 
-	name, err := prompt.Input("Input your new Managed Cluster name", &prompt.InputOpt{
-		Validate: validateCluster,
+	name, err := prompt.Input(ctx, "Input your new Managed Cluster name", &prompt.InputOpt{
+		Validate: validateNew(validateCluster, clusters, func(mc armcontainerservice.ManagedCluster) string { return *mc.Name }),
 	})
 	if err != nil {
 		return "", fmt.Errorf("inputting new managed cluster name: %w", err)
 	}
 
-	locations, err := azure.ListLocations(ctx, subscriptionId)
+	locations, err := az.ListLocations(ctx, subscriptionId)
 	if err != nil {
 		return "", fmt.Errorf("listing locations: %w", err)
 	}
 
-	location, err := prompt.Select("Input your new Managed Cluster location", locations, &prompt.SelectOpt[armsubscriptions.Location]{
+	location, err := prompt.Select(ctx, "Input your new Managed Cluster location", locations, &prompt.SelectOpt[armsubscriptions.Location]{
 		Field: func(t armsubscriptions.Location) string {
 			return *t.DisplayName
 		},
@@ -475,22 +801,29 @@ func GetClusterName(ctx context.Context, subscriptionId, resourceGroup string) (
 		return "", fmt.Errorf("selecting new managed cluster location: %w", err)
 	}
 
-	if err := azure.NewCluster(ctx, subscriptionId, resourceGroup, name, *location.Name); err != nil {
-		return "", fmt.Errorf("creating new managed cluster: %w", err)
-	}
-	lgr.Info("created Managed Cluster " + name)
-
-	if err := state.Set(ctx, clusterKey, name); err != nil {
-		// failing to set cluster in state is not worth failing
-		lgr.Debug("failed to set cluster in state: " + err.Error())
-	}
+	ch.require(azure.AccessCheck{
+		Scope:   azure.ResourceGroupScope(subscriptionId, resourceGroup),
+		Role:    azure.ClusterContributorRoleName,
+		Actions: []string{azure.ClusterWriteAction},
+	})
+	ch.create(azure.ClusterScope(subscriptionId, resourceGroup, name), func(ctx context.Context) error {
+		if err := az.NewCluster(ctx, subscriptionId, resourceGroup, name, *location.Name); err != nil {
+			return fmt.Errorf("creating new managed cluster: %w", err)
+		}
+		lgr.Info("created Managed Cluster " + name)
+		setCreatedDefault(ctx, project, clusterKey, name)
+		return nil
+	})
 
 	lgr.Debug("finished getting cluster")
 	return name, nil
 }
 
-func getContainerRegistry(ctx context.Context, subscriptionId, resourceGroup string) (string, error) {
+// getContainerRegistry goes through steps of prompting user for a container registry. A new container registry is
+// added to ch to be created later.
+func getContainerRegistry(ctx context.Context, project, subscriptionId, resourceGroup string, ch *changes) (string, error) {
 	lgr := logger.FromContext(ctx)
+	az := azure.ProviderFromContext(ctx)
 	lgr.Debug("starting to get container registry")
 
 	if subscriptionId == "" {
@@ -500,12 +833,17 @@ func getContainerRegistry(ctx context.Context, subscriptionId, resourceGroup str
 		return "", errors.New("resourceGroup is empty")
 	}
 
-	acrs, err := azure.ListContainerRegistries(ctx, c.ContainerRegistry.Subscription, c.ContainerRegistry.ResourceGroup)
-	if err != nil {
-		return "", fmt.Errorf("listing acrs: %w", err)
+	// a resource group that's created later has nothing to list yet
+	var acrs []armcontainerregistry.Registry
+	if !ch.isNew(azure.ResourceGroupScope(subscriptionId, resourceGroup)) {
+		var err error
+		acrs, err = az.ListContainerRegistries(ctx, subscriptionId, resourceGroup)
+		if err != nil {
+			return "", fmt.Errorf("listing acrs: %w", err)
+		}
 	}
 
-	def, err := state.Get(ctx, containerRegistryKey)
+	def, err := state.GetDefault(ctx, project, containerRegistryKey)
 	if err != nil && !errors.Is(err, state.KeyNotFoundErr) {
 		// failing to get container registry from state is not worth failing
 		lgr.Debug("failed to get container registry from state: " + err.Error())
@@ -513,7 +851,7 @@ func getContainerRegistry(ctx context.Context, subscriptionId, resourceGroup str
 	}
 
 	acrsWithNew := withNew(acrs)
-	selection, err := prompt.Select("Select your Container Registry", acrsWithNew, &prompt.SelectOpt[newish[armcontainerregistry.Registry]]{
+	selection, err := prompt.Select(ctx, "Select your Container Registry", acrsWithNew, &prompt.SelectOpt[newish[armcontainerregistry.Registry]]{
 		Field: func(t newish[armcontainerregistry.Registry]) string {
 			if t.IsNew {
 				return "New Container Registry"
@@ -528,7 +866,7 @@ func getContainerRegistry(ctx context.Context, subscriptionId, resourceGroup str
 	}
 
 	if !selection.IsNew {
-		if err := state.Set(ctx, containerRegistryKey, *selection.Data.Name); err != nil {
+		if err := state.SetDefault(ctx, project, containerRegistryKey, *selection.Data.Name); err != nil {
 			// failing to set container registry in state is not worth failing
 			lgr.Debug("failed to set container registry in state: " + err.Error())
 		}
@@ -537,19 +875,19 @@ func getContainerRegistry(ctx context.Context, subscriptionId, resourceGroup str
 		return *selection.Data.Name, nil
 	}
 
-	name, err := prompt.Input("Input your new Container Registry name", &prompt.InputOpt{
-		Validate: validateContainerRegistry,
+	name, err := prompt.Input(ctx, "Input your new Container Registry name", &prompt.InputOpt{
+		Validate: validateNew(validateContainerRegistry, acrs, func(r armcontainerregistry.Registry) string { return *r.Name }),
 	})
 	if err != nil {
 		return "", fmt.Errorf("inputting new container registry name: %w", err)
 	}
 
-	locations, err := azure.ListLocations(ctx, subscriptionId)
+	locations, err := az.ListLocations(ctx, subscriptionId)
 	if err != nil {
 		return "", fmt.Errorf("listing locations: %w", err)
 	}
 
-	location, err := prompt.Select("Input your new Container Registry location", locations, &prompt.SelectOpt[armsubscriptions.Location]{
+	location, err := prompt.Select(ctx, "Input your new Container Registry location", locations, &prompt.SelectOpt[armsubscriptions.Location]{
 		Field: func(t armsubscriptions.Location) string {
 			return *t.DisplayName
 		},
@@ -558,22 +896,28 @@ func getContainerRegistry(ctx context.Context, subscriptionId, resourceGroup str
 		return "", fmt.Errorf("selecting new container registry location: %w", err)
 	}
 
-	if err := azure.NewContainerRegistry(ctx, subscriptionId, resourceGroup, name, *location.Name); err != nil {
-		return "", fmt.Errorf("creating new container registry: %w", err)
-	}
-	lgr.Info("created Container Registry " + name)
-
-	if err := state.Set(ctx, containerRegistryKey, name); err != nil {
-		// failing to set container registry in state is not worth failing
-		lgr.Debug("failed to set container registry in state: " + err.Error())
-	}
+	ch.require(azure.AccessCheck{
+		Scope:   azure.ResourceGroupScope(subscriptionId, resourceGroup),
+		Role:    azure.ContributorRoleName,
+		Actions: []string{azure.ContainerRegistryWriteAction},
+	})
+	ch.create(azure.ContainerRegistryScope(subscriptionId, resourceGroup, name), func(ctx context.Context) error {
+		if err := az.NewContainerRegistry(ctx, subscriptionId, resourceGroup, name, *location.Name); err != nil {
+			return fmt.Errorf("creating new container registry: %w", err)
+		}
+		lgr.Info("created Container Registry " + name)
+		setCreatedDefault(ctx, project, containerRegistryKey, name)
+		return nil
+	})
 
 	lgr.Debug("finished getting container registry")
 	return name, nil
 }
 
-func getKeyVault(ctx context.Context, subscriptionId, resourceGroup string) (string, error) {
+// getKeyVault goes through steps of prompting user for a keyvault. A new keyvault is added to ch to be created later.
+func getKeyVault(ctx context.Context, project, subscriptionId, resourceGroup string, ch *changes) (string, error) {
 	lgr := logger.FromContext(ctx)
+	az := azure.ProviderFromContext(ctx)
 	lgr.Debug("starting to get keyvault")
 
 	if subscriptionId == "" {
@@ -583,12 +927,17 @@ func getKeyVault(ctx context.Context, subscriptionId, resourceGroup string) (str
 		return "", errors.New("resourceGroup is empty")
 	}
 
-	kvs, err := azure.ListKeyVaults(ctx, c.ContainerRegistry.Subscription, c.ContainerRegistry.ResourceGroup)
-	if err != nil {
-		return "", fmt.Errorf("listing kvs: %w", err)
+	// a resource group that's created later has nothing to list yet
+	var kvs []azure.Akv
+	if !ch.isNew(azure.ResourceGroupScope(subscriptionId, resourceGroup)) {
+		var err error
+		kvs, err = az.ListKeyVaults(ctx, subscriptionId, resourceGroup)
+		if err != nil {
+			return "", fmt.Errorf("listing kvs: %w", err)
+		}
 	}
 
-	def, err := state.Get(ctx, keyVaultKey)
+	def, err := state.GetDefault(ctx, project, keyVaultKey)
 	if err != nil && !errors.Is(err, state.KeyNotFoundErr) {
 		// failing to get key vault from state is not worth failing
 		lgr.Debug("failed to get key vault from state: " + err.Error())
@@ -596,7 +945,7 @@ func getKeyVault(ctx context.Context, subscriptionId, resourceGroup string) (str
 	}
 
 	kvsWithNew := withNew(kvs)
-	selection, err := prompt.Select("Select your KeyVault", kvsWithNew, &prompt.SelectOpt[newish[azure.Akv]]{
+	selection, err := prompt.Select(ctx, "Select your KeyVault", kvsWithNew, &prompt.SelectOpt[newish[azure.Akv]]{
 		Field: func(t newish[azure.Akv]) string {
 			if t.IsNew {
 				return "New KeyVault"
@@ -611,7 +960,7 @@ func getKeyVault(ctx context.Context, subscriptionId, resourceGroup string) (str
 	}
 
 	if !selection.IsNew {
-		if err := state.Set(ctx, keyVaultKey, selection.Data.Name); err != nil {
+		if err := state.SetDefault(ctx, project, keyVaultKey, selection.Data.Name); err != nil {
 			// failing to set container registry in state is not worth failing
 			lgr.Debug("failed to set keyvault in state: " + err.Error())
 		}
@@ -620,19 +969,19 @@ func getKeyVault(ctx context.Context, subscriptionId, resourceGroup string) (str
 		return selection.Data.Name, nil
 	}
 
-	name, err := prompt.Input("Input your new KeyVault name", &prompt.InputOpt{
-		Validate: validateKeyVault,
+	name, err := prompt.Input(ctx, "Input your new KeyVault name", &prompt.InputOpt{
+		Validate: validateNew(validateKeyVault, kvs, func(kv azure.Akv) string { return kv.Name }),
 	})
 	if err != nil {
 		return "", fmt.Errorf("inputting new keyvault name: %w", err)
 	}
 
-	locations, err := azure.ListLocations(ctx, subscriptionId)
+	locations, err := az.ListLocations(ctx, subscriptionId)
 	if err != nil {
 		return "", fmt.Errorf("listing locations: %w", err)
 	}
 
-	location, err := prompt.Select("Input your new KeyVault location", locations, &prompt.SelectOpt[armsubscriptions.Location]{
+	location, err := prompt.Select(ctx, "Input your new KeyVault location", locations, &prompt.SelectOpt[armsubscriptions.Location]{
 		Field: func(t armsubscriptions.Location) string {
 			return *t.DisplayName
 		},
@@ -641,33 +990,78 @@ func getKeyVault(ctx context.Context, subscriptionId, resourceGroup string) (str
 		return "", fmt.Errorf("selecting new keyvault location: %w", err)
 	}
 
-	t, err := azure.GetTenant(ctx)
-	if err != nil {
-		return "", fmt.Errorf("getting tenant: %w", err)
+	if c.TenantID == "" {
+		return "", errors.New("tenant is empty")
 	}
-	tenantId := *t.TenantID
 
-	_, err = azure.NewAkv(ctx, tenantId, subscriptionId, resourceGroup, name, *location.Name)
-	if err != nil {
-		return "", fmt.Errorf("creating new container registry: %w", err)
-	}
-	lgr.Info("created KeyVault" + name)
+	// new keyvaults use rbac authorization so role assignments on them are inherited from the resource group
+	ch.require(
+		azure.AccessCheck{
+			Scope:   azure.ResourceGroupScope(subscriptionId, resourceGroup),
+			Role:    azure.KeyVaultContributorRoleName,
+			Actions: []string{azure.KeyVaultWriteAction},
+		},
+		azure.AccessCheck{
+			Scope:   azure.ResourceGroupScope(subscriptionId, resourceGroup),
+			Role:    azure.UserAccessAdministratorRoleName,
+			Actions: []string{azure.RoleAssignmentWriteAction},
+		},
+		azure.AccessCheck{
+			Scope:   azure.ClusterScope(c.Cluster.Subscription, c.Cluster.ResourceGroup, c.Cluster.Name),
+			Role:    azure.ClusterContributorRoleName,
+			Actions: []string{azure.ClusterWriteAction},
+		},
+	)
+	ch.create(azure.KeyVaultScope(subscriptionId, resourceGroup, name), func(ctx context.Context) error {
+		if _, err := az.NewAkv(ctx, c.TenantID, subscriptionId, resourceGroup, name, *location.Name); err != nil {
+			return fmt.Errorf("creating new keyvault: %w", err)
+		}
+		lgr.Info("created KeyVault " + name)
+
+		lgr.Debug("enabling KeyVault CSI driver add-on")
+		if err := az.EnableKeyvaultCSIDriver(ctx, c.Cluster.Subscription, c.Cluster.ResourceGroup, c.Cluster.Name); err != nil {
+			return fmt.Errorf("enabling CSI driver add-on: %w", err)
+		}
+		lgr.Debug("finished enabling KeyVault CSI driver add-on")
+		setCreatedDefault(ctx, project, keyVaultKey, name)
+		return nil
+	})
+
+	lgr.Debug("finished getting keyvault")
+	return name, nil
+}
 
-	if err := state.Set(ctx, keyVaultKey, name); err != nil {
-		// failing to set container registry in state is not worth failing
-		lgr.Debug("failed to set keyvault in state: " + err.Error())
+// setCreatedDefault remembers the name of a new resource as the default of the prompt key once it's created. Dry runs
+// only plan the resource so its name isn't remembered for the next run to select.
+func setCreatedDefault(ctx context.Context, project, key, name string) {
+	if _, ok := dryrun.FromContext(ctx); ok {
+		return
 	}
 
-	lgr.Debug("enabling KeyVault CSI driver add-on")
-	err = azure.EnableKeyvaultCSIDriver(ctx, c.Cluster.Subscription, c.Cluster.ResourceGroup, c.Cluster.Name)
-	if err != nil {
-		return "", fmt.Errorf("enabling CSI driver add-on: %w", err)
+	if err := state.SetDefault(ctx, project, key, name); err != nil {
+		// failing to set the default in state is not worth failing
+		logger.FromContext(ctx).Debug("failed to set " + key + " in state: " + err.Error())
 	}
-	lgr.Debug("finished enabling KeyVault CSI driver add-on")
+}
 
-	lgr.Debug("finished getting keyvault")
-	return name, nil
+// validateNew returns validate that also rejects the names of existing resources. Entering an existing resource as
+// new would otherwise leave it looking like the plugin created it.
+func validateNew[T any](validate func(string) error, existing []T, name func(T) string) func(string) error {
+	return func(s string) error {
+		if err := validate(s); err != nil {
+			return err
+		}
+
+		for _, e := range existing {
+			if strings.EqualFold(name(e), s) {
+				return errors.New("already exists, select it instead of creating a new one")
+			}
+		}
+
+		return nil
+	}
 }
+
 func withNew[T any](instantiated []T) []newish[T] {
 	ret := make([]newish[T], 0, len(instantiated)+1)
 
@@ -0,0 +1,589 @@
+package config
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/keyvault/armkeyvault"
+	"github.com/azure/spin-aks-plugin/pkg/azure"
+	"github.com/azure/spin-aks-plugin/pkg/azure/fake"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	"github.com/azure/spin-aks-plugin/pkg/prompt"
+	"github.com/azure/spin-aks-plugin/pkg/state"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	testSub = "00000000-0000-0000-0000-000000000sub"
+	testRg  = "rg"
+)
+
+// newTestEnv points state at a temporary directory, writes the spin manifest, and returns a context
+// with a fake provider seeded with a cluster, container registry, and keyvault
+func newTestEnv(t *testing.T, spinToml string) (context.Context, *fake.Provider, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_DATA_HOME", dir)
+
+	manifest := filepath.Join(dir, "spin.toml")
+	if err := os.WriteFile(manifest, []byte(spinToml), 0644); err != nil {
+		t.Fatalf("writing spin manifest: %s", err)
+	}
+
+	p := fake.New()
+	p.AddSubscription(testSub, "Test Subscription")
+	p.AddResourceGroup(testSub, testRg, "eastus")
+	p.AddCluster(testSub, testRg, "cluster")
+	p.AddContainerRegistry(testSub, testRg, "acr")
+	p.AddKeyVault(testSub, testRg, "kv")
+
+	c = config{}
+	return azure.WithProvider(context.Background(), p), p, manifest
+}
+
+func TestEnsureValidConfigured(t *testing.T) {
+	g := NewWithT(t)
+	ctx, p, manifest := newTestEnv(t, `
+name = "app"
+
+[variables]
+password = { required = true, secret = true }
+
+[[component]]
+id = "app"
+source = "app.wasm"
+`)
+
+	resource := ResourceId{Subscription: testSub, ResourceGroup: testRg}
+	c.Cluster.ResourceId = resource
+	c.Cluster.Name = "cluster"
+	c.ContainerRegistry.ResourceId = resource
+	c.ContainerRegistry.Name = "acr"
+	c.KeyVault.ResourceId = resource
+	c.KeyVault.Name = "kv"
+	c.SpinManifest = manifest
+
+	// running twice shouldn't duplicate access policies
+	g.Expect(EnsureValid(ctx)).To(Succeed())
+	g.Expect(EnsureValid(ctx)).To(Succeed())
+	g.Expect(c.TenantID).To(Equal(fake.TenantId))
+
+	policies := p.AccessPolicies(fake.KeyVaultId(testSub, testRg, "kv"))
+	g.Expect(policies).To(HaveLen(2))
+	g.Expect(policies[0].ObjectId).To(Equal(fake.ClusterPrincipalId("cluster")))
+	g.Expect(policies[0].Permissions.Secrets).To(HaveExactElements(HaveValue(Equal(armkeyvault.SecretPermissionsGet))))
+	g.Expect(policies[1].ObjectId).To(Equal(fake.ObjectId))
+	g.Expect(policies[1].Permissions.Secrets).To(HaveExactElements(
+		HaveValue(Equal(armkeyvault.SecretPermissionsGet)),
+		HaveValue(Equal(armkeyvault.SecretPermissionsSet)),
+	))
+}
+
+func TestEnsureValidRbacKeyVault(t *testing.T) {
+	g := NewWithT(t)
+	ctx, p, manifest := newTestEnv(t, `
+name = "app"
+
+[variables]
+password = { required = true, secret = true }
+
+[[component]]
+id = "app"
+source = "app.wasm"
+`)
+	p.AddRbacKeyVault(testSub, testRg, "rbackv")
+
+	resource := ResourceId{Subscription: testSub, ResourceGroup: testRg}
+	c.Cluster.ResourceId = resource
+	c.Cluster.Name = "cluster"
+	c.ContainerRegistry.ResourceId = resource
+	c.ContainerRegistry.Name = "acr"
+	c.KeyVault.ResourceId = resource
+	c.KeyVault.Name = "rbackv"
+	c.SpinManifest = manifest
+
+	// running twice shouldn't duplicate role assignments
+	g.Expect(EnsureValid(ctx)).To(Succeed())
+	g.Expect(EnsureValid(ctx)).To(Succeed())
+
+	kvId := fake.KeyVaultId(testSub, testRg, "rbackv")
+	g.Expect(p.AccessPolicies(kvId)).To(BeEmpty())
+
+	assignments := p.RoleAssignments(kvId)
+	g.Expect(assignments).To(HaveLen(2))
+	g.Expect(*assignments[0].Properties.PrincipalID).To(Equal(fake.ClusterPrincipalId("cluster")))
+	g.Expect(*assignments[0].Properties.RoleDefinitionID).To(Equal(azure.KeyVaultSecretsUserRole.ID))
+	g.Expect(*assignments[1].Properties.PrincipalID).To(Equal(fake.ObjectId))
+	g.Expect(*assignments[1].Properties.RoleDefinitionID).To(Equal(azure.KeyVaultSecretsOfficerRole.ID))
+}
+
+func TestEnsureValidMissingAccess(t *testing.T) {
+	g := NewWithT(t)
+	ctx, p, manifest := newTestEnv(t, `
+name = "app"
+
+[variables]
+password = { required = true, secret = true }
+
+[[component]]
+id = "app"
+source = "app.wasm"
+`)
+	p.AddRbacKeyVault(testSub, testRg, "rbackv")
+	p.DenyAccess(fake.ResourceGroupId(testSub, testRg), azure.RoleAssignmentWriteAction)
+
+	resource := ResourceId{Subscription: testSub, ResourceGroup: testRg}
+	c.Cluster.ResourceId = resource
+	c.Cluster.Name = "cluster"
+	c.ContainerRegistry.ResourceId = resource
+	c.ContainerRegistry.Name = "acr"
+	c.KeyVault.ResourceId = resource
+	c.KeyVault.Name = "rbackv"
+	c.SpinManifest = manifest
+
+	kvId := fake.KeyVaultId(testSub, testRg, "rbackv")
+	err := EnsureValid(ctx)
+	g.Expect(err).To(HaveOccurred())
+
+	uerr, ok := usererror.Is(err)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(uerr.Msg()).To(ContainSubstring(fake.ObjectId))
+	g.Expect(uerr.Msg()).To(ContainSubstring(azure.UserAccessAdministratorRoleName + " on " + kvId))
+
+	// nothing is granted when the preflight fails
+	g.Expect(p.RoleAssignments(kvId)).To(BeEmpty())
+}
+
+func TestEnsureValidMissingAccessCreatesNothing(t *testing.T) {
+	g := NewWithT(t)
+	ctx, p, manifest := newTestEnv(t, `
+name = "app"
+
+[variables]
+password = { required = true, secret = true }
+
+[[component]]
+id = "app"
+source = "app.wasm"
+`)
+	p.AddRbacKeyVault(testSub, testRg, "rbackv")
+	p.DenyAccess(fake.KeyVaultId(testSub, testRg, "rbackv"), azure.RoleAssignmentWriteAction)
+
+	resource := ResourceId{Subscription: testSub, ResourceGroup: testRg}
+	c.Cluster.ResourceId = resource
+	c.Cluster.Name = "cluster"
+	c.KeyVault.ResourceId = resource
+	c.KeyVault.Name = "rbackv"
+	c.SpinManifest = manifest
+
+	ctx = prompt.WithPrompter(ctx, prompt.NewScripted(
+		prompt.Answer{Label: "Select your Container Registry's Subscription", Value: "Test Subscription"},
+		prompt.Answer{Label: "Select your Container Registry's Resource Group", Value: "New Resource Group"},
+		prompt.Answer{Label: "Input your new Resource Group name", Value: "acr-rg"},
+		prompt.Answer{Label: "Input your new Resource Group location", Value: "East US"},
+		prompt.Answer{Label: "Select your Container Registry", Value: "New Container Registry"},
+		prompt.Answer{Label: "Input your new Container Registry name", Value: "newacr"},
+		prompt.Answer{Label: "Input your new Container Registry location", Value: "East US"},
+	))
+
+	// the keyvault's access is checked before the container registry chosen earlier is created
+	err := EnsureValid(ctx)
+	g.Expect(err).To(MatchError(ContainSubstring(azure.UserAccessAdministratorRoleName)))
+	g.Expect(p.Exists(fake.ResourceGroupId(testSub, "acr-rg"))).To(BeFalse())
+	g.Expect(p.Exists(fake.ContainerRegistryId(testSub, "acr-rg", "newacr"))).To(BeFalse())
+}
+
+func TestEnsureValidNewResourceGroupShared(t *testing.T) {
+	g := NewWithT(t)
+	ctx, p, manifest := newTestEnv(t, `name = "app"`)
+	c.SpinManifest = manifest
+
+	ctx = prompt.WithPrompter(ctx, prompt.NewScripted(
+		prompt.Answer{Label: "Select your Cluster's Subscription", Value: "Test Subscription"},
+		prompt.Answer{Label: "Select your Cluster's Resource Group", Value: "New Resource Group"},
+		prompt.Answer{Label: "Input your new Resource Group name", Value: "app-rg"},
+		prompt.Answer{Label: "Input your new Resource Group location", Value: "East US"},
+		prompt.Answer{Label: "Select your Cluster", Value: "New Managed Cluster"},
+		prompt.Answer{Label: "Input your new Managed Cluster name", Value: "newcluster"},
+		prompt.Answer{Label: "Input your new Managed Cluster location", Value: "East US"},
+		prompt.Answer{Label: "Select your Container Registry's Subscription", Value: "Test Subscription"},
+		prompt.Answer{Label: "Select your Container Registry's Resource Group", Value: "app-rg"},
+		prompt.Answer{Label: "Select your Container Registry", Value: "New Container Registry"},
+		prompt.Answer{Label: "Input your new Container Registry name", Value: "newacr"},
+		prompt.Answer{Label: "Input your new Container Registry location", Value: "East US"},
+	))
+
+	// the resource group chosen to be created for the cluster can be selected for the container registry
+	g.Expect(EnsureValid(ctx)).To(Succeed())
+	g.Expect(p.Exists(fake.ClusterId(testSub, "app-rg", "newcluster"))).To(BeTrue())
+	g.Expect(p.Exists(fake.ContainerRegistryId(testSub, "app-rg", "newacr"))).To(BeTrue())
+}
+
+func TestEnsureValidDryRunDefaults(t *testing.T) {
+	g := NewWithT(t)
+	ctx, _, manifest := newTestEnv(t, `name = "app"`)
+
+	// the fake creates the planned resources anyway so each run enters new names
+	ensure := func(ctx context.Context, suffix string) {
+		c = config{SpinManifest: manifest}
+		g.Expect(EnsureValid(prompt.WithPrompter(ctx, prompt.NewScripted(
+			prompt.Answer{Label: "Select your Cluster's Subscription", Value: "Test Subscription"},
+			prompt.Answer{Label: "Select your Cluster's Resource Group", Value: "New Resource Group"},
+			prompt.Answer{Label: "Input your new Resource Group name", Value: "app-rg" + suffix},
+			prompt.Answer{Label: "Input your new Resource Group location", Value: "East US"},
+			prompt.Answer{Label: "Select your Cluster", Value: "New Managed Cluster"},
+			prompt.Answer{Label: "Input your new Managed Cluster name", Value: "newcluster" + suffix},
+			prompt.Answer{Label: "Input your new Managed Cluster location", Value: "East US"},
+			prompt.Answer{Label: "Select your Container Registry's Subscription", Value: "Test Subscription"},
+			prompt.Answer{Label: "Select your Container Registry's Resource Group", Value: "app-rg" + suffix},
+			prompt.Answer{Label: "Select your Container Registry", Value: "New Container Registry"},
+			prompt.Answer{Label: "Input your new Container Registry name", Value: "newacr" + suffix},
+			prompt.Answer{Label: "Input your new Container Registry location", Value: "East US"},
+		)))).To(Succeed())
+	}
+	project := projectOf(ctx, manifest)
+
+	// resources only planned in a dry run don't exist so the next run shouldn't select them
+	ensure(dryrun.WithContext(ctx, &dryrun.Plan{}), "1")
+	for _, key := range []string{kindKey(clusterKey, resourceGroupKey), clusterKey, containerRegistryKey} {
+		_, err := state.GetDefault(ctx, project, key)
+		g.Expect(err).To(MatchError(state.KeyNotFoundErr), key)
+	}
+
+	ensure(ctx, "2")
+	for key, want := range map[string]string{
+		kindKey(clusterKey, resourceGroupKey): "app-rg2",
+		clusterKey:                            "newcluster2",
+		containerRegistryKey:                  "newacr2",
+	} {
+		value, err := state.GetDefault(ctx, project, key)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(value).To(Equal(want), key)
+	}
+}
+
+func TestEnsureValidNoSecrets(t *testing.T) {
+	g := NewWithT(t)
+	ctx, p, manifest := newTestEnv(t, `
+name = "app"
+
+[variables]
+greeting = { default = "hello" }
+
+[[component]]
+id = "app"
+source = "app.wasm"
+`)
+
+	resource := ResourceId{Subscription: testSub, ResourceGroup: testRg}
+	c.Cluster.ResourceId = resource
+	c.Cluster.Name = "cluster"
+	c.ContainerRegistry.ResourceId = resource
+	c.ContainerRegistry.Name = "acr"
+	c.SpinManifest = manifest
+
+	g.Expect(EnsureValid(ctx)).To(Succeed())
+	g.Expect(c.KeyVault).To(BeZero())
+	g.Expect(p.AccessPolicies(fake.KeyVaultId(testSub, testRg, "kv"))).To(BeEmpty())
+}
+
+func TestEnsureValidPromptOrder(t *testing.T) {
+	g := NewWithT(t)
+	ctx, p, manifest := newTestEnv(t, `
+name = "app"
+
+[variables]
+password = { required = true, secret = true }
+
+[[component]]
+id = "app"
+source = "app.wasm"
+`)
+
+	answers := []prompt.Answer{
+		{Label: "Input your spin manifest location", Value: manifest},
+		{Label: "Select your Cluster's Subscription", Value: "Test Subscription"},
+		{Label: "Select your Cluster's Resource Group", Value: testRg},
+		{Label: "Select your Cluster", Value: "cluster"},
+		{Label: "Select your Container Registry's Subscription", Value: "Test Subscription"},
+		{Label: "Select your Container Registry's Resource Group", Value: "New Resource Group"},
+		{Label: "Input your new Resource Group name", Value: "acr-rg"},
+		{Label: "Input your new Resource Group location", Value: "East US"},
+		{Label: "Select your Container Registry", Value: "New Container Registry"},
+		{Label: "Input your new Container Registry name", Value: "newacr"},
+		{Label: "Input your new Container Registry location", Value: "East US"},
+		{Label: "Select your KeyVault's Subscription", Value: "Test Subscription"},
+		{Label: "Select your KeyVault's Resource Group", Value: testRg},
+		{Label: "Select your KeyVault", Value: "kv"},
+	}
+	scripted := prompt.NewScripted(answers...)
+	ctx = prompt.WithPrompter(ctx, scripted)
+
+	g.Expect(EnsureValid(ctx)).To(Succeed())
+
+	var labels []string
+	for _, a := range answers {
+		labels = append(labels, a.Label)
+	}
+	g.Expect(scripted.Asked()).To(Equal(labels))
+	g.Expect(scripted.Unused()).To(BeEmpty())
+
+	g.Expect(c.Cluster.ResourceId).To(Equal(ResourceId{Subscription: testSub, ResourceGroup: testRg, Name: "cluster"}))
+	g.Expect(c.ContainerRegistry.ResourceId).To(Equal(ResourceId{Subscription: testSub, ResourceGroup: "acr-rg", Name: "newacr"}))
+	g.Expect(c.KeyVault.ResourceId).To(Equal(ResourceId{Subscription: testSub, ResourceGroup: testRg, Name: "kv"}))
+	g.Expect(c.SpinManifest).To(Equal(manifest))
+	g.Expect(p.Exists(fake.ContainerRegistryId(testSub, "acr-rg", "newacr"))).To(BeTrue())
+}
+
+func TestEnsureValidNewExisting(t *testing.T) {
+	g := NewWithT(t)
+	ctx, p, manifest := newTestEnv(t, `name = "app"`)
+	c.SpinManifest = manifest
+	p.SetTags(fake.ResourceGroupId(testSub, testRg), map[string]string{"owner": "someone"})
+
+	ctx = prompt.WithPrompter(ctx, prompt.NewScripted(
+		prompt.Answer{Label: "Select your Cluster's Subscription", Value: "Test Subscription"},
+		prompt.Answer{Label: "Select your Cluster's Resource Group", Value: "New Resource Group"},
+		prompt.Answer{Label: "Input your new Resource Group name", Value: testRg},
+	))
+
+	// the existing resource group isn't treated as created by the plugin so down never deletes it
+	g.Expect(EnsureValid(ctx)).To(MatchError(ContainSubstring("already exists")))
+	g.Expect(azure.WasCreated(ctx, fake.ResourceGroupId(testSub, testRg))).To(BeFalse())
+
+	tags, err := p.GetTags(ctx, fake.ResourceGroupId(testSub, testRg))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tags).To(Equal(map[string]string{"owner": "someone"}))
+}
+
+func TestNewExistingNotRecorded(t *testing.T) {
+	g := NewWithT(t)
+	ctx, p, _ := newTestEnv(t, `name = "app"`)
+
+	g.Expect(p.NewResourceGroup(ctx, testSub, testRg, "eastus")).To(Succeed())
+	g.Expect(p.NewCluster(ctx, testSub, testRg, "cluster", "eastus")).To(Succeed())
+	g.Expect(p.NewContainerRegistry(ctx, testSub, testRg, "acr", "eastus")).To(Succeed())
+	_, err := p.NewAkv(ctx, fake.TenantId, testSub, testRg, "kv", "eastus")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	created, err := azure.CreatedResources(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(created).To(BeEmpty())
+}
+
+func TestEnsureValidMissingAnswer(t *testing.T) {
+	g := NewWithT(t)
+	ctx, _, manifest := newTestEnv(t, `name = "app"`)
+	c.SpinManifest = manifest
+	ctx = prompt.WithPrompter(ctx, prompt.NewScripted())
+
+	g.Expect(EnsureValid(ctx)).To(MatchError(ContainSubstring(`no scripted answer for prompt "Select your Cluster's Subscription"`)))
+}
+
+func TestEnsureValidTenantDefault(t *testing.T) {
+	g := NewWithT(t)
+	ctx, p, manifest := newTestEnv(t, `name = "app"`)
+	p.AddTenant("00000000-0000-0000-0000-0000000guest", "Guest Tenant")
+
+	resource := ResourceId{Subscription: testSub, ResourceGroup: testRg}
+	c.Cluster.ResourceId = resource
+	c.Cluster.Name = "cluster"
+	c.ContainerRegistry.ResourceId = resource
+	c.ContainerRegistry.Name = "acr"
+	c.SpinManifest = manifest
+
+	ctx = prompt.WithPrompter(ctx, prompt.NewScripted(prompt.Answer{Label: "Select your Tenant", Default: true}))
+	g.Expect(EnsureValid(ctx)).To(Succeed())
+	g.Expect(c.TenantID).To(Equal(fake.TenantId))
+}
+
+func TestEnsureValidTenantSelected(t *testing.T) {
+	g := NewWithT(t)
+	ctx, p, manifest := newTestEnv(t, `
+name = "app"
+
+[variables]
+password = { required = true, secret = true }
+
+[[component]]
+id = "app"
+source = "app.wasm"
+`)
+	const guestTenant = "00000000-0000-0000-0000-0000000guest"
+	const guestSub = "00000000-0000-0000-0000-0000guestsub"
+	p.AddTenant(guestTenant, "Guest Tenant")
+	p.AddTenantSubscription(guestTenant, guestSub, "Guest Subscription")
+	p.AddResourceGroup(guestSub, testRg, "eastus")
+
+	resource := ResourceId{Subscription: testSub, ResourceGroup: testRg}
+	c.Cluster.ResourceId = resource
+	c.Cluster.Name = "cluster"
+	c.ContainerRegistry.ResourceId = resource
+	c.ContainerRegistry.Name = "acr"
+	c.KeyVault.ResourceId = ResourceId{Subscription: guestSub, ResourceGroup: testRg}
+	c.SpinManifest = manifest
+
+	ctx = prompt.WithPrompter(ctx, prompt.NewScripted(
+		prompt.Answer{Label: "Select your Tenant", Value: "Guest Tenant"},
+		prompt.Answer{Label: "Select your KeyVault", Value: "New KeyVault"},
+		prompt.Answer{Label: "Input your new KeyVault name", Value: "guestkv"},
+		prompt.Answer{Label: "Input your new KeyVault location", Value: "East US"},
+	))
+	g.Expect(EnsureValid(ctx)).To(Succeed())
+	g.Expect(c.TenantID).To(Equal(guestTenant))
+
+	kv, err := p.GetKeyVault(ctx, guestSub, testRg, "guestkv")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(kv.TenantId).To(Equal(guestTenant))
+	g.Expect(kv.RbacAuthorization).To(BeTrue())
+	g.Expect(p.RoleAssignments(kv.Id)).To(HaveLen(2))
+}
+
+func TestEnsureValidTenantMismatch(t *testing.T) {
+	g := NewWithT(t)
+	ctx, p, manifest := newTestEnv(t, `
+name = "app"
+
+[variables]
+password = { required = true, secret = true }
+
+[[component]]
+id = "app"
+source = "app.wasm"
+`)
+	p.AddTenant("00000000-0000-0000-0000-0000000guest", "Guest Tenant")
+
+	resource := ResourceId{Subscription: testSub, ResourceGroup: testRg}
+	c.Cluster.ResourceId = resource
+	c.Cluster.Name = "cluster"
+	c.ContainerRegistry.ResourceId = resource
+	c.ContainerRegistry.Name = "acr"
+	c.KeyVault.ResourceId = resource
+	c.KeyVault.Name = "kv"
+	c.SpinManifest = manifest
+	c.TenantID = "00000000-0000-0000-0000-0000000guest"
+
+	g.Expect(EnsureValid(ctx)).To(MatchError(ContainSubstring("doesn't match selected tenant")))
+}
+
+func TestEnsureValidWorkloadIdentity(t *testing.T) {
+	g := NewWithT(t)
+	ctx, p, manifest := newTestEnv(t, `
+name = "app"
+
+[variables]
+password = { required = true, secret = true }
+
+[[component]]
+id = "app"
+source = "app.wasm"
+`)
+	p.AddRbacKeyVault(testSub, testRg, "rbackv")
+
+	resource := ResourceId{Subscription: testSub, ResourceGroup: testRg}
+	c.Cluster.ResourceId = resource
+	c.Cluster.Name = "cluster"
+	c.ContainerRegistry.ResourceId = resource
+	c.ContainerRegistry.Name = "acr"
+	c.KeyVault.ResourceId = resource
+	c.KeyVault.Name = "rbackv"
+	c.SpinManifest = manifest
+	c.WorkloadIdentity.Enabled = true
+
+	g.Expect(EnsureValid(ctx)).To(Succeed())
+	g.Expect(c.WorkloadIdentity.ResourceId).To(Equal(ResourceId{Subscription: testSub, ResourceGroup: testRg, Name: "app-identity"}))
+	g.Expect(c.WorkloadIdentity.ClientID).To(Equal(fake.IdentityClientId("app-identity")))
+	g.Expect(p.WorkloadIdentityEnabled(fake.ClusterId(testSub, testRg, "cluster"))).To(BeTrue())
+
+	g.Expect(p.FederatedCredentials(fake.IdentityId(testSub, testRg, "app-identity"))).To(Equal([]fake.FederatedCredential{
+		{Name: "app", Issuer: fake.OidcIssuer("cluster"), Subject: "system:serviceaccount:app:app"},
+	}))
+
+	assignments := p.RoleAssignments(fake.KeyVaultId(testSub, testRg, "rbackv"))
+	g.Expect(assignments).To(HaveLen(2))
+	g.Expect(*assignments[0].Properties.PrincipalID).To(Equal(fake.IdentityPrincipalId("app-identity")))
+	g.Expect(*assignments[0].Properties.RoleDefinitionID).To(Equal(azure.KeyVaultSecretsUserRole.ID))
+}
+
+func TestEnsureValidDefaultsPerKindAndProject(t *testing.T) {
+	g := NewWithT(t)
+	ctx, p, manifest := newTestEnv(t, `name = "app"`)
+	const otherSub = "00000000-0000-0000-0000-0000othersub"
+	p.AddSubscription(otherSub, "Other Subscription")
+	p.AddResourceGroup(otherSub, "other-rg", "eastus")
+	p.AddContainerRegistry(otherSub, "other-rg", "otheracr")
+
+	otherManifest := filepath.Join(filepath.Dir(manifest), "other", "spin.toml")
+	g.Expect(os.MkdirAll(filepath.Dir(otherManifest), 0755)).To(Succeed())
+	g.Expect(os.WriteFile(otherManifest, []byte(`name = "other"`), 0644)).To(Succeed())
+
+	labels := []string{
+		"Select your Cluster's Subscription",
+		"Select your Cluster's Resource Group",
+		"Select your Cluster",
+		"Select your Container Registry's Subscription",
+		"Select your Container Registry's Resource Group",
+		"Select your Container Registry",
+	}
+	ensure := func(manifest string, values ...string) {
+		answers := make([]prompt.Answer, len(labels))
+		for i, label := range labels {
+			answers[i] = prompt.Answer{Label: label, Default: true}
+			if i < len(values) {
+				answers[i] = prompt.Answer{Label: label, Value: values[i]}
+			}
+		}
+
+		c = config{SpinManifest: manifest}
+		g.Expect(EnsureValid(prompt.WithPrompter(ctx, prompt.NewScripted(answers...)))).To(Succeed())
+	}
+
+	ensure(manifest, "Test Subscription", testRg, "cluster", "Other Subscription", "other-rg", "otheracr")
+
+	// choosing the container registry's subscription doesn't change the cluster's default
+	ensure(manifest)
+	g.Expect(c.Cluster.ResourceId).To(Equal(ResourceId{Subscription: testSub, ResourceGroup: testRg, Name: "cluster"}))
+	g.Expect(c.ContainerRegistry.ResourceId).To(Equal(ResourceId{Subscription: otherSub, ResourceGroup: "other-rg", Name: "otheracr"}))
+
+	// a new project defaults to the last choices in any project
+	ensure(otherManifest)
+	g.Expect(c.ContainerRegistry.ResourceId).To(Equal(ResourceId{Subscription: otherSub, ResourceGroup: "other-rg", Name: "otheracr"}))
+
+	ensure(otherManifest, "Test Subscription", testRg, "cluster", "Test Subscription", testRg, "acr")
+
+	// choices in the other project don't change this project's defaults
+	ensure(manifest)
+	g.Expect(c.ContainerRegistry.ResourceId).To(Equal(ResourceId{Subscription: otherSub, ResourceGroup: "other-rg", Name: "otheracr"}))
+
+	ensure(otherManifest)
+	g.Expect(c.ContainerRegistry.ResourceId).To(Equal(ResourceId{Subscription: testSub, ResourceGroup: testRg, Name: "acr"}))
+}
+
+func TestProjectOf(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// outside of git the project is the manifest's absolute path
+	manifest := filepath.Join(dir, "spin.toml")
+	g.Expect(projectOf(ctx, manifest)).To(Equal(manifest))
+
+	// apps in the same repository are different projects shared by every clone
+	repo := filepath.Join(dir, "repo")
+	g.Expect(os.MkdirAll(filepath.Join(repo, "apps", "api"), 0755)).To(Succeed())
+	for _, args := range [][]string{{"init", "-q"}, {"remote", "add", "origin", "https://github.com/org/repo"}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		g.Expect(err).ToNot(HaveOccurred(), string(out))
+	}
+
+	g.Expect(projectOf(ctx, filepath.Join(repo, "spin.toml"))).To(Equal("https://github.com/org/repo#spin.toml"))
+	g.Expect(projectOf(ctx, filepath.Join(repo, "apps", "api", "spin.toml"))).To(Equal("https://github.com/org/repo#apps/api/spin.toml"))
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/azure/spin-aks-plugin/pkg/azure"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+)
+
+// preflight checks the signed-in principal has the access before anything is changed. Every missing role is
+// returned in a single user error so users can ask for all of them at once.
+func preflight(ctx context.Context, checks ...azure.AccessCheck) error {
+	lgr := logger.FromContext(ctx)
+	az := azure.ProviderFromContext(ctx)
+	lgr.Debug("starting preflight access checks")
+
+	missing, err := az.MissingAccess(ctx, checks)
+	if err != nil {
+		return fmt.Errorf("checking access: %w", err)
+	}
+
+	if len(missing) == 0 {
+		lgr.Debug("done with preflight access checks")
+		return nil
+	}
+
+	principal := "The signed-in principal"
+	if objectId, err := az.SignedInObjectId(ctx); err == nil {
+		principal = fmt.Sprintf("The signed-in principal (object id %s)", objectId)
+	} else {
+		// the object id only makes the message more helpful, not worth failing
+		lgr.Debug("failed to get signed-in object id: " + err.Error())
+	}
+
+	names := make([]string, 0, len(missing))
+	lines := make([]string, 0, len(missing))
+	for _, m := range missing {
+		names = append(names, m.String())
+		lines = append(lines, "  - "+m.String())
+	}
+
+	err = fmt.Errorf("missing access: %s", strings.Join(names, ", "))
+	return usererror.New(err, fmt.Sprintf("%s is missing access needed to continue:\n%s\nAsk an owner of each scope to assign the role and try again.", principal, strings.Join(lines, "\n")))
+}
+
+// changes are the access checks and changes collected while prompting. Nothing is changed until every prompt is
+// answered and every check passes, so missing access never leaves the resources half set up.
+type changes struct {
+	checks []azure.AccessCheck
+	steps  []func(ctx context.Context) error
+	// creates are the lowercase ids of resources the steps create
+	creates map[string]bool
+	// resourceGroups are the resource groups the steps create so they can be selected for other resources too
+	resourceGroups []ResourceId
+}
+
+func newChanges() *changes {
+	return &changes{creates: map[string]bool{}}
+}
+
+// require adds access checks that must pass before anything is changed
+func (ch *changes) require(checks ...azure.AccessCheck) {
+	ch.checks = append(ch.checks, checks...)
+}
+
+// then adds a step that's run after every previous step
+func (ch *changes) then(step func(ctx context.Context) error) {
+	ch.steps = append(ch.steps, step)
+}
+
+// create adds a step that creates the resource with the id
+func (ch *changes) create(id string, step func(ctx context.Context) error) {
+	ch.creates[strings.ToLower(id)] = true
+	ch.then(step)
+}
+
+// isNew returns true if the resource with the id is created by a step and doesn't exist yet
+func (ch *changes) isNew(id string) bool {
+	return ch.creates[strings.ToLower(id)]
+}
+
+// apply checks every access check at once and then runs the steps in order
+func (ch *changes) apply(ctx context.Context) error {
+	if len(ch.checks) > 0 {
+		if err := preflight(ctx, ch.checks...); err != nil {
+			return err
+		}
+	}
+
+	for _, step := range ch.steps {
+		if err := step(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,11 +1,15 @@
 package config
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"path"
 
 	"github.com/BurntSushi/toml"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
 	"github.com/caarlos0/env/v9"
 )
 
@@ -49,8 +53,28 @@ func Load(o Opts) error {
 	return nil
 }
 
-// Write writes the current aks spin config to a file
-func Write() error {
+// Write writes the current aks spin config to a file. When the context is a dry run the difference
+// to the current file is printed instead.
+func Write(ctx context.Context) error {
+	if plan, ok := dryrun.FromContext(ctx); ok {
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(c); err != nil {
+			return fmt.Errorf("encoding aks spin config: %w", err)
+		}
+
+		existing, err := os.ReadFile(opts.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("reading config file: %w", err)
+		}
+
+		plan.Record(dryrun.Change{Action: dryrun.Write, Kind: "File", Target: opts.Path})
+		if diff := dryrun.Diff(opts.Path, existing, buf.Bytes()); diff != "" {
+			logger.FromContext(ctx).Info(diff)
+		}
+
+		return nil
+	}
+
 	// create directories if they don't exist
 	dirs := path.Dir(opts.Path)
 	if _, err := os.Stat(dirs); err != nil {
@@ -77,6 +101,16 @@ func Write() error {
 	return nil
 }
 
-func GetKeyVault() KeyVault{
+func GetKeyVault() KeyVault {
 	return c.KeyVault
 }
+
+// EnableWorkloadIdentity enables workload identity in the current aks spin config
+func EnableWorkloadIdentity() {
+	c.WorkloadIdentity.Enabled = true
+}
+
+// SetCloud overrides the Azure cloud in the current aks spin config
+func SetCloud(cloud string) {
+	c.Cloud = cloud
+}
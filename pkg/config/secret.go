@@ -0,0 +1,44 @@
+package config
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/azure/spin-aks-plugin/pkg/azure"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+)
+
+// StoreSecret puts the secret in the keyvault of the current aks spin config unless it already has the value
+func StoreSecret(ctx context.Context, name, value string) error {
+	lgr := logger.FromContext(ctx).With("name", name)
+	lgr.Debug("starting to store secret")
+
+	kv := c.KeyVault
+	if kv.Name == "" || kv.Subscription == "" || kv.ResourceGroup == "" {
+		return usererror.New(fmt.Errorf("no keyvault found in config"), "no keyvault found in config, try running `spin aks init` first")
+	}
+
+	az := azure.ProviderFromContext(ctx)
+	akv, err := az.GetKeyVault(ctx, kv.Subscription, kv.ResourceGroup, kv.Name)
+	if err != nil {
+		return fmt.Errorf("getting keyvault: %w", err)
+	}
+	if akv == nil {
+		return fmt.Errorf("getting keyvault: keyvault was nil")
+	}
+
+	// no provider is asked to write the secret in a dry run
+	if plan, ok := dryrun.FromContext(ctx); ok {
+		plan.Record(dryrun.Change{Action: dryrun.Update, Kind: "Key Vault Secret", Target: akv.SecretUri(name)})
+		return nil
+	}
+
+	if err := az.PutSecretIfNewValue(ctx, akv, name, value); err != nil {
+		return fmt.Errorf("putting secret: %w", err)
+	}
+
+	lgr.Debug("finished storing secret")
+	return nil
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/azure/spin-aks-plugin/pkg/azure"
+	"github.com/azure/spin-aks-plugin/pkg/azure/fake"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+	. "github.com/onsi/gomega"
+)
+
+func TestStoreSecret(t *testing.T) {
+	g := NewWithT(t)
+	ctx, p, _ := newTestEnv(t, "")
+
+	c.KeyVault.ResourceId = ResourceId{Subscription: testSub, ResourceGroup: testRg}
+	c.KeyVault.Name = "kv"
+
+	g.Expect(StoreSecret(ctx, "password", "secret")).To(Succeed())
+	value, ok := p.Secret(fake.KeyVaultId(testSub, testRg, "kv"), "password")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(value).To(Equal("secret"))
+}
+
+func TestStoreSecretDryRun(t *testing.T) {
+	g := NewWithT(t)
+	ctx, p, _ := newTestEnv(t, "")
+
+	c.KeyVault.ResourceId = ResourceId{Subscription: testSub, ResourceGroup: testRg}
+	c.KeyVault.Name = "kv"
+
+	plan := &dryrun.Plan{}
+	g.Expect(StoreSecret(dryrun.WithContext(ctx, plan), "password", "secret")).To(Succeed())
+
+	_, ok := p.Secret(fake.KeyVaultId(testSub, testRg, "kv"), "password")
+	g.Expect(ok).To(BeFalse())
+	g.Expect(plan.Changes()).To(Equal([]dryrun.Change{
+		{Action: dryrun.Update, Kind: "Key Vault Secret", Target: azure.VaultUri("kv") + "secrets/password"},
+	}))
+}
+
+func TestStoreSecretNoKeyVault(t *testing.T) {
+	g := NewWithT(t)
+	ctx, _, _ := newTestEnv(t, "")
+
+	_, ok := usererror.Is(StoreSecret(ctx, "password", "secret"))
+	g.Expect(ok).To(BeTrue())
+}
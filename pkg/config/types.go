@@ -18,6 +18,15 @@ type config struct {
 	Store        Store    `toml:"store,omitempty"`
 	KeyVault     KeyVault `toml:"keyvault,omitempty"`
 	TenantID     string   `toml:"tenant_id,omitempty"`
+	// WorkloadIdentity is the identity the application runs as through AKS workload identity
+	WorkloadIdentity WorkloadIdentity `toml:"workload_identity,omitempty"`
+	// Cloud is the Azure cloud to use. AzurePublic, AzureGovernment, AzureChina, or the path to a custom
+	// cloud endpoint metadata file. Defaults to AzurePublic.
+	Cloud string `toml:"cloud,omitempty"`
+	// Signing is the key pushed images are signed with
+	Signing Signing `toml:"signing,omitempty"`
+	// RequireSignedImages makes deploy and rollback refuse images that aren't signed by the signing key
+	RequireSignedImages bool `toml:"require_signed_images,omitempty"`
 }
 
 type ResourceId struct {
@@ -38,6 +47,26 @@ type KeyVault struct {
 	ResourceId
 }
 
+// WorkloadIdentity is a user-assigned managed identity federated with the application's ServiceAccount
+type WorkloadIdentity struct {
+	// Enabled grants KeyVault access to the identity instead of the whole cluster's identity
+	Enabled bool `toml:"enabled"`
+	ResourceId
+	// ClientID is the client id of the identity used in the generated Kubernetes manifests
+	ClientID string `toml:"client_id,omitempty"`
+}
+
+// Signing is the ECDSA P-256 key images are signed with. Either a local key file or a key in the configured KeyVault.
+type Signing struct {
+	// Key is the path to a PEM encoded private key file
+	Key string `toml:"key,omitempty"`
+	// PublicKey is the path to the PEM encoded public key images are verified with. Defaults to the public key of Key,
+	// so deploying from a machine without the private key only needs the public key.
+	PublicKey string `toml:"public_key,omitempty"`
+	// KeyVaultKey is the name of a key in the configured KeyVault
+	KeyVaultKey string `toml:"keyvault_key,omitempty"`
+}
+
 type storeKind string
 
 var (
@@ -0,0 +1,474 @@
+// Package doctor checks that everything a Spin application on AKS needs is in place and fixes what it safely can
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/keyvault/armkeyvault"
+	"github.com/azure/spin-aks-plugin/pkg/azure"
+	"github.com/azure/spin-aks-plugin/pkg/config"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	"github.com/azure/spin-aks-plugin/pkg/generate"
+	"github.com/azure/spin-aks-plugin/pkg/k8s"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/spin"
+	nodev1 "k8s.io/api/node/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const keyVaultAddon = "azureKeyvaultSecretsProvider"
+
+// Status is the outcome of a check
+type Status string
+
+const (
+	Pass    Status = "PASS"
+	Warn    Status = "WARN"
+	Fail    Status = "FAIL"
+	Fixed   Status = "FIXED"
+	Planned Status = "PLANNED"
+)
+
+// Result is the outcome of a single check
+type Result struct {
+	// Check is the name of the check
+	Check   string
+	Status  Status
+	Message string
+	// Hint tells users how to resolve a warning or failure
+	Hint string
+
+	// fix safely resolves the failure. It's nil for failures users have to resolve themselves.
+	fix func(ctx context.Context) error
+	// fixed is the message after fix succeeds
+	fixed string
+}
+
+// Options are the resources to check
+type Options struct {
+	Cluster           config.ResourceId
+	ContainerRegistry config.ResourceId
+	KeyVault          config.ResourceId
+	WorkloadIdentity  config.WorkloadIdentity
+	SpinManifest      string
+	// Fix applies the safe fixes of failed checks
+	Fix bool
+}
+
+type doctor struct {
+	opts     Options
+	az       azure.Provider
+	manifest *spin.Manifest
+	cluster  *armcontainerservice.ManagedCluster
+	kube     kubernetes.Interface
+}
+
+// Run runs every check in order and returns the results. Checks that don't apply, like KeyVault checks for
+// applications without secrets, are left out.
+func Run(ctx context.Context, opts Options) []Result {
+	lgr := logger.FromContext(ctx)
+	lgr.Debug("starting doctor checks")
+
+	d := &doctor{
+		opts: opts,
+		az:   azure.ProviderFromContext(ctx),
+	}
+
+	checks := []func(context.Context) *Result{
+		d.spinCLI,
+		d.spinManifest,
+		d.variables,
+		d.clusterReachable,
+		d.wasmNodes,
+		d.runtimeClass,
+		d.keyVaultAddon,
+		d.acrPull,
+		d.keyVaultAccess,
+	}
+
+	var results []Result
+	for _, check := range checks {
+		r := check(ctx)
+		if r == nil {
+			continue
+		}
+
+		if r.Status == Fail && r.fix != nil && opts.Fix {
+			lgr.Debug("fixing " + r.Check)
+			if err := r.fix(ctx); err != nil {
+				r.Message = fmt.Sprintf("%s (fix failed: %s)", r.Message, err)
+			} else {
+				r.Status = Fixed
+				if _, ok := dryrun.FromContext(ctx); ok {
+					r.Status = Planned
+				}
+				r.Message = r.fixed
+				r.Hint = ""
+			}
+		}
+
+		results = append(results, *r)
+	}
+
+	lgr.Debug("finished doctor checks")
+	return results
+}
+
+// Print writes the results as a table with the hint of each warning and failure under it
+func Print(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Status, r.Check, r.Message)
+		if r.Hint != "" && (r.Status == Warn || r.Status == Fail) {
+			fmt.Fprintf(tw, "\t\thint: %s\n", r.Hint)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// Failed returns the number of failed checks
+func Failed(results []Result) int {
+	n := 0
+	for _, r := range results {
+		if r.Status == Fail {
+			n++
+		}
+	}
+
+	return n
+}
+
+// fixHint returns the hint for a failure that --fix resolves
+func fixHint(action string) string {
+	return fmt.Sprintf("Run `spin aks doctor --fix` to %s.", action)
+}
+
+func skipped(check, reason string) *Result {
+	return &Result{Check: check, Status: Warn, Message: "skipped, " + reason}
+}
+
+func (d *doctor) spinCLI(ctx context.Context) *Result {
+	r := &Result{Check: "Spin CLI"}
+	hint := "Install spin 1.x.x from https://developer.fermyon.com/spin/install and add it to your PATH."
+
+	version, err := spin.CheckCLIVersion(ctx)
+	if version == "" {
+		r.Status, r.Message, r.Hint = Fail, "spin CLI not found: "+err.Error(), hint
+		return r
+	}
+	if err != nil {
+		r.Status, r.Message, r.Hint = Fail, fmt.Sprintf("spin %s is installed but isn't supported", version), hint
+		return r
+	}
+
+	r.Status, r.Message = Pass, fmt.Sprintf("spin %s is installed", version)
+	return r
+}
+
+func (d *doctor) spinManifest(_ context.Context) *Result {
+	r := &Result{Check: "Spin manifest"}
+
+	if d.opts.SpinManifest == "" {
+		r.Status, r.Message, r.Hint = Fail, "spin manifest not set in config", "Run `spin aks init`."
+		return r
+	}
+
+	m, err := spin.Load(d.opts.SpinManifest)
+	if err != nil {
+		r.Status, r.Message, r.Hint = Fail, err.Error(), fmt.Sprintf("Fix the spin manifest at %s.", d.opts.SpinManifest)
+		return r
+	}
+	d.manifest = &m
+
+	r.Status, r.Message = Pass, fmt.Sprintf("%s parses", d.opts.SpinManifest)
+	return r
+}
+
+func (d *doctor) variables(_ context.Context) *Result {
+	const check = "Variables"
+	if d.manifest == nil {
+		return skipped(check, "spin manifest didn't load")
+	}
+
+	var names []string
+	for name := range d.manifest.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var noValue, noKeyVault []string
+	for _, name := range names {
+		v := d.manifest.Variables[name]
+		switch {
+		case v.Secret && d.opts.KeyVault.Name == "":
+			noKeyVault = append(noKeyVault, name)
+		case v.Required && !v.Secret && v.Def == "":
+			noValue = append(noValue, name)
+		}
+	}
+
+	r := &Result{Check: check}
+	switch {
+	case len(noKeyVault) > 0:
+		r.Status = Fail
+		r.Message = fmt.Sprintf("secret variables %s have no KeyVault to load from", strings.Join(noKeyVault, ", "))
+		r.Hint = "Run `spin aks init` to select a KeyVault."
+	case len(noValue) > 0:
+		r.Status = Fail
+		r.Message = fmt.Sprintf("required variables %s have no value", strings.Join(noValue, ", "))
+		r.Hint = "Give them a default in the spin manifest or make them secrets loaded from KeyVault."
+	default:
+		r.Status, r.Message = Pass, "every required variable has a value"
+	}
+
+	return r
+}
+
+func (d *doctor) clusterReachable(ctx context.Context) *Result {
+	r := &Result{Check: "Cluster"}
+	c := d.opts.Cluster
+
+	if c.Name == "" {
+		r.Status, r.Message, r.Hint = Fail, "cluster not set in config", "Run `spin aks init`."
+		return r
+	}
+
+	mc, err := d.az.GetManagedCluster(ctx, c.Subscription, c.ResourceGroup, c.Name)
+	if err != nil {
+		r.Status, r.Message, r.Hint = Fail, "getting cluster: "+err.Error(), "Check the cluster in your config exists and you have access to it."
+		return r
+	}
+	d.cluster = &mc
+
+	kube, err := k8s.Client(ctx, c.Subscription, c.ResourceGroup, c.Name)
+	if err != nil {
+		r.Status, r.Message, r.Hint = Fail, "connecting to cluster: "+err.Error(), "Check you have access to the cluster's user credentials."
+		return r
+	}
+
+	version, err := kube.Discovery().ServerVersion()
+	if err != nil {
+		r.Status, r.Message, r.Hint = Fail, "reaching cluster API server: "+err.Error(), "Check the cluster is running and its API server is reachable from your network. Private clusters aren't supported."
+		return r
+	}
+	d.kube = kube
+
+	r.Status, r.Message = Pass, fmt.Sprintf("cluster %s is reachable (Kubernetes %s)", c.Name, version.GitVersion)
+	return r
+}
+
+func (d *doctor) wasmNodes(ctx context.Context) *Result {
+	const check = "Wasm node pool"
+	if d.kube == nil {
+		return skipped(check, "cluster isn't reachable")
+	}
+
+	r := &Result{Check: check}
+	nodes, err := d.kube.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: generate.SpinNodeLabel + "=true"})
+	if err != nil {
+		r.Status, r.Message = Fail, "listing nodes: "+err.Error()
+		return r
+	}
+
+	if len(nodes.Items) == 0 {
+		c := d.opts.Cluster
+		r.Status = Fail
+		r.Message = "no nodes can run Spin applications"
+		r.Hint = fmt.Sprintf("Add a node pool with the Wasm workload runtime with `az aks nodepool add --resource-group %s --cluster-name %s --name wasm --workload-runtime WasmWasi`.", c.ResourceGroup, c.Name)
+		return r
+	}
+
+	r.Status, r.Message = Pass, fmt.Sprintf("%d nodes can run Spin applications", len(nodes.Items))
+	return r
+}
+
+func (d *doctor) runtimeClass(ctx context.Context) *Result {
+	const check = "RuntimeClass"
+	if d.kube == nil {
+		return skipped(check, "cluster isn't reachable")
+	}
+
+	r := &Result{Check: check}
+	rc, err := d.kube.NodeV1().RuntimeClasses().Get(ctx, generate.RuntimeClassName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		r.Status = Fail
+		r.Message = fmt.Sprintf("RuntimeClass %s doesn't exist", generate.RuntimeClassName)
+		r.Hint = fixHint("create it")
+		r.fix = d.createRuntimeClass
+		r.fixed = fmt.Sprintf("created RuntimeClass %s", generate.RuntimeClassName)
+		return r
+	}
+	if err != nil {
+		r.Status, r.Message = Fail, "getting RuntimeClass: "+err.Error()
+		return r
+	}
+
+	if rc.Handler != generate.RuntimeClassHandler {
+		r.Status = Warn
+		r.Message = fmt.Sprintf("RuntimeClass %s uses handler %s instead of %s", rc.Name, rc.Handler, generate.RuntimeClassHandler)
+		r.Hint = "Delete the RuntimeClass and run `spin aks doctor --fix` to recreate it."
+		return r
+	}
+
+	r.Status, r.Message = Pass, fmt.Sprintf("RuntimeClass %s exists", rc.Name)
+	return r
+}
+
+func (d *doctor) createRuntimeClass(ctx context.Context) error {
+	if plan, ok := dryrun.FromContext(ctx); ok {
+		plan.Record(dryrun.Change{Action: dryrun.Create, Kind: "RuntimeClass", Target: generate.RuntimeClassName})
+		return nil
+	}
+
+	_, err := d.kube.NodeV1().RuntimeClasses().Create(ctx, &nodev1.RuntimeClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        generate.RuntimeClassName,
+			Annotations: generate.Annotations(),
+		},
+		Handler: generate.RuntimeClassHandler,
+		Scheduling: &nodev1.Scheduling{
+			NodeSelector: map[string]string{generate.SpinNodeLabel: "true"},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("creating RuntimeClass: %w", err)
+	}
+
+	return nil
+}
+
+func (d *doctor) keyVaultAddon(_ context.Context) *Result {
+	const check = "KeyVault CSI add-on"
+	if d.opts.KeyVault.Name == "" {
+		return nil
+	}
+	if d.cluster == nil {
+		return skipped(check, "cluster isn't reachable")
+	}
+
+	r := &Result{Check: check}
+	if d.cluster.Properties != nil {
+		if addon, ok := d.cluster.Properties.AddonProfiles[keyVaultAddon]; ok && addon != nil && addon.Enabled != nil && *addon.Enabled {
+			r.Status, r.Message = Pass, keyVaultAddon+" add-on is enabled"
+			return r
+		}
+	}
+
+	c := d.opts.Cluster
+	r.Status = Fail
+	r.Message = keyVaultAddon + " add-on isn't enabled so secrets can't be loaded"
+	r.Hint = fixHint("enable it")
+	r.fix = func(ctx context.Context) error {
+		return d.az.EnableKeyvaultCSIDriver(ctx, c.Subscription, c.ResourceGroup, c.Name)
+	}
+	r.fixed = "enabled " + keyVaultAddon + " add-on"
+	return r
+}
+
+func (d *doctor) acrPull(ctx context.Context) *Result {
+	const check = "AcrPull"
+	acr := d.opts.ContainerRegistry
+	c := d.opts.Cluster
+
+	r := &Result{Check: check}
+	if acr.Name == "" {
+		r.Status, r.Message, r.Hint = Warn, "container registry not set in config", "Run `spin aks init`."
+		return r
+	}
+	if d.cluster == nil {
+		return skipped(check, "cluster isn't reachable")
+	}
+	err := d.az.CheckACRPullAccess(ctx, c.Subscription, c.ResourceGroup, c.Name, acr.Subscription, acr.ResourceGroup, acr.Name)
+	if err != nil && !errors.Is(err, azure.MissingAcrPullErr) {
+		// assigning AcrPull doesn't help when the access couldn't be checked, like on network or auth errors
+		r.Status = Fail
+		r.Message = fmt.Sprintf("checking whether the cluster can pull from %s: %s", acr.Name, err)
+		r.Hint = "Check the container registry in your config exists and you have access to it."
+		return r
+	}
+	if err != nil {
+		r.Status = Fail
+		r.Message = fmt.Sprintf("cluster can't pull from %s: %s", acr.Name, err)
+		r.Hint = fixHint("assign the cluster AcrPull on the container registry")
+		r.fix = func(ctx context.Context) error {
+			return d.az.LinkAcr(ctx, c.Subscription, c.ResourceGroup, c.Name, acr.Subscription, acr.ResourceGroup, acr.Name)
+		}
+		r.fixed = fmt.Sprintf("assigned cluster AcrPull on %s", acr.Name)
+		return r
+	}
+
+	r.Status, r.Message = Pass, fmt.Sprintf("cluster can pull from %s", acr.Name)
+	return r
+}
+
+func (d *doctor) keyVaultAccess(ctx context.Context) *Result {
+	const check = "KeyVault access"
+	kv := d.opts.KeyVault
+	if kv.Name == "" {
+		return nil
+	}
+
+	r := &Result{Check: check}
+	akv, err := d.az.GetKeyVault(ctx, kv.Subscription, kv.ResourceGroup, kv.Name)
+	if err != nil {
+		r.Status, r.Message, r.Hint = Fail, "getting keyvault: "+err.Error(), "Check the KeyVault in your config exists and you have access to it."
+		return r
+	}
+
+	principal := "cluster"
+	var principalId string
+	if wi := d.opts.WorkloadIdentity; wi.Enabled {
+		principal = "identity " + wi.Name
+		identity, err := d.az.GetIdentity(ctx, wi.Subscription, wi.ResourceGroup, wi.Name)
+		if err != nil {
+			r.Status, r.Message, r.Hint = Fail, "getting workload identity: "+err.Error(), "Run `spin aks init` to create the identity."
+			return r
+		}
+		principalId = identity.PrincipalId
+	} else {
+		if d.cluster == nil {
+			return skipped(check, "cluster isn't reachable")
+		}
+		if d.cluster.Identity == nil || d.cluster.Identity.PrincipalID == nil {
+			r.Status, r.Message = Fail, "cluster has no managed identity principal"
+			return r
+		}
+		principalId = *d.cluster.Identity.PrincipalID
+	}
+
+	ok, err := d.az.HasSecretAccess(ctx, akv, principalId)
+	if err != nil {
+		r.Status, r.Message = Fail, "checking keyvault access: "+err.Error()
+		return r
+	}
+	if ok {
+		r.Status, r.Message = Pass, fmt.Sprintf("%s can read secrets from %s", principal, akv.Name)
+		return r
+	}
+
+	r.Status = Fail
+	r.Message = fmt.Sprintf("%s can't read secrets from %s", principal, akv.Name)
+	r.Hint = fixHint("grant it access")
+	r.fix = func(ctx context.Context) error {
+		if akv.RbacAuthorization {
+			return d.az.AddRoleAssignment(ctx, akv, principalId, azure.KeyVaultSecretsUserRole)
+		}
+
+		return d.az.AddAccessPolicy(ctx, akv, principalId, armkeyvault.Permissions{
+			Secrets: []*armkeyvault.SecretPermissions{to.Ptr(armkeyvault.SecretPermissionsGet)},
+		})
+	}
+	r.fixed = fmt.Sprintf("granted %s access to secrets in %s", principal, akv.Name)
+	return r
+}
@@ -0,0 +1,204 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azure/spin-aks-plugin/pkg/azure"
+	"github.com/azure/spin-aks-plugin/pkg/azure/fake"
+	"github.com/azure/spin-aks-plugin/pkg/config"
+	"github.com/azure/spin-aks-plugin/pkg/generate"
+	"github.com/azure/spin-aks-plugin/pkg/k8s"
+	"github.com/azure/spin-aks-plugin/pkg/spin"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	testSub = "00000000-0000-0000-0000-000000000sub"
+	testRg  = "rg"
+)
+
+// newTestEnv returns a context with a fake provider seeded with a cluster, container registry, and keyvault, a fake
+// cluster with a Wasm node, and options pointing at them
+func newTestEnv(t *testing.T, spinToml string) (context.Context, *fake.Provider, *kubefake.Clientset, Options) {
+	t.Helper()
+
+	orig := spin.CLIVersion
+	spin.CLIVersion = func(context.Context) (string, error) { return "1.5.1", nil }
+	t.Cleanup(func() { spin.CLIVersion = orig })
+
+	manifest := filepath.Join(t.TempDir(), "spin.toml")
+	if err := os.WriteFile(manifest, []byte(spinToml), 0644); err != nil {
+		t.Fatalf("writing spin manifest: %s", err)
+	}
+
+	p := fake.New()
+	p.AddSubscription(testSub, "Test Subscription")
+	p.AddResourceGroup(testSub, testRg, "eastus")
+	p.AddCluster(testSub, testRg, "cluster")
+	p.AddContainerRegistry(testSub, testRg, "acr")
+	p.AddKeyVault(testSub, testRg, "kv")
+
+	kube := kubefake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "wasm-node",
+			Labels: map[string]string{generate.SpinNodeLabel: "true"},
+		},
+	})
+
+	ctx := azure.WithProvider(context.Background(), p)
+	ctx = k8s.WithClient(ctx, kube)
+
+	return ctx, p, kube, Options{
+		Cluster:           config.ResourceId{Subscription: testSub, ResourceGroup: testRg, Name: "cluster"},
+		ContainerRegistry: config.ResourceId{Subscription: testSub, ResourceGroup: testRg, Name: "acr"},
+		KeyVault:          config.ResourceId{Subscription: testSub, ResourceGroup: testRg, Name: "kv"},
+		SpinManifest:      manifest,
+	}
+}
+
+const secretManifest = `
+name = "app"
+
+[variables]
+password = { required = true, secret = true }
+
+[[component]]
+id = "app"
+source = "app.wasm"
+`
+
+func statuses(results []Result) map[string]Status {
+	ret := map[string]Status{}
+	for _, r := range results {
+		ret[r.Check] = r.Status
+	}
+
+	return ret
+}
+
+func TestRunFix(t *testing.T) {
+	g := NewWithT(t)
+	ctx, p, kube, opts := newTestEnv(t, secretManifest)
+
+	results := Run(ctx, opts)
+	g.Expect(statuses(results)).To(Equal(map[string]Status{
+		"Spin CLI":            Pass,
+		"Spin manifest":       Pass,
+		"Variables":           Pass,
+		"Cluster":             Pass,
+		"Wasm node pool":      Pass,
+		"RuntimeClass":        Fail,
+		"KeyVault CSI add-on": Fail,
+		"AcrPull":             Fail,
+		"KeyVault access":     Fail,
+	}))
+	g.Expect(Failed(results)).To(Equal(4))
+
+	opts.Fix = true
+	results = Run(ctx, opts)
+	g.Expect(Failed(results)).To(BeZero())
+	g.Expect(statuses(results)).To(HaveKeyWithValue("RuntimeClass", Fixed))
+
+	_, err := kube.NodeV1().RuntimeClasses().Get(ctx, generate.RuntimeClassName, metav1.GetOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(p.AccessPolicies(fake.KeyVaultId(testSub, testRg, "kv"))).To(HaveLen(1))
+
+	// everything passes once fixed
+	opts.Fix = false
+	results = Run(ctx, opts)
+	for _, r := range results {
+		g.Expect(r.Status).To(Equal(Pass), r.Check+": "+r.Message)
+	}
+
+	var out bytes.Buffer
+	g.Expect(Print(&out, results)).To(Succeed())
+	g.Expect(out.String()).To(ContainSubstring("PASS  RuntimeClass"))
+}
+
+func TestRunNotFixable(t *testing.T) {
+	g := NewWithT(t)
+	ctx, _, kube, opts := newTestEnv(t, `
+name = "app"
+
+[variables]
+greeting = { required = true }
+
+[[component]]
+id = "app"
+source = "app.wasm"
+`)
+	spin.CLIVersion = func(context.Context) (string, error) { return "", errors.New("executable file not found in $PATH") }
+	g.Expect(kube.CoreV1().Nodes().Delete(ctx, "wasm-node", metav1.DeleteOptions{})).To(Succeed())
+	_, err := kube.NodeV1().RuntimeClasses().Create(ctx, &nodev1.RuntimeClass{
+		ObjectMeta: metav1.ObjectMeta{Name: generate.RuntimeClassName},
+		Handler:    generate.RuntimeClassHandler,
+	}, metav1.CreateOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	opts.KeyVault = config.ResourceId{}
+	opts.Fix = true
+
+	results := Run(ctx, opts)
+	g.Expect(statuses(results)).To(Equal(map[string]Status{
+		"Spin CLI":       Fail,
+		"Spin manifest":  Pass,
+		"Variables":      Fail,
+		"Cluster":        Pass,
+		"Wasm node pool": Fail,
+		"RuntimeClass":   Pass,
+		"AcrPull":        Fixed,
+	}))
+
+	var out bytes.Buffer
+	g.Expect(Print(&out, results)).To(Succeed())
+	g.Expect(out.String()).To(ContainSubstring("required variables greeting have no value"))
+	g.Expect(out.String()).To(ContainSubstring("--workload-runtime WasmWasi"))
+}
+
+func TestRunAcrPullCheckError(t *testing.T) {
+	g := NewWithT(t)
+	ctx, p, _, opts := newTestEnv(t, `name = "app"`)
+	opts.ContainerRegistry.Name = "missing"
+	opts.Fix = true
+
+	// AcrPull isn't assigned when the access couldn't be checked
+	results := Run(ctx, opts)
+	g.Expect(statuses(results)).To(HaveKeyWithValue("AcrPull", Fail))
+	g.Expect(p.RoleAssignments(fake.ContainerRegistryId(testSub, testRg, "missing"))).To(BeEmpty())
+
+	var out bytes.Buffer
+	g.Expect(Print(&out, results)).To(Succeed())
+	g.Expect(out.String()).To(ContainSubstring("checking whether the cluster can pull from missing"))
+	g.Expect(out.String()).ToNot(ContainSubstring("assign the cluster AcrPull"))
+}
+
+func TestRunAcrPullOtherSubscription(t *testing.T) {
+	g := NewWithT(t)
+	ctx, p, _, opts := newTestEnv(t, `name = "app"`)
+	const acrSub = "00000000-0000-0000-0000-00000000acrsub"
+	p.AddSubscription(acrSub, "Registry Subscription")
+	p.AddResourceGroup(acrSub, "acr-rg", "eastus")
+	p.AddContainerRegistry(acrSub, "acr-rg", "otheracr")
+	opts.ContainerRegistry = config.ResourceId{Subscription: acrSub, ResourceGroup: "acr-rg", Name: "otheracr"}
+
+	// the pull grant is checked and assigned in the registry's subscription
+	results := Run(ctx, opts)
+	g.Expect(statuses(results)).To(HaveKeyWithValue("AcrPull", Fail))
+
+	opts.Fix = true
+	results = Run(ctx, opts)
+	g.Expect(statuses(results)).To(HaveKeyWithValue("AcrPull", Fixed))
+	g.Expect(p.RoleAssignments(fake.ContainerRegistryId(acrSub, "acr-rg", "otheracr"))).To(HaveLen(1))
+
+	opts.Fix = false
+	results = Run(ctx, opts)
+	g.Expect(statuses(results)).To(HaveKeyWithValue("AcrPull", Pass))
+}
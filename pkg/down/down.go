@@ -0,0 +1,298 @@
+// Package down removes an application from its cluster and, optionally, the Azure resources the plugin created for it
+package down
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/azure/spin-aks-plugin/pkg/azure"
+	"github.com/azure/spin-aks-plugin/pkg/config"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	"github.com/azure/spin-aks-plugin/pkg/generate"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// managedByLabel marks the release ConfigMaps deploy records
+const managedByLabel = "app.kubernetes.io/managed-by=spin-aks-plugin"
+
+var secretProviderClasses = schema.GroupVersionResource{
+	Group:    "secrets-store.csi.x-k8s.io",
+	Version:  "v1",
+	Resource: "secretproviderclasses",
+}
+
+// Options describes what to remove
+type Options struct {
+	// App is the name of the application. The generated manifests put its objects in a namespace with the same name.
+	App string
+	// Infra also removes the Azure resources below that the plugin created
+	Infra             bool
+	Cluster           config.ResourceId
+	ContainerRegistry config.ResourceId
+	KeyVault          config.ResourceId
+	WorkloadIdentity  config.ResourceId
+}
+
+// Item is something that's removed
+type Item struct {
+	Kind   string
+	Target string
+	remove func(ctx context.Context) error
+}
+
+func (i Item) String() string {
+	return i.Kind + " " + i.Target
+}
+
+// Plan returns everything that would be removed in the order it's removed. Only objects with the annotation of the
+// generated manifests are removed from the cluster. Only Azure resources that are recorded in state as created by
+// the plugin and still carry its created-by tag are removed so pre-existing resources are never deleted.
+func Plan(ctx context.Context, kube kubernetes.Interface, dyn dynamic.Interface, opts Options) ([]Item, error) {
+	lgr := logger.FromContext(ctx).With("app", opts.App)
+	lgr.Debug("planning down")
+
+	items, err := appItems(ctx, kube, dyn, opts.App)
+	if err != nil {
+		return nil, fmt.Errorf("finding app objects: %w", err)
+	}
+
+	if opts.Infra {
+		infra, err := infraItems(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("finding created Azure resources: %w", err)
+		}
+
+		items = append(items, infra...)
+	}
+
+	lgr.Debug("finished planning down")
+	return items, nil
+}
+
+// Run removes the items in order. Dry runs record the removals instead.
+func Run(ctx context.Context, items []Item) error {
+	lgr := logger.FromContext(ctx)
+
+	if plan, ok := dryrun.FromContext(ctx); ok {
+		for _, item := range items {
+			plan.Record(dryrun.Change{Action: dryrun.Delete, Kind: item.Kind, Target: item.Target})
+		}
+
+		return nil
+	}
+
+	for _, item := range items {
+		lgr.Info("deleting " + item.String())
+		if err := item.remove(ctx); err != nil {
+			return fmt.Errorf("deleting %s: %w", item, err)
+		}
+	}
+
+	return nil
+}
+
+func appItems(ctx context.Context, kube kubernetes.Interface, dyn dynamic.Interface, app string) ([]Item, error) {
+	var items []Item
+	target := app + "/" + app
+
+	dep, err := kube.AppsV1().Deployments(app).Get(ctx, app, metav1.GetOptions{})
+	if err := ignoreNotFound(err); err != nil {
+		return nil, fmt.Errorf("getting deployment: %w", err)
+	}
+	if err == nil && generated(dep.Annotations) {
+		items = append(items, Item{Kind: "Deployment", Target: target, remove: func(ctx context.Context) error {
+			return ignoreNotFound(kube.AppsV1().Deployments(app).Delete(ctx, app, metav1.DeleteOptions{}))
+		}})
+	}
+
+	svc, err := kube.CoreV1().Services(app).Get(ctx, app, metav1.GetOptions{})
+	if err := ignoreNotFound(err); err != nil {
+		return nil, fmt.Errorf("getting service: %w", err)
+	}
+	if err == nil && generated(svc.Annotations) {
+		items = append(items, Item{Kind: "Service", Target: target, remove: func(ctx context.Context) error {
+			return ignoreNotFound(kube.CoreV1().Services(app).Delete(ctx, app, metav1.DeleteOptions{}))
+		}})
+	}
+
+	// clusters without the Secrets Store CSI driver don't serve the resource type which is also not found
+	spc, err := dyn.Resource(secretProviderClasses).Namespace(app).Get(ctx, app, metav1.GetOptions{})
+	if err := ignoreNotFound(err); err != nil {
+		return nil, fmt.Errorf("getting secret provider class: %w", err)
+	}
+	if err == nil && generated(spc.GetAnnotations()) {
+		items = append(items, Item{Kind: "SecretProviderClass", Target: target, remove: func(ctx context.Context) error {
+			return ignoreNotFound(dyn.Resource(secretProviderClasses).Namespace(app).Delete(ctx, app, metav1.DeleteOptions{}))
+		}})
+	}
+
+	sa, err := kube.CoreV1().ServiceAccounts(app).Get(ctx, app, metav1.GetOptions{})
+	if err := ignoreNotFound(err); err != nil {
+		return nil, fmt.Errorf("getting service account: %w", err)
+	}
+	if err == nil && generated(sa.Annotations) {
+		items = append(items, Item{Kind: "ServiceAccount", Target: target, remove: func(ctx context.Context) error {
+			return ignoreNotFound(kube.CoreV1().ServiceAccounts(app).Delete(ctx, app, metav1.DeleteOptions{}))
+		}})
+	}
+
+	releases, err := kube.CoreV1().ConfigMaps(app).List(ctx, metav1.ListOptions{LabelSelector: managedByLabel})
+	if err != nil {
+		return nil, fmt.Errorf("listing release config maps: %w", err)
+	}
+	for _, cm := range releases.Items {
+		name := cm.Name
+		items = append(items, Item{Kind: "ConfigMap", Target: app + "/" + name, remove: func(ctx context.Context) error {
+			return ignoreNotFound(kube.CoreV1().ConfigMaps(app).Delete(ctx, name, metav1.DeleteOptions{}))
+		}})
+	}
+
+	ns, err := kube.CoreV1().Namespaces().Get(ctx, app, metav1.GetOptions{})
+	if err := ignoreNotFound(err); err != nil {
+		return nil, fmt.Errorf("getting namespace: %w", err)
+	}
+	if err == nil && generated(ns.Annotations) {
+		items = append(items, Item{Kind: "Namespace", Target: app, remove: func(ctx context.Context) error {
+			return ignoreNotFound(kube.CoreV1().Namespaces().Delete(ctx, app, metav1.DeleteOptions{}))
+		}})
+	}
+
+	return items, nil
+}
+
+func infraItems(ctx context.Context, opts Options) ([]Item, error) {
+	az := azure.ProviderFromContext(ctx)
+
+	type candidate struct {
+		kind string
+		id   config.ResourceId
+		full string
+	}
+	var candidates []candidate
+	add := func(kind string, id config.ResourceId, full func(sub, rg, name string) string) {
+		if id.Subscription != "" && id.ResourceGroup != "" && id.Name != "" {
+			candidates = append(candidates, candidate{kind: kind, id: id, full: full(id.Subscription, id.ResourceGroup, id.Name)})
+		}
+	}
+	add("Managed Identity", opts.WorkloadIdentity, azure.IdentityScope)
+	add("Key Vault", opts.KeyVault, azure.KeyVaultScope)
+	add("Container Registry", opts.ContainerRegistry, azure.ContainerRegistryScope)
+	add("Managed Cluster", opts.Cluster, azure.ClusterScope)
+
+	var items []Item
+	deleting := map[string]bool{}
+	var resourceGroups []config.ResourceId
+	seenGroups := map[string]bool{}
+	for _, c := range candidates {
+		rg := config.ResourceId{Subscription: c.id.Subscription, Name: c.id.ResourceGroup}
+		if key := strings.ToLower(azure.ResourceGroupScope(rg.Subscription, rg.Name)); !seenGroups[key] {
+			seenGroups[key] = true
+			resourceGroups = append(resourceGroups, rg)
+		}
+
+		owned, err := createdByPlugin(ctx, c.full)
+		if err != nil {
+			return nil, fmt.Errorf("checking %s: %w", c.full, err)
+		}
+		if !owned || deleting[strings.ToLower(c.full)] {
+			continue
+		}
+
+		deleting[strings.ToLower(c.full)] = true
+		items = append(items, azureItem(az, c.kind, c.full))
+	}
+
+	// resource groups are only deleted when nothing but resources being deleted is left in them
+	for _, rg := range resourceGroups {
+		id := azure.ResourceGroupScope(rg.Subscription, rg.Name)
+		owned, err := createdByPlugin(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("checking %s: %w", id, err)
+		}
+		if !owned {
+			continue
+		}
+
+		ids, err := az.ListResourceIds(ctx, rg.Subscription, rg.Name)
+		if err != nil {
+			return nil, fmt.Errorf("listing resources in %s: %w", id, err)
+		}
+
+		empty := true
+		for _, r := range ids {
+			if !deleting[strings.ToLower(r)] {
+				logger.FromContext(ctx).Debug("keeping resource group with other resources", "resource group", id, "resource", r)
+				empty = false
+				break
+			}
+		}
+
+		if empty {
+			items = append(items, azureItem(az, "Resource Group", id))
+		}
+	}
+
+	return items, nil
+}
+
+func azureItem(az azure.Provider, kind, id string) Item {
+	return Item{Kind: kind, Target: id, remove: func(ctx context.Context) error {
+		return az.DeleteResource(ctx, id)
+	}}
+}
+
+// createdByPlugin returns true if the resource is recorded as created by the plugin and still has its tag. Recorded
+// resources that were deleted outside the plugin are forgotten since there's nothing left to delete.
+func createdByPlugin(ctx context.Context, id string) (bool, error) {
+	created, err := azure.WasCreated(ctx, id)
+	if err != nil {
+		return false, fmt.Errorf("checking created resources: %w", err)
+	}
+	if !created {
+		return false, nil
+	}
+
+	tags, err := azure.ProviderFromContext(ctx).GetTags(ctx, id)
+	if errors.Is(err, azure.ErrNotFound) {
+		// a dry run leaves state as it is
+		if _, ok := dryrun.FromContext(ctx); !ok {
+			logger.FromContext(ctx).Debug("forgetting created resource that no longer exists", "id", id)
+			if err := azure.ForgetCreated(ctx, id); err != nil {
+				return false, fmt.Errorf("forgetting deleted resource: %w", err)
+			}
+		}
+
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("getting tags: %w", err)
+	}
+
+	return tags[azure.CreatedByTag] == azure.CreatedByValue, nil
+}
+
+// generated returns true if the object has the annotation every generated object has
+func generated(annotations map[string]string) bool {
+	for k, v := range generate.Annotations() {
+		if annotations[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func ignoreNotFound(err error) error {
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,203 @@
+package down
+
+import (
+	"context"
+	"testing"
+
+	"github.com/azure/spin-aks-plugin/pkg/azure"
+	"github.com/azure/spin-aks-plugin/pkg/azure/fake"
+	"github.com/azure/spin-aks-plugin/pkg/config"
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	"github.com/azure/spin-aks-plugin/pkg/generate"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+const testSub = "00000000-0000-0000-0000-000000000sub"
+
+func generatedMeta(name, namespace string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: name, Namespace: namespace, Annotations: generate.Annotations()}
+}
+
+func newClients(namespaceAnnotated bool) (*kubefake.Clientset, *dynamicfake.FakeDynamicClient) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app"}}
+	if namespaceAnnotated {
+		ns.Annotations = generate.Annotations()
+	}
+
+	kube := kubefake.NewSimpleClientset(
+		ns,
+		&appsv1.Deployment{ObjectMeta: generatedMeta("app", "app")},
+		&corev1.Service{ObjectMeta: generatedMeta("app", "app")},
+		&corev1.ServiceAccount{ObjectMeta: generatedMeta("app", "app")},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-release-1",
+			Namespace: "app",
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "spin-aks-plugin"},
+		}},
+		// not created by the plugin
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "app"}},
+	)
+
+	spc := &unstructured.Unstructured{}
+	spc.SetAPIVersion("secrets-store.csi.x-k8s.io/v1")
+	spc.SetKind("SecretProviderClass")
+	spc.SetName("app")
+	spc.SetNamespace("app")
+	spc.SetAnnotations(generate.Annotations())
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		secretProviderClasses: "SecretProviderClassList",
+	}, spc)
+
+	return kube, dyn
+}
+
+func isolateState(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_DATA_HOME", dir)
+}
+
+func targets(items []Item) []string {
+	ret := make([]string, len(items))
+	for i, item := range items {
+		ret[i] = item.String()
+	}
+
+	return ret
+}
+
+func TestDownApp(t *testing.T) {
+	g := NewWithT(t)
+	isolateState(t)
+	ctx := context.Background()
+	kube, dyn := newClients(true)
+
+	items, err := Plan(ctx, kube, dyn, Options{App: "app"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(targets(items)).To(Equal([]string{
+		"Deployment app/app",
+		"Service app/app",
+		"SecretProviderClass app/app",
+		"ServiceAccount app/app",
+		"ConfigMap app/app-release-1",
+		"Namespace app",
+	}))
+
+	g.Expect(Run(ctx, items)).To(Succeed())
+
+	_, err = kube.AppsV1().Deployments("app").Get(ctx, "app", metav1.GetOptions{})
+	g.Expect(err).To(HaveOccurred())
+	_, err = dyn.Resource(secretProviderClasses).Namespace("app").Get(ctx, "app", metav1.GetOptions{})
+	g.Expect(err).To(HaveOccurred())
+	_, err = kube.CoreV1().ConfigMaps("app").Get(ctx, "other", metav1.GetOptions{})
+	g.Expect(err).NotTo(HaveOccurred())
+}
+
+func TestDownKeepsPreexistingNamespace(t *testing.T) {
+	g := NewWithT(t)
+	isolateState(t)
+	kube, dyn := newClients(false)
+
+	items, err := Plan(context.Background(), kube, dyn, Options{App: "app"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(targets(items)).NotTo(ContainElement("Namespace app"))
+}
+
+func TestDownInfra(t *testing.T) {
+	g := NewWithT(t)
+	isolateState(t)
+	p := fake.New()
+	ctx := azure.WithProvider(context.Background(), p)
+	kube, dyn := newClients(true)
+
+	// pre-existing resource group and cluster with a registry and identity the plugin created in it
+	p.AddResourceGroup(testSub, "existing", "eastus")
+	p.AddCluster(testSub, "existing", "cluster")
+	g.Expect(p.NewContainerRegistry(ctx, testSub, "existing", "acr", "eastus")).To(Succeed())
+	_, err := p.NewIdentity(ctx, testSub, "existing", "identity", "eastus")
+	g.Expect(err).NotTo(HaveOccurred())
+	// the identity's tag was removed so it's no longer treated as created by the plugin
+	p.SetTags(fake.IdentityId(testSub, "existing", "identity"), map[string]string{})
+
+	// a resource group and key vault the plugin created
+	g.Expect(p.NewResourceGroup(ctx, testSub, "created", "eastus")).To(Succeed())
+	_, err = p.NewAkv(ctx, fake.TenantId, testSub, "created", "kv", "eastus")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	opts := Options{
+		App:               "app",
+		Infra:             true,
+		Cluster:           config.ResourceId{Subscription: testSub, ResourceGroup: "existing", Name: "cluster"},
+		ContainerRegistry: config.ResourceId{Subscription: testSub, ResourceGroup: "existing", Name: "acr"},
+		KeyVault:          config.ResourceId{Subscription: testSub, ResourceGroup: "created", Name: "kv"},
+		WorkloadIdentity:  config.ResourceId{Subscription: testSub, ResourceGroup: "existing", Name: "identity"},
+	}
+	items, err := Plan(ctx, kube, dyn, opts)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(targets(items)).To(ContainElements(
+		"Key Vault "+fake.KeyVaultId(testSub, "created", "kv"),
+		"Container Registry "+fake.ContainerRegistryId(testSub, "existing", "acr"),
+		"Resource Group "+fake.ResourceGroupId(testSub, "created"),
+	))
+	g.Expect(targets(items)).NotTo(ContainElement(ContainSubstring("Managed Cluster")))
+	g.Expect(targets(items)).NotTo(ContainElement(ContainSubstring("Managed Identity")))
+	g.Expect(targets(items)).NotTo(ContainElement("Resource Group " + fake.ResourceGroupId(testSub, "existing")))
+
+	plan := &dryrun.Plan{}
+	g.Expect(Run(dryrun.WithContext(ctx, plan), items)).To(Succeed())
+	g.Expect(plan.Changes()).To(HaveLen(len(items)))
+	g.Expect(p.Exists(fake.KeyVaultId(testSub, "created", "kv"))).To(BeTrue())
+
+	g.Expect(Run(ctx, items)).To(Succeed())
+	g.Expect(p.Exists(fake.KeyVaultId(testSub, "created", "kv"))).To(BeFalse())
+	g.Expect(p.Exists(fake.ResourceGroupId(testSub, "created"))).To(BeFalse())
+	g.Expect(p.Exists(fake.ContainerRegistryId(testSub, "existing", "acr"))).To(BeFalse())
+	g.Expect(p.Exists(fake.ClusterId(testSub, "existing", "cluster"))).To(BeTrue())
+	g.Expect(p.Exists(fake.IdentityId(testSub, "existing", "identity"))).To(BeTrue())
+	g.Expect(p.Exists(fake.ResourceGroupId(testSub, "existing"))).To(BeTrue())
+
+	created, err := azure.CreatedResources(ctx)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(created).To(ConsistOf(fake.IdentityId(testSub, "existing", "identity")))
+}
+
+func TestDownInfraForgetsDeletedResources(t *testing.T) {
+	g := NewWithT(t)
+	isolateState(t)
+	p := fake.New()
+	ctx := azure.WithProvider(context.Background(), p)
+	kube, dyn := newClients(true)
+
+	// a key vault the plugin created that was deleted outside the plugin
+	g.Expect(p.NewResourceGroup(ctx, testSub, "created", "eastus")).To(Succeed())
+	g.Expect(azure.RecordCreated(ctx, fake.KeyVaultId(testSub, "created", "kv"))).To(Succeed())
+
+	opts := Options{
+		App:      "app",
+		Infra:    true,
+		KeyVault: config.ResourceId{Subscription: testSub, ResourceGroup: "created", Name: "kv"},
+	}
+	items, err := Plan(dryrun.WithContext(ctx, &dryrun.Plan{}), kube, dyn, opts)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(targets(items)).NotTo(ContainElement(ContainSubstring("Key Vault")))
+	created, err := azure.CreatedResources(ctx)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(created).To(ContainElement(fake.KeyVaultId(testSub, "created", "kv")))
+
+	items, err = Plan(ctx, kube, dyn, opts)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(targets(items)).NotTo(ContainElement(ContainSubstring("Key Vault")))
+	g.Expect(targets(items)).To(ContainElement("Resource Group " + fake.ResourceGroupId(testSub, "created")))
+
+	created, err = azure.CreatedResources(ctx)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(created).NotTo(ContainElement(fake.KeyVaultId(testSub, "created", "kv")))
+}
@@ -0,0 +1,175 @@
+package dryrun
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+type opKind int
+
+const (
+	equal opKind = iota
+	del
+	ins
+)
+
+type op struct {
+	kind opKind
+	line string
+}
+
+// Diff returns a unified diff between the old and new contents of the file at path. An empty string
+// is returned when the contents are the same.
+func Diff(path string, old, new []byte) string {
+	if string(old) == string(new) {
+		return ""
+	}
+
+	ops := diffLines(splitLines(string(old)), splitLines(string(new)))
+
+	var b strings.Builder
+	if len(old) == 0 {
+		b.WriteString("--- /dev/null\n")
+	} else {
+		b.WriteString("--- " + path + "\n")
+	}
+	b.WriteString("+++ " + path + "\n")
+
+	for _, h := range hunks(ops) {
+		b.WriteString(h)
+	}
+
+	return b.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.SplitAfter(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines computes the edit script between a and b using the longest common subsequence.
+// Inputs are generated files which are small so the quadratic approach is fine.
+func diffLines(a, b []string) []op {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := make([]op, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, op{equal, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, op{del, a[i]})
+			i++
+		default:
+			ops = append(ops, op{ins, b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, op{del, a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, op{ins, b[j]})
+	}
+
+	return ops
+}
+
+// hunks groups the edit script into unified diff hunks with surrounding context
+func hunks(ops []op) []string {
+	var ret []string
+
+	for start := 0; start < len(ops); {
+		// find the next change
+		first := start
+		for first < len(ops) && ops[first].kind == equal {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+
+		// extend the hunk until there's more than twice the context of unchanged lines
+		last := first
+		for k := first; k < len(ops); k++ {
+			if ops[k].kind != equal {
+				last = k
+				continue
+			}
+			if k-last > 2*diffContext {
+				break
+			}
+		}
+
+		from := max(first-diffContext, start)
+		to := min(last+diffContext+1, len(ops))
+
+		// line numbers are 1-indexed and count lines before the hunk in each file
+		oldLine, newLine := 1, 1
+		for _, o := range ops[:from] {
+			if o.kind != ins {
+				oldLine++
+			}
+			if o.kind != del {
+				newLine++
+			}
+		}
+
+		var b strings.Builder
+		oldCount, newCount := 0, 0
+		for _, o := range ops[from:to] {
+			line := o.line
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+
+			switch o.kind {
+			case equal:
+				oldCount++
+				newCount++
+				b.WriteString(" " + line)
+			case del:
+				oldCount++
+				b.WriteString("-" + line)
+			case ins:
+				newCount++
+				b.WriteString("+" + line)
+			}
+		}
+
+		if oldCount == 0 {
+			oldLine--
+		}
+		if newCount == 0 {
+			newLine--
+		}
+		ret = append(ret, fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", oldLine, oldCount, newLine, newCount)+b.String())
+		start = to
+	}
+
+	return ret
+}
@@ -0,0 +1,37 @@
+package dryrun
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestDiff(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(Diff("Dockerfile", []byte("same\n"), []byte("same\n"))).To(BeEmpty())
+
+	g.Expect(Diff("Dockerfile", nil, []byte("FROM scratch\nCOPY a ./a\n"))).To(Equal(
+		"--- /dev/null\n" +
+			"+++ Dockerfile\n" +
+			"@@ -0,0 +1,2 @@\n" +
+			"+FROM scratch\n" +
+			"+COPY a ./a\n",
+	))
+
+	old := []byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n")
+	new := []byte("1\n2\n3\n4\nfive\n6\n7\n8\n9\n10\n")
+	g.Expect(Diff("numbers", old, new)).To(Equal(
+		"--- numbers\n" +
+			"+++ numbers\n" +
+			"@@ -2,7 +2,7 @@\n" +
+			" 2\n" +
+			" 3\n" +
+			" 4\n" +
+			"-5\n" +
+			"+five\n" +
+			" 6\n" +
+			" 7\n" +
+			" 8\n",
+	))
+}
@@ -0,0 +1,133 @@
+// Package dryrun records the changes a command would make without making them. Commands run with
+// --dry-run carry a Plan in their context and mutating functions record their change on the Plan
+// instead of applying it so users can review everything before anything is changed.
+package dryrun
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+var planKey = ctxKey{}
+
+// ctxKey is used to store the plan in the ctx.
+// Using a new type avoids collisions.
+type ctxKey struct{}
+
+// Action describes the kind of change being made
+type Action string
+
+const (
+	Create Action = "create"
+	Update Action = "update"
+	Grant  Action = "grant"
+	Write  Action = "write"
+	Apply  Action = "apply"
+	Delete Action = "delete"
+	Push   Action = "push"
+)
+
+// symbols are the prefixes used when printing changes of each action
+var symbols = map[Action]string{
+	Create: "+",
+	Update: "~",
+	Grant:  "+",
+	Write:  "~",
+	Apply:  "~",
+	Delete: "-",
+	Push:   "+",
+}
+
+// Change is a single change that would be made
+type Change struct {
+	Action Action
+	// Kind is the human-readable kind of the target like "Resource Group"
+	Kind string
+	// Target identifies what is changed, typically an Azure resource id or a file path
+	Target string
+	// Detail is optional additional information about the change
+	Detail string
+}
+
+func (c Change) String() string {
+	s := fmt.Sprintf("%s %s %s %s", symbols[c.Action], c.Action, c.Kind, c.Target)
+	if c.Detail != "" {
+		s += " (" + c.Detail + ")"
+	}
+
+	return s
+}
+
+// Plan is the ordered list of changes recorded during a dry run. It's safe for concurrent use.
+type Plan struct {
+	mu      sync.Mutex
+	changes []Change
+}
+
+// WithContext sets the plan as the plan for the context which turns on dry run
+func WithContext(ctx context.Context, p *Plan) context.Context {
+	return context.WithValue(ctx, planKey, p)
+}
+
+// FromContext returns the plan from the context. The bool is false when the context isn't a dry run.
+func FromContext(ctx context.Context) (*Plan, bool) {
+	p, ok := ctx.Value(planKey).(*Plan)
+	return p, ok && p != nil
+}
+
+// Record adds a change to the plan
+func (p *Plan) Record(c Change) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.changes = append(p.changes, c)
+}
+
+// Changes returns a copy of the recorded changes in the order they were recorded
+func (p *Plan) Changes() []Change {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ret := make([]Change, len(p.changes))
+	copy(ret, p.changes)
+	return ret
+}
+
+// Creates returns true if the plan creates the target. Functions reading a resource use this
+// to avoid looking up resources that only exist in the plan.
+func (p *Plan) Creates(target string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, c := range p.changes {
+		if c.Action == Create && strings.EqualFold(c.Target, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Print writes the plan in a human-readable format
+func (p *Plan) Print(w io.Writer) error {
+	changes := p.Changes()
+	if len(changes) == 0 {
+		_, err := fmt.Fprintln(w, "Dry run: no changes would be made")
+		return err
+	}
+
+	if _, err := fmt.Fprintln(w, "Dry run: the following changes would be made"); err != nil {
+		return err
+	}
+
+	for _, c := range changes {
+		if _, err := fmt.Fprintln(w, "  "+c.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,8 +2,11 @@ package generate
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/azure/spin-aks-plugin/pkg/oci"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	apps "k8s.io/client-go/applyconfigurations/apps/v1"
@@ -15,6 +18,27 @@ import (
 
 const (
 	ymlSeparator = "---\n"
+
+	// RuntimeClassName is the RuntimeClass Spin applications run with
+	RuntimeClassName = "wasmtime-spin-v1"
+	// RuntimeClassHandler is the containerd shim handler of the RuntimeClass
+	RuntimeClassHandler = "spin"
+	// ShimVersion is the containerd-shim-spin version the RuntimeClass targets on nodes labeled SpinNodeLabel
+	ShimVersion = "0.5.1"
+	// SpinNodeLabel labels nodes in node pools with the Wasm workload runtime that can run Spin applications
+	SpinNodeLabel = "kubernetes.azure.com/wasmtime-spin-v0-5-1"
+	// SpinOCIRuntimeClassName is the RuntimeClass Spin OCI artifacts run with. The shim on nodes labeled
+	// SpinNodeLabel can't run them so it isn't generated, it's installed along with containerd-shim-spin
+	// SpinOCIShimVersion or later, for example by spin-operator.
+	SpinOCIRuntimeClassName = "wasmtime-spin-v2"
+	// SpinOCIShimVersion is the first containerd-shim-spin version that runs Spin OCI artifacts
+	SpinOCIShimVersion = "0.10.0"
+
+	// publicCloudName is the name of public Azure, the cloud the Secrets Store CSI driver's Azure provider defaults to
+	publicCloudName = "AzurePublicCloud"
+
+	secretsVolume    = "secrets-store"
+	secretsMountPath = "/mnt/secrets-store"
 )
 
 var (
@@ -23,21 +47,149 @@ var (
 	}
 )
 
-func Manifests(name, image string) ([]byte, error) {
+// Annotations returns the annotations every generated object has
+func Annotations() map[string]string {
+	ret := make(map[string]string, len(annotations))
+	for k, v := range annotations {
+		ret[k] = v
+	}
+
+	return ret
+}
+
+// ManifestOpts describes the application the Kubernetes manifests are generated for
+type ManifestOpts struct {
+	// Name is the name of the application. It's used for the namespace, ServiceAccount, and workloads.
+	Name string
+	// Image is the container image of the application
+	Image string
+	// ImageFormat is how the image is packaged, image or spin-oci. Spin OCI artifacts run with
+	// SpinOCIRuntimeClassName instead of the generated RuntimeClass.
+	ImageFormat oci.Format
+	// Secrets are the names of the secret spin variables loaded from KeyVault
+	Secrets []string
+	// KeyVaultName is the name of the KeyVault secrets are loaded from
+	KeyVaultName string
+	// TenantID is the tenant of the KeyVault
+	TenantID string
+	// CloudName is the Azure cloud of the KeyVault as the Secrets Store CSI driver's Azure provider names it like
+	// AzureUSGovernmentCloud. Empty means AzurePublicCloud.
+	CloudName string
+	// WorkloadIdentityClientID is the client id of the user-assigned managed identity the application runs as
+	// through workload identity. When empty, secrets are read with the managed identity of the cluster's nodes.
+	WorkloadIdentityClientID string
+}
+
+// secretProviderClass is a Secrets Store CSI driver SecretProviderClass. There are no applyconfiguration types for
+// it so only the fields we use are defined.
+type secretProviderClass struct {
+	APIVersion string                  `json:"apiVersion"`
+	Kind       string                  `json:"kind"`
+	Metadata   secretProviderClassMeta `json:"metadata"`
+	Spec       secretProviderClassSpec `json:"spec"`
+}
+
+type secretProviderClassMeta struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type secretProviderClassSpec struct {
+	Provider      string            `json:"provider"`
+	Parameters    map[string]string `json:"parameters"`
+	SecretObjects []secretObject    `json:"secretObjects,omitempty"`
+}
+
+type secretObject struct {
+	SecretName string             `json:"secretName"`
+	Type       string             `json:"type"`
+	Data       []secretObjectData `json:"data"`
+}
+
+type secretObjectData struct {
+	ObjectName string `json:"objectName"`
+	Key        string `json:"key"`
+}
+
+func Manifests(opts ManifestOpts) ([]byte, error) {
+	name := opts.Name
+	if name == "" {
+		return nil, errors.New("name is empty")
+	}
+
 	// define the objects we want to generate
 
 	// using applyconfiguration types to generate yaml
 	// means we only generate yaml with the fields we care about
 	ns := core.Namespace(name).WithAnnotations(annotations)
-	rc := node.RuntimeClass("wasmtime-spin-v1").
+	rc := node.RuntimeClass(RuntimeClassName).
 		WithAnnotations(annotations).
-		WithHandler("spin").
+		WithHandler(RuntimeClassHandler).
 		WithScheduling(node.Scheduling().WithNodeSelector(map[string]string{
-			"kubernetes.azure.com/wasmtime-spin-v0-5-1": "true",
+			SpinNodeLabel: "true",
 		}))
 	appLabels := map[string]string{
 		"app": name,
 	}
+	podLabels := map[string]string{
+		"app": name,
+	}
+
+	sa := core.ServiceAccount(name, *ns.Name).WithAnnotations(annotations)
+	if opts.WorkloadIdentityClientID != "" {
+		sa.WithAnnotations(map[string]string{
+			"azure.workload.identity/client-id": opts.WorkloadIdentityClientID,
+		})
+		podLabels["azure.workload.identity/use"] = "true"
+	}
+
+	container := core.Container().
+		WithName(name).
+		WithImage(opts.Image).
+		WithCommand("/")
+	runtimeClass := *rc.Name
+	if opts.ImageFormat == oci.FormatSpinOCI {
+		runtimeClass = SpinOCIRuntimeClassName
+	}
+	podSpec := core.PodSpec().
+		WithRuntimeClassName(runtimeClass).
+		WithServiceAccountName(*sa.Name)
+
+	var spc *secretProviderClass
+	if len(opts.Secrets) > 0 {
+		if opts.KeyVaultName == "" {
+			return nil, errors.New("keyvault name is empty but there are secrets")
+		}
+
+		spc = newSecretProviderClass(opts, *ns.Name)
+		container.WithVolumeMounts(core.VolumeMount().
+			WithName(secretsVolume).
+			WithMountPath(secretsMountPath).
+			WithReadOnly(true),
+		)
+		for _, secret := range opts.Secrets {
+			container.WithEnv(core.EnvVar().
+				WithName(spinVariableEnv(secret)).
+				WithValueFrom(core.EnvVarSource().WithSecretKeyRef(core.SecretKeySelector().
+					WithName(spc.Spec.SecretObjects[0].SecretName).
+					WithKey(secret),
+				)),
+			)
+		}
+		podSpec.WithVolumes(core.Volume().
+			WithName(secretsVolume).
+			WithCSI(core.CSIVolumeSource().
+				WithDriver("secrets-store.csi.k8s.io").
+				WithReadOnly(true).
+				WithVolumeAttributes(map[string]string{
+					"secretProviderClass": spc.Metadata.Name,
+				}),
+			),
+		)
+	}
+	podSpec.WithContainers(container)
+
 	dep := apps.Deployment(name, *ns.Name).
 		WithAnnotations(annotations).
 		WithSpec(
@@ -45,16 +197,9 @@ func Manifests(name, image string) ([]byte, error) {
 				WithReplicas(3).
 				WithSelector(meta.LabelSelector().WithMatchLabels(appLabels)).
 				WithTemplate(core.PodTemplateSpec().
-					WithLabels(appLabels).
+					WithLabels(podLabels).
 					WithAnnotations(annotations).
-					WithSpec(core.PodSpec().
-						WithRuntimeClassName(*rc.Name).
-						WithContainers(core.Container().
-							WithName(name).
-							WithImage(image).
-							WithCommand("/"),
-						),
-					),
+					WithSpec(podSpec),
 				),
 		)
 	service := core.Service(name, *ns.Name).
@@ -69,13 +214,19 @@ func Manifests(name, image string) ([]byte, error) {
 			),
 		)
 
-	objs := []interface{}{
-		ns,
-		rc,
+	objs := []interface{}{ns}
+	if runtimeClass == *rc.Name {
+		objs = append(objs, rc)
+	}
+	objs = append(objs, sa)
+	if spc != nil {
+		objs = append(objs, spc)
+	}
+	objs = append(objs,
 		dep,
 		service,
 		// TODO: add kwasm operator deployment
-	}
+	)
 
 	// marshal to yaml
 	var buf bytes.Buffer
@@ -98,3 +249,64 @@ func Manifests(name, image string) ([]byte, error) {
 
 	return buf.Bytes(), nil
 }
+
+// newSecretProviderClass returns a SecretProviderClass that mounts the secrets from KeyVault and syncs them to a
+// Kubernetes Secret so they can be used as environment variables
+func newSecretProviderClass(opts ManifestOpts, namespace string) *secretProviderClass {
+	var objects strings.Builder
+	objects.WriteString("array:\n")
+	data := make([]secretObjectData, 0, len(opts.Secrets))
+	for _, secret := range opts.Secrets {
+		objects.WriteString(fmt.Sprintf("  - |\n    objectName: %s\n    objectType: secret\n", keyVaultSecretName(secret)))
+		data = append(data, secretObjectData{
+			ObjectName: keyVaultSecretName(secret),
+			Key:        secret,
+		})
+	}
+
+	params := map[string]string{
+		"keyvaultName": opts.KeyVaultName,
+		"tenantId":     opts.TenantID,
+		"objects":      objects.String(),
+	}
+	if opts.CloudName != "" && opts.CloudName != publicCloudName {
+		params["cloudName"] = opts.CloudName
+	}
+	if opts.WorkloadIdentityClientID != "" {
+		params["clientID"] = opts.WorkloadIdentityClientID
+	} else {
+		params["useVMManagedIdentity"] = "true"
+	}
+
+	return &secretProviderClass{
+		APIVersion: "secrets-store.csi.x-k8s.io/v1",
+		Kind:       "SecretProviderClass",
+		Metadata: secretProviderClassMeta{
+			Name:        opts.Name,
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+		Spec: secretProviderClassSpec{
+			Provider:   "azure",
+			Parameters: params,
+			SecretObjects: []secretObject{
+				{
+					SecretName: opts.Name + "-secrets",
+					Type:       "Opaque",
+					Data:       data,
+				},
+			},
+		},
+	}
+}
+
+// keyVaultSecretName returns the name of the KeyVault secret for a spin variable. KeyVault secret names can't
+// contain underscores.
+func keyVaultSecretName(variable string) string {
+	return strings.ReplaceAll(variable, "_", "-")
+}
+
+// spinVariableEnv returns the environment variable Spin reads a variable from
+func spinVariableEnv(variable string) string {
+	return "SPIN_VARIABLE_" + strings.ToUpper(variable)
+}
@@ -0,0 +1,83 @@
+package generate
+
+import (
+	"testing"
+
+	"github.com/azure/spin-aks-plugin/pkg/oci"
+	. "github.com/onsi/gomega"
+)
+
+func TestManifests(t *testing.T) {
+	g := NewWithT(t)
+
+	out, err := Manifests(ManifestOpts{Name: "app", Image: "app:latest"})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(out)).To(ContainSubstring("kind: ServiceAccount"))
+	g.Expect(string(out)).To(ContainSubstring("serviceAccountName: app"))
+	g.Expect(string(out)).ToNot(ContainSubstring("SecretProviderClass"))
+	g.Expect(string(out)).ToNot(ContainSubstring("azure.workload.identity"))
+}
+
+func TestManifestsWorkloadIdentity(t *testing.T) {
+	g := NewWithT(t)
+
+	out, err := Manifests(ManifestOpts{
+		Name:                     "app",
+		Image:                    "app:latest",
+		Secrets:                  []string{"db_password"},
+		KeyVaultName:             "kv",
+		TenantID:                 "tenant",
+		WorkloadIdentityClientID: "client-id",
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	manifests := string(out)
+	g.Expect(manifests).To(ContainSubstring("azure.workload.identity/client-id: client-id"))
+	g.Expect(manifests).To(ContainSubstring(`azure.workload.identity/use: "true"`))
+	g.Expect(manifests).To(ContainSubstring("kind: SecretProviderClass"))
+	g.Expect(manifests).To(ContainSubstring("clientID: client-id"))
+	g.Expect(manifests).To(ContainSubstring("objectName: db-password"))
+	g.Expect(manifests).To(ContainSubstring("name: SPIN_VARIABLE_DB_PASSWORD"))
+	g.Expect(manifests).ToNot(ContainSubstring("useVMManagedIdentity"))
+}
+
+func TestManifestsSecretsWithoutKeyVault(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := Manifests(ManifestOpts{Name: "app", Secrets: []string{"password"}})
+	g.Expect(err).To(MatchError(ContainSubstring("keyvault name is empty")))
+}
+
+func TestManifestsImageFormat(t *testing.T) {
+	g := NewWithT(t)
+
+	out, err := Manifests(ManifestOpts{Name: "app", Image: "app:latest", ImageFormat: oci.FormatImage})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(out)).To(ContainSubstring("kind: RuntimeClass"))
+	g.Expect(string(out)).To(ContainSubstring("runtimeClassName: " + RuntimeClassName))
+
+	out, err = Manifests(ManifestOpts{Name: "app", Image: "app:src-1234-spin", ImageFormat: oci.FormatSpinOCI})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(out)).ToNot(ContainSubstring("kind: RuntimeClass"))
+	g.Expect(string(out)).To(ContainSubstring("runtimeClassName: " + SpinOCIRuntimeClassName))
+	g.Expect(string(out)).To(ContainSubstring("image: app:src-1234-spin"))
+}
+
+func TestManifestsCloudName(t *testing.T) {
+	g := NewWithT(t)
+
+	opts := ManifestOpts{Name: "app", Image: "app:latest", Secrets: []string{"password"}, KeyVaultName: "kv", TenantID: "tenant"}
+	out, err := Manifests(opts)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(out)).ToNot(ContainSubstring("cloudName"))
+
+	opts.CloudName = "AzurePublicCloud"
+	out, err = Manifests(opts)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(out)).ToNot(ContainSubstring("cloudName"))
+
+	opts.CloudName = "AzureUSGovernmentCloud"
+	out, err = Manifests(opts)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(out)).To(ContainSubstring("cloudName: AzureUSGovernmentCloud"))
+}
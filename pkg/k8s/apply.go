@@ -0,0 +1,99 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
+)
+
+// FieldManager is the field manager of everything the plugin applies
+const FieldManager = "spin-aks-plugin"
+
+// Decode splits multi-document yaml into objects
+func Decode(manifests []byte) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	d := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(manifests), 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := d.Decode(&obj.Object); err != nil {
+			if errors.Is(err, io.EOF) {
+				return objs, nil
+			}
+
+			return nil, fmt.Errorf("decoding manifest: %w", err)
+		}
+
+		if len(obj.Object) == 0 { // empty documents
+			continue
+		}
+
+		objs = append(objs, obj)
+	}
+}
+
+// Apply server-side applies the manifests in order. Resource types are resolved through the cluster's discovery api
+// so custom resources like SecretProviderClasses are applied too.
+func Apply(ctx context.Context, kube kubernetes.Interface, dyn dynamic.Interface, manifests []byte) error {
+	lgr := logger.FromContext(ctx)
+	lgr.Debug("applying manifests")
+
+	objs, err := Decode(manifests)
+	if err != nil {
+		return fmt.Errorf("decoding manifests: %w", err)
+	}
+
+	if plan, ok := dryrun.FromContext(ctx); ok {
+		for _, obj := range objs {
+			plan.Record(dryrun.Change{Action: dryrun.Apply, Kind: obj.GetKind(), Target: objectName(obj)})
+		}
+
+		return nil
+	}
+
+	groups, err := restmapper.GetAPIGroupResources(kube.Discovery())
+	if err != nil {
+		return fmt.Errorf("discovering api resources: %w", err)
+	}
+	mapper := restmapper.NewDiscoveryRESTMapper(groups)
+
+	for _, obj := range objs {
+		gvk := obj.GroupVersionKind()
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return fmt.Errorf("mapping %s: %w", gvk.Kind, err)
+		}
+
+		var client dynamic.ResourceInterface = dyn.Resource(mapping.Resource)
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			client = dyn.Resource(mapping.Resource).Namespace(obj.GetNamespace())
+		}
+
+		lgr.Debug("applying object", "kind", gvk.Kind, "name", objectName(obj))
+		if _, err := client.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{FieldManager: FieldManager, Force: true}); err != nil {
+			return fmt.Errorf("applying %s %s: %w", gvk.Kind, objectName(obj), err)
+		}
+	}
+
+	lgr.Debug("finished applying manifests")
+	return nil
+}
+
+func objectName(obj *unstructured.Unstructured) string {
+	if ns := obj.GetNamespace(); ns != "" {
+		return ns + "/" + obj.GetName()
+	}
+
+	return obj.GetName()
+}
@@ -0,0 +1,101 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/yaml"
+)
+
+const testManifests = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: app
+---
+apiVersion: secrets-store.csi.x-k8s.io/v1
+kind: SecretProviderClass
+metadata:
+  name: app
+  namespace: app
+spec:
+  provider: azure
+---
+`
+
+// newApplyClients returns clients that know about namespaces and SecretProviderClasses. The fake dynamic client
+// doesn't implement server-side apply so applies are recorded and stored as creates.
+func newApplyClients() (*kubefake.Clientset, *dynamicfake.FakeDynamicClient, *[]string) {
+	kube := kubefake.NewSimpleClientset()
+	kube.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{{Name: "namespaces", Kind: "Namespace", Namespaced: false}},
+		},
+		{
+			GroupVersion: "secrets-store.csi.x-k8s.io/v1",
+			APIResources: []metav1.APIResource{{Name: "secretproviderclasses", Kind: "SecretProviderClass", Namespaced: true}},
+		},
+	}
+
+	dyn := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	var applied []string
+	dyn.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(patch.GetPatch(), &obj.Object); err != nil {
+			return true, nil, err
+		}
+
+		applied = append(applied, patch.GetResource().Resource+"/"+patch.GetNamespace()+"/"+patch.GetName())
+		return true, obj, nil
+	})
+
+	return kube, dyn, &applied
+}
+
+func TestApply(t *testing.T) {
+	g := NewWithT(t)
+	kube, dyn, applied := newApplyClients()
+
+	g.Expect(Apply(context.Background(), kube, dyn, []byte(testManifests))).To(Succeed())
+	g.Expect(*applied).To(Equal([]string{
+		"namespaces//app",
+		"secretproviderclasses/app/app",
+	}))
+}
+
+func TestApplyDryRun(t *testing.T) {
+	g := NewWithT(t)
+	kube, dyn, applied := newApplyClients()
+	plan := &dryrun.Plan{}
+	ctx := dryrun.WithContext(context.Background(), plan)
+
+	g.Expect(Apply(ctx, kube, dyn, []byte(testManifests))).To(Succeed())
+	g.Expect(*applied).To(BeEmpty())
+	g.Expect(plan.Changes()).To(Equal([]dryrun.Change{
+		{Action: dryrun.Apply, Kind: "Namespace", Target: "app"},
+		{Action: dryrun.Apply, Kind: "SecretProviderClass", Target: "app/app"},
+	}))
+}
+
+func TestApplyUnknownKind(t *testing.T) {
+	g := NewWithT(t)
+	kube, dyn, _ := newApplyClients()
+
+	err := Apply(context.Background(), kube, dyn, []byte("apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: w\n"))
+	g.Expect(err).To(MatchError(ContainSubstring("mapping Widget")))
+}
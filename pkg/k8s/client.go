@@ -0,0 +1,99 @@
+// Package k8s connects to the Kubernetes API server of AKS clusters
+package k8s
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/azure/spin-aks-plugin/pkg/azure"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	clientKey        = ctxKey{name: "client"}
+	dynamicClientKey = ctxKey{name: "dynamic client"}
+)
+
+// ctxKey is used to store the clients in the ctx.
+// Using a new type avoids collisions.
+type ctxKey struct {
+	name string
+}
+
+// WithClient sets the client used for every cluster in the context. Tests use it to replace the cluster with a
+// fake clientset.
+func WithClient(ctx context.Context, c kubernetes.Interface) context.Context {
+	return context.WithValue(ctx, clientKey, c)
+}
+
+// WithDynamicClient sets the dynamic client used for every cluster in the context. Tests use it to replace the
+// cluster with a fake dynamic client.
+func WithDynamicClient(ctx context.Context, c dynamic.Interface) context.Context {
+	return context.WithValue(ctx, dynamicClientKey, c)
+}
+
+// Client returns a client for the cluster authenticated with the signed-in user's cluster credentials or the
+// client set on the context with WithClient
+func Client(ctx context.Context, subscriptionId, resourceGroup, name string) (kubernetes.Interface, error) {
+	if c, ok := ctx.Value(clientKey).(kubernetes.Interface); ok && c != nil {
+		return c, nil
+	}
+
+	lgr := logger.FromContext(ctx).With("subscription", subscriptionId, "resource group", resourceGroup, "cluster name", name)
+	lgr.Debug("creating kubernetes client")
+
+	restConfig, err := restConfig(ctx, subscriptionId, resourceGroup, name)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("creating kubernetes client: %w", err)
+	}
+
+	lgr.Debug("finished creating kubernetes client")
+	return c, nil
+}
+
+// DynamicClient returns a dynamic client for the cluster authenticated the same way as Client or the client set on
+// the context with WithDynamicClient
+func DynamicClient(ctx context.Context, subscriptionId, resourceGroup, name string) (dynamic.Interface, error) {
+	if c, ok := ctx.Value(dynamicClientKey).(dynamic.Interface); ok && c != nil {
+		return c, nil
+	}
+
+	lgr := logger.FromContext(ctx).With("subscription", subscriptionId, "resource group", resourceGroup, "cluster name", name)
+	lgr.Debug("creating dynamic kubernetes client")
+
+	restConfig, err := restConfig(ctx, subscriptionId, resourceGroup, name)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("creating dynamic kubernetes client: %w", err)
+	}
+
+	lgr.Debug("finished creating dynamic kubernetes client")
+	return c, nil
+}
+
+func restConfig(ctx context.Context, subscriptionId, resourceGroup, name string) (*rest.Config, error) {
+	kubeconfig, err := azure.ProviderFromContext(ctx).GetKubeconfig(ctx, subscriptionId, resourceGroup, name)
+	if err != nil {
+		return nil, fmt.Errorf("getting kubeconfig: %w", err)
+	}
+
+	c, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("parsing kubeconfig: %w", err)
+	}
+
+	return c, nil
+}
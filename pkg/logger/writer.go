@@ -0,0 +1,56 @@
+package logger
+
+import (
+	"bytes"
+	"log/slog"
+	"sync"
+)
+
+// LineWriter logs every line written to it at info level with a prefix. It's used to stream the output of
+// subprocesses. Close logs the last line if it doesn't end with a newline.
+type LineWriter struct {
+	lgr    *slog.Logger
+	prefix string
+	mu     sync.Mutex
+	buf    []byte
+}
+
+// NewLineWriter returns a LineWriter logging to the logger with the prefix
+func NewLineWriter(lgr *slog.Logger, prefix string) *LineWriter {
+	return &LineWriter{lgr: lgr, prefix: prefix}
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		w.log(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Close logs the remaining partial line
+func (w *LineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.log(w.buf)
+		w.buf = nil
+	}
+
+	return nil
+}
+
+func (w *LineWriter) log(line []byte) {
+	w.lgr.Info(w.prefix + string(bytes.TrimRight(line, "\r")))
+}
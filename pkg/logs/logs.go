@@ -0,0 +1,342 @@
+// Package logs streams the logs of every pod of a Spin application
+package logs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/spin"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// colors are the ANSI colors pod prefixes cycle through
+var colors = []string{"\033[36m", "\033[33m", "\033[32m", "\033[35m", "\033[34m", "\033[31m"}
+
+const colorReset = "\033[0m"
+
+// Options controls which logs are streamed
+type Options struct {
+	// Follow keeps streaming logs, including from pods created during rollouts, until the context is done
+	Follow bool
+	// Since only streams logs newer than the duration
+	Since time.Duration
+	// Color colors each pod's prefix
+	Color bool
+}
+
+// Stream writes the logs of every pod of the application to w with each line prefixed by the pod name. The
+// generated manifests put the pods in a namespace with the same name as the application and label them with it.
+func Stream(ctx context.Context, kube kubernetes.Interface, app string, w io.Writer, opts Options) error {
+	lgr := logger.FromContext(ctx).With("app", app)
+	lgr.Debug("streaming logs")
+
+	s := &streamer{
+		kube:     kube,
+		app:      app,
+		opts:     opts,
+		out:      w,
+		active:   map[string]bool{},
+		seen:     map[string]corev1.Pod{},
+		restarts: map[string]int32{},
+		since:    map[string]time.Time{},
+		colors:   map[string]string{},
+		errs:     make(chan error, 1),
+		stopped:  make(chan string),
+	}
+
+	selector := metav1.ListOptions{LabelSelector: "app=" + app}
+	pods, err := kube.CoreV1().Pods(app).List(ctx, selector)
+	if err != nil {
+		return fmt.Errorf("listing pods: %w", err)
+	}
+
+	if !opts.Follow {
+		for _, p := range pods.Items {
+			if p.Status.Phase != corev1.PodPending {
+				s.start(ctx, p.Name)
+			}
+		}
+
+		s.wg.Wait()
+		select {
+		case err := <-s.errs:
+			return err
+		default:
+		}
+
+		lgr.Debug("finished streaming logs")
+		return nil
+	}
+
+	resourceVersion := pods.ResourceVersion
+	watcher, err := s.watch(ctx, resourceVersion)
+	if err != nil {
+		return err
+	}
+	defer func() { watcher.Stop() }()
+
+	for _, p := range pods.Items {
+		s.seen[p.Name] = p
+		s.follow(ctx, p)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.wg.Wait()
+			lgr.Debug("finished streaming logs")
+			return nil
+		case err := <-s.errs:
+			return err
+		case name := <-s.stopped:
+			// the container exited or restarted. A restart that was already seen while the stream was ending is
+			// picked back up right away, otherwise the next pod update picks it back up.
+			s.mu.Lock()
+			delete(s.active, name)
+			s.mu.Unlock()
+
+			if p, ok := s.seen[name]; ok && restartCount(p, app) > s.restarts[name] {
+				s.follow(ctx, p)
+			}
+		case e, ok := <-watcher.ResultChan():
+			if !ok || e.Type == watch.Error {
+				// the api server ends watches after a timeout. Watching continues from the last seen version, or
+				// from a new list of the pods if that version is too old.
+				lgr.Debug("pod watch ended, watching again", "resourceVersion", resourceVersion)
+				watcher.Stop()
+				if e.Type == watch.Error {
+					resourceVersion = ""
+				}
+
+				watcher, resourceVersion, err = s.rewatch(ctx, resourceVersion)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			p, isPod := e.Object.(*corev1.Pod)
+			if !isPod {
+				continue
+			}
+			resourceVersion = p.ResourceVersion
+
+			switch e.Type {
+			case watch.Added, watch.Modified:
+				s.seen[p.Name] = *p
+				s.follow(ctx, *p)
+			case watch.Deleted:
+				lgr.Debug("pod deleted", "pod", p.Name)
+				delete(s.seen, p.Name)
+			}
+		}
+	}
+}
+
+// CheckComponent returns an error unless every line of the application's logs belongs to the component. The shim
+// writes the output of every component to the pod's log without saying which component wrote it, so lines can only
+// be attributed to a component when it's the only one in the application.
+func CheckComponent(components []spin.Component, id string) error {
+	found := false
+	for _, c := range components {
+		if c.Id == id {
+			found = true
+		}
+	}
+
+	if !found {
+		err := fmt.Errorf("component %q not found", id)
+		return usererror.New(err, fmt.Sprintf("Component %q isn't in the spin manifest.", id))
+	}
+
+	if len(components) > 1 {
+		err := fmt.Errorf("component %q is one of %d components", id, len(components))
+		return usererror.New(err, fmt.Sprintf("Logs can't be filtered to component %q. Spin writes the output of every component to the same pod log without labeling which component wrote each line. Run without --component to see the logs of all components.", id))
+	}
+
+	return nil
+}
+
+type streamer struct {
+	kube kubernetes.Interface
+	app  string
+	opts Options
+
+	// seen is the latest version of every pod and restarts is the restart count of each pod's container when its
+	// stream started. Both are only used by the Stream loop.
+	seen     map[string]corev1.Pod
+	restarts map[string]int32
+
+	wg      sync.WaitGroup
+	mu      sync.Mutex // guards everything below
+	out     io.Writer
+	active  map[string]bool
+	since   map[string]time.Time
+	colors  map[string]string
+	errs    chan error
+	stopped chan string
+}
+
+// watch watches the application's pods for changes after the resource version
+func (s *streamer) watch(ctx context.Context, resourceVersion string) (watch.Interface, error) {
+	watcher, err := s.kube.CoreV1().Pods(s.app).Watch(ctx, metav1.ListOptions{
+		LabelSelector:   "app=" + s.app,
+		ResourceVersion: resourceVersion,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("watching pods: %w", err)
+	}
+
+	return watcher, nil
+}
+
+// rewatch watches the application's pods again after a watch ended. It continues from the resource version if it's
+// set and the api server still has it. Otherwise the pods are listed again, running pods missed in between are
+// followed, and the watch continues from the list. It returns the new watch and the resource version it started at.
+func (s *streamer) rewatch(ctx context.Context, resourceVersion string) (watch.Interface, string, error) {
+	lgr := logger.FromContext(ctx).With("app", s.app)
+
+	if resourceVersion != "" {
+		watcher, err := s.watch(ctx, resourceVersion)
+		if err == nil {
+			return watcher, resourceVersion, nil
+		}
+		lgr.Debug("failed to watch from the last seen version, listing pods again", "error", err.Error())
+	}
+
+	pods, err := s.kube.CoreV1().Pods(s.app).List(ctx, metav1.ListOptions{LabelSelector: "app=" + s.app})
+	if err != nil {
+		return nil, "", fmt.Errorf("listing pods: %w", err)
+	}
+
+	watcher, err := s.watch(ctx, pods.ResourceVersion)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.seen = map[string]corev1.Pod{}
+	for _, p := range pods.Items {
+		s.seen[p.Name] = p
+		s.follow(ctx, p)
+	}
+
+	return watcher, pods.ResourceVersion, nil
+}
+
+// follow starts streaming a running pod's logs if they aren't already being streamed
+func (s *streamer) follow(ctx context.Context, p corev1.Pod) {
+	if p.Status.Phase != corev1.PodRunning || p.DeletionTimestamp != nil {
+		return
+	}
+
+	s.mu.Lock()
+	streaming := s.active[p.Name]
+	s.mu.Unlock()
+	if streaming {
+		return
+	}
+
+	s.restarts[p.Name] = restartCount(p, s.app)
+	s.start(ctx, p.Name)
+}
+
+// restartCount returns how often the pod's container restarted
+func restartCount(p corev1.Pod, container string) int32 {
+	for _, status := range p.Status.ContainerStatuses {
+		if status.Name == container {
+			return status.RestartCount
+		}
+	}
+
+	return 0
+}
+
+func (s *streamer) start(ctx context.Context, pod string) {
+	s.mu.Lock()
+	s.active[pod] = true
+	if _, ok := s.colors[pod]; !ok {
+		s.colors[pod] = colors[len(s.colors)%len(colors)]
+	}
+	since, resumed := s.since[pod]
+	s.mu.Unlock()
+
+	logOpts := &corev1.PodLogOptions{Container: s.app, Follow: s.opts.Follow}
+	switch {
+	case resumed:
+		logOpts.SinceTime = &metav1.Time{Time: since}
+	case s.opts.Since > 0:
+		seconds := int64(s.opts.Since.Seconds())
+		logOpts.SinceSeconds = &seconds
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		err := s.stream(ctx, pod, logOpts)
+
+		s.mu.Lock()
+		s.since[pod] = time.Now()
+		s.mu.Unlock()
+
+		if err != nil && ctx.Err() == nil {
+			if s.opts.Follow {
+				// pods going away during rollouts end their streams, that's expected
+				logger.FromContext(ctx).Debug("log stream ended", "pod", pod, "error", err.Error())
+			} else {
+				select {
+				case s.errs <- fmt.Errorf("streaming logs of pod %s: %w", pod, err):
+				default:
+				}
+			}
+		}
+
+		if s.opts.Follow {
+			select {
+			case s.stopped <- pod:
+			case <-ctx.Done():
+			}
+		}
+	}()
+}
+
+func (s *streamer) stream(ctx context.Context, pod string, opts *corev1.PodLogOptions) error {
+	rc, err := s.kube.CoreV1().Pods(s.app).GetLogs(pod, opts).Stream(ctx)
+	if err != nil {
+		return fmt.Errorf("opening log stream: %w", err)
+	}
+	defer rc.Close()
+
+	prefix := "[" + pod + "] "
+	if s.opts.Color {
+		s.mu.Lock()
+		prefix = s.colors[pod] + "[" + pod + "]" + colorReset + " "
+		s.mu.Unlock()
+	}
+
+	scanner := bufio.NewScanner(rc)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		s.mu.Lock()
+		_, err := fmt.Fprintln(s.out, prefix+scanner.Text())
+		s.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("writing log line: %w", err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading logs: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/azure/spin-aks-plugin/pkg/spin"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// syncBuffer lets the test read output while streams are still writing it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func appPod(name string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "app", Labels: map[string]string{"app": "app"}},
+		Status:     corev1.PodStatus{Phase: phase},
+	}
+}
+
+func TestStream(t *testing.T) {
+	g := NewWithT(t)
+	kube := kubefake.NewSimpleClientset(
+		appPod("app-a", corev1.PodRunning),
+		appPod("app-b", corev1.PodRunning),
+		appPod("app-c", corev1.PodPending),
+	)
+
+	var out bytes.Buffer
+	g.Expect(Stream(context.Background(), kube, "app", &out, Options{})).To(Succeed())
+
+	// the fake clientset returns "fake logs" for every pod
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	g.Expect(lines).To(ConsistOf("[app-a] fake logs", "[app-b] fake logs"))
+}
+
+func TestStreamColor(t *testing.T) {
+	g := NewWithT(t)
+	kube := kubefake.NewSimpleClientset(appPod("app-a", corev1.PodRunning))
+
+	var out bytes.Buffer
+	g.Expect(Stream(context.Background(), kube, "app", &out, Options{Color: true})).To(Succeed())
+	g.Expect(out.String()).To(Equal(colors[0] + "[app-a]" + colorReset + " fake logs\n"))
+}
+
+func TestStreamFollowPicksUpNewPods(t *testing.T) {
+	g := NewWithT(t)
+	kube := kubefake.NewSimpleClientset(appPod("app-a", corev1.PodRunning))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	out := &syncBuffer{}
+	done := make(chan error)
+	go func() {
+		done <- Stream(ctx, kube, "app", out, Options{Follow: true})
+	}()
+
+	g.Eventually(out.String).Should(ContainSubstring("[app-a] fake logs"))
+
+	// a pod created during a rollout is streamed once it's running
+	_, err := kube.CoreV1().Pods("app").Create(ctx, appPod("app-b", corev1.PodPending), metav1.CreateOptions{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Consistently(out.String, "200ms").ShouldNot(ContainSubstring("[app-b]"))
+
+	_, err = kube.CoreV1().Pods("app").UpdateStatus(ctx, appPod("app-b", corev1.PodRunning), metav1.UpdateOptions{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Eventually(out.String).Should(ContainSubstring("[app-b] fake logs"))
+
+	cancel()
+	g.Eventually(done).Should(Receive(BeNil()))
+}
+
+func TestStreamFollowWatchesAgain(t *testing.T) {
+	g := NewWithT(t)
+	kube := kubefake.NewSimpleClientset(appPod("app-a", corev1.PodRunning))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the first watch is ended like the api server ends watches after a timeout
+	first := watch.NewFake()
+	var mu sync.Mutex
+	watches := 0
+	kube.PrependWatchReactor("pods", func(k8stesting.Action) (bool, watch.Interface, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		watches++
+		return watches == 1, first, nil
+	})
+	watched := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return watches
+	}
+
+	out := &syncBuffer{}
+	done := make(chan error)
+	go func() {
+		done <- Stream(ctx, kube, "app", out, Options{Follow: true})
+	}()
+
+	g.Eventually(out.String).Should(ContainSubstring("[app-a] fake logs"))
+	first.Stop()
+	g.Eventually(watched).Should(Equal(2))
+
+	_, err := kube.CoreV1().Pods("app").Create(ctx, appPod("app-b", corev1.PodRunning), metav1.CreateOptions{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Eventually(out.String).Should(ContainSubstring("[app-b] fake logs"))
+	g.Consistently(done, "100ms").ShouldNot(Receive())
+
+	cancel()
+	g.Eventually(done).Should(Receive(BeNil()))
+}
+
+func TestCheckComponent(t *testing.T) {
+	tests := []struct {
+		name       string
+		components []string
+		component  string
+		expectErr  bool
+	}{
+		{name: "only component", components: []string{"hello"}, component: "hello", expectErr: false},
+		{name: "missing component", components: []string{"hello"}, component: "world", expectErr: true},
+		{name: "one of several components", components: []string{"hello", "world"}, component: "hello", expectErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var components []spin.Component
+			for _, id := range test.components {
+				components = append(components, spin.Component{Id: id})
+			}
+
+			err := CheckComponent(components, test.component)
+			if test.expectErr {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	}
+}
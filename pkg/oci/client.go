@@ -0,0 +1,392 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+)
+
+// manifestAccept are the manifest media types the client accepts
+var manifestAccept = strings.Join([]string{MediaTypeImageManifest, MediaTypeImageIndex, MediaTypeDockerV2}, ", ")
+
+// Credential authenticates to a registry. ACR accepts the 00000000-0000-0000-0000-000000000000 username with a
+// refresh token as the password.
+type Credential struct {
+	Username string
+	Password string
+}
+
+// Client calls the OCI distribution API of a registry. It handles basic and bearer token authentication.
+type Client struct {
+	registry string
+	cred     Credential
+	http     *http.Client
+
+	mu sync.Mutex
+	// tokens are the bearer tokens of each repository
+	tokens map[string]string
+}
+
+// NewClient returns a client of the registry like myregistry.azurecr.io. Registries on localhost are called over
+// http and every other registry over https.
+func NewClient(registry string, cred Credential) *Client {
+	return &Client{
+		registry: registry,
+		cred:     cred,
+		http:     http.DefaultClient,
+		tokens:   map[string]string{},
+	}
+}
+
+// Registry returns the registry the client calls
+func (c *Client) Registry() string {
+	return c.registry
+}
+
+func (c *Client) baseUrl() string {
+	host := c.registry
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if host == "localhost" || host == "127.0.0.1" || host == "::1" {
+		return "http://" + c.registry
+	}
+
+	return "https://" + c.registry
+}
+
+// Resolve returns the descriptor of the manifest the tag or digest points to. NotFoundErr is returned if it doesn't
+// exist.
+func (c *Client) Resolve(ctx context.Context, repo, ref string) (Descriptor, error) {
+	resp, err := c.do(ctx, repo, http.MethodHead, "/manifests/"+ref, nil, http.Header{"Accept": {manifestAccept}})
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("resolving %s:%s: %w", repo, ref, err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return Descriptor{}, fmt.Errorf("resolving %s:%s: %w", repo, ref, err)
+	}
+
+	return Descriptor{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    resp.Header.Get("Docker-Content-Digest"),
+		Size:      resp.ContentLength,
+	}, nil
+}
+
+// FetchManifest returns the manifest the tag or digest points to and its descriptor
+func (c *Client) FetchManifest(ctx context.Context, repo, ref string) (Descriptor, []byte, error) {
+	resp, err := c.do(ctx, repo, http.MethodGet, "/manifests/"+ref, nil, http.Header{"Accept": {manifestAccept}})
+	if err != nil {
+		return Descriptor{}, nil, fmt.Errorf("fetching manifest %s:%s: %w", repo, ref, err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return Descriptor{}, nil, fmt.Errorf("fetching manifest %s:%s: %w", repo, ref, err)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Descriptor{}, nil, fmt.Errorf("reading manifest: %w", err)
+	}
+
+	return Descriptor{MediaType: resp.Header.Get("Content-Type"), Digest: Digest(b), Size: int64(len(b))}, b, nil
+}
+
+// PushManifest uploads the manifest under the tag or digest
+func (c *Client) PushManifest(ctx context.Context, repo, ref, mediaType string, content []byte) (Descriptor, error) {
+	resp, err := c.do(ctx, repo, http.MethodPut, "/manifests/"+ref, content, http.Header{"Content-Type": {mediaType}})
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("pushing manifest %s:%s: %w", repo, ref, err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus(resp, http.StatusCreated); err != nil {
+		return Descriptor{}, fmt.Errorf("pushing manifest %s:%s: %w", repo, ref, err)
+	}
+
+	return Descriptor{MediaType: mediaType, Digest: Digest(content), Size: int64(len(content))}, nil
+}
+
+// Referrers returns the descriptors of the manifests whose subject is the digest. Only referrers of the artifact type
+// are returned if it isn't empty.
+func (c *Client) Referrers(ctx context.Context, repo, digest, artifactType string) ([]Descriptor, error) {
+	path := "/referrers/" + digest
+	if artifactType != "" {
+		path += "?artifactType=" + url.QueryEscape(artifactType)
+	}
+
+	resp, err := c.do(ctx, repo, http.MethodGet, path, nil, http.Header{"Accept": {MediaTypeImageIndex}})
+	if err != nil {
+		return nil, fmt.Errorf("listing referrers of %s: %w", digest, err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("listing referrers of %s: %w", digest, err)
+	}
+
+	var index Index
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return nil, fmt.Errorf("decoding referrers: %w", err)
+	}
+
+	// registries may ignore the filter, which they signal with the OCI-Filters-Applied header
+	var referrers []Descriptor
+	for _, d := range index.Manifests {
+		if artifactType == "" || d.ArtifactType == artifactType {
+			referrers = append(referrers, d)
+		}
+	}
+
+	return referrers, nil
+}
+
+// BlobExists returns true if the repository has the blob
+func (c *Client) BlobExists(ctx context.Context, repo, digest string) (bool, error) {
+	resp, err := c.do(ctx, repo, http.MethodHead, "/blobs/"+digest, nil, nil)
+	if err != nil {
+		return false, fmt.Errorf("checking blob %s: %w", digest, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return false, fmt.Errorf("checking blob %s: %w", digest, err)
+	}
+
+	return true, nil
+}
+
+// FetchBlob returns the content of the blob
+func (c *Client) FetchBlob(ctx context.Context, repo, digest string) ([]byte, error) {
+	resp, err := c.do(ctx, repo, http.MethodGet, "/blobs/"+digest, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetching blob %s: %w", digest, err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("fetching blob %s: %w", digest, err)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading blob: %w", err)
+	}
+
+	if Digest(b) != digest {
+		return nil, fmt.Errorf("blob %s has digest %s", digest, Digest(b))
+	}
+
+	return b, nil
+}
+
+// PushBlob uploads the blob unless the repository already has it
+func (c *Client) PushBlob(ctx context.Context, repo string, b Blob) error {
+	lgr := logger.FromContext(ctx).With("digest", b.Digest)
+
+	exists, err := c.BlobExists(ctx, repo, b.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		lgr.Debug("blob already exists")
+		return nil
+	}
+
+	lgr.Debug("uploading blob", "size", b.Size)
+	resp, err := c.do(ctx, repo, http.MethodPost, "/blobs/uploads/", nil, nil)
+	if err != nil {
+		return fmt.Errorf("starting upload of blob %s: %w", b.Digest, err)
+	}
+	resp.Body.Close()
+
+	if err := checkStatus(resp, http.StatusAccepted); err != nil {
+		return fmt.Errorf("starting upload of blob %s: %w", b.Digest, err)
+	}
+
+	location, err := resp.Location()
+	if err != nil {
+		return fmt.Errorf("getting upload location: %w", err)
+	}
+	q := location.Query()
+	q.Set("digest", b.Digest)
+	location.RawQuery = q.Encode()
+
+	resp, err = c.doUrl(ctx, repo, http.MethodPut, location.String(), b.Content, http.Header{"Content-Type": {"application/octet-stream"}})
+	if err != nil {
+		return fmt.Errorf("uploading blob %s: %w", b.Digest, err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus(resp, http.StatusCreated); err != nil {
+		return fmt.Errorf("uploading blob %s: %w", b.Digest, err)
+	}
+
+	return nil
+}
+
+// do sends a request to a path under the repository like /manifests/latest
+func (c *Client) do(ctx context.Context, repo, method, path string, body []byte, header http.Header) (*http.Response, error) {
+	return c.doUrl(ctx, repo, method, c.baseUrl()+"/v2/"+repo+path, body, header)
+}
+
+// doUrl sends a request authenticating when the registry challenges it
+func (c *Client) doUrl(ctx context.Context, repo, method, u string, body []byte, header http.Header) (*http.Response, error) {
+	send := func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("creating request: %w", err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.ContentLength = int64(len(body))
+
+		c.mu.Lock()
+		token, ok := c.tokens[repo]
+		c.mu.Unlock()
+		switch {
+		case ok && token != "":
+			req.Header.Set("Authorization", "Bearer "+token)
+		case ok:
+			req.SetBasicAuth(c.cred.Username, c.cred.Password)
+		}
+
+		return c.http.Do(req)
+	}
+
+	resp, err := send()
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+
+	if err := c.authenticate(ctx, repo, challenge); err != nil {
+		return nil, err
+	}
+
+	return send()
+}
+
+// authenticate responds to the registry's WWW-Authenticate challenge by getting a bearer token for the repository or
+// by using basic authentication
+func (c *Client) authenticate(ctx context.Context, repo, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		c.mu.Lock()
+		c.tokens[repo] = ""
+		c.mu.Unlock()
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return fmt.Errorf("invalid authentication realm %q", params["realm"])
+	}
+
+	q := realm.Query()
+	if service := params["service"]; service != "" {
+		q.Set("service", service)
+	}
+	q.Set("scope", fmt.Sprintf("repository:%s:pull,push", repo))
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return fmt.Errorf("creating token request: %w", err)
+	}
+	if c.cred.Username != "" || c.cred.Password != "" {
+		req.SetBasicAuth(c.cred.Username, c.cred.Password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("getting registry token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return fmt.Errorf("getting registry token: %w", err)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("decoding registry token: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return fmt.Errorf("registry returned an empty token")
+	}
+
+	c.mu.Lock()
+	c.tokens[repo] = token.Token
+	c.mu.Unlock()
+	return nil
+}
+
+// parseChallenge parses a WWW-Authenticate header like Bearer realm="https://x/token",service="x"
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+
+		if key = strings.TrimSpace(key); key != "" {
+			params[strings.ToLower(key)] = value
+		}
+	}
+
+	return scheme, params
+}
+
+// checkStatus returns an error if the response doesn't have the expected status. NotFoundErr is returned for 404s.
+func checkStatus(resp *http.Response, expected int) error {
+	if resp.StatusCode == expected {
+		return nil
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return NotFoundErr
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	msg := strings.TrimSpace(string(body))
+	if msg == "" {
+		msg = http.StatusText(resp.StatusCode)
+	}
+
+	return fmt.Errorf("unexpected status %s: %s", strconv.Itoa(resp.StatusCode), msg)
+}
@@ -0,0 +1,90 @@
+package oci
+
+import (
+	"context"
+	"testing"
+
+	"github.com/azure/spin-aks-plugin/pkg/oci/fake"
+	. "github.com/onsi/gomega"
+)
+
+func newTestRegistry(t *testing.T) (*fake.Registry, *Client) {
+	t.Helper()
+
+	r := fake.NewRegistry()
+	t.Cleanup(r.Close)
+	r.RequireAuth("user", "password")
+
+	return r, NewClient(r.Host(), Credential{Username: "user", Password: "password"})
+}
+
+func TestClientBlobs(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	r, c := newTestRegistry(t)
+
+	b := NewBlob("application/octet-stream", []byte("hello"))
+	exists, err := c.BlobExists(ctx, "app", b.Digest)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(exists).To(BeFalse())
+
+	g.Expect(c.PushBlob(ctx, "app", b)).To(Succeed())
+	g.Expect(c.PushBlob(ctx, "app", b)).To(Succeed())
+	g.Expect(r.Uploads()).To(Equal(1), "existing blobs aren't uploaded again")
+
+	content, err := c.FetchBlob(ctx, "app", b.Digest)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(content)).To(Equal("hello"))
+}
+
+func TestClientManifests(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	_, c := newTestRegistry(t)
+
+	_, err := c.Resolve(ctx, "app", "v1")
+	g.Expect(err).To(MatchError(NotFoundErr))
+
+	config := NewBlob(MediaTypeImageConfig, []byte("{}"))
+	g.Expect(c.PushBlob(ctx, "app", config)).To(Succeed())
+
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"` + config.Digest + `","size":2},"layers":[]}`)
+	pushed, err := c.PushManifest(ctx, "app", "v1", MediaTypeImageManifest, manifest)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(pushed.Digest).To(Equal(Digest(manifest)))
+
+	resolved, err := c.Resolve(ctx, "app", "v1")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(resolved).To(Equal(Descriptor{MediaType: MediaTypeImageManifest, Digest: pushed.Digest, Size: int64(len(manifest))}))
+
+	desc, content, err := c.FetchManifest(ctx, "app", pushed.Digest)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(desc).To(Equal(resolved))
+	g.Expect(content).To(Equal(manifest))
+}
+
+func TestClientBadCredential(t *testing.T) {
+	g := NewWithT(t)
+
+	r, _ := newTestRegistry(t)
+	c := NewClient(r.Host(), Credential{Username: "user", Password: "wrong"})
+
+	_, err := c.Resolve(context.Background(), "app", "v1")
+	g.Expect(err).To(MatchError(ContainSubstring("getting registry token")))
+}
+
+func TestParseChallenge(t *testing.T) {
+	g := NewWithT(t)
+
+	scheme, params := parseChallenge(`Bearer realm="https://myacr.azurecr.io/oauth2/token",service="myacr.azurecr.io",scope="repository:app:pull"`)
+	g.Expect(scheme).To(Equal("Bearer"))
+	g.Expect(params).To(Equal(map[string]string{
+		"realm":   "https://myacr.azurecr.io/oauth2/token",
+		"service": "myacr.azurecr.io",
+		"scope":   "repository:app:pull",
+	}))
+
+	scheme, params = parseChallenge(`Basic realm="registry"`)
+	g.Expect(scheme).To(Equal("Basic"))
+	g.Expect(params).To(HaveKeyWithValue("realm", "registry"))
+}
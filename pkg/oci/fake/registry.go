@@ -0,0 +1,306 @@
+// Package fake provides an in-memory OCI registry for tests
+package fake
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const token = "fake-registry-token"
+
+// Registry is an in-memory registry implementing the parts of the OCI distribution API the plugin uses. It runs on
+// localhost so clients call it over http.
+type Registry struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	// mediaTypes are the media types of the manifests by digest
+	mediaTypes map[string]string
+	// tags are the digests of the tags by repository/tag
+	tags map[string]string
+	// referrers are the descriptors of the manifests referring to a subject by repository@digest
+	referrers map[string][]referrer
+	uploads   int
+
+	username, password string
+	// Requests are the method and path of every request made to the registry
+	Requests []string
+}
+
+// NewRegistry starts a registry. Callers stop it with Close.
+func NewRegistry() *Registry {
+	r := &Registry{
+		blobs:      map[string][]byte{},
+		manifests:  map[string][]byte{},
+		mediaTypes: map[string]string{},
+		tags:       map[string]string{},
+		referrers:  map[string][]referrer{},
+	}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serve))
+	return r
+}
+
+// RequireAuth makes the registry challenge for a bearer token that it only gives to the username and password
+func (r *Registry) RequireAuth(username, password string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.username, r.password = username, password
+}
+
+// Host returns the host and port of the registry
+func (r *Registry) Host() string {
+	return strings.TrimPrefix(r.server.URL, "http://")
+}
+
+// Close stops the registry
+func (r *Registry) Close() {
+	r.server.Close()
+}
+
+// Blob returns the content of a pushed blob
+func (r *Registry) Blob(digest string) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.blobs[digest]
+	return b, ok
+}
+
+// Manifest returns the content of the manifest the tag or digest points to
+func (r *Registry) Manifest(repo, ref string) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.manifests[r.digest(repo, ref)]
+	return b, ok
+}
+
+// Uploads returns the number of blobs uploaded
+func (r *Registry) Uploads() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.uploads
+}
+
+func (r *Registry) serve(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Requests = append(r.Requests, req.Method+" "+req.URL.Path)
+
+	if req.URL.Path == "/token" {
+		if u, p, ok := req.BasicAuth(); !ok || u != r.username || p != r.password {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"token": token})
+		return
+	}
+
+	if r.username != "" && req.Header.Get("Authorization") != "Bearer "+token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="%s"`, r.server.URL, r.Host()))
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	for _, route := range []struct {
+		sep     string
+		handler func(w http.ResponseWriter, req *http.Request, repo, rest string)
+	}{
+		{"/referrers/", r.referrer},
+		{"/blobs/uploads/", r.upload},
+		{"/blobs/", r.blob},
+		{"/manifests/", r.manifest},
+	} {
+		if i := strings.LastIndex(path, route.sep); i > 0 {
+			route.handler(w, req, path[:i], path[i+len(route.sep):])
+			return
+		}
+	}
+
+	if path == "" {
+		return
+	}
+	http.NotFound(w, req)
+}
+
+func (r *Registry) upload(w http.ResponseWriter, req *http.Request, repo, id string) {
+	switch req.Method {
+	case http.MethodPost:
+		r.uploads++
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%d", repo, r.uploads))
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		content, _ := io.ReadAll(req.Body)
+		digest := req.URL.Query().Get("digest")
+		if digestOf(content) != digest {
+			http.Error(w, "digest mismatch", http.StatusBadRequest)
+			return
+		}
+
+		r.blobs[digest] = content
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *Registry) blob(w http.ResponseWriter, req *http.Request, _, digest string) {
+	content, ok := r.blobs[digest]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Header().Set("Docker-Content-Digest", digest)
+	if req.Method == http.MethodGet {
+		w.Write(content)
+	}
+}
+
+func (r *Registry) manifest(w http.ResponseWriter, req *http.Request, repo, ref string) {
+	switch req.Method {
+	case http.MethodPut:
+		content, _ := io.ReadAll(req.Body)
+		digest := digestOf(content)
+
+		var m struct {
+			MediaType    string `json:"mediaType"`
+			ArtifactType string `json:"artifactType"`
+			Config       struct {
+				Digest    string `json:"digest"`
+				MediaType string `json:"mediaType"`
+			} `json:"config"`
+			Layers []struct {
+				Digest string `json:"digest"`
+			} `json:"layers"`
+			Subject *struct {
+				Digest string `json:"digest"`
+			} `json:"subject"`
+		}
+		if err := json.Unmarshal(content, &m); err != nil {
+			http.Error(w, "invalid manifest", http.StatusBadRequest)
+			return
+		}
+		digests := []string{m.Config.Digest}
+		for _, l := range m.Layers {
+			digests = append(digests, l.Digest)
+		}
+		for _, d := range digests {
+			if _, ok := r.blobs[d]; !ok && d != "" {
+				http.Error(w, "blob unknown "+d, http.StatusBadRequest)
+				return
+			}
+		}
+
+		if m.Subject != nil {
+			artifactType := m.ArtifactType
+			if artifactType == "" {
+				artifactType = m.Config.MediaType
+			}
+			r.addReferrer(repo+"@"+m.Subject.Digest, referrer{
+				MediaType:    req.Header.Get("Content-Type"),
+				Digest:       digest,
+				Size:         int64(len(content)),
+				ArtifactType: artifactType,
+			})
+			w.Header().Set("OCI-Subject", m.Subject.Digest)
+		}
+
+		r.manifests[digest] = content
+		r.mediaTypes[digest] = req.Header.Get("Content-Type")
+		if !strings.HasPrefix(ref, "sha256:") {
+			r.tags[repo+":"+ref] = digest
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet, http.MethodHead:
+		digest := r.digest(repo, ref)
+		content, ok := r.manifests[digest]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+
+		w.Header().Set("Content-Type", r.mediaTypes[digest])
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Header().Set("Docker-Content-Digest", digest)
+		if req.Method == http.MethodGet {
+			w.Write(content)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// referrer is a descriptor in the referrers index
+type referrer struct {
+	MediaType    string `json:"mediaType"`
+	Digest       string `json:"digest"`
+	Size         int64  `json:"size"`
+	ArtifactType string `json:"artifactType,omitempty"`
+}
+
+func (r *Registry) addReferrer(subject string, ref referrer) {
+	for _, existing := range r.referrers[subject] {
+		if existing.Digest == ref.Digest {
+			return
+		}
+	}
+
+	r.referrers[subject] = append(r.referrers[subject], ref)
+}
+
+func (r *Registry) referrer(w http.ResponseWriter, req *http.Request, repo, digest string) {
+	if req.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	artifactType := req.URL.Query().Get("artifactType")
+	manifests := []referrer{}
+	for _, ref := range r.referrers[repo+"@"+digest] {
+		if artifactType == "" || ref.ArtifactType == artifactType {
+			manifests = append(manifests, ref)
+		}
+	}
+
+	if artifactType != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.index.v1+json",
+		"manifests":     manifests,
+	})
+}
+
+// digest returns the digest the reference points to
+func (r *Registry) digest(repo, ref string) string {
+	if strings.HasPrefix(ref, "sha256:") {
+		return ref
+	}
+
+	return r.tags[repo+":"+ref]
+}
+
+func digestOf(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
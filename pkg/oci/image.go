@@ -0,0 +1,289 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/spin"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+)
+
+// Image is an OCI image ready to push
+type Image struct {
+	Manifest Blob
+	Config   Blob
+	Layers   []Blob
+}
+
+// imageConfig is the config of images run by containerd-shim-spin
+type imageConfig struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	RootFS       struct {
+		Type    string   `json:"type"`
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// BuildImage assembles the image the scaffolded Dockerfile would build: a wasi/wasm image with the spin manifest at
+// /spin.toml and each component's wasm at its source path. It's built without a container runtime and is
+// reproducible, building the same files always results in the same digest.
+func BuildImage(manifestPath string, m spin.Manifest) (Image, error) {
+	files := []layerFile{{path: "spin.toml", source: manifestPath}}
+	for _, c := range m.Components {
+		if c.Source.URLSource.Url != "" {
+			return Image{}, usererror.New(
+				errors.New("component source is a URL"),
+				fmt.Sprintf("Component source %s is a URL which isn't currently supported.", c.Id),
+			)
+		}
+
+		if len(c.Files.StringFiles) > 0 || len(c.Files.MapFiles) > 0 {
+			return Image{}, usererror.New(
+				errors.New("file source exists"),
+				fmt.Sprintf("Component %s contains files which isn't currently supported.", c.Id),
+			)
+		}
+
+		source := filepath.Clean(string(c.Source.StringSource))
+		files = append(files, layerFile{
+			path:   filepath.ToSlash(source),
+			source: filepath.Join(filepath.Dir(manifestPath), source),
+		})
+	}
+
+	layer, diffId, err := buildLayer(files)
+	if err != nil {
+		return Image{}, fmt.Errorf("building layer: %w", err)
+	}
+
+	config := imageConfig{Architecture: "wasm", OS: "wasi"}
+	config.RootFS.Type = "layers"
+	config.RootFS.DiffIDs = []string{diffId}
+
+	return newImage(MediaTypeImageConfig, config, []Blob{NewBlob(MediaTypeImageLayer, layer)}, nil)
+}
+
+// newImage returns an image of the config and layers with a manifest that has the annotations
+func newImage(configMediaType string, config any, layers []Blob, annotations map[string]string) (Image, error) {
+	configContent, err := json.Marshal(config)
+	if err != nil {
+		return Image{}, fmt.Errorf("marshalling config: %w", err)
+	}
+
+	img := Image{Config: NewBlob(configMediaType, configContent), Layers: layers}
+	manifest := Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeImageManifest,
+		Config:        img.Config.Descriptor,
+		Layers:        []Descriptor{},
+		Annotations:   annotations,
+	}
+	for _, l := range layers {
+		manifest.Layers = append(manifest.Layers, l.Descriptor)
+	}
+
+	manifestContent, err := json.Marshal(manifest)
+	if err != nil {
+		return Image{}, fmt.Errorf("marshalling manifest: %w", err)
+	}
+	img.Manifest = NewBlob(MediaTypeImageManifest, manifestContent)
+
+	return img, nil
+}
+
+// layerFile is a file copied into a layer
+type layerFile struct {
+	// path is the slash separated path in the layer
+	path string
+	// source is the path of the file on disk
+	source string
+}
+
+// buildLayer returns the gzipped tar of the files and the digest of the uncompressed tar. Timestamps and owners are
+// left empty so the layer only depends on the files' paths and contents.
+func buildLayer(files []layerFile) ([]byte, string, error) {
+	var tarred bytes.Buffer
+	tw := tar.NewWriter(&tarred)
+	seen := map[string]bool{}
+	for _, f := range files {
+		if seen[f.path] {
+			continue
+		}
+		seen[f.path] = true
+
+		content, err := os.ReadFile(f.source)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, "", usererror.New(err, fmt.Sprintf("%s doesn't exist. Run `spin aks build` first.", f.source))
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("reading %s: %w", f.source, err)
+		}
+
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     f.path,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Format:   tar.FormatPAX,
+		}); err != nil {
+			return nil, "", fmt.Errorf("writing tar header of %s: %w", f.path, err)
+		}
+
+		if _, err := tw.Write(content); err != nil {
+			return nil, "", fmt.Errorf("writing %s to tar: %w", f.path, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, "", fmt.Errorf("closing tar: %w", err)
+	}
+
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	if _, err := gw.Write(tarred.Bytes()); err != nil {
+		return nil, "", fmt.Errorf("compressing layer: %w", err)
+	}
+	if err := gw.Close(); err != nil {
+		return nil, "", fmt.Errorf("closing gzip: %w", err)
+	}
+
+	return gzipped.Bytes(), Digest(tarred.Bytes()), nil
+}
+
+// Push uploads the image's blobs and manifest to the repository under every tag. Blobs the repository already has
+// aren't uploaded again.
+func Push(ctx context.Context, c *Client, repo string, img Image, tags ...string) (Descriptor, error) {
+	lgr := logger.FromContext(ctx).With("repository", repo, "digest", img.Manifest.Digest)
+	lgr.Debug("pushing image")
+
+	if p, ok := dryrun.FromContext(ctx); ok {
+		for _, tag := range tags {
+			p.Record(dryrun.Change{Action: dryrun.Push, Kind: "Image", Target: c.Registry() + "/" + repo + ":" + tag, Detail: img.Manifest.Digest})
+		}
+		return img.Manifest.Descriptor, nil
+	}
+
+	if err := pushImage(ctx, c, repo, img, tags); err != nil {
+		return Descriptor{}, err
+	}
+
+	lgr.Debug("finished pushing image")
+	return img.Manifest.Descriptor, nil
+}
+
+// pushImage uploads the image's blobs and its manifest under every ref
+func pushImage(ctx context.Context, c *Client, repo string, img Image, refs []string) error {
+	for _, b := range append([]Blob{img.Config}, img.Layers...) {
+		if err := c.PushBlob(ctx, repo, b); err != nil {
+			return fmt.Errorf("pushing blob: %w", err)
+		}
+	}
+
+	for _, ref := range refs {
+		if _, err := c.PushManifest(ctx, repo, ref, img.Manifest.MediaType, img.Manifest.Content); err != nil {
+			return fmt.Errorf("pushing manifest: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Attach pushes the content as an artifact of the artifact type referring to the subject manifest so registries list
+// it through the referrers API. The artifact is only addressed by its digest.
+func Attach(ctx context.Context, c *Client, repo string, subject Descriptor, artifactType string, content []byte) (Descriptor, error) {
+	lgr := logger.FromContext(ctx).With("repository", repo, "subject", subject.Digest, "artifactType", artifactType)
+	lgr.Debug("attaching artifact")
+
+	layer := NewBlob(artifactType, content)
+	img := Image{Config: NewBlob(MediaTypeEmptyJSON, []byte("{}")), Layers: []Blob{layer}}
+	manifest, err := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeImageManifest,
+		ArtifactType:  artifactType,
+		Config:        img.Config.Descriptor,
+		Layers:        []Descriptor{layer.Descriptor},
+		Subject:       &Descriptor{MediaType: subject.MediaType, Digest: subject.Digest, Size: subject.Size},
+	})
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("marshalling manifest: %w", err)
+	}
+	img.Manifest = NewBlob(MediaTypeImageManifest, manifest)
+	img.Manifest.ArtifactType = artifactType
+
+	if p, ok := dryrun.FromContext(ctx); ok {
+		p.Record(dryrun.Change{Action: dryrun.Push, Kind: "Referrer", Target: c.Registry() + "/" + repo + "@" + subject.Digest, Detail: artifactType})
+		return img.Manifest.Descriptor, nil
+	}
+
+	if err := pushImage(ctx, c, repo, img, []string{img.Manifest.Digest}); err != nil {
+		return Descriptor{}, err
+	}
+
+	lgr.Debug("finished attaching artifact")
+	return img.Manifest.Descriptor, nil
+}
+
+// Pull fetches the manifest, config, and layers of the image the tag or digest points to
+func Pull(ctx context.Context, c *Client, repo, ref string) (Image, error) {
+	desc, content, err := c.FetchManifest(ctx, repo, ref)
+	if err != nil {
+		return Image{}, fmt.Errorf("fetching manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return Image{}, fmt.Errorf("decoding manifest: %w", err)
+	}
+
+	img := Image{Manifest: Blob{Descriptor: desc, Content: content}}
+	fetch := func(d Descriptor) (Blob, error) {
+		b, err := c.FetchBlob(ctx, repo, d.Digest)
+		if err != nil {
+			return Blob{}, err
+		}
+
+		return Blob{Descriptor: d, Content: b}, nil
+	}
+
+	if img.Config, err = fetch(manifest.Config); err != nil {
+		return Image{}, fmt.Errorf("fetching config: %w", err)
+	}
+
+	for _, l := range manifest.Layers {
+		layer, err := fetch(l)
+		if err != nil {
+			return Image{}, fmt.Errorf("fetching layer: %w", err)
+		}
+		img.Layers = append(img.Layers, layer)
+	}
+
+	return img, nil
+}
+
+// TagManifest points the tag at the manifest the repository already has under ref
+func TagManifest(ctx context.Context, c *Client, repo, ref, tag string) error {
+	desc, content, err := c.FetchManifest(ctx, repo, ref)
+	if err != nil {
+		return fmt.Errorf("fetching manifest: %w", err)
+	}
+
+	if p, ok := dryrun.FromContext(ctx); ok {
+		p.Record(dryrun.Change{Action: dryrun.Push, Kind: "Image Tag", Target: c.Registry() + "/" + repo + ":" + tag, Detail: desc.Digest})
+		return nil
+	}
+
+	if _, err := c.PushManifest(ctx, repo, tag, desc.MediaType, content); err != nil {
+		return fmt.Errorf("tagging manifest: %w", err)
+	}
+
+	return nil
+}
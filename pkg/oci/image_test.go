@@ -0,0 +1,228 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	"github.com/azure/spin-aks-plugin/pkg/spin"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+	. "github.com/onsi/gomega"
+)
+
+// writeApp writes a spin manifest with two components and their wasm
+func writeApp(t *testing.T) (string, spin.Manifest) {
+	t.Helper()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"spin.toml":         "spin_manifest_version = \"1\"\nname = \"app\"\n",
+		"target/hello.wasm": "hello wasm",
+		"static.wasm":       "static wasm",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("creating directory: %s", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("writing %s: %s", name, err)
+		}
+	}
+
+	m := spin.Manifest{Name: "app", Components: []spin.Component{
+		{Id: "hello", Source: spin.ComponentSource{StringSource: "target/hello.wasm"}},
+		{Id: "static", Source: spin.ComponentSource{StringSource: "./static.wasm"}},
+	}}
+
+	return filepath.Join(dir, "spin.toml"), m
+}
+
+// layerFiles returns the contents of the files in a gzipped tar layer
+func layerFiles(t *testing.T, layer []byte) map[string]string {
+	t.Helper()
+
+	gr, err := gzip.NewReader(bytes.NewReader(layer))
+	if err != nil {
+		t.Fatalf("reading gzip: %s", err)
+	}
+
+	files := map[string]string{}
+	tr := tar.NewReader(gr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading tar: %s", err)
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("reading %s: %s", h.Name, err)
+		}
+		files[h.Name] = string(content)
+	}
+
+	return files
+}
+
+func TestBuildImage(t *testing.T) {
+	g := NewWithT(t)
+
+	manifest, m := writeApp(t)
+	img, err := BuildImage(manifest, m)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	again, err := BuildImage(manifest, m)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(again.Manifest.Digest).To(Equal(img.Manifest.Digest), "images are reproducible")
+
+	g.Expect(img.Layers).To(HaveLen(1))
+	g.Expect(layerFiles(t, img.Layers[0].Content)).To(Equal(map[string]string{
+		"spin.toml":         "spin_manifest_version = \"1\"\nname = \"app\"\n",
+		"target/hello.wasm": "hello wasm",
+		"static.wasm":       "static wasm",
+	}))
+
+	var config imageConfig
+	g.Expect(json.Unmarshal(img.Config.Content, &config)).To(Succeed())
+	g.Expect(config.OS).To(Equal("wasi"))
+	g.Expect(config.Architecture).To(Equal("wasm"))
+
+	var parsed Manifest
+	g.Expect(json.Unmarshal(img.Manifest.Content, &parsed)).To(Succeed())
+	g.Expect(parsed.Config).To(Equal(img.Config.Descriptor))
+	g.Expect(parsed.Layers).To(Equal([]Descriptor{img.Layers[0].Descriptor}))
+}
+
+func TestBuildImageUnsupported(t *testing.T) {
+	g := NewWithT(t)
+
+	manifest, m := writeApp(t)
+	m.Components = append(m.Components, spin.Component{Id: "remote", Source: spin.ComponentSource{URLSource: spin.ComponentSourceURL{Url: "https://example.com/remote.wasm"}}})
+	_, err := BuildImage(manifest, m)
+	_, ok := usererror.Is(err)
+	g.Expect(ok).To(BeTrue())
+
+	manifest, m = writeApp(t)
+	m.Components = append(m.Components, spin.Component{Id: "missing", Source: spin.ComponentSource{StringSource: "missing.wasm"}})
+	_, err = BuildImage(manifest, m)
+	u, ok := usererror.Is(err)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(u.Msg()).To(ContainSubstring("Run `spin aks build` first"))
+}
+
+func TestPush(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	r, c := newTestRegistry(t)
+
+	manifest, m := writeApp(t)
+	img, err := BuildImage(manifest, m)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	desc, err := Push(ctx, c, "app", img, "src-1234", "v1")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(desc.Digest).To(Equal(img.Manifest.Digest))
+
+	for _, tag := range []string{"src-1234", "v1"} {
+		content, ok := r.Manifest("app", tag)
+		g.Expect(ok).To(BeTrue(), tag)
+		g.Expect(content).To(Equal(img.Manifest.Content))
+	}
+	layer, ok := r.Blob(img.Layers[0].Digest)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(layer).To(Equal(img.Layers[0].Content))
+
+	// pushing again doesn't upload the blobs again
+	uploads := r.Uploads()
+	_, err = Push(ctx, c, "app", img, "src-1234")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(r.Uploads()).To(Equal(uploads))
+
+	g.Expect(TagManifest(ctx, c, "app", "src-1234", "latest")).To(Succeed())
+	content, ok := r.Manifest("app", "latest")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(content).To(Equal(img.Manifest.Content))
+}
+
+func TestPushDryRun(t *testing.T) {
+	g := NewWithT(t)
+	r, c := newTestRegistry(t)
+
+	plan := &dryrun.Plan{}
+	ctx := dryrun.WithContext(context.Background(), plan)
+
+	manifest, m := writeApp(t)
+	img, err := BuildImage(manifest, m)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = Push(ctx, c, "app", img, "src-1234")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(r.Uploads()).To(BeZero())
+	g.Expect(plan.Changes()).To(Equal([]dryrun.Change{
+		{Action: dryrun.Push, Kind: "Image", Target: r.Host() + "/app:src-1234", Detail: img.Manifest.Digest},
+	}))
+}
+
+func TestAttach(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	r, c := newTestRegistry(t)
+
+	manifest, m := writeApp(t)
+	img, err := BuildImage(manifest, m)
+	g.Expect(err).NotTo(HaveOccurred())
+	subject, err := Push(ctx, c, "app", img, "v1")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	sbom, err := Attach(ctx, c, "app", subject, "application/spdx+json", []byte(`{"spdxVersion":"SPDX-2.3"}`))
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = Attach(ctx, c, "app", subject, "application/vnd.in-toto+json", []byte(`{}`))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	content, ok := r.Manifest("app", sbom.Digest)
+	g.Expect(ok).To(BeTrue())
+	var parsed Manifest
+	g.Expect(json.Unmarshal(content, &parsed)).To(Succeed())
+	g.Expect(parsed.ArtifactType).To(Equal("application/spdx+json"))
+	g.Expect(parsed.Subject.Digest).To(Equal(subject.Digest))
+	g.Expect(parsed.Config.MediaType).To(Equal(MediaTypeEmptyJSON))
+
+	referrers, err := c.Referrers(ctx, "app", subject.Digest, "")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(referrers).To(HaveLen(2))
+
+	referrers, err = c.Referrers(ctx, "app", subject.Digest, "application/spdx+json")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(referrers).To(HaveLen(1))
+	g.Expect(referrers[0].Digest).To(Equal(sbom.Digest))
+
+	layer, err := c.FetchBlob(ctx, "app", parsed.Layers[0].Digest)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(layer)).To(Equal(`{"spdxVersion":"SPDX-2.3"}`))
+}
+
+func TestAttachDryRun(t *testing.T) {
+	g := NewWithT(t)
+	r, c := newTestRegistry(t)
+
+	plan := &dryrun.Plan{}
+	ctx := dryrun.WithContext(context.Background(), plan)
+	subject := Descriptor{MediaType: MediaTypeImageManifest, Digest: Digest([]byte("manifest")), Size: 8}
+	_, err := Attach(ctx, c, "app", subject, "application/spdx+json", []byte(`{}`))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(r.Uploads()).To(BeZero())
+	g.Expect(plan.Changes()).To(Equal([]dryrun.Change{
+		{Action: dryrun.Push, Kind: "Referrer", Target: r.Host() + "/app@" + subject.Digest, Detail: "application/spdx+json"},
+	}))
+}
@@ -0,0 +1,140 @@
+// Package oci assembles Spin applications into OCI images and pushes them to container registries through the
+// OCI distribution API
+package oci
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	MediaTypeImageManifest = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeImageIndex    = "application/vnd.oci.image.index.v1+json"
+	MediaTypeImageConfig   = "application/vnd.oci.image.config.v1+json"
+	MediaTypeImageLayer    = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeDockerV2      = "application/vnd.docker.distribution.manifest.v2+json"
+	// MediaTypeEmptyJSON is the config of artifacts that have none
+	MediaTypeEmptyJSON = "application/vnd.oci.empty.v1+json"
+)
+
+var (
+	NotFoundErr = errors.New("not found")
+
+	// tagRegex is the format of valid tags from the OCI distribution spec
+	tagRegex = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+	// invalidTagChars matches characters that aren't allowed in tags
+	invalidTagChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+)
+
+// Descriptor describes content in a registry
+type Descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest is an OCI image manifest
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Subject       *Descriptor       `json:"subject,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Index is an OCI image index. The referrers API lists referrers as an index.
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// Blob is content and its descriptor
+type Blob struct {
+	Descriptor
+	Content []byte
+}
+
+// NewBlob returns the blob of the content with the media type
+func NewBlob(mediaType string, content []byte) Blob {
+	return Blob{
+		Descriptor: Descriptor{MediaType: mediaType, Digest: Digest(content), Size: int64(len(content))},
+		Content:    content,
+	}
+}
+
+// Digest returns the sha256 digest of the content like sha256:abc...
+func Digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Reference identifies an image like myregistry.azurecr.io/app:v1 or myregistry.azurecr.io/app@sha256:abc...
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference parses an image reference. The registry is required.
+func ParseReference(s string) (Reference, error) {
+	registry, rest, ok := strings.Cut(s, "/")
+	if !ok || registry == "" || rest == "" {
+		return Reference{}, fmt.Errorf("parsing image reference %q: expected registry/repository[:tag][@digest]", s)
+	}
+
+	r := Reference{Registry: registry}
+	if repo, digest, ok := strings.Cut(rest, "@"); ok {
+		rest, r.Digest = repo, digest
+	}
+	if i := strings.LastIndex(rest, ":"); i >= 0 {
+		rest, r.Tag = rest[:i], rest[i+1:]
+	}
+	r.Repository = rest
+
+	if r.Repository == "" || (r.Tag == "" && r.Digest == "") {
+		return Reference{}, fmt.Errorf("parsing image reference %q: expected a repository and a tag or digest", s)
+	}
+
+	return r, nil
+}
+
+// Ref returns the digest if set and the tag otherwise, the way registries address manifests
+func (r Reference) Ref() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+
+	return r.Tag
+}
+
+func (r Reference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+
+	return s
+}
+
+// Tag turns s into a valid tag by replacing invalid characters with dashes. It's empty if s can't be a tag.
+func Tag(s string) string {
+	tag := invalidTagChars.ReplaceAllString(s, "-")
+	if !tagRegex.MatchString(tag) {
+		return ""
+	}
+
+	return tag
+}
@@ -0,0 +1,36 @@
+package oci
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestParseReference(t *testing.T) {
+	g := NewWithT(t)
+
+	r, err := ParseReference("myacr.azurecr.io/team/app:src-1234")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(r).To(Equal(Reference{Registry: "myacr.azurecr.io", Repository: "team/app", Tag: "src-1234"}))
+	g.Expect(r.Ref()).To(Equal("src-1234"))
+	g.Expect(r.String()).To(Equal("myacr.azurecr.io/team/app:src-1234"))
+
+	r, err = ParseReference("localhost:5000/app:v1@sha256:abc")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(r).To(Equal(Reference{Registry: "localhost:5000", Repository: "app", Tag: "v1", Digest: "sha256:abc"}))
+	g.Expect(r.Ref()).To(Equal("sha256:abc"))
+
+	for _, invalid := range []string{"app:v1", "myacr.azurecr.io/app", "myacr.azurecr.io/:v1"} {
+		_, err := ParseReference(invalid)
+		g.Expect(err).To(HaveOccurred(), invalid)
+	}
+}
+
+func TestTag(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(Tag("1.0.0")).To(Equal("1.0.0"))
+	g.Expect(Tag("1.0.0+build.5")).To(Equal("1.0.0-build.5"))
+	g.Expect(Tag("")).To(BeEmpty())
+	g.Expect(Tag("-leading")).To(BeEmpty())
+}
@@ -0,0 +1,245 @@
+package oci
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+)
+
+const (
+	// MediaTypeSimpleSigning is the media type of cosign signature payloads
+	MediaTypeSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// SignatureAnnotation is the layer annotation holding the base64 signature of the payload
+	SignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+	signatureType = "cosign container image signature"
+)
+
+// Signer signs payloads with ECDSA over their SHA-256 digest and returns the ASN.1 DER signature
+type Signer interface {
+	Sign(ctx context.Context, payload []byte) ([]byte, error)
+}
+
+// KeySigner signs with a local private key
+type KeySigner struct {
+	Key *ecdsa.PrivateKey
+}
+
+func (s KeySigner) Sign(_ context.Context, payload []byte) ([]byte, error) {
+	digest := sha256.Sum256(payload)
+	return ecdsa.SignASN1(rand.Reader, s.Key, digest[:])
+}
+
+// LoadPrivateKey reads a PEM encoded, unencrypted ECDSA private key in PKCS #8 or SEC 1 form like
+// `openssl ecparam -genkey -name prime256v1 -noout` creates
+func LoadPrivateKey(path string) (*ecdsa.PrivateKey, error) {
+	block, err := readPem(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, usererror.New(err, fmt.Sprintf("%s isn't an unencrypted ECDSA private key. Encrypted cosign keys aren't supported.", path))
+	}
+
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, usererror.New(errors.New("not an ecdsa key"), fmt.Sprintf("%s isn't an ECDSA private key.", path))
+	}
+
+	return key, nil
+}
+
+// LoadPublicKey reads a PEM encoded ECDSA public key like `cosign public-key` prints
+func LoadPublicKey(path string) (*ecdsa.PublicKey, error) {
+	block, err := readPem(path)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, usererror.New(err, fmt.Sprintf("%s isn't a PEM encoded public key.", path))
+	}
+
+	key, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, usererror.New(errors.New("not an ecdsa key"), fmt.Sprintf("%s isn't an ECDSA public key.", path))
+	}
+
+	return key, nil
+}
+
+func readPem(path string) (*pem.Block, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, usererror.New(err, fmt.Sprintf("Key file %s doesn't exist.", path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading key file: %w", err)
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, usererror.New(errors.New("no pem block"), fmt.Sprintf("%s isn't a PEM encoded key.", path))
+	}
+
+	return block, nil
+}
+
+// SignatureTag returns the tag cosign stores the signatures of the manifest digest under like sha256-abc.sig
+func SignatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+// simpleSigning is the payload cosign signs. It binds the signature to the repository and the manifest digest.
+type simpleSigning struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]string `json:"optional"`
+}
+
+// Sign signs the manifest digest in the repository and pushes the signature the way `cosign sign` does so it can be
+// checked with `cosign verify --key`. Any existing signature of the digest is replaced.
+func Sign(ctx context.Context, c *Client, repo, digest string, s Signer) error {
+	lgr := logger.FromContext(ctx).With("repository", repo, "digest", digest)
+	lgr.Debug("signing image")
+
+	tag := SignatureTag(digest)
+	if p, ok := dryrun.FromContext(ctx); ok {
+		p.Record(dryrun.Change{Action: dryrun.Push, Kind: "Signature", Target: c.Registry() + "/" + repo + ":" + tag, Detail: digest})
+		return nil
+	}
+
+	var payload simpleSigning
+	payload.Critical.Identity.DockerReference = c.Registry() + "/" + repo
+	payload.Critical.Image.DockerManifestDigest = digest
+	payload.Critical.Type = signatureType
+	content, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshalling signature payload: %w", err)
+	}
+
+	sig, err := s.Sign(ctx, content)
+	if err != nil {
+		return fmt.Errorf("signing payload: %w", err)
+	}
+
+	layer := NewBlob(MediaTypeSimpleSigning, content)
+	layer.Annotations = map[string]string{SignatureAnnotation: base64.StdEncoding.EncodeToString(sig)}
+
+	config := imageConfig{}
+	config.RootFS.Type = "layers"
+	config.RootFS.DiffIDs = []string{layer.Digest}
+	img, err := newImage(MediaTypeImageConfig, config, []Blob{layer}, nil)
+	if err != nil {
+		return fmt.Errorf("building signature: %w", err)
+	}
+
+	if _, err := Push(ctx, c, repo, img, tag); err != nil {
+		return fmt.Errorf("pushing signature: %w", err)
+	}
+
+	lgr.Debug("finished signing image")
+	return nil
+}
+
+// Verify checks that the manifest digest in the repository has a signature of the public key's private key for that
+// repository and digest
+func Verify(ctx context.Context, c *Client, repo, digest string, pub *ecdsa.PublicKey) error {
+	lgr := logger.FromContext(ctx).With("repository", repo, "digest", digest)
+	lgr.Debug("verifying image signature")
+
+	image := c.Registry() + "/" + repo + "@" + digest
+	_, content, err := c.FetchManifest(ctx, repo, SignatureTag(digest))
+	if errors.Is(err, NotFoundErr) {
+		return usererror.New(err, fmt.Sprintf("%s isn't signed. Configure a signing key and push it again.", image))
+	}
+	if err != nil {
+		return fmt.Errorf("fetching signature: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return fmt.Errorf("decoding signature manifest: %w", err)
+	}
+
+	for _, l := range manifest.Layers {
+		if l.MediaType != MediaTypeSimpleSigning {
+			continue
+		}
+
+		if err := verifyLayer(ctx, c, repo, digest, l, pub); err != nil {
+			lgr.Debug("signature doesn't match", "layer", l.Digest, "error", err)
+			continue
+		}
+
+		lgr.Debug("finished verifying image signature")
+		return nil
+	}
+
+	err = errors.New("no matching signature")
+	return usererror.New(err, fmt.Sprintf("%s isn't signed by the configured key. Push it again with that key to sign it.", image))
+}
+
+// verifyLayer checks the signature of the layer's payload and that the payload is for the repository and digest
+func verifyLayer(ctx context.Context, c *Client, repo, digest string, l Descriptor, pub *ecdsa.PublicKey) error {
+	sig, err := base64.StdEncoding.DecodeString(l.Annotations[SignatureAnnotation])
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+
+	content, err := c.FetchBlob(ctx, repo, l.Digest)
+	if err != nil {
+		return fmt.Errorf("fetching payload: %w", err)
+	}
+	if Digest(content) != l.Digest {
+		return errors.New("payload doesn't match its digest")
+	}
+
+	hash := sha256.Sum256(content)
+	if !ecdsa.VerifyASN1(pub, hash[:], sig) {
+		return errors.New("invalid signature")
+	}
+
+	var payload simpleSigning
+	if err := json.Unmarshal(content, &payload); err != nil {
+		return fmt.Errorf("decoding payload: %w", err)
+	}
+
+	switch {
+	case payload.Critical.Type != signatureType:
+		return fmt.Errorf("unexpected signature type %q", payload.Critical.Type)
+	case payload.Critical.Image.DockerManifestDigest != digest:
+		return fmt.Errorf("signature is for digest %s", payload.Critical.Image.DockerManifestDigest)
+	case payload.Critical.Identity.DockerReference != c.Registry()+"/"+repo:
+		return fmt.Errorf("signature is for %s", payload.Critical.Identity.DockerReference)
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package oci
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+	. "github.com/onsi/gomega"
+)
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+
+	return key
+}
+
+// pushTestImage pushes the image of writeApp and returns its digest
+func pushTestImage(t *testing.T, c *Client, repo string) string {
+	t.Helper()
+
+	manifest, m := writeApp(t)
+	img, err := BuildImage(manifest, m)
+	if err != nil {
+		t.Fatalf("building image: %s", err)
+	}
+
+	desc, err := Push(context.Background(), c, repo, img, "v1")
+	if err != nil {
+		t.Fatalf("pushing image: %s", err)
+	}
+
+	return desc.Digest
+}
+
+func TestSignVerify(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	r, c := newTestRegistry(t)
+
+	digest := pushTestImage(t, c, "app")
+	key := newKey(t)
+	g.Expect(Sign(ctx, c, "app", digest, KeySigner{Key: key})).To(Succeed())
+
+	content, ok := r.Manifest("app", SignatureTag(digest))
+	g.Expect(ok).To(BeTrue())
+	var manifest Manifest
+	g.Expect(json.Unmarshal(content, &manifest)).To(Succeed())
+	g.Expect(manifest.Layers).To(HaveLen(1))
+	g.Expect(manifest.Layers[0].MediaType).To(Equal(MediaTypeSimpleSigning))
+	g.Expect(manifest.Layers[0].Annotations).To(HaveKey(SignatureAnnotation))
+
+	g.Expect(Verify(ctx, c, "app", digest, &key.PublicKey)).To(Succeed())
+
+	err := Verify(ctx, c, "app", digest, &newKey(t).PublicKey)
+	u, ok := usererror.Is(err)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(u.Msg()).To(ContainSubstring("isn't signed by the configured key"))
+}
+
+func TestVerifyUnsigned(t *testing.T) {
+	g := NewWithT(t)
+	_, c := newTestRegistry(t)
+
+	digest := pushTestImage(t, c, "app")
+	err := Verify(context.Background(), c, "app", digest, &newKey(t).PublicKey)
+	u, ok := usererror.Is(err)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(u.Msg()).To(ContainSubstring("isn't signed"))
+}
+
+func TestVerifyMismatched(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	r, c := newTestRegistry(t)
+
+	key := newKey(t)
+	signed := pushTestImage(t, c, "app")
+	g.Expect(Sign(ctx, c, "app", signed, KeySigner{Key: key})).To(Succeed())
+
+	// a valid signature of one image copied to another image's signature tag doesn't verify the other image
+	other := "sha256:" + "0000000000000000000000000000000000000000000000000000000000000000"
+	content, _ := r.Manifest("app", SignatureTag(signed))
+	_, err := c.PushManifest(ctx, "app", SignatureTag(other), MediaTypeImageManifest, content)
+	g.Expect(err).NotTo(HaveOccurred())
+	_, ok := usererror.Is(Verify(ctx, c, "app", other, &key.PublicKey))
+	g.Expect(ok).To(BeTrue())
+
+	// a valid signature copied to another repository doesn't verify the image there
+	_, err = c.PushManifest(ctx, "other", SignatureTag(signed), MediaTypeImageManifest, content)
+	g.Expect(err).NotTo(HaveOccurred())
+	_, ok = usererror.Is(Verify(ctx, c, "other", signed, &key.PublicKey))
+	g.Expect(ok).To(BeTrue())
+}
+
+func TestSignDryRun(t *testing.T) {
+	g := NewWithT(t)
+	r, c := newTestRegistry(t)
+
+	digest := pushTestImage(t, c, "app")
+	uploads := r.Uploads()
+
+	plan := &dryrun.Plan{}
+	ctx := dryrun.WithContext(context.Background(), plan)
+	g.Expect(Sign(ctx, c, "app", digest, KeySigner{Key: newKey(t)})).To(Succeed())
+	g.Expect(r.Uploads()).To(Equal(uploads))
+	g.Expect(plan.Changes()).To(Equal([]dryrun.Change{
+		{Action: dryrun.Push, Kind: "Signature", Target: r.Host() + "/app:" + SignatureTag(digest), Detail: digest},
+	}))
+}
+
+func TestLoadKeys(t *testing.T) {
+	g := NewWithT(t)
+	dir := t.TempDir()
+	key := newKey(t)
+
+	write := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		g.Expect(os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)).To(Succeed())
+		return path
+	}
+
+	sec1, err := x509.MarshalECPrivateKey(key)
+	g.Expect(err).NotTo(HaveOccurred())
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	g.Expect(err).NotTo(HaveOccurred())
+	pkix, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	for _, path := range []string{write("sec1.pem", "EC PRIVATE KEY", sec1), write("pkcs8.pem", "PRIVATE KEY", pkcs8)} {
+		loaded, err := LoadPrivateKey(path)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(loaded.Equal(key)).To(BeTrue(), path)
+	}
+
+	pub, err := LoadPublicKey(write("pub.pem", "PUBLIC KEY", pkix))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(pub.Equal(&key.PublicKey)).To(BeTrue())
+
+	_, err = LoadPrivateKey(write("encrypted.pem", "ENCRYPTED SIGSTORE PRIVATE KEY", []byte("encrypted")))
+	_, ok := usererror.Is(err)
+	g.Expect(ok).To(BeTrue())
+
+	_, err = LoadPrivateKey(filepath.Join(dir, "missing.pem"))
+	_, ok = usererror.Is(err)
+	g.Expect(ok).To(BeTrue())
+}
@@ -0,0 +1,230 @@
+package oci
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/azure/spin-aks-plugin/pkg/spin"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+)
+
+// Format is how an application is packaged in a registry
+type Format string
+
+const (
+	// FormatImage is a container image with the spin manifest and wasm files that containerd-shim-spin runs
+	FormatImage Format = "image"
+	// FormatSpinOCI is the Spin OCI artifact `spin registry push` pushes
+	FormatSpinOCI Format = "spin-oci"
+)
+
+// Formats are the valid formats
+var Formats = []Format{FormatImage, FormatSpinOCI}
+
+// Build assembles the application in the format
+func (f Format) Build(manifestPath string, m spin.Manifest) (Image, error) {
+	switch f {
+	case FormatImage:
+		return BuildImage(manifestPath, m)
+	case FormatSpinOCI:
+		return BuildSpinApp(manifestPath, m)
+	default:
+		return Image{}, fmt.Errorf("unknown format %q", f)
+	}
+}
+
+const (
+	MediaTypeSpinConfig = "application/vnd.fermyon.spin.application.v1+config"
+	MediaTypeWasmLayer  = "application/vnd.wasm.content.layer.v1+wasm"
+	MediaTypeDataLayer  = "application/vnd.wasm.content.layer.v1+data"
+)
+
+// LockedApp is the config of a Spin OCI artifact. It's the spin manifest with every wasm and static file replaced by
+// the digest of the layer that has its content.
+type LockedApp struct {
+	SpinLockVersion int                       `json:"spin_lock_version"`
+	Metadata        LockedMetadata            `json:"metadata"`
+	Triggers        []LockedTrigger           `json:"triggers"`
+	Variables       map[string]LockedVariable `json:"variables,omitempty"`
+	Components      []LockedComponent         `json:"components"`
+}
+
+// LockedMetadata is the application's metadata from the spin manifest
+type LockedMetadata struct {
+	Name        string           `json:"name"`
+	Version     string           `json:"version,omitempty"`
+	Description string           `json:"description,omitempty"`
+	Authors     []string         `json:"authors,omitempty"`
+	Trigger     LockedAppTrigger `json:"trigger"`
+}
+
+// LockedAppTrigger is the application's trigger type and its settings
+type LockedAppTrigger struct {
+	Type string `json:"type"`
+	Base string `json:"base,omitempty"`
+}
+
+// LockedTrigger routes a trigger to a component
+type LockedTrigger struct {
+	Id            string         `json:"id"`
+	TriggerType   string         `json:"trigger_type"`
+	TriggerConfig map[string]any `json:"trigger_config"`
+}
+
+// LockedVariable is an application variable. Required variables have no default.
+type LockedVariable struct {
+	Default *string `json:"default,omitempty"`
+	Secret  bool    `json:"secret"`
+}
+
+// LockedComponent is a component with its wasm and files replaced by layer digests
+type LockedComponent struct {
+	Id       string            `json:"id"`
+	Metadata map[string]any    `json:"metadata,omitempty"`
+	Source   LockedSource      `json:"source"`
+	Env      map[string]string `json:"env,omitempty"`
+	Files    []LockedFile      `json:"files,omitempty"`
+	Config   map[string]string `json:"config,omitempty"`
+}
+
+// LockedSource is the wasm of a component
+type LockedSource struct {
+	ContentType string        `json:"content_type"`
+	Content     LockedContent `json:"content"`
+}
+
+// LockedContent points at the layer with the content
+type LockedContent struct {
+	Digest string `json:"digest"`
+}
+
+// LockedFile is a static file mounted at the path
+type LockedFile struct {
+	Content LockedContent `json:"content"`
+	Path    string        `json:"path"`
+}
+
+// BuildSpinApp assembles the Spin OCI artifact of the application. Each distinct wasm and static file is a layer and
+// the config is the locked application pointing at them. Like BuildImage it's reproducible.
+func BuildSpinApp(manifestPath string, m spin.Manifest) (Image, error) {
+	manifestDir := filepath.Dir(manifestPath)
+
+	var layers []Blob
+	seen := map[string]bool{}
+	addLayer := func(mediaType, path string) (string, error) {
+		content, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return "", usererror.New(err, fmt.Sprintf("%s doesn't exist. Run `spin aks build` first.", path))
+		}
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", path, err)
+		}
+
+		b := NewBlob(mediaType, content)
+		if !seen[b.Digest] {
+			seen[b.Digest] = true
+			layers = append(layers, b)
+		}
+
+		return b.Digest, nil
+	}
+
+	app := LockedApp{
+		Metadata: LockedMetadata{
+			Name:        m.Name,
+			Version:     m.Version,
+			Description: m.Description,
+			Authors:     m.Authors,
+			Trigger:     LockedAppTrigger{Type: m.Trigger.T, Base: m.Trigger.Base},
+		},
+		Triggers:   []LockedTrigger{},
+		Components: []LockedComponent{},
+	}
+
+	if len(m.Variables) > 0 {
+		app.Variables = map[string]LockedVariable{}
+	}
+	for name, v := range m.Variables {
+		lv := LockedVariable{Secret: v.Secret}
+		if !v.Required {
+			def := v.Def
+			lv.Default = &def
+		}
+		app.Variables[name] = lv
+	}
+
+	for _, c := range m.Components {
+		if c.Source.URLSource.Url != "" {
+			return Image{}, usererror.New(
+				errors.New("component source is a URL"),
+				fmt.Sprintf("Component source %s is a URL which isn't currently supported.", c.Id),
+			)
+		}
+
+		digest, err := addLayer(MediaTypeWasmLayer, filepath.Join(manifestDir, string(c.Source.StringSource)))
+		if err != nil {
+			return Image{}, fmt.Errorf("adding wasm of component %s: %w", c.Id, err)
+		}
+
+		lc := LockedComponent{
+			Id:     c.Id,
+			Source: LockedSource{ContentType: "application/wasm", Content: LockedContent{Digest: digest}},
+			Env:    c.Environment,
+			Config: c.Config,
+		}
+
+		metadata := map[string]any{}
+		if len(c.AllowedHttpHosts) > 0 {
+			metadata["allowed_http_hosts"] = c.AllowedHttpHosts
+		}
+		if len(c.KeyValueStores) > 0 {
+			metadata["key_value_stores"] = c.KeyValueStores
+		}
+		if len(metadata) > 0 {
+			lc.Metadata = metadata
+		}
+
+		files, err := spin.ResolveFiles(manifestDir, c)
+		if err != nil {
+			return Image{}, fmt.Errorf("resolving files of component %s: %w", c.Id, err)
+		}
+		for _, f := range files {
+			digest, err := addLayer(MediaTypeDataLayer, f.Source)
+			if err != nil {
+				return Image{}, fmt.Errorf("adding file of component %s: %w", c.Id, err)
+			}
+
+			lc.Files = append(lc.Files, LockedFile{Content: LockedContent{Digest: digest}, Path: f.Path})
+		}
+
+		app.Components = append(app.Components, lc)
+		app.Triggers = append(app.Triggers, lockedTrigger(m.Trigger.T, c))
+	}
+
+	return newImage(MediaTypeSpinConfig, app, layers, nil)
+}
+
+// lockedTrigger returns the trigger of the component with the settings of the trigger type
+func lockedTrigger(triggerType string, c spin.Component) LockedTrigger {
+	config := map[string]any{"component": c.Id}
+	switch triggerType {
+	case "http":
+		config["route"] = c.Trigger.Route
+		if c.Trigger.Executor.T != "" {
+			executor := map[string]any{"type": c.Trigger.Executor.T}
+			if c.Trigger.Executor.Argv != "" {
+				executor["argv"] = c.Trigger.Executor.Argv
+			}
+			if c.Trigger.Executor.Entrypoint != "" {
+				executor["entrypoint"] = c.Trigger.Executor.Entrypoint
+			}
+			config["executor"] = executor
+		}
+	case "redis":
+		config["channel"] = c.Trigger.Channel
+	}
+
+	return LockedTrigger{Id: "trigger--" + c.Id, TriggerType: triggerType, TriggerConfig: config}
+}
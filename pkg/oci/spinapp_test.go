@@ -0,0 +1,163 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azure/spin-aks-plugin/pkg/spin"
+	. "github.com/onsi/gomega"
+)
+
+const spinAppManifest = `
+spin_manifest_version = "1"
+name = "app"
+version = "1.0.0"
+trigger = { type = "http", base = "/" }
+
+[variables]
+greeting = { default = "hello" }
+password = { required = true, secret = true }
+
+[[component]]
+id = "hello"
+source = "target/hello.wasm"
+files = ["static/*", { source = "assets", destination = "/img" }]
+allowed_http_hosts = ["example.com"]
+[component.trigger]
+route = "/hello"
+[component.config]
+greeting = "{{ greeting }}"
+
+[[component]]
+id = "wagi"
+source = "wagi.wasm"
+[component.trigger]
+route = "/wagi"
+executor = { type = "wagi", entrypoint = "main" }
+`
+
+// writeSpinApp writes a spin manifest with static files and loads it
+func writeSpinApp(t *testing.T) (string, spin.Manifest) {
+	t.Helper()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"spin.toml":         spinAppManifest,
+		"target/hello.wasm": "hello wasm",
+		"wagi.wasm":         "wagi wasm",
+		"static/index.html": "index",
+		"assets/logo.png":   "logo",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("creating directory: %s", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("writing %s: %s", name, err)
+		}
+	}
+
+	manifest := filepath.Join(dir, "spin.toml")
+	m, err := spin.Load(manifest)
+	if err != nil {
+		t.Fatalf("loading spin manifest: %s", err)
+	}
+
+	return manifest, m
+}
+
+func TestBuildSpinApp(t *testing.T) {
+	g := NewWithT(t)
+
+	manifest, m := writeSpinApp(t)
+	img, err := FormatSpinOCI.Build(manifest, m)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	again, err := BuildSpinApp(manifest, m)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(again.Manifest.Digest).To(Equal(img.Manifest.Digest), "artifacts are reproducible")
+
+	g.Expect(img.Config.MediaType).To(Equal(MediaTypeSpinConfig))
+	digests := map[string]string{}
+	for _, l := range img.Layers {
+		digests[string(l.Content)] = l.Digest
+	}
+	g.Expect(img.Layers).To(HaveLen(4))
+	for _, l := range img.Layers {
+		expected := MediaTypeDataLayer
+		if string(l.Content) == "hello wasm" || string(l.Content) == "wagi wasm" {
+			expected = MediaTypeWasmLayer
+		}
+		g.Expect(l.MediaType).To(Equal(expected), string(l.Content))
+	}
+
+	var app LockedApp
+	g.Expect(json.Unmarshal(img.Config.Content, &app)).To(Succeed())
+	g.Expect(app.Metadata.Name).To(Equal("app"))
+	g.Expect(app.Metadata.Trigger).To(Equal(LockedAppTrigger{Type: "http", Base: "/"}))
+
+	greeting := "hello"
+	g.Expect(app.Variables).To(Equal(map[string]LockedVariable{
+		"greeting": {Default: &greeting},
+		"password": {Secret: true},
+	}))
+
+	g.Expect(app.Components).To(HaveLen(2))
+	hello := app.Components[0]
+	g.Expect(hello.Source).To(Equal(LockedSource{ContentType: "application/wasm", Content: LockedContent{Digest: digests["hello wasm"]}}))
+	g.Expect(hello.Files).To(Equal([]LockedFile{
+		{Content: LockedContent{Digest: digests["logo"]}, Path: "/img/logo.png"},
+		{Content: LockedContent{Digest: digests["index"]}, Path: "/static/index.html"},
+	}))
+	g.Expect(hello.Config).To(Equal(map[string]string{"greeting": "{{ greeting }}"}))
+	g.Expect(hello.Metadata).To(HaveKeyWithValue("allowed_http_hosts", []any{"example.com"}))
+
+	g.Expect(app.Triggers).To(Equal([]LockedTrigger{
+		{Id: "trigger--hello", TriggerType: "http", TriggerConfig: map[string]any{"component": "hello", "route": "/hello"}},
+		{Id: "trigger--wagi", TriggerType: "http", TriggerConfig: map[string]any{
+			"component": "wagi",
+			"route":     "/wagi",
+			"executor":  map[string]any{"type": "wagi", "entrypoint": "main"},
+		}},
+	}))
+}
+
+func TestSpinAppRoundTrip(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	_, c := newTestRegistry(t)
+
+	manifest, m := writeSpinApp(t)
+	img, err := BuildSpinApp(manifest, m)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = Push(ctx, c, "app", img, "src-1234-spin")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	pulled, err := Pull(ctx, c, "app", "src-1234-spin")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(pulled.Manifest.Digest).To(Equal(img.Manifest.Digest))
+	g.Expect(pulled.Manifest.MediaType).To(Equal(MediaTypeImageManifest))
+	g.Expect(pulled.Config).To(Equal(img.Config))
+	g.Expect(pulled.Layers).To(Equal(img.Layers))
+
+	var app LockedApp
+	g.Expect(json.Unmarshal(pulled.Config.Content, &app)).To(Succeed())
+	for _, comp := range app.Components {
+		layers := []string{comp.Source.Content.Digest}
+		for _, f := range comp.Files {
+			layers = append(layers, f.Content.Digest)
+		}
+		for _, digest := range layers {
+			found := false
+			for _, l := range pulled.Layers {
+				found = found || l.Digest == digest
+			}
+			g.Expect(found).To(BeTrue(), "layer %s of component %s", digest, comp.Id)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package prompt
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	no  = "No"
+	yes = "Yes"
+)
+
+// Confirm asks a yes or no question defaulting to no
+func Confirm(ctx context.Context, label string) (bool, error) {
+	answer, err := Select(ctx, label, []string{no, yes}, nil)
+	if err != nil {
+		return false, fmt.Errorf("confirming: %w", err)
+	}
+
+	return answer == yes, nil
+}
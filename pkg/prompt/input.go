@@ -1,11 +1,10 @@
 package prompt
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
-
-	"github.com/manifoldco/promptui"
 )
 
 type InputOpt struct {
@@ -13,16 +12,14 @@ type InputOpt struct {
 	Validate func(string) error
 }
 
-func Input(label string, opt *InputOpt) (string, error) {
-	p := promptui.Prompt{
-		Label:    label,
-		Validate: opt.Validate,
-		Default:  opt.Default,
+func Input(ctx context.Context, label string, opt *InputOpt) (string, error) {
+	if opt == nil {
+		opt = &InputOpt{}
 	}
 
-	ret, err := p.Run()
+	ret, err := FromContext(ctx).Input(label, opt.Default, opt.Validate)
 	if err != nil {
-		return "", fmt.Errorf("running input: %w", err)
+		return "", fmt.Errorf("inputting: %w", err)
 	}
 
 	return ret, nil
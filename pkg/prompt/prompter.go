@@ -0,0 +1,79 @@
+package prompt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+)
+
+var prompterKey = ctxKey{}
+
+// ctxKey is used to store the prompter in the ctx.
+// Using a new type avoids collisions.
+type ctxKey struct{}
+
+// Prompter asks the user for input. Select and Input use the Prompter from the context which is an
+// interactive terminal prompter unless another is set with WithPrompter.
+type Prompter interface {
+	// Select asks the user to choose one of items and returns the index of the choice. def is the index
+	// of the default choice.
+	Select(label string, items []string, def int) (int, error)
+	// Input asks the user for text. def is the default text and validate, if not nil, validates the text.
+	Input(label, def string, validate func(string) error) (string, error)
+}
+
+// WithPrompter sets the prompter as the prompter for the context
+func WithPrompter(ctx context.Context, p Prompter) context.Context {
+	return context.WithValue(ctx, prompterKey, p)
+}
+
+// FromContext returns the prompter from the context or the interactive terminal prompter if none is set
+func FromContext(ctx context.Context) Prompter {
+	if p, ok := ctx.Value(prompterKey).(Prompter); ok && p != nil {
+		return p
+	}
+
+	return terminal{}
+}
+
+// terminal prompts through promptui which requires a TTY
+type terminal struct{}
+
+func (terminal) Select(label string, items []string, def int) (int, error) {
+	searcher := func(search string, i int) bool {
+		selection := strings.ToLower(items[i])
+		search = strings.ToLower(search)
+
+		return strings.Contains(selection, search)
+	}
+
+	p := promptui.Select{
+		Label:    label,
+		Items:    items,
+		Searcher: searcher,
+	}
+
+	i, _, err := p.RunCursorAt(def, def)
+	if err != nil {
+		return 0, fmt.Errorf("running select: %w", err)
+	}
+
+	return i, nil
+}
+
+func (terminal) Input(label, def string, validate func(string) error) (string, error) {
+	p := promptui.Prompt{
+		Label:    label,
+		Validate: validate,
+		Default:  def,
+	}
+
+	ret, err := p.Run()
+	if err != nil {
+		return "", fmt.Errorf("running input: %w", err)
+	}
+
+	return ret, nil
+}
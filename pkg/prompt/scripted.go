@@ -0,0 +1,147 @@
+package prompt
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Answer is a scripted answer to a prompt
+type Answer struct {
+	// Label is the label of the prompt being answered
+	Label string `json:"label"`
+	// Value is the text to input or, for selects, the name of the item to choose
+	Value string `json:"value,omitempty"`
+	// Default accepts the default of the prompt instead of using Value
+	Default bool `json:"default,omitempty"`
+}
+
+// answersFile is the format of an answers file
+type answersFile struct {
+	Answers []Answer `json:"answers"`
+}
+
+// Scripted is a Prompter that answers prompts from a script instead of asking the user. Each prompt
+// consumes the first unused answer with a matching label so prompts asked multiple times are answered
+// in order. A prompt without an answer is an error so scripts fail loudly when the prompts change.
+type Scripted struct {
+	mu      sync.Mutex
+	answers []Answer
+	used    []bool
+	asked   []string
+}
+
+var _ Prompter = &Scripted{}
+
+// NewScripted returns a Prompter that answers with the answers
+func NewScripted(answers ...Answer) *Scripted {
+	return &Scripted{
+		answers: answers,
+		used:    make([]bool, len(answers)),
+	}
+}
+
+// LoadAnswers returns a Prompter that answers with the answers in the YAML or JSON answers file at path.
+// The file is a list of answers like
+//
+//	answers:
+//	  - label: Select your Cluster's Subscription
+//	    value: My Subscription
+//	  - label: Input your spin manifest location
+//	    default: true
+func LoadAnswers(path string) (*Scripted, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading answers file: %w", err)
+	}
+
+	var f answersFile
+	if err := yaml.UnmarshalStrict(b, &f); err != nil {
+		return nil, fmt.Errorf("decoding answers file %s: %w", path, err)
+	}
+
+	return NewScripted(f.Answers...), nil
+}
+
+// Asked returns the labels of every prompt asked so far in order
+func (s *Scripted) Asked() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ret := make([]string, len(s.asked))
+	copy(ret, s.asked)
+	return ret
+}
+
+// Unused returns the answers that haven't answered a prompt
+func (s *Scripted) Unused() []Answer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ret []Answer
+	for i, a := range s.answers {
+		if !s.used[i] {
+			ret = append(ret, a)
+		}
+	}
+
+	return ret
+}
+
+func (s *Scripted) Select(label string, items []string, def int) (int, error) {
+	a, err := s.next(label)
+	if err != nil {
+		return 0, err
+	}
+
+	if a.Default {
+		return def, nil
+	}
+
+	for i, item := range items {
+		if item == a.Value {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("scripted answer %q for prompt %q is not one of the options: %s", a.Value, label, strings.Join(items, ", "))
+}
+
+func (s *Scripted) Input(label, def string, validate func(string) error) (string, error) {
+	a, err := s.next(label)
+	if err != nil {
+		return "", err
+	}
+
+	value := a.Value
+	if a.Default {
+		value = def
+	}
+
+	if validate != nil {
+		if err := validate(value); err != nil {
+			return "", fmt.Errorf("scripted answer %q for prompt %q is invalid: %w", value, label, err)
+		}
+	}
+
+	return value, nil
+}
+
+// next returns the first unused answer for the label and marks it as used
+func (s *Scripted) next(label string) (Answer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.asked = append(s.asked, label)
+	for i, a := range s.answers {
+		if !s.used[i] && a.Label == label {
+			s.used[i] = true
+			return a, nil
+		}
+	}
+
+	return Answer{}, fmt.Errorf("no scripted answer for prompt %q", label)
+}
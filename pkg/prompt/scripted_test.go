@@ -0,0 +1,66 @@
+package prompt
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestScripted(t *testing.T) {
+	g := NewWithT(t)
+
+	path := filepath.Join(t.TempDir(), "answers.yaml")
+	g.Expect(os.WriteFile(path, []byte(`
+answers:
+  - label: Pick a color
+    value: blue
+  - label: Name
+    default: true
+  - label: Pick a color
+    value: red
+`), 0644)).To(Succeed())
+
+	s, err := LoadAnswers(path)
+	g.Expect(err).ToNot(HaveOccurred())
+	ctx := WithPrompter(context.Background(), s)
+
+	colors := []string{"red", "green", "blue"}
+	color, err := Select(ctx, "Pick a color", colors, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(color).To(Equal("blue"))
+
+	name, err := Input(ctx, "Name", &InputOpt{Default: "spin"})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(name).To(Equal("spin"))
+
+	color, err = Select(ctx, "Pick a color", colors, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(color).To(Equal("red"))
+
+	_, err = Select(ctx, "Pick a color", colors, nil)
+	g.Expect(err).To(MatchError(ContainSubstring(`no scripted answer for prompt "Pick a color"`)))
+	g.Expect(s.Asked()).To(Equal([]string{"Pick a color", "Name", "Pick a color", "Pick a color"}))
+}
+
+func TestScriptedInvalid(t *testing.T) {
+	g := NewWithT(t)
+	ctx := WithPrompter(context.Background(), NewScripted(
+		Answer{Label: "Pick a color", Value: "purple"},
+		Answer{Label: "Name", Value: ""},
+	))
+
+	_, err := Select(ctx, "Pick a color", []string{"red"}, nil)
+	g.Expect(err).To(MatchError(ContainSubstring("is not one of the options: red")))
+
+	_, err = Input(ctx, "Name", &InputOpt{Validate: func(s string) error {
+		if s == "" {
+			return errors.New("must not be empty")
+		}
+		return nil
+	}})
+	g.Expect(err).To(MatchError(ContainSubstring("must not be empty")))
+}
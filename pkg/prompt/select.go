@@ -1,11 +1,9 @@
 package prompt
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"strings"
-
-	"github.com/manifoldco/promptui"
 )
 
 type SelectOpt[T any] struct {
@@ -15,7 +13,7 @@ type SelectOpt[T any] struct {
 	Default string
 }
 
-func Select[T any](label string, items []T, opt *SelectOpt[T]) (T, error) {
+func Select[T any](ctx context.Context, label string, items []T, opt *SelectOpt[T]) (T, error) {
 	selections := make([]interface{}, len(items))
 	for i, item := range items {
 		selections[i] = item
@@ -31,39 +29,30 @@ func Select[T any](label string, items []T, opt *SelectOpt[T]) (T, error) {
 		return *new(T), errors.New("no selection options")
 	}
 
-	if _, ok := selections[0].(string); !ok {
-		return *new(T), errors.New("selections must be of type string or use opt.Field")
-	}
-
-	searcher := func(search string, i int) bool {
-		str, _ := selections[i].(string) // no need to check if okay, we guard earlier
-
-		selection := strings.ToLower(str)
-		search = strings.ToLower(search)
+	names := make([]string, len(selections))
+	for i, selection := range selections {
+		name, ok := selection.(string)
+		if !ok {
+			return *new(T), errors.New("selections must be of type string or use opt.Field")
+		}
 
-		return strings.Contains(selection, search)
+		names[i] = name
 	}
 
 	// find the default selection if exists
 	pos := 0
 	if opt != nil && opt.Default != "" {
-		for i, selection := range selections {
-			if opt.Default == selection {
+		for i, name := range names {
+			if opt.Default == name {
 				pos = i
 				break
 			}
 		}
 	}
 
-	p := promptui.Select{
-		Label:    label,
-		Items:    selections,
-		Searcher: searcher,
-	}
-
-	i, _, err := p.RunCursorAt(pos, pos)
+	i, err := FromContext(ctx).Select(label, names, pos)
 	if err != nil {
-		return *new(T), fmt.Errorf("running select: %w", err)
+		return *new(T), fmt.Errorf("selecting: %w", err)
 	}
 
 	return items[i], nil
@@ -0,0 +1,245 @@
+// Package release records the manifests of every deploy on the cluster so previous deploys can be listed and
+// reapplied without the source they were built from
+package release
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/azure/spin-aks-plugin/pkg/dryrun"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// MaxHistory is the number of releases kept per application. Older releases are deleted.
+	MaxHistory = 10
+
+	appLabel          = "spin-aks-plugin/release-of"
+	revisionLabel     = "spin-aks-plugin/revision"
+	imageKey          = "spin-aks-plugin/image"
+	configHashKey     = "spin-aks-plugin/config-hash"
+	spinVersionKey    = "spin-aks-plugin/spin-version"
+	timeKey           = "spin-aks-plugin/time"
+	userKey           = "spin-aks-plugin/user"
+	rollbackOfKey     = "spin-aks-plugin/rollback-of"
+	manifestsDataKey  = "manifests.yaml"
+	managedByLabel    = "app.kubernetes.io/managed-by"
+	managedByLabelVal = "spin-aks-plugin"
+)
+
+// NotFoundErr is returned when a release doesn't exist
+var NotFoundErr = errors.New("release not found")
+
+// Release is a deploy of an application. Releases are stored as ConfigMaps in the application's namespace, which
+// the generated manifests name after the application.
+type Release struct {
+	Revision int `json:"revision"`
+	// Image is the image the release runs
+	Image string `json:"image"`
+	// ConfigHash is the sha256 of the rendered manifests
+	ConfigHash string `json:"configHash"`
+	// SpinVersion is the version from the spin manifest
+	SpinVersion string    `json:"spinVersion"`
+	Time        time.Time `json:"time"`
+	// User is the object id of the principal that deployed
+	User string `json:"user"`
+	// RollbackOf is the revision the release rolled back to
+	RollbackOf int    `json:"rollbackOf,omitempty"`
+	Manifests  []byte `json:"-"`
+}
+
+// Hash returns the config hash of rendered manifests
+func Hash(manifests []byte) string {
+	sum := sha256.Sum256(manifests)
+	return hex.EncodeToString(sum[:])
+}
+
+// Record stores the release as the next revision and deletes releases beyond MaxHistory
+func Record(ctx context.Context, kube kubernetes.Interface, app string, r Release) (*Release, error) {
+	lgr := logger.FromContext(ctx).With("app", app)
+	lgr.Debug("recording release")
+
+	history, err := History(ctx, kube, app)
+	if err != nil {
+		return nil, fmt.Errorf("getting history: %w", err)
+	}
+
+	r.Revision = 1
+	if len(history) > 0 {
+		r.Revision = history[len(history)-1].Revision + 1
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+	if r.ConfigHash == "" {
+		r.ConfigHash = Hash(r.Manifests)
+	}
+
+	if plan, ok := dryrun.FromContext(ctx); ok {
+		plan.Record(dryrun.Change{Action: dryrun.Create, Kind: "ConfigMap", Target: app + "/" + configMapName(app, r.Revision), Detail: "release " + strconv.Itoa(r.Revision)})
+		return &r, nil
+	}
+
+	if _, err := kube.CoreV1().ConfigMaps(app).Create(ctx, toConfigMap(app, r), metav1.CreateOptions{}); err != nil {
+		return nil, fmt.Errorf("creating release config map: %w", err)
+	}
+
+	history = append(history, r)
+	for len(history) > MaxHistory {
+		old := history[0]
+		history = history[1:]
+
+		lgr.Debug("deleting old release", "revision", old.Revision)
+		err := kube.CoreV1().ConfigMaps(app).Delete(ctx, configMapName(app, old.Revision), metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("deleting release %d: %w", old.Revision, err)
+		}
+	}
+
+	lgr.Debug("finished recording release", "revision", r.Revision)
+	return &r, nil
+}
+
+// History returns the releases of the application oldest first
+func History(ctx context.Context, kube kubernetes.Interface, app string) ([]Release, error) {
+	cms, err := kube.CoreV1().ConfigMaps(app).List(ctx, metav1.ListOptions{LabelSelector: appLabel + "=" + app})
+	if err != nil {
+		return nil, fmt.Errorf("listing release config maps: %w", err)
+	}
+
+	releases := make([]Release, 0, len(cms.Items))
+	for _, cm := range cms.Items {
+		r, err := fromConfigMap(cm)
+		if err != nil {
+			return nil, fmt.Errorf("reading release config map %s: %w", cm.Name, err)
+		}
+
+		releases = append(releases, r)
+	}
+	sort.Slice(releases, func(i, j int) bool { return releases[i].Revision < releases[j].Revision })
+
+	return releases, nil
+}
+
+// Get returns a release of the application. Revision 0 returns the release before the current one.
+func Get(ctx context.Context, kube kubernetes.Interface, app string, revision int) (*Release, error) {
+	history, err := History(ctx, kube, app)
+	if err != nil {
+		return nil, fmt.Errorf("getting history: %w", err)
+	}
+
+	if revision == 0 {
+		if len(history) < 2 {
+			return nil, fmt.Errorf("no release before the current one: %w", NotFoundErr)
+		}
+
+		return &history[len(history)-2], nil
+	}
+
+	for _, r := range history {
+		if r.Revision == revision {
+			return &r, nil
+		}
+	}
+
+	return nil, fmt.Errorf("revision %d: %w", revision, NotFoundErr)
+}
+
+// Print writes the releases as a table
+func Print(w io.Writer, releases []Release) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REVISION\tDEPLOYED\tIMAGE\tVERSION\tCONFIG\tUSER\tDESCRIPTION")
+	for _, r := range releases {
+		description := "deploy"
+		if r.RollbackOf != 0 {
+			description = fmt.Sprintf("rollback to %d", r.RollbackOf)
+		}
+
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Revision, r.Time.Local().Format(time.DateTime), r.Image, r.SpinVersion, shortHash(r.ConfigHash), r.User, description)
+	}
+
+	return tw.Flush()
+}
+
+func configMapName(app string, revision int) string {
+	return fmt.Sprintf("%s-release-%d", app, revision)
+}
+
+func toConfigMap(app string, r Release) *corev1.ConfigMap {
+	annotations := map[string]string{
+		imageKey:       r.Image,
+		configHashKey:  r.ConfigHash,
+		spinVersionKey: r.SpinVersion,
+		timeKey:        r.Time.Format(time.RFC3339),
+		userKey:        r.User,
+	}
+	if r.RollbackOf != 0 {
+		annotations[rollbackOfKey] = strconv.Itoa(r.RollbackOf)
+	}
+
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      configMapName(app, r.Revision),
+			Namespace: app,
+			Labels: map[string]string{
+				appLabel:       app,
+				revisionLabel:  strconv.Itoa(r.Revision),
+				managedByLabel: managedByLabelVal,
+			},
+			Annotations: annotations,
+		},
+		Data: map[string]string{
+			manifestsDataKey: string(r.Manifests),
+		},
+	}
+}
+
+func fromConfigMap(cm corev1.ConfigMap) (Release, error) {
+	revision, err := strconv.Atoi(cm.Labels[revisionLabel])
+	if err != nil {
+		return Release{}, fmt.Errorf("parsing revision: %w", err)
+	}
+
+	r := Release{
+		Revision:    revision,
+		Image:       cm.Annotations[imageKey],
+		ConfigHash:  cm.Annotations[configHashKey],
+		SpinVersion: cm.Annotations[spinVersionKey],
+		User:        cm.Annotations[userKey],
+		Manifests:   []byte(cm.Data[manifestsDataKey]),
+	}
+
+	if t := cm.Annotations[timeKey]; t != "" {
+		if r.Time, err = time.Parse(time.RFC3339, t); err != nil {
+			return Release{}, fmt.Errorf("parsing time: %w", err)
+		}
+	}
+
+	if of := cm.Annotations[rollbackOfKey]; of != "" {
+		if r.RollbackOf, err = strconv.Atoi(of); err != nil {
+			return Release{}, fmt.Errorf("parsing rollback revision: %w", err)
+		}
+	}
+
+	return r, nil
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+
+	return hash
+}
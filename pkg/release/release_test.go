@@ -0,0 +1,89 @@
+package release
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRecord(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	kube := kubefake.NewSimpleClientset()
+
+	first, err := Record(ctx, kube, "app", Release{Image: "acr.azurecr.io/app:v1", SpinVersion: "1.0.0", User: "user", Manifests: []byte("v1")})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(first.Revision).To(Equal(1))
+	g.Expect(first.ConfigHash).To(Equal(Hash([]byte("v1"))))
+
+	second, err := Record(ctx, kube, "app", Release{Image: "acr.azurecr.io/app:v2", SpinVersion: "1.1.0", User: "user", Manifests: []byte("v2")})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(second.Revision).To(Equal(2))
+
+	history, err := History(ctx, kube, "app")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(history).To(HaveLen(2))
+	g.Expect(history[0].Image).To(Equal("acr.azurecr.io/app:v1"))
+	g.Expect(history[0].SpinVersion).To(Equal("1.0.0"))
+	g.Expect(history[0].Manifests).To(Equal([]byte("v1")))
+	g.Expect(history[0].Time).To(BeTemporally("~", first.Time, 1e9))
+
+	previous, err := Get(ctx, kube, "app", 0)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(previous.Revision).To(Equal(1))
+
+	rollback, err := Record(ctx, kube, "app", Release{Image: previous.Image, RollbackOf: previous.Revision, Manifests: previous.Manifests})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rollback.Revision).To(Equal(3))
+
+	got, err := Get(ctx, kube, "app", 3)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(got.RollbackOf).To(Equal(1))
+	g.Expect(got.Manifests).To(Equal([]byte("v1")))
+
+	_, err = Get(ctx, kube, "app", 42)
+	g.Expect(errors.Is(err, NotFoundErr)).To(BeTrue())
+
+	var out bytes.Buffer
+	g.Expect(Print(&out, []Release{*got})).To(Succeed())
+	g.Expect(out.String()).To(ContainSubstring("rollback to 1"))
+}
+
+func TestRecordPrunesHistory(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	kube := kubefake.NewSimpleClientset()
+
+	for i := 1; i <= MaxHistory+2; i++ {
+		_, err := Record(ctx, kube, "app", Release{Manifests: []byte(fmt.Sprint(i))})
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+
+	history, err := History(ctx, kube, "app")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(history).To(HaveLen(MaxHistory))
+	g.Expect(history[0].Revision).To(Equal(3))
+	g.Expect(history[MaxHistory-1].Revision).To(Equal(MaxHistory + 2))
+
+	cms, err := kube.CoreV1().ConfigMaps("app").List(ctx, metav1.ListOptions{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(cms.Items).To(HaveLen(MaxHistory))
+}
+
+func TestGetNoPrevious(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	kube := kubefake.NewSimpleClientset()
+
+	_, err := Record(ctx, kube, "app", Release{Manifests: []byte("v1")})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = Get(ctx, kube, "app", 0)
+	g.Expect(errors.Is(err, NotFoundErr)).To(BeTrue())
+}
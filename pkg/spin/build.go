@@ -0,0 +1,334 @@
+package spin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/state"
+	"github.com/azure/spin-aks-plugin/pkg/utils"
+)
+
+// BuildStatus is the outcome of building a component
+type BuildStatus string
+
+const (
+	BuildBuilt    BuildStatus = "built"
+	BuildSkipped  BuildStatus = "skipped"
+	BuildFailed   BuildStatus = "failed"
+	BuildCanceled BuildStatus = "canceled"
+)
+
+// BuildOpts controls how components are built
+type BuildOpts struct {
+	// Force builds every component even if its inputs and output are unchanged
+	Force bool
+	// Jobs is the number of components built at once. Zero or less uses the number of CPUs.
+	Jobs int
+}
+
+// BuildResult is the outcome of building a component
+type BuildResult struct {
+	Component string
+	Status    BuildStatus
+	Duration  time.Duration
+}
+
+// buildWaitDelay is how long a canceled build command gets to exit before its output pipes are closed
+const buildWaitDelay = 5 * time.Second
+
+// runBuild runs a component's build command in its workdir the way spin build does, streaming its output to out
+var runBuild = func(ctx context.Context, dir, command string, out io.Writer) error {
+	c := exec.CommandContext(ctx, "sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		c = exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	c.Dir = dir
+	c.Stdout = out
+	c.Stderr = out
+	killProcessGroup(c)
+	// processes that inherited the output pipes can keep Wait from returning after the group is killed
+	c.WaitDelay = buildWaitDelay
+
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("running %q: %w", command, err)
+	}
+
+	return nil
+}
+
+// Build runs the build command of every component of the manifest whose inputs or output wasm changed since it was
+// last built, up to opts.Jobs at once. A component's inputs are the files in its build workdir that aren't excluded
+// by its exclude_files or the workdir's .gitignore. Output is logged live with each line prefixed by the component
+// id. The first failure cancels the other builds. Results are returned in manifest order even on failure.
+func Build(ctx context.Context, manifestPath string, m Manifest, opts BuildOpts) ([]BuildResult, error) {
+	lgr := logger.FromContext(ctx).With("manifest", manifestPath)
+	lgr.Debug("building spin application")
+
+	manifestPath, err := filepath.Abs(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("getting absolute manifest path: %w", err)
+	}
+
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		sem      = make(chan struct{}, jobs)
+		results  = make([]BuildResult, len(m.Components))
+	)
+	for i, c := range m.Components {
+		i, c := i, c
+		results[i] = BuildResult{Component: c.Id, Status: BuildCanceled}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+
+			start := time.Now()
+			status, err := buildComponent(ctx, manifestPath, c, opts.Force)
+			results[i] = BuildResult{Component: c.Id, Status: status, Duration: time.Since(start)}
+			if err == nil {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if ctx.Err() != nil && firstErr != nil {
+				// canceled because another component failed first
+				results[i].Status = BuildCanceled
+				return
+			}
+
+			if firstErr == nil {
+				firstErr = fmt.Errorf("building component %s: %w", c.Id, err)
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return results, firstErr
+	}
+
+	if err := ctx.Err(); err != nil {
+		return results, fmt.Errorf("building spin application: %w", err)
+	}
+
+	lgr.Debug("finished building spin application")
+	return results, nil
+}
+
+// PrintBuildSummary logs the outcome and duration of each component's build
+func PrintBuildSummary(ctx context.Context, results []BuildResult) {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	for _, r := range results {
+		duration := ""
+		if r.Status == BuildBuilt || r.Status == BuildFailed {
+			duration = r.Duration.Round(time.Millisecond).String()
+		}
+
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", r.Component, r.Status, duration)
+	}
+	w.Flush()
+
+	logger.FromContext(ctx).Info("Build summary:\n" + strings.TrimRight(b.String(), "\n"))
+}
+
+func buildComponent(ctx context.Context, manifestPath string, c Component, force bool) (BuildStatus, error) {
+	lgr := logger.FromContext(ctx).With("component", c.Id)
+	if c.Build.Command == "" {
+		lgr.Debug("component has no build command")
+		return BuildSkipped, nil
+	}
+
+	manifestDir := filepath.Dir(manifestPath)
+	workdir := filepath.Join(manifestDir, c.Build.Workdir)
+	output := ""
+	if source := string(c.Source.StringSource); source != "" {
+		output = filepath.Join(manifestDir, source)
+	}
+
+	if !force {
+		current, err := buildHash(ctx, workdir, manifestDir, output, c)
+		if err != nil {
+			return BuildFailed, fmt.Errorf("hashing inputs: %w", err)
+		}
+
+		st, err := state.Load(ctx)
+		if err != nil {
+			return BuildFailed, fmt.Errorf("loading state: %w", err)
+		}
+
+		if current != "" && current == st.Builds[manifestPath][c.Id] {
+			lgr.Debug("component is unchanged, skipping build")
+			return BuildSkipped, nil
+		}
+	}
+
+	out := logger.NewLineWriter(logger.FromContext(ctx), "["+c.Id+"] ")
+	out.Write([]byte(c.Build.Command + "\n"))
+	err := runBuild(ctx, workdir, c.Build.Command, out)
+	out.Close()
+	if err != nil {
+		return BuildFailed, err
+	}
+
+	// hashed after building so files the build updates, like lock files, don't cause the next build
+	current, err := buildHash(ctx, workdir, manifestDir, output, c)
+	if err != nil {
+		return BuildFailed, fmt.Errorf("hashing inputs: %w", err)
+	}
+
+	err = state.Update(ctx, func(s *state.State) error {
+		if _, ok := s.Builds[manifestPath]; !ok {
+			s.Builds[manifestPath] = map[string]string{}
+		}
+		s.Builds[manifestPath][c.Id] = current
+		return nil
+	})
+	if err != nil {
+		return BuildFailed, fmt.Errorf("setting build hash: %w", err)
+	}
+
+	return BuildBuilt, nil
+}
+
+// buildHash returns the hash of the component's build command, inputs, and output wasm. It's empty when there's no
+// output wasm so the component is always built.
+func buildHash(ctx context.Context, workdir, manifestDir, output string, c Component) (string, error) {
+	if output == "" {
+		return "", nil
+	}
+
+	outputHash, err := utils.HashDirectories(output)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("hashing output: %w", err)
+	}
+
+	inputs, err := inputsHash(ctx, workdir, manifestDir, output, c)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join([]string{c.Build.Command, inputs, outputHash}, "\n")))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// inputsHash returns the hash of the files in the workdir that aren't the output wasm, in .git, or excluded by the
+// .gitignore files from the repository root down to the workdir or the component's exclude_files
+func inputsHash(ctx context.Context, workdir, manifestDir, output string, c Component) (string, error) {
+	gitignore, err := utils.LoadGitignores(ctx, workdir)
+	if err != nil {
+		return "", fmt.Errorf("loading .gitignore: %w", err)
+	}
+
+	excluded, err := utils.NewIgnore(manifestDir, c.ExcludeFiles...)
+	if err != nil {
+		return "", fmt.Errorf("parsing exclude_files: %w", err)
+	}
+
+	hash, err := utils.HashDirectoriesFiltered(func(path string, isDir bool) bool {
+		return path == output ||
+			filepath.Base(path) == ".git" ||
+			gitignore.Match(path, isDir) ||
+			excluded.Match(path, isDir)
+	}, workdir)
+	if err != nil {
+		return "", fmt.Errorf("hashing inputs: %w", err)
+	}
+
+	return hash, nil
+}
+
+// SourceHash returns the hash of the spin manifest and every component's sources. A component's sources are its
+// build command and inputs when it has a build command and its wasm otherwise, and the paths and contents of its
+// static files. It doesn't need the application to be built so it can tell whether anything changed before building.
+func SourceHash(ctx context.Context, manifestPath string, m Manifest) (string, error) {
+	manifestPath, err := filepath.Abs(manifestPath)
+	if err != nil {
+		return "", fmt.Errorf("getting absolute manifest path: %w", err)
+	}
+	manifestDir := filepath.Dir(manifestPath)
+
+	manifestHash, err := utils.HashDirectories(manifestPath)
+	if err != nil {
+		return "", fmt.Errorf("hashing spin manifest: %w", err)
+	}
+
+	hashes := []string{manifestHash}
+	for _, c := range m.Components {
+		output := ""
+		if source := string(c.Source.StringSource); source != "" {
+			output = filepath.Join(manifestDir, source)
+		}
+
+		switch {
+		case c.Build.Command != "":
+			hash, err := inputsHash(ctx, filepath.Join(manifestDir, c.Build.Workdir), manifestDir, output, c)
+			if err != nil {
+				return "", fmt.Errorf("hashing component %s: %w", c.Id, err)
+			}
+			hashes = append(hashes, c.Id, c.Build.Command, hash)
+		case output != "":
+			hash, err := utils.HashDirectories(output)
+			if err != nil {
+				return "", fmt.Errorf("hashing component %s: %w", c.Id, err)
+			}
+			hashes = append(hashes, c.Id, hash)
+		default:
+			hashes = append(hashes, c.Id, c.Source.URLSource.Url, c.Source.URLSource.Digest)
+		}
+
+		// static files are packaged with the component even when they're ignored or outside of the build workdir
+		files, err := ResolveFiles(manifestDir, c)
+		if err != nil {
+			return "", fmt.Errorf("resolving component %s files: %w", c.Id, err)
+		}
+		for _, f := range files {
+			hash, err := utils.HashDirectories(f.Source)
+			if err != nil {
+				return "", fmt.Errorf("hashing component %s file %s: %w", c.Id, f.Path, err)
+			}
+			hashes = append(hashes, f.Path, hash)
+		}
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(hashes, "\n")))
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,304 @@
+package spin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const buildManifest = `
+spin_manifest_version = "1"
+name = "app"
+
+[[component]]
+id = "hello"
+source = "target/hello.wasm"
+exclude_files = ["**/*.md"]
+[component.build]
+command = "cargo build"
+
+[[component]]
+id = "static"
+source = "static.wasm"
+files = [{ source = "public", destination = "/" }]
+`
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("creating directory: %s", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("writing file: %s", err)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", home)
+
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "spin.toml")
+	writeFile(t, manifest, buildManifest)
+	writeFile(t, filepath.Join(dir, ".gitignore"), "target/\n")
+	writeFile(t, filepath.Join(dir, "src", "lib.rs"), "fn main() {}")
+
+	builds := 0
+	orig := runBuild
+	runBuild = func(_ context.Context, workdir, command string, _ io.Writer) error {
+		builds++
+		if workdir != dir || command != "cargo build" {
+			t.Fatalf("unexpected build %q in %s", command, workdir)
+		}
+
+		writeFile(t, filepath.Join(dir, "target", "hello.wasm"), "wasm")
+		writeFile(t, filepath.Join(dir, "target", "debug", "cache"), "changes every build "+string(rune('a'+builds)))
+		return nil
+	}
+	t.Cleanup(func() { runBuild = orig })
+
+	m, err := Load(manifest)
+	if err != nil {
+		t.Fatalf("loading manifest: %s", err)
+	}
+	if m.Components[0].Build.Command != "cargo build" {
+		t.Fatalf("expected build command to be decoded, got %q", m.Components[0].Build.Command)
+	}
+
+	ctx := context.Background()
+	steps := []struct {
+		name     string
+		change   func()
+		force    bool
+		expected []string
+	}{
+		{name: "first build", expected: []string{"hello"}},
+		{name: "unchanged", expected: nil},
+		{
+			name:     "ignored and excluded files changed",
+			change:   func() { writeFile(t, filepath.Join(dir, "README.md"), "docs") },
+			expected: nil,
+		},
+		{
+			name:     "input changed",
+			change:   func() { writeFile(t, filepath.Join(dir, "src", "lib.rs"), "fn main() { changed() }") },
+			expected: []string{"hello"},
+		},
+		{
+			name:     "output changed",
+			change:   func() { writeFile(t, filepath.Join(dir, "target", "hello.wasm"), "tampered") },
+			expected: []string{"hello"},
+		},
+		{name: "forced", force: true, expected: []string{"hello"}},
+	}
+
+	for _, step := range steps {
+		if step.change != nil {
+			step.change()
+		}
+
+		results, err := Build(ctx, manifest, m, BuildOpts{Force: step.force})
+		if err != nil {
+			t.Fatalf("%s: building: %s", step.name, err)
+		}
+
+		var built []string
+		for _, r := range results {
+			if r.Status == BuildBuilt {
+				built = append(built, r.Component)
+			}
+		}
+
+		if !reflect.DeepEqual(built, step.expected) {
+			t.Fatalf("%s: expected %v to be built, got %v", step.name, step.expected, built)
+		}
+	}
+
+	if builds != 4 {
+		t.Fatalf("expected 4 builds, got %d", builds)
+	}
+}
+
+// parallelManifest returns a manifest with components a through d that each have a build command
+func parallelManifest(t *testing.T) (string, Manifest) {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", home)
+
+	m := Manifest{Name: "app"}
+	for _, id := range []string{"a", "b", "c", "d"} {
+		c := Component{Id: id, Source: ComponentSource{StringSource: ComponentSourceString(id + ".wasm")}}
+		c.Build.Command = "build " + id
+		m.Components = append(m.Components, c)
+	}
+
+	return filepath.Join(t.TempDir(), "spin.toml"), m
+}
+
+func TestBuildParallel(t *testing.T) {
+	manifest, m := parallelManifest(t)
+
+	var running, maxRunning int32
+	var mu sync.Mutex
+	orig := runBuild
+	runBuild = func(_ context.Context, _, command string, out io.Writer) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+
+		mu.Lock()
+		if n > maxRunning {
+			maxRunning = n
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+		_, err := fmt.Fprintln(out, "done "+command)
+		return err
+	}
+	t.Cleanup(func() { runBuild = orig })
+
+	results, err := Build(context.Background(), manifest, m, BuildOpts{Jobs: 2})
+	if err != nil {
+		t.Fatalf("building: %s", err)
+	}
+
+	if maxRunning != 2 {
+		t.Fatalf("expected 2 builds at once, got %d", maxRunning)
+	}
+
+	for i, r := range results {
+		if r.Component != m.Components[i].Id || r.Status != BuildBuilt || r.Duration <= 0 {
+			t.Fatalf("unexpected result %+v", r)
+		}
+	}
+}
+
+func TestBuildFailureCancels(t *testing.T) {
+	manifest, m := parallelManifest(t)
+
+	orig := runBuild
+	runBuild = func(ctx context.Context, _, command string, _ io.Writer) error {
+		if command == "build a" {
+			return errors.New("compile error")
+		}
+
+		// the other builds only finish when canceled
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	t.Cleanup(func() { runBuild = orig })
+
+	results, err := Build(context.Background(), manifest, m, BuildOpts{Jobs: 2})
+	if err == nil || !strings.Contains(err.Error(), "compile error") {
+		t.Fatalf("expected component a's error, got %v", err)
+	}
+
+	if results[0].Status != BuildFailed {
+		t.Fatalf("expected a to fail, got %s", results[0].Status)
+	}
+	for _, r := range results[1:] {
+		if r.Status != BuildCanceled {
+			t.Fatalf("expected %s to be canceled, got %s", r.Component, r.Status)
+		}
+	}
+}
+
+func TestRunBuildCancelKillsProcessGroup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	// the background sleep keeps the output pipe open unless it's killed with the shell
+	start := time.Now()
+	err := runBuild(ctx, t.TempDir(), "sleep 30 & wait", io.Discard)
+	if err == nil {
+		t.Fatal("expected canceling to fail the build")
+	}
+	if elapsed := time.Since(start); elapsed > buildWaitDelay/2 {
+		t.Fatalf("expected the canceled build to return right away, took %s", elapsed)
+	}
+}
+
+func TestSourceHash(t *testing.T) {
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "spin.toml")
+	writeFile(t, manifest, buildManifest)
+	// static files are often ignored by git, like generated assets
+	writeFile(t, filepath.Join(dir, ".gitignore"), "target/\npublic/\n")
+	writeFile(t, filepath.Join(dir, "src", "lib.rs"), "fn main() {}")
+	writeFile(t, filepath.Join(dir, "static.wasm"), "static")
+	writeFile(t, filepath.Join(dir, "public", "index.html"), "<html></html>")
+
+	m, err := Load(manifest)
+	if err != nil {
+		t.Fatalf("loading manifest: %s", err)
+	}
+
+	hash := func() string {
+		t.Helper()
+
+		h, err := SourceHash(context.Background(), manifest, m)
+		if err != nil {
+			t.Fatalf("hashing sources: %s", err)
+		}
+		return h
+	}
+
+	original := hash()
+	if original == "" {
+		t.Fatal("expected a hash")
+	}
+
+	// build outputs, ignored files, and excluded files aren't sources
+	writeFile(t, filepath.Join(dir, "target", "hello.wasm"), "wasm")
+	writeFile(t, filepath.Join(dir, "README.md"), "docs")
+	if h := hash(); h != original {
+		t.Fatal("expected building to not change the hash")
+	}
+
+	steps := []struct {
+		name   string
+		change func()
+	}{
+		{"source", func() { writeFile(t, filepath.Join(dir, "src", "lib.rs"), "fn main() { }") }},
+		{"wasm without build", func() { writeFile(t, filepath.Join(dir, "static.wasm"), "static v2") }},
+		{"static file", func() { writeFile(t, filepath.Join(dir, "public", "index.html"), "<html> </html>") }},
+		{"static file path", func() {
+			if err := os.Rename(filepath.Join(dir, "public", "index.html"), filepath.Join(dir, "public", "home.html")); err != nil {
+				t.Fatalf("renaming static file: %s", err)
+			}
+		}},
+		{"manifest", func() { writeFile(t, manifest, buildManifest+"\n# comment\n") }},
+		{"build command", func() { m.Components[0].Build.Command = "cargo build --release" }},
+	}
+
+	previous := original
+	for _, step := range steps {
+		step.change()
+		h := hash()
+		if h == previous {
+			t.Fatalf("%s: expected the hash to change", step.name)
+		}
+		previous = h
+	}
+}
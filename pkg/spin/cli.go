@@ -0,0 +1,28 @@
+package spin
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// CLIVersion returns the version of the spin CLI on the PATH like 1.5.1. Tests replace it to not depend on the spin CLI.
+var CLIVersion = func(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, "spin", "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("running spin --version: %w", err)
+	}
+
+	return parseCLIVersion(string(out))
+}
+
+// parseCLIVersion parses the output of spin --version like "spin 1.5.1 (8d4334e 2023-09-26)"
+func parseCLIVersion(out string) (string, error) {
+	fields := strings.Fields(out)
+	if len(fields) < 2 || fields[0] != "spin" {
+		return "", fmt.Errorf("unexpected spin version output %q", strings.TrimSpace(out))
+	}
+
+	return strings.TrimPrefix(fields[1], "v"), nil
+}
@@ -0,0 +1,17 @@
+package spin
+
+import "testing"
+
+func TestParseCLIVersion(t *testing.T) {
+	v, err := parseCLIVersion("spin 1.5.1 (8d4334e 2023-09-26)\n")
+	if err != nil {
+		t.Fatalf("parsing version: %s", err)
+	}
+	if v != "1.5.1" {
+		t.Errorf("expected version 1.5.1 but got %s", v)
+	}
+
+	if _, err := parseCLIVersion("command not found"); err == nil {
+		t.Error("expected error parsing unexpected output")
+	}
+}
@@ -0,0 +1,216 @@
+package spin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+)
+
+const installUrl = "https://developer.fermyon.com/spin/install"
+
+// compatibility is a combination of versions known to work together on AKS
+type compatibility struct {
+	// cliMin and cliMax are the range of spin CLI versions, including cliMin and excluding cliMax
+	cliMin, cliMax string
+	// manifestVersions are the spin_manifest_version values the spin CLI builds
+	manifestVersions []string
+	// shimVersions are the containerd-shim-spin versions that run applications the spin CLI builds
+	shimVersions []string
+}
+
+// compatibilityMatrix is every supported combination of spin CLI, spin_manifest_version, and containerd-shim-spin
+var compatibilityMatrix = []compatibility{
+	{cliMin: "1.0.0", cliMax: "2.0.0", manifestVersions: []string{"1"}, shimVersions: []string{"0.5.1"}},
+}
+
+// CheckCompatibility checks that the spin CLI on the PATH, the manifest's spin_manifest_version, and the
+// containerd-shim-spin version are a supported combination
+func CheckCompatibility(ctx context.Context, manifestPath, shimVersion string) error {
+	lgr := logger.FromContext(ctx)
+	lgr.Debug("checking spin compatibility")
+
+	cli, err := CLIVersion(ctx)
+	if err != nil {
+		return cliNotFound(err)
+	}
+
+	manifestVersion, err := ManifestVersion(manifestPath)
+	if err != nil {
+		return fmt.Errorf("getting spin manifest version: %w", err)
+	}
+
+	if err := checkVersions(cli, manifestVersion, shimVersion); err != nil {
+		return err
+	}
+
+	lgr.Debug("finished checking spin compatibility")
+	return nil
+}
+
+// CheckCLIVersion returns the version of the spin CLI on the PATH and checks that it's in the compatibility matrix.
+// The version is empty if the spin CLI wasn't found.
+func CheckCLIVersion(ctx context.Context) (string, error) {
+	cli, err := CLIVersion(ctx)
+	if err != nil {
+		return "", cliNotFound(err)
+	}
+
+	if _, err := cliCompatibility(cli); err != nil {
+		return cli, err
+	}
+
+	return cli, nil
+}
+
+func cliNotFound(err error) error {
+	return usererror.New(err, fmt.Sprintf("The spin CLI wasn't found. Install spin %s from %s and add it to your PATH.", supportedCLIs(), installUrl))
+}
+
+// ManifestVersion returns the spin_manifest_version of the spin manifest, falling back to the older spin_version key.
+// It only decodes the version so it works for manifests this plugin can't otherwise load.
+func ManifestVersion(manifestPath string) (string, error) {
+	contents, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return "", fmt.Errorf("reading spin manifest: %w", err)
+	}
+
+	var header struct {
+		SpinManifestVersion any `toml:"spin_manifest_version"`
+		SpinVersion         any `toml:"spin_version"`
+	}
+	if _, err := toml.Decode(string(contents), &header); err != nil {
+		return "", fmt.Errorf("decoding spin manifest version: %w", err)
+	}
+
+	for _, v := range []any{header.SpinManifestVersion, header.SpinVersion} {
+		if v != nil {
+			return fmt.Sprint(v), nil
+		}
+	}
+
+	return "", nil
+}
+
+func checkVersions(cli, manifestVersion, shimVersion string) error {
+	c, err := cliCompatibility(cli)
+	if err != nil {
+		return err
+	}
+
+	if manifestVersion == "" {
+		return usererror.New(errors.New("spin manifest version not set"), fmt.Sprintf("The spin manifest doesn't set spin_manifest_version. Add spin_manifest_version = %q to it.", c.manifestVersions[0]))
+	}
+
+	if !contains(c.manifestVersions, manifestVersion) {
+		err := fmt.Errorf("spin_manifest_version %s isn't supported with spin %s", manifestVersion, cli)
+		return usererror.New(err, fmt.Sprintf("spin_manifest_version %s isn't supported with spin %s, which supports spin_manifest_version %s. Update the spin manifest or install a spin version that supports it from %s.", manifestVersion, cli, strings.Join(c.manifestVersions, ", "), installUrl))
+	}
+
+	if !contains(c.shimVersions, shimVersion) {
+		err := fmt.Errorf("spin %s isn't supported by containerd-shim-spin %s", cli, shimVersion)
+		return usererror.New(err, fmt.Sprintf("Applications built with spin %s can't run on containerd-shim-spin %s, the version the AKS Wasm node pool runs. Install spin %s from %s.", cli, shimVersion, supportedCLIs(shimVersion), installUrl))
+	}
+
+	return nil
+}
+
+// cliCompatibility returns the compatibility matrix entry for the spin CLI version
+func cliCompatibility(cli string) (compatibility, error) {
+	v, err := parseSemver(cli)
+	if err != nil {
+		return compatibility{}, usererror.New(err, fmt.Sprintf("Couldn't parse spin version %q. Install spin %s from %s.", cli, supportedCLIs(), installUrl))
+	}
+
+	for _, c := range compatibilityMatrix {
+		if compareSemver(v, mustParseSemver(c.cliMin)) >= 0 && compareSemver(v, mustParseSemver(c.cliMax)) < 0 {
+			return c, nil
+		}
+	}
+
+	err = fmt.Errorf("spin %s isn't supported", cli)
+	return compatibility{}, usererror.New(err, fmt.Sprintf("spin %s isn't supported. Install spin %s from %s.", cli, supportedCLIs(), installUrl))
+}
+
+// supportedCLIs describes the spin CLI versions in the compatibility matrix like ">=1.0.0 <2.0.0". Only versions
+// supporting one of the shim versions are described if any are given.
+func supportedCLIs(shimVersions ...string) string {
+	var ranges []string
+	for _, c := range compatibilityMatrix {
+		supported := len(shimVersions) == 0
+		for _, s := range shimVersions {
+			supported = supported || contains(c.shimVersions, s)
+		}
+
+		if supported {
+			ranges = append(ranges, fmt.Sprintf(">=%s <%s", c.cliMin, c.cliMax))
+		}
+	}
+
+	return strings.Join(ranges, " or ")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// semver is the major, minor, and patch of a semantic version. Pre-release and build metadata are ignored so
+// pre-releases compare like their release.
+type semver [3]int
+
+func parseSemver(s string) (semver, error) {
+	core := strings.TrimPrefix(s, "v")
+	if i := strings.IndexAny(core, "-+"); i >= 0 {
+		core = core[:i]
+	}
+
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return semver{}, fmt.Errorf("parsing semver %q: expected major.minor.patch", s)
+	}
+
+	var v semver
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return semver{}, fmt.Errorf("parsing semver %q: invalid number %q", s, p)
+		}
+		v[i] = n
+	}
+
+	return v, nil
+}
+
+func mustParseSemver(s string) semver {
+	v, err := parseSemver(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+func compareSemver(a, b semver) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return 0
+}
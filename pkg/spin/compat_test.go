@@ -0,0 +1,122 @@
+package spin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+)
+
+func TestManifestVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		expected string
+	}{
+		{"manifest version", `spin_manifest_version = "1"`, "1"},
+		{"legacy spin version", `spin_version = "1"`, "1"},
+		{"integer manifest version", "spin_manifest_version = 2\n[component.hello]\nsource = \"hello.wasm\"", "2"},
+		{"unset", `name = "app"`, ""},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "spin.toml")
+		if err := os.WriteFile(path, []byte(test.manifest), 0644); err != nil {
+			t.Fatalf("%s: writing manifest: %s", test.name, err)
+		}
+
+		v, err := ManifestVersion(path)
+		if err != nil {
+			t.Fatalf("%s: getting manifest version: %s", test.name, err)
+		}
+		if v != test.expected {
+			t.Errorf("%s: expected version %q but got %q", test.name, test.expected, v)
+		}
+	}
+}
+
+func TestCheckVersions(t *testing.T) {
+	tests := []struct {
+		name            string
+		cli             string
+		manifestVersion string
+		shimVersion     string
+		// msg is part of the user error message, empty when the versions are compatible
+		msg string
+	}{
+		{"compatible", "1.5.1", "1", "0.5.1", ""},
+		{"first 1.x", "1.0.0", "1", "0.5.1", ""},
+		{"1.x pre-release", "1.6.0-rc.1", "1", "0.5.1", ""},
+		{"cli too new", "2.0.0", "1", "0.5.1", "spin 2.0.0 isn't supported. Install spin >=1.0.0 <2.0.0"},
+		{"2.x pre-release", "2.0.0-pre0", "1", "0.5.1", "spin 2.0.0-pre0 isn't supported"},
+		{"cli too old", "0.10.1", "1", "0.5.1", "spin 0.10.1 isn't supported"},
+		{"unparseable cli", "latest", "1", "0.5.1", `Couldn't parse spin version "latest"`},
+		{"manifest version unsupported", "1.5.1", "2", "0.5.1", "spin_manifest_version 2 isn't supported with spin 1.5.1, which supports spin_manifest_version 1"},
+		{"manifest version unset", "1.5.1", "", "0.5.1", `Add spin_manifest_version = "1"`},
+		{"shim unsupported", "1.5.1", "1", "0.3.0", "can't run on containerd-shim-spin 0.3.0"},
+	}
+
+	for _, test := range tests {
+		err := checkVersions(test.cli, test.manifestVersion, test.shimVersion)
+		if test.msg == "" {
+			if err != nil {
+				t.Errorf("%s: expected compatible but got %s", test.name, err)
+			}
+			continue
+		}
+
+		u, ok := usererror.Is(err)
+		if !ok {
+			t.Errorf("%s: expected user error but got %v", test.name, err)
+			continue
+		}
+		if !strings.Contains(u.Msg(), test.msg) {
+			t.Errorf("%s: expected message containing %q but got %q", test.name, test.msg, u.Msg())
+		}
+	}
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spin.toml")
+	if err := os.WriteFile(path, []byte(`spin_manifest_version = "1"`), 0644); err != nil {
+		t.Fatalf("writing manifest: %s", err)
+	}
+
+	orig := CLIVersion
+	t.Cleanup(func() { CLIVersion = orig })
+
+	CLIVersion = func(context.Context) (string, error) { return "1.5.1", nil }
+	if err := CheckCompatibility(context.Background(), path, "0.5.1"); err != nil {
+		t.Errorf("expected compatible but got %s", err)
+	}
+
+	CLIVersion = func(context.Context) (string, error) { return "", errors.New("executable file not found in $PATH") }
+	err := CheckCompatibility(context.Background(), path, "0.5.1")
+	if u, ok := usererror.Is(err); !ok || !strings.Contains(u.Msg(), "spin CLI wasn't found") {
+		t.Errorf("expected spin CLI not found user error but got %v", err)
+	}
+}
+
+func TestCheckCLIVersion(t *testing.T) {
+	orig := CLIVersion
+	t.Cleanup(func() { CLIVersion = orig })
+
+	CLIVersion = func(context.Context) (string, error) { return "1.5.1", nil }
+	if v, err := CheckCLIVersion(context.Background()); v != "1.5.1" || err != nil {
+		t.Errorf("expected supported 1.5.1 but got %q, %v", v, err)
+	}
+
+	CLIVersion = func(context.Context) (string, error) { return "0.1.0", nil }
+	if v, err := CheckCLIVersion(context.Background()); v != "0.1.0" || err == nil {
+		t.Errorf("expected unsupported 0.1.0 but got %q, %v", v, err)
+	}
+
+	CLIVersion = func(context.Context) (string, error) { return "", errors.New("executable file not found in $PATH") }
+	if v, err := CheckCLIVersion(context.Background()); v != "" || err == nil {
+		t.Errorf("expected spin CLI not found but got %q, %v", v, err)
+	}
+}
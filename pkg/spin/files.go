@@ -0,0 +1,93 @@
+package spin
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/azure/spin-aks-plugin/pkg/utils"
+)
+
+// MountedFile is a static file of a component
+type MountedFile struct {
+	// Source is the path of the file on disk
+	Source string
+	// Path is the absolute slash separated path the component sees the file at
+	Path string
+}
+
+// ResolveFiles returns the static files of the component sorted by path. Glob patterns are relative to the manifest
+// directory and mount files at the same relative path. Source directories mount every file under the destination.
+// Files matching the component's exclude_files are skipped.
+func ResolveFiles(manifestDir string, c Component) ([]MountedFile, error) {
+	excluded, err := utils.NewIgnore(manifestDir, c.ExcludeFiles...)
+	if err != nil {
+		return nil, fmt.Errorf("parsing exclude_files: %w", err)
+	}
+
+	mounted := map[string]string{}
+	add := func(source, mountPath string) {
+		if !excluded.Match(source, false) {
+			mounted[mountPath] = source
+		}
+	}
+
+	for _, pattern := range c.Files.StringFiles {
+		// anchored so patterns only match relative to the manifest directory
+		glob, err := utils.NewIgnore(manifestDir, "/"+string(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("parsing files pattern %q: %w", pattern, err)
+		}
+
+		if err := walkFiles(manifestDir, func(source, rel string) {
+			if glob.Match(source, false) {
+				add(source, "/"+rel)
+			}
+		}); err != nil {
+			return nil, fmt.Errorf("finding files matching %q: %w", pattern, err)
+		}
+	}
+
+	for _, f := range c.Files.MapFiles {
+		dir := filepath.Join(manifestDir, f.Source)
+		if err := walkFiles(dir, func(source, rel string) {
+			add(source, path.Join("/", f.Destination, rel))
+		}); err != nil {
+			return nil, fmt.Errorf("finding files in %s: %w", f.Source, err)
+		}
+	}
+
+	files := make([]MountedFile, 0, len(mounted))
+	for p, source := range mounted {
+		files = append(files, MountedFile{Source: source, Path: p})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	return files, nil
+}
+
+// walkFiles calls fn with the path and slash separated path relative to dir of every regular file under dir outside
+// of .git directories
+func walkFiles(dir string, fn func(source, rel string)) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		fn(p, filepath.ToSlash(rel))
+		return nil
+	})
+}
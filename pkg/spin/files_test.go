@@ -0,0 +1,54 @@
+package spin
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolveFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"static/index.html",
+		"static/css/site.css",
+		"static/README.md",
+		"assets/logo.png",
+		"assets/.git/HEAD",
+		"other.txt",
+	} {
+		writeFile(t, filepath.Join(dir, name), name)
+	}
+
+	c := Component{
+		Id: "web",
+		Files: ComponentFiles{
+			StringFiles: []ComponentFileString{"static/**/*"},
+			MapFiles:    []ComponentFileMap{{Source: "assets", Destination: "/img"}},
+		},
+		ExcludeFiles: []string{"**/*.md"},
+	}
+
+	files, err := ResolveFiles(dir, c)
+	if err != nil {
+		t.Fatalf("resolving files: %s", err)
+	}
+
+	expected := []MountedFile{
+		{Source: filepath.Join(dir, "assets", "logo.png"), Path: "/img/logo.png"},
+		{Source: filepath.Join(dir, "static", "css", "site.css"), Path: "/static/css/site.css"},
+		{Source: filepath.Join(dir, "static", "index.html"), Path: "/static/index.html"},
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected files %v, got %v", expected, files)
+	}
+}
+
+func TestResolveFilesNone(t *testing.T) {
+	files, err := ResolveFiles(t.TempDir(), Component{Id: "hello"})
+	if err != nil {
+		t.Fatalf("resolving files: %s", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected no files, got %v", files)
+	}
+}
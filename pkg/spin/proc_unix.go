@@ -0,0 +1,18 @@
+//go:build !windows
+
+package spin
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killProcessGroup runs the command in its own process group and makes canceling it kill the whole group, so build
+// tools the shell started, like cargo and its rustc processes, don't outlive a canceled build
+func killProcessGroup(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	c.Cancel = func() error {
+		// a negative pid signals every process in the group
+		return syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+	}
+}
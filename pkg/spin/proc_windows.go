@@ -0,0 +1,16 @@
+//go:build windows
+
+package spin
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// killProcessGroup makes canceling the command kill its whole process tree, so build tools the shell started, like
+// cargo and its rustc processes, don't outlive a canceled build
+func killProcessGroup(c *exec.Cmd) {
+	c.Cancel = func() error {
+		return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(c.Process.Pid)).Run()
+	}
+}
@@ -0,0 +1,97 @@
+package spin
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+)
+
+// Toolchain is the program a component's build command runs and its version
+type Toolchain struct {
+	Name    string
+	Version string
+}
+
+var (
+	// versionRegex matches versions in version output like "cargo 1.72.0 (103a7ff2e 2023-08-15)"
+	versionRegex = regexp.MustCompile(`\d+\.\d+(\.\d+)?([-+][0-9A-Za-z.-]+)?`)
+	// envAssignRegex matches environment assignments prefixing commands like GOOS=wasip1
+	envAssignRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+	// commandSeparatorRegex splits shell commands like "cd app && cargo build"
+	commandSeparatorRegex = regexp.MustCompile(`&&|\|\||;|\|`)
+)
+
+// Toolchains returns the toolchain of each component's build command by component id. The program is found in the
+// component's build directory, so toolchain files like rust-toolchain.toml are respected, and its version is parsed
+// from `<program> --version` or `<program> version`. Components without a build command or whose toolchain version
+// isn't found are left out.
+func Toolchains(ctx context.Context, manifestPath string, m Manifest) map[string]Toolchain {
+	lgr := logger.FromContext(ctx)
+	manifestDir := filepath.Dir(manifestPath)
+
+	toolchains := map[string]Toolchain{}
+	found := map[string]string{}
+	for _, c := range m.Components {
+		program := commandProgram(c.Build.Command)
+		if program == "" {
+			continue
+		}
+
+		workdir := filepath.Join(manifestDir, c.Build.Workdir)
+		key := workdir + "\x00" + program
+		version, ok := found[key]
+		if !ok {
+			version = toolchainVersion(ctx, workdir, program)
+			found[key] = version
+		}
+		if version == "" {
+			lgr.Debug("toolchain version not found", "component", c.Id, "program", program)
+			continue
+		}
+
+		toolchains[c.Id] = Toolchain{Name: program, Version: version}
+	}
+
+	return toolchains
+}
+
+// commandProgram returns the first program on the PATH a shell command runs. Changing directories is skipped and so
+// are scripts referenced by path since running them with --version could run the build itself.
+func commandProgram(command string) string {
+	for _, segment := range commandSeparatorRegex.Split(command, -1) {
+		for _, field := range strings.Fields(segment) {
+			if envAssignRegex.MatchString(field) {
+				continue
+			}
+
+			if field != "cd" && !strings.ContainsAny(field, `/\`) {
+				return field
+			}
+			break
+		}
+	}
+
+	return ""
+}
+
+func toolchainVersion(ctx context.Context, workdir, program string) string {
+	for _, arg := range []string{"--version", "version"} {
+		cmd := exec.CommandContext(ctx, program, arg)
+		cmd.Dir = workdir
+		out, err := cmd.Output()
+		if err != nil {
+			continue
+		}
+
+		line, _, _ := strings.Cut(string(out), "\n")
+		if v := versionRegex.FindString(line); v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,60 @@
+package spin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestCommandProgram(t *testing.T) {
+	for command, expected := range map[string]string{
+		"cargo build --target wasm32-wasi --release": "cargo",
+		"GOOS=wasip1 GOARCH=wasm tinygo build":       "tinygo",
+		"cd app && npm run build":                    "npm",
+		"./build.sh":                                 "",
+		"cd app; ./build.sh && make":                 "make",
+		"":                                           "",
+	} {
+		if actual := commandProgram(command); actual != expected {
+			t.Errorf("expected program %q of %q, got %q", expected, command, actual)
+		}
+	}
+}
+
+func TestToolchains(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake toolchains are shell scripts")
+	}
+
+	bin := t.TempDir()
+	writeFile(t, filepath.Join(bin, "fakec"), "#!/bin/sh\necho \"fakec 1.72.0 (103a7ff2e 2023-08-15)\"\n")
+	// like tinygo and go, only supports the version subcommand
+	writeFile(t, filepath.Join(bin, "fakego"), "#!/bin/sh\nif [ \"$1\" = version ]; then echo \"fakego version 0.29.0 linux/amd64\"; else exit 1; fi\n")
+	writeFile(t, filepath.Join(bin, "noversion"), "#!/bin/sh\nexit 1\n")
+	for _, name := range []string{"fakec", "fakego", "noversion"} {
+		if err := os.Chmod(filepath.Join(bin, name), 0755); err != nil {
+			t.Fatalf("making %s executable: %s", name, err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	m := Manifest{Components: []Component{
+		{Id: "rust", Build: build{Command: "fakec build --release"}},
+		{Id: "go", Build: build{Command: "GOOS=wasip1 fakego build -o main.wasm"}},
+		{Id: "none", Build: build{Command: "noversion"}},
+		{Id: "prebuilt"},
+	}}
+
+	toolchains := Toolchains(context.Background(), filepath.Join(dir, "spin.toml"), m)
+	expected := map[string]Toolchain{
+		"rust": {Name: "fakec", Version: "1.72.0"},
+		"go":   {Name: "fakego", Version: "0.29.0"},
+	}
+	if !reflect.DeepEqual(toolchains, expected) {
+		t.Errorf("expected toolchains %v, got %v", expected, toolchains)
+	}
+}
@@ -16,7 +16,7 @@ type Manifest struct {
 
 type manifestTrigger struct {
 	// t type of trigger
-	T    string `mapstructure:"type"`
+	T    string `mapstructure:"type" toml:"type"`
 	Base string
 }
 
@@ -42,19 +42,24 @@ type Component struct {
 }
 
 type componentTrigger struct {
-	route    string
-	executor executor
-	channel  string
+	// Route is the path the http trigger routes to the component
+	Route string `toml:"route"`
+	// Executor is how the http trigger runs the component
+	Executor executor `toml:"executor"`
+	// Channel is the channel the redis trigger subscribes the component to
+	Channel string `toml:"channel"`
 }
 
 type executor struct {
-	// t type of executor
-	t          string `toml:"type"`
-	argv       string
-	entrypoint string
+	// T type of executor like spin or wagi
+	T          string `toml:"type"`
+	Argv       string `toml:"argv"`
+	Entrypoint string `toml:"entrypoint"`
 }
 
 type build struct {
-	command string
-	workdir string
+	// Command is run through the shell to build the component
+	Command string `toml:"command"`
+	// Workdir is the directory the command runs in relative to the spin manifest
+	Workdir string `toml:"workdir"`
 }
@@ -0,0 +1,43 @@
+//go:build !windows
+
+package state
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lock takes an advisory lock on the lock file, blocking until it's available, and returns the function releasing
+// it. The lock is exclusive for writes and shared for reads. The OS releases it if the process dies.
+func lock(exclusive bool) (func(), error) {
+	if err := os.MkdirAll(stateDir(), 0755); err != nil {
+		return nil, fmt.Errorf("creating state directory: %w", err)
+	}
+
+	f, err := os.OpenFile(lockPath(), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening state lock file: %w", err)
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	for {
+		err = syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking %s: %w", f.Name(), err)
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,40 @@
+//go:build windows
+
+package state
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lock takes an advisory lock on the lock file, blocking until it's available, and returns the function releasing
+// it. The lock is exclusive for writes and shared for reads. The OS releases it if the process dies.
+func lock(exclusive bool) (func(), error) {
+	if err := os.MkdirAll(stateDir(), 0755); err != nil {
+		return nil, fmt.Errorf("creating state directory: %w", err)
+	}
+
+	f, err := os.OpenFile(lockPath(), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening state lock file: %w", err)
+	}
+
+	var flags uint32
+	if exclusive {
+		flags = windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+
+	// every run locks the first byte of the file
+	ol := new(windows.Overlapped)
+	if err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, ol); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking %s: %w", f.Name(), err)
+	}
+
+	return func() {
+		windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, ol)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,93 @@
+package state
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+)
+
+// legacyKindKeys are the keys of each resource kind's default that the legacy defaults shared by every resource kind
+// map to
+var legacyKindKeys = map[string][]string{
+	"subscription":  {"cluster/subscription", "containerRegistry/subscription", "keyVault/subscription"},
+	"resourceGroup": {"cluster/resourceGroup", "containerRegistry/resourceGroup", "keyVault/resourceGroup"},
+}
+
+func legacyStatePath() string {
+	return filepath.Join(stateDir(), "state")
+}
+
+// migrate migrates the legacy state under the exclusive lock
+func migrate(ctx context.Context) error {
+	unlock, err := lock(true)
+	if err != nil {
+		return fmt.Errorf("locking state: %w", err)
+	}
+	defer unlock()
+
+	return migrateLegacy(ctx)
+}
+
+// migrateLegacy converts the gob-encoded key-value state of older plugin versions. The legacy file is kept as a
+// backup next to the state. Nothing is done if there's a state file or no legacy state file since another plugin run
+// may have migrated it while this one waited for the lock. The caller must hold the exclusive lock.
+func migrateLegacy(ctx context.Context) error {
+	path := legacyStatePath()
+	if exists(statePath()) || !exists(path) {
+		return nil
+	}
+
+	lgr := logger.FromContext(ctx).With("path", path)
+	lgr.Debug("migrating legacy state")
+
+	legacy, err := readLegacy(path)
+	if err != nil {
+		return err
+	}
+
+	// the legacy state only held the most recent answer to each prompt
+	s := newState()
+	for key, value := range legacy {
+		if kindKeys, ok := legacyKindKeys[key]; ok {
+			for _, kindKey := range kindKeys {
+				s.Defaults[kindKey] = value
+			}
+			continue
+		}
+
+		s.Defaults[key] = value
+	}
+
+	if err := write(s); err != nil {
+		return fmt.Errorf("saving migrated state: %w", err)
+	}
+
+	if err := os.Rename(path, path+".bak"); err != nil {
+		return fmt.Errorf("backing up legacy state file: %w", err)
+	}
+
+	lgr.Debug("legacy state migrated")
+	return nil
+}
+
+// readLegacy decodes the legacy state file. It's closed before returning so it can be renamed on windows.
+func readLegacy(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening legacy state file: %w", err)
+	}
+	defer f.Close()
+
+	var legacy map[string]string
+	if err := gob.NewDecoder(f).Decode(&legacy); err != nil && !errors.Is(err, io.EOF) { // empty files have no state
+		return nil, fmt.Errorf("decoding legacy state: %w", err)
+	}
+
+	return legacy, nil
+}
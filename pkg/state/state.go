@@ -1,55 +1,140 @@
-// Package state provides a simple key-value store for persisting state between plugin runs.
+// Package state persists state between plugin runs as versioned JSON with typed sections.
 // This should never be relied on but should be used to improve the user experience. Common uses
 // will be remembering the latest customer inputs and using them as defaults for the next run.
+//
+// Reads take a shared and writes an exclusive advisory lock on a lock file next to the state, so concurrent plugin
+// runs, like in a CI matrix, never see or write a partial state. Writes go to a temporary file that replaces the state
+// file so a crash mid-write leaves the previous state intact.
 package state
 
 import (
 	"context"
-	"encoding/gob"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"runtime"
-	"sync"
 
 	"github.com/azure/spin-aks-plugin/pkg/logger"
 )
 
-var (
-	// KeyNotFoundErr is returned when a key is not found in the state
-	KeyNotFoundErr = errors.New("key not found in state")
+// Version is the version of the state schema. It's increased whenever the schema changes in a way older plugin
+// versions can't read.
+const Version = 1
+
+// KeyNotFoundErr is returned when a key is not found in the state
+var KeyNotFoundErr = errors.New("key not found in state")
+
+// State is everything persisted between plugin runs
+type State struct {
+	Version int `json:"version"`
+	// Defaults are the most recent answers to prompts by prompt
+	Defaults map[string]string `json:"defaults"`
+	// Projects are the most recent answers to prompts by project, like a git remote or spin manifest path, and prompt
+	Projects map[string]map[string]string `json:"projects"`
+	// Builds are the hashes of the last build of each component by spin manifest path and component id
+	Builds map[string]map[string]string `json:"builds"`
+	// Pushed is the most recently pushed image of each application by application name
+	Pushed map[string]PushedImage `json:"pushed"`
+	// Created are the ids of the Azure resources the plugin created
+	Created []string `json:"created"`
+}
 
-	mu sync.Mutex // needed to ensure no state is lost if multiple goroutines try to save  or load state concurrently
-)
+// PushedImage is a pushed image and how it's packaged
+type PushedImage struct {
+	Image  string `json:"image"`
+	Format string `json:"format,omitempty"`
+}
 
-// Set sets a key-value pair in the state
-func Set(ctx context.Context, key string, value string) error {
-	mu.Lock()
-	defer mu.Unlock()
+// newState returns an empty state of the current version
+func newState() State {
+	return State{
+		Version:  Version,
+		Defaults: map[string]string{},
+		Projects: map[string]map[string]string{},
+		Builds:   map[string]map[string]string{},
+		Pushed:   map[string]PushedImage{},
+		Created:  []string{},
+	}
+}
+
+// Load returns the current state
+func Load(ctx context.Context) (State, error) {
+	lgr := logger.FromContext(ctx).With("path", statePath())
+	lgr.Debug("loading state")
+
+	// migrating writes so it needs the exclusive lock, checked first so reads usually only take the shared lock
+	if !exists(statePath()) && exists(legacyStatePath()) {
+		if err := migrate(ctx); err != nil {
+			return State{}, err
+		}
+	}
+
+	unlock, err := lock(false)
+	if err != nil {
+		return State{}, fmt.Errorf("locking state: %w", err)
+	}
+	defer unlock()
 
-	state, err := loadState(ctx)
+	s, err := read()
 	if err != nil {
-		return fmt.Errorf("loading state: %w", err)
+		return State{}, err
 	}
 
-	state[key] = value
-	if err := saveState(ctx, state); err != nil {
+	lgr.Debug("state loaded")
+	return s, nil
+}
+
+// Update applies fn to the current state and saves the result. No other plugin run reads or writes the state in
+// between. Nothing is saved if fn returns an error.
+func Update(ctx context.Context, fn func(s *State) error) error {
+	lgr := logger.FromContext(ctx).With("path", statePath())
+	lgr.Debug("updating state")
+
+	unlock, err := lock(true)
+	if err != nil {
+		return fmt.Errorf("locking state: %w", err)
+	}
+	defer unlock()
+
+	if err := migrateLegacy(ctx); err != nil {
+		return err
+	}
+
+	s, err := read()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(&s); err != nil {
+		return err
+	}
+
+	if err := write(s); err != nil {
 		return fmt.Errorf("saving state: %w", err)
 	}
 
+	lgr.Debug("state updated")
 	return nil
 }
 
-// Get gets a value from the state by key
+// Set remembers the value as the default of the prompt key
+func Set(ctx context.Context, key string, value string) error {
+	return Update(ctx, func(s *State) error {
+		s.Defaults[key] = value
+		return nil
+	})
+}
+
+// Get returns the default of the prompt key. KeyNotFoundErr is returned if there's none.
 func Get(ctx context.Context, key string) (string, error) {
-	state, err := loadState(ctx)
+	s, err := Load(ctx)
 	if err != nil {
-		return "", fmt.Errorf("loading state: %w", err)
+		return "", err
 	}
 
-	value, ok := state[key]
+	value, ok := s.Defaults[key]
 	if !ok {
 		return "", KeyNotFoundErr
 	}
@@ -57,65 +142,135 @@ func Get(ctx context.Context, key string) (string, error) {
 	return value, nil
 }
 
-func loadState(ctx context.Context) (map[string]string, error) {
-	path := statePath()
-	lgr := logger.FromContext(ctx).With("path", path)
-	lgr.Debug("loading state")
+// SetDefault remembers the value as the default of the prompt key in the project and globally. The project is
+// ignored if it's empty.
+func SetDefault(ctx context.Context, project, key, value string) error {
+	return Update(ctx, func(s *State) error {
+		s.Defaults[key] = value
+		if project == "" {
+			return nil
+		}
 
-	lgr.Debug("opening state file")
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-		return nil, fmt.Errorf("creating state directory: %w", err)
+		if _, ok := s.Projects[project]; !ok {
+			s.Projects[project] = map[string]string{}
+		}
+		s.Projects[project][key] = value
+		return nil
+	})
+}
+
+// GetDefault returns the default of the prompt key in the project, falling back to the global default of the prompt
+// key. KeyNotFoundErr is returned if there's neither.
+func GetDefault(ctx context.Context, project, key string) (string, error) {
+	s, err := Load(ctx)
+	if err != nil {
+		return "", err
 	}
 
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0644)
+	if value, ok := s.Projects[project][key]; ok {
+		return value, nil
+	}
+	if value, ok := s.Defaults[key]; ok {
+		return value, nil
+	}
+
+	return "", KeyNotFoundErr
+}
+
+// read decodes the state file. The state is empty if there's no state file yet. The caller must hold the lock.
+func read() (State, error) {
+	content, err := os.ReadFile(statePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return newState(), nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("opening state file: %w", err)
+		return State{}, fmt.Errorf("reading state: %w", err)
 	}
-	defer f.Close()
 
-	lgr.Debug("decoding current state")
-	var decoded map[string]string
-	d := gob.NewDecoder(f)
-	if err := d.Decode(&decoded); err != nil {
-		if errors.Is(err, io.EOF) { // if the file is empty, return an empty map
-			return map[string]string{}, nil
-		}
+	var version struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(content, &version); err != nil {
+		return State{}, fmt.Errorf("decoding state version: %w", err)
+	}
+	if version.Version > Version {
+		return State{}, fmt.Errorf("state version %d is newer than the supported version %d, upgrade the plugin", version.Version, Version)
+	}
 
-		return nil, fmt.Errorf("decoding state: %w", err)
+	s := newState()
+	if err := json.Unmarshal(content, &s); err != nil {
+		return State{}, fmt.Errorf("decoding state: %w", err)
 	}
+	s.Version = Version
 
-	lgr.Debug("state loaded")
-	return decoded, nil
+	// sections missing from the file decode as nil
+	if s.Defaults == nil {
+		s.Defaults = map[string]string{}
+	}
+	if s.Projects == nil {
+		s.Projects = map[string]map[string]string{}
+	}
+	if s.Builds == nil {
+		s.Builds = map[string]map[string]string{}
+	}
+	if s.Pushed == nil {
+		s.Pushed = map[string]PushedImage{}
+	}
+	if s.Created == nil {
+		s.Created = []string{}
+	}
+
+	return s, nil
 }
 
-func saveState(ctx context.Context, state map[string]string) error {
+// write atomically replaces the state file. The caller must hold the exclusive lock.
+func write(s State) error {
 	path := statePath()
-	lgr := logger.FromContext(ctx).With("path", path)
-	lgr.Debug("saving state")
-
-	lgr.Debug("opening state file")
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return fmt.Errorf("creating state directory: %w", err)
 	}
 
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	content, err := json.MarshalIndent(s, "", "  ")
 	if err != nil {
-		return fmt.Errorf("opening state file: %w", err)
+		return fmt.Errorf("encoding state: %w", err)
 	}
-	defer f.Close()
 
-	lgr.Debug("encoding state")
-	e := gob.NewEncoder(f)
-	if err := e.Encode(state); err != nil {
-		return fmt.Errorf("encoding state: %w", err)
+	// the temporary file is in the same directory so renaming it can't cross filesystems
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating temporary state file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return fmt.Errorf("writing temporary state file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("syncing temporary state file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing temporary state file: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("replacing state file: %w", err)
 	}
 
-	lgr.Debug("state saved")
 	return nil
 }
 
+func stateDir() string {
+	return filepath.Join(dataDir(), "spin", "plugins", "aks")
+}
+
 func statePath() string {
-	return filepath.Join(dataDir(), "spin", "plugins", "aks", "state")
+	return filepath.Join(stateDir(), "state.json")
+}
+
+func lockPath() string {
+	return filepath.Join(stateDir(), "state.lock")
 }
 
 // dataDir returns the path to the data directory according to the Spin spec
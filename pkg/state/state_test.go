@@ -0,0 +1,233 @@
+package state
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func isolateState(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_DATA_HOME", dir)
+}
+
+func writeLegacy(t *testing.T, legacy map[string]string) {
+	t.Helper()
+
+	if err := os.MkdirAll(stateDir(), 0755); err != nil {
+		t.Fatalf("creating state directory: %s", err)
+	}
+
+	f, err := os.Create(legacyStatePath())
+	if err != nil {
+		t.Fatalf("creating legacy state: %s", err)
+	}
+	defer f.Close()
+
+	if err := gob.NewEncoder(f).Encode(legacy); err != nil {
+		t.Fatalf("encoding legacy state: %s", err)
+	}
+}
+
+func TestSetGet(t *testing.T) {
+	g := NewWithT(t)
+	isolateState(t)
+	ctx := context.Background()
+
+	_, err := Get(ctx, "subscription")
+	g.Expect(err).To(MatchError(KeyNotFoundErr))
+
+	g.Expect(Set(ctx, "subscription", "sub")).To(Succeed())
+	value, err := Get(ctx, "subscription")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(value).To(Equal("sub"))
+
+	content, err := os.ReadFile(statePath())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(content)).To(ContainSubstring(`"version": 1`))
+	g.Expect(string(content)).To(ContainSubstring(`"subscription": "sub"`))
+}
+
+func TestDefaultsByProject(t *testing.T) {
+	g := NewWithT(t)
+	isolateState(t)
+	ctx := context.Background()
+
+	_, err := GetDefault(ctx, "project", "cluster")
+	g.Expect(err).To(MatchError(KeyNotFoundErr))
+
+	g.Expect(SetDefault(ctx, "project", "cluster", "project-cluster")).To(Succeed())
+	g.Expect(SetDefault(ctx, "other", "cluster", "other-cluster")).To(Succeed())
+
+	value, err := GetDefault(ctx, "project", "cluster")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(value).To(Equal("project-cluster"))
+
+	// projects without a default fall back to the last one set in any project
+	value, err = GetDefault(ctx, "new", "cluster")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(value).To(Equal("other-cluster"))
+
+	value, err = Get(ctx, "cluster")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(value).To(Equal("other-cluster"))
+}
+
+func TestUpdateError(t *testing.T) {
+	g := NewWithT(t)
+	isolateState(t)
+	ctx := context.Background()
+
+	g.Expect(Set(ctx, "cluster", "before")).To(Succeed())
+
+	err := Update(ctx, func(s *State) error {
+		s.Defaults["cluster"] = "after"
+		return fmt.Errorf("failed")
+	})
+	g.Expect(err).To(MatchError("failed"))
+
+	value, err := Get(ctx, "cluster")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(value).To(Equal("before"))
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	g := NewWithT(t)
+	isolateState(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- Update(ctx, func(s *State) error {
+				s.Created = append(s.Created, fmt.Sprintf("id-%d", i))
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+
+	s, err := Load(ctx)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(s.Created).To(HaveLen(20))
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	g := NewWithT(t)
+	isolateState(t)
+	ctx := context.Background()
+
+	legacy := map[string]string{
+		"subscription":  "sub",
+		"resourceGroup": "rg",
+		"cluster":       "cluster",
+		"spinManifest":  "/app/spin.toml",
+	}
+	writeLegacy(t, legacy)
+
+	s, err := Load(ctx)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(s).To(Equal(State{
+		Version: Version,
+		// the defaults shared by every resource kind are the defaults of each kind
+		Defaults: map[string]string{
+			"cluster/subscription":            "sub",
+			"containerRegistry/subscription":  "sub",
+			"keyVault/subscription":           "sub",
+			"cluster/resourceGroup":           "rg",
+			"containerRegistry/resourceGroup": "rg",
+			"keyVault/resourceGroup":          "rg",
+			"cluster":                         "cluster",
+			"spinManifest":                    "/app/spin.toml",
+		},
+		Projects: map[string]map[string]string{},
+		Builds:   map[string]map[string]string{},
+		Pushed:   map[string]PushedImage{},
+		Created:  []string{},
+	}))
+
+	g.Expect(legacyStatePath()).NotTo(BeAnExistingFile())
+	g.Expect(legacyStatePath() + ".bak").To(BeAnExistingFile())
+	g.Expect(statePath()).To(BeAnExistingFile())
+
+	// the migrated state is read from the new file afterwards
+	again, err := Load(ctx)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(again).To(Equal(s))
+}
+
+func TestMigrateLegacyConcurrently(t *testing.T) {
+	g := NewWithT(t)
+	isolateState(t)
+	ctx := context.Background()
+
+	writeLegacy(t, map[string]string{"cluster": "cluster"})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := Load(ctx)
+			if err == nil && s.Defaults["cluster"] != "cluster" {
+				err = fmt.Errorf("cluster default %q wasn't migrated", s.Defaults["cluster"])
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+	g.Expect(legacyStatePath() + ".bak").To(BeAnExistingFile())
+}
+
+func TestNewerVersion(t *testing.T) {
+	g := NewWithT(t)
+	isolateState(t)
+	ctx := context.Background()
+
+	g.Expect(os.MkdirAll(stateDir(), 0755)).To(Succeed())
+	g.Expect(os.WriteFile(statePath(), []byte(`{"version": 2}`), 0644)).To(Succeed())
+
+	_, err := Load(ctx)
+	g.Expect(err).To(MatchError(ContainSubstring("state version 2 is newer")))
+
+	err = Set(ctx, "subscription", "sub")
+	g.Expect(err).To(HaveOccurred())
+
+	content, err := os.ReadFile(statePath())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(content)).To(Equal(`{"version": 2}`))
+}
+
+func TestNoTemporaryFilesLeft(t *testing.T) {
+	g := NewWithT(t)
+	isolateState(t)
+	ctx := context.Background()
+
+	g.Expect(Set(ctx, "subscription", "sub")).To(Succeed())
+
+	tmp, err := filepath.Glob(filepath.Join(stateDir(), "*.tmp"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(tmp).To(BeEmpty())
+}
@@ -0,0 +1,387 @@
+// Package status reads the live state of a Spin application on a cluster
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// maxEvents is the number of recent Warning events shown
+	maxEvents = 10
+	// minRewatchDelay and maxRewatchDelay bound the backoff between attempts to start an ended watch again
+	minRewatchDelay = 500 * time.Millisecond
+	maxRewatchDelay = 30 * time.Second
+)
+
+// Status is the live state of an application. The generated manifests put every object of the application in a
+// namespace with the same name as the application.
+type Status struct {
+	Name       string      `json:"name"`
+	Namespace  string      `json:"namespace"`
+	Deployment *Deployment `json:"deployment,omitempty"`
+	Pods       []Pod       `json:"pods"`
+	Events     []Event     `json:"events"`
+	// Endpoints are the external IPs and hostnames of the Service and the hosts of Ingresses
+	Endpoints []string `json:"endpoints"`
+	// Image is the image the Deployment runs
+	Image string `json:"image,omitempty"`
+	// PushedImage is the most recently pushed image of the application
+	PushedImage string `json:"pushedImage,omitempty"`
+}
+
+// Deployment is the rollout state of the application's Deployment
+type Deployment struct {
+	Desired   int32 `json:"desired"`
+	Ready     int32 `json:"ready"`
+	Updated   int32 `json:"updated"`
+	Available int32 `json:"available"`
+}
+
+// Pod is the state of a pod of the application
+type Pod struct {
+	Name     string `json:"name"`
+	Phase    string `json:"phase"`
+	Ready    bool   `json:"ready"`
+	Restarts int32  `json:"restarts"`
+	Image    string `json:"image,omitempty"`
+}
+
+// Event is a Warning event about an object in the application's namespace
+type Event struct {
+	Object   string    `json:"object"`
+	Reason   string    `json:"reason"`
+	Message  string    `json:"message"`
+	Count    int32     `json:"count"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// Get returns the live state of the application
+func Get(ctx context.Context, kube kubernetes.Interface, name string) (*Status, error) {
+	lgr := logger.FromContext(ctx).With("name", name)
+	lgr.Debug("getting app status")
+
+	s := &Status{
+		Name:      name,
+		Namespace: name,
+		Pods:      []Pod{},
+		Events:    []Event{},
+		Endpoints: []string{},
+	}
+
+	dep, err := kube.AppsV1().Deployments(s.Namespace).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		lgr.Debug("deployment not found")
+	case err != nil:
+		return nil, fmt.Errorf("getting deployment: %w", err)
+	default:
+		s.Deployment = &Deployment{
+			Ready:     dep.Status.ReadyReplicas,
+			Updated:   dep.Status.UpdatedReplicas,
+			Available: dep.Status.AvailableReplicas,
+		}
+		if dep.Spec.Replicas != nil {
+			s.Deployment.Desired = *dep.Spec.Replicas
+		}
+		for _, c := range dep.Spec.Template.Spec.Containers {
+			if c.Name == name {
+				s.Image = c.Image
+			}
+		}
+	}
+
+	pods, err := kube.CoreV1().Pods(s.Namespace).List(ctx, metav1.ListOptions{LabelSelector: "app=" + name})
+	if err != nil {
+		return nil, fmt.Errorf("listing pods: %w", err)
+	}
+	for _, p := range pods.Items {
+		s.Pods = append(s.Pods, newPod(p, name))
+	}
+	sort.Slice(s.Pods, func(i, j int) bool { return s.Pods[i].Name < s.Pods[j].Name })
+
+	events, err := kube.CoreV1().Events(s.Namespace).List(ctx, metav1.ListOptions{FieldSelector: "type=" + corev1.EventTypeWarning})
+	if err != nil {
+		return nil, fmt.Errorf("listing events: %w", err)
+	}
+	for _, e := range events.Items {
+		if e.Type != corev1.EventTypeWarning {
+			continue
+		}
+
+		s.Events = append(s.Events, Event{
+			Object:   strings.ToLower(e.InvolvedObject.Kind) + "/" + e.InvolvedObject.Name,
+			Reason:   e.Reason,
+			Message:  e.Message,
+			Count:    e.Count,
+			LastSeen: lastSeen(e),
+		})
+	}
+	sort.SliceStable(s.Events, func(i, j int) bool { return s.Events[i].LastSeen.After(s.Events[j].LastSeen) })
+	if len(s.Events) > maxEvents {
+		s.Events = s.Events[:maxEvents]
+	}
+
+	svc, err := kube.CoreV1().Services(s.Namespace).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		lgr.Debug("service not found")
+	case err != nil:
+		return nil, fmt.Errorf("getting service: %w", err)
+	default:
+		for _, ing := range svc.Status.LoadBalancer.Ingress {
+			s.Endpoints = append(s.Endpoints, loadBalancerAddress(ing.IP, ing.Hostname))
+		}
+		if svc.Spec.Type == corev1.ServiceTypeLoadBalancer && len(svc.Status.LoadBalancer.Ingress) == 0 {
+			s.Endpoints = append(s.Endpoints, "<pending>")
+		}
+	}
+
+	ingresses, err := kube.NetworkingV1().Ingresses(s.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing ingresses: %w", err)
+	}
+	for _, ing := range ingresses.Items {
+		for _, rule := range ing.Spec.Rules {
+			if rule.Host != "" {
+				s.Endpoints = append(s.Endpoints, rule.Host)
+			}
+		}
+	}
+
+	lgr.Debug("finished getting app status")
+	return s, nil
+}
+
+// Watch calls fn with the state of the application whenever it changes until the context is done
+func Watch(ctx context.Context, kube kubernetes.Interface, name string, fn func(*Status) error) error {
+	lgr := logger.FromContext(ctx).With("name", name)
+	lgr.Debug("watching app status")
+
+	opts := metav1.ListOptions{}
+	starts := map[string]func() (watch.Interface, error){
+		"deployments": func() (watch.Interface, error) { return kube.AppsV1().Deployments(name).Watch(ctx, opts) },
+		"pods":        func() (watch.Interface, error) { return kube.CoreV1().Pods(name).Watch(ctx, opts) },
+		"events":      func() (watch.Interface, error) { return kube.CoreV1().Events(name).Watch(ctx, opts) },
+		"services":    func() (watch.Interface, error) { return kube.CoreV1().Services(name).Watch(ctx, opts) },
+		"ingresses":   func() (watch.Interface, error) { return kube.NetworkingV1().Ingresses(name).Watch(ctx, opts) },
+	}
+
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default: // a refresh is already pending
+		}
+	}
+
+	for kind, start := range starts {
+		w, err := start()
+		if err != nil {
+			return fmt.Errorf("watching %s: %w", kind, err)
+		}
+
+		go follow(ctx, kind, w, start, notify)
+	}
+
+	var last []byte
+	refresh := func() error {
+		s, err := Get(ctx, kube, name)
+		if err != nil {
+			return fmt.Errorf("getting status: %w", err)
+		}
+
+		current, err := json.Marshal(s)
+		if err != nil {
+			return fmt.Errorf("marshaling status: %w", err)
+		}
+		if string(current) == string(last) {
+			return nil
+		}
+		last = current
+
+		return fn(s)
+	}
+
+	if err := refresh(); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			lgr.Debug("finished watching app status")
+			return nil
+		case <-changed:
+			if err := refresh(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// follow calls notify on every change the watch sees until the context is done. The api server ends watches after a
+// timeout so ended watches are started again, backing off while starting them fails. Every restart notifies since
+// changes may have been missed in between.
+func follow(ctx context.Context, kind string, w watch.Interface, start func() (watch.Interface, error), notify func()) {
+	lgr := logger.FromContext(ctx).With("kind", kind)
+	defer func() { w.Stop() }()
+
+	delay := minRewatchDelay
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-w.ResultChan():
+			if ok && e.Type != watch.Error {
+				delay = minRewatchDelay
+				notify()
+				continue
+			}
+		}
+
+		lgr.Debug("watch ended, watching again")
+		w.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, maxRewatchDelay)
+
+			next, err := start()
+			if err == nil {
+				w = next
+				break
+			}
+			lgr.Debug("failed to watch again", "error", err.Error())
+		}
+		notify()
+	}
+}
+
+// Print writes the status in a human readable format
+func Print(w io.Writer, s *Status) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "App:\t%s (namespace %s)\n", s.Name, s.Namespace)
+	if s.Deployment == nil {
+		fmt.Fprintf(tw, "Deployment:\tnot deployed\n")
+	} else {
+		fmt.Fprintf(tw, "Deployment:\t%d/%d ready, %d updated, %d available\n", s.Deployment.Ready, s.Deployment.Desired, s.Deployment.Updated, s.Deployment.Available)
+	}
+
+	image := s.Image
+	if image == "" {
+		image = "<none>"
+	}
+	switch {
+	case s.PushedImage == "":
+		fmt.Fprintf(tw, "Image:\t%s\n", image)
+	case s.PushedImage == s.Image || strings.HasPrefix(s.Image, s.PushedImage+"@"):
+		// deploys requiring signed images pin the pushed tag to the verified digest
+		fmt.Fprintf(tw, "Image:\t%s (latest pushed)\n", image)
+	default:
+		fmt.Fprintf(tw, "Image:\t%s (latest pushed is %s)\n", image, s.PushedImage)
+	}
+
+	endpoints := strings.Join(s.Endpoints, ", ")
+	if endpoints == "" {
+		endpoints = "<none>"
+	}
+	fmt.Fprintf(tw, "Endpoints:\t%s\n", endpoints)
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("writing summary: %w", err)
+	}
+
+	fmt.Fprintln(w, "\nPods:")
+	if len(s.Pods) == 0 {
+		fmt.Fprintln(w, "  <none>")
+	} else {
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  NAME\tPHASE\tREADY\tRESTARTS")
+		for _, p := range s.Pods {
+			fmt.Fprintf(tw, "  %s\t%s\t%t\t%d\n", p.Name, p.Phase, p.Ready, p.Restarts)
+		}
+		if err := tw.Flush(); err != nil {
+			return fmt.Errorf("writing pods: %w", err)
+		}
+	}
+
+	fmt.Fprintln(w, "\nRecent warning events:")
+	if len(s.Events) == 0 {
+		fmt.Fprintln(w, "  <none>")
+		return nil
+	}
+
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  LAST SEEN\tOBJECT\tREASON\tMESSAGE")
+	for _, e := range s.Events {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", age(e.LastSeen), e.Object, e.Reason, e.Message)
+	}
+
+	return tw.Flush()
+}
+
+func newPod(p corev1.Pod, container string) Pod {
+	ret := Pod{
+		Name:  p.Name,
+		Phase: string(p.Status.Phase),
+	}
+
+	for _, c := range p.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			ret.Ready = c.Status == corev1.ConditionTrue
+		}
+	}
+
+	for _, cs := range p.Status.ContainerStatuses {
+		ret.Restarts += cs.RestartCount
+		if cs.Name == container {
+			ret.Image = cs.Image
+		}
+	}
+
+	return ret
+}
+
+func lastSeen(e corev1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	default:
+		return e.FirstTimestamp.Time
+	}
+}
+
+func loadBalancerAddress(ip, hostname string) string {
+	if hostname != "" {
+		return hostname
+	}
+
+	return ip
+}
+
+func age(t time.Time) string {
+	if t.IsZero() {
+		return "<unknown>"
+	}
+
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
@@ -0,0 +1,247 @@
+package status
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func appObjects() []runtime.Object {
+	return []runtime.Object{
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "app"},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: to.Ptr(int32(3)),
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: "app", Image: "acr.azurecr.io/app:v1"}},
+					},
+				},
+			},
+			Status: appsv1.DeploymentStatus{ReadyReplicas: 2, UpdatedReplicas: 3, AvailableReplicas: 2},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-b", Namespace: "app", Labels: map[string]string{"app": "app"}},
+			Status: corev1.PodStatus{
+				Phase:             corev1.PodPending,
+				ContainerStatuses: []corev1.ContainerStatus{{Name: "app", RestartCount: 4}},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-a", Namespace: "app", Labels: map[string]string{"app": "app"}},
+			Status: corev1.PodStatus{
+				Phase:             corev1.PodRunning,
+				Conditions:        []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+				ContainerStatuses: []corev1.ContainerStatus{{Name: "app", Image: "acr.azurecr.io/app:v1"}},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "app", Labels: map[string]string{"app": "other"}},
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "old", Namespace: "app"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "app-b"},
+			Type:           corev1.EventTypeWarning,
+			Reason:         "FailedScheduling",
+			LastTimestamp:  metav1.NewTime(time.Now().Add(-time.Hour)),
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "new", Namespace: "app"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "app-b"},
+			Type:           corev1.EventTypeWarning,
+			Reason:         "BackOff",
+			Message:        "Back-off restarting failed container",
+			LastTimestamp:  metav1.NewTime(time.Now().Add(-time.Minute)),
+		},
+		&corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{Name: "normal", Namespace: "app"},
+			Type:       corev1.EventTypeNormal,
+			Reason:     "Scheduled",
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "app"},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+			Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{{IP: "20.1.2.3"}},
+			}},
+		},
+		&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "app"},
+			Spec:       networkingv1.IngressSpec{Rules: []networkingv1.IngressRule{{Host: "app.example.com"}}},
+		},
+	}
+}
+
+func TestGet(t *testing.T) {
+	g := NewWithT(t)
+	kube := kubefake.NewSimpleClientset(appObjects()...)
+
+	s, err := Get(context.Background(), kube, "app")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(s.Deployment).To(Equal(&Deployment{Desired: 3, Ready: 2, Updated: 3, Available: 2}))
+	g.Expect(s.Image).To(Equal("acr.azurecr.io/app:v1"))
+	g.Expect(s.Pods).To(Equal([]Pod{
+		{Name: "app-a", Phase: "Running", Ready: true, Image: "acr.azurecr.io/app:v1"},
+		{Name: "app-b", Phase: "Pending", Restarts: 4},
+	}))
+	g.Expect(s.Events).To(HaveLen(2))
+	g.Expect(s.Events[0].Reason).To(Equal("BackOff"))
+	g.Expect(s.Events[0].Object).To(Equal("pod/app-b"))
+	g.Expect(s.Events[1].Reason).To(Equal("FailedScheduling"))
+	g.Expect(s.Endpoints).To(Equal([]string{"20.1.2.3", "app.example.com"}))
+
+	s.PushedImage = "acr.azurecr.io/app:v2"
+	var out bytes.Buffer
+	g.Expect(Print(&out, s)).To(Succeed())
+	g.Expect(out.String()).To(ContainSubstring("2/3 ready"))
+	g.Expect(out.String()).To(ContainSubstring("latest pushed is acr.azurecr.io/app:v2"))
+	g.Expect(out.String()).To(ContainSubstring("Back-off restarting failed container"))
+
+	s.Image, s.PushedImage = "acr.azurecr.io/app:v2@sha256:abc", "acr.azurecr.io/app:v2"
+	out.Reset()
+	g.Expect(Print(&out, s)).To(Succeed())
+	g.Expect(out.String()).To(ContainSubstring("acr.azurecr.io/app:v2@sha256:abc (latest pushed)"))
+}
+
+func TestGetNotDeployed(t *testing.T) {
+	g := NewWithT(t)
+	kube := kubefake.NewSimpleClientset()
+
+	s, err := Get(context.Background(), kube, "app")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(s.Deployment).To(BeNil())
+	g.Expect(s.Pods).To(BeEmpty())
+	g.Expect(s.Endpoints).To(BeEmpty())
+
+	var out bytes.Buffer
+	g.Expect(Print(&out, s)).To(Succeed())
+	g.Expect(out.String()).To(ContainSubstring("not deployed"))
+}
+
+func TestWatch(t *testing.T) {
+	g := NewWithT(t)
+	kube := kubefake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates := make(chan *Status, 10)
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, kube, "app", func(s *Status) error {
+			updates <- s
+			return nil
+		})
+	}()
+
+	var first *Status
+	g.Eventually(updates).Should(Receive(&first))
+	g.Expect(first.Pods).To(BeEmpty())
+
+	_, err := kube.CoreV1().Pods("app").Create(ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-a", Labels: map[string]string{"app": "app"}},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}, metav1.CreateOptions{})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	var next *Status
+	g.Eventually(updates).Should(Receive(&next))
+	g.Expect(next.Pods).To(Equal([]Pod{{Name: "app-a", Phase: "Running"}}))
+
+	cancel()
+	g.Eventually(done).Should(Receive(BeNil()))
+}
+
+func TestWatchWatchesAgain(t *testing.T) {
+	g := NewWithT(t)
+	kube := kubefake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the first pod watch is ended like the api server ends watches after a timeout
+	first := watch.NewFake()
+	var mu sync.Mutex
+	watches := 0
+	kube.PrependWatchReactor("pods", func(k8stesting.Action) (bool, watch.Interface, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		watches++
+		return watches == 1, first, nil
+	})
+	watched := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return watches
+	}
+
+	updates := make(chan *Status, 10)
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, kube, "app", func(s *Status) error {
+			updates <- s
+			return nil
+		})
+	}()
+
+	g.Eventually(updates).Should(Receive())
+	first.Stop()
+	g.Eventually(watched).WithTimeout(5 * time.Second).Should(Equal(2))
+
+	_, err := kube.CoreV1().Pods("app").Create(ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-a", Labels: map[string]string{"app": "app"}},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}, metav1.CreateOptions{})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	var next *Status
+	g.Eventually(updates).Should(Receive(&next))
+	g.Expect(next.Pods).To(Equal([]Pod{{Name: "app-a", Phase: "Running"}}))
+
+	cancel()
+	g.Eventually(done).Should(Receive(BeNil()))
+}
+
+func TestWatchIngresses(t *testing.T) {
+	g := NewWithT(t)
+	kube := kubefake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates := make(chan *Status, 10)
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, kube, "app", func(s *Status) error {
+			updates <- s
+			return nil
+		})
+	}()
+
+	g.Eventually(updates).Should(Receive())
+
+	_, err := kube.NetworkingV1().Ingresses("app").Create(ctx, &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "app"},
+		Spec:       networkingv1.IngressSpec{Rules: []networkingv1.IngressRule{{Host: "app.example.com"}}},
+	}, metav1.CreateOptions{})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	var next *Status
+	g.Eventually(updates).Should(Receive(&next))
+	g.Expect(next.Endpoints).To(Equal([]string{"app.example.com"}))
+
+	cancel()
+	g.Eventually(done).Should(Receive(BeNil()))
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+// GitCommit returns the commit checked out in the git repository containing dir and whether the working tree has
+// uncommitted changes
+func GitCommit(ctx context.Context, dir string) (string, bool, error) {
+	commit, err := git(ctx, dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", false, err
+	}
+
+	status, err := git(ctx, dir, "status", "--porcelain")
+	if err != nil {
+		return "", false, err
+	}
+
+	return commit, status != "", nil
+}
+
+// GitRoot returns the absolute path of the top level directory of the git repository containing dir
+func GitRoot(ctx context.Context, dir string) (string, error) {
+	return git(ctx, dir, "rev-parse", "--show-toplevel")
+}
+
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("running git %s: %w", strings.Join(args, " "), err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// GitRemote returns the URL of the origin remote of the git repository containing dir. Credentials in the URL are
+// removed.
+func GitRemote(ctx context.Context, dir string) (string, error) {
+	remote, err := git(ctx, dir, "remote", "get-url", "origin")
+	if err != nil {
+		return "", err
+	}
+
+	if u, err := url.Parse(remote); err == nil && u.User != nil {
+		u.User = nil
+		remote = u.String()
+	}
+
+	return remote, nil
+}
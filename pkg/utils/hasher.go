@@ -12,6 +12,12 @@ import (
 
 // HashDirectories computes the SHA256 hash for a set of directories.
 func HashDirectories(dirs ...string) (string, error) {
+	return HashDirectoriesFiltered(nil, dirs...)
+}
+
+// HashDirectoriesFiltered computes the SHA256 hash for a set of directories skipping the files and directories skip
+// returns true for. A nil skip hashes everything.
+func HashDirectoriesFiltered(skip func(path string, isDir bool) bool, dirs ...string) (string, error) {
 	var allHashes []string
 	for _, dir := range dirs {
 		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
@@ -19,6 +25,14 @@ func HashDirectories(dirs ...string) (string, error) {
 				return err
 			}
 
+			if skip != nil && path != dir && skip(path, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
 			if !info.IsDir() {
 				fileHash, err := hashFile(path)
 				if err != nil {
@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Ignore matches paths against gitignore style patterns relative to a base directory. It supports comments,
+// negation with !, directory-only patterns with a trailing /, anchoring with a leading or inner /, and the *, ?, and
+// ** wildcards.
+type Ignore struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	// base is the directory the pattern is relative to
+	base    string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// NewIgnore returns an Ignore for the patterns relative to the base directory
+func NewIgnore(base string, patterns ...string) (*Ignore, error) {
+	i := &Ignore{}
+	for _, pattern := range patterns {
+		if err := i.add(base, pattern); err != nil {
+			return nil, fmt.Errorf("adding pattern %q: %w", pattern, err)
+		}
+	}
+
+	return i, nil
+}
+
+// LoadGitignore returns an Ignore for the .gitignore file in the directory. A missing file ignores nothing.
+func LoadGitignore(dir string) (*Ignore, error) {
+	f, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if errors.Is(err, os.ErrNotExist) {
+		return NewIgnore(dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening .gitignore: %w", err)
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		patterns = append(patterns, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading .gitignore: %w", err)
+	}
+
+	return NewIgnore(dir, patterns...)
+}
+
+// LoadGitignores returns an Ignore for the .gitignore files in the directory and every parent directory up to the
+// root of the git repository containing it. Like git, patterns of deeper files take precedence. Only the directory's
+// .gitignore is loaded if it isn't in a git repository.
+func LoadGitignores(ctx context.Context, dir string) (*Ignore, error) {
+	dirs := []string{dir}
+	if root, err := GitRoot(ctx, dir); err == nil {
+		dirs = parentsUpTo(root, dir)
+	}
+
+	i := &Ignore{}
+	for j := len(dirs) - 1; j >= 0; j-- {
+		gitignore, err := LoadGitignore(dirs[j])
+		if err != nil {
+			return nil, err
+		}
+		i.rules = append(i.rules, gitignore.rules...)
+	}
+
+	return i, nil
+}
+
+// parentsUpTo returns the directory followed by its parents up to root. Only the directory is returned if root isn't
+// the directory or one of its parents. git resolves symlinks in root so they're resolved to compare the two.
+func parentsUpTo(root, dir string) []string {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return []string{dir}
+	}
+	resolvedDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return []string{dir}
+	}
+
+	rel, err := filepath.Rel(resolvedRoot, resolvedDir)
+	if err != nil || outside(rel) {
+		return []string{dir}
+	}
+
+	// the parents are taken from dir, not the resolved path, so they're in the same form as the matched paths
+	dirs := []string{dir}
+	if rel == "." {
+		return dirs
+	}
+	for range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Dir(dir)
+		dirs = append(dirs, dir)
+	}
+
+	return dirs
+}
+
+// outside returns true if the relative path leaves its base directory. Names starting with two dots, like ..foo,
+// are inside it.
+func outside(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Match returns true if the path is ignored. Paths outside the base directory of a pattern are never matched by it.
+func (i *Ignore) Match(path string, isDir bool) bool {
+	ignored := false
+	for _, r := range i.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+
+		rel, err := filepath.Rel(r.base, path)
+		if err != nil || rel == "." || outside(rel) {
+			continue
+		}
+
+		if r.re.MatchString(filepath.ToSlash(rel)) {
+			ignored = !r.negate
+		}
+	}
+
+	return ignored
+}
+
+func (i *Ignore) add(base, pattern string) error {
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return nil
+	}
+
+	r := ignoreRule{base: base}
+	if strings.HasPrefix(pattern, "!") {
+		r.negate = true
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		r.dirOnly = true
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+
+	// patterns with a slash are relative to the base, others match at any depth
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var expr strings.Builder
+	if anchored {
+		expr.WriteString("^")
+	} else {
+		expr.WriteString("^(.*/)?")
+	}
+
+	for j := 0; j < len(pattern); j++ {
+		switch c := pattern[j]; {
+		case strings.HasPrefix(pattern[j:], "**/"):
+			expr.WriteString("(.*/)?")
+			j += 2
+		case strings.HasPrefix(pattern[j:], "/**") && j+3 == len(pattern):
+			expr.WriteString("/.*")
+			j += 2
+		case strings.HasPrefix(pattern[j:], "**"):
+			expr.WriteString(".*")
+			j++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return fmt.Errorf("compiling pattern: %w", err)
+	}
+
+	r.re = re
+	i.rules = append(i.rules, r)
+	return nil
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestIgnore(t *testing.T) {
+	base := filepath.Join("/", "app")
+	ignore, err := NewIgnore(base,
+		"# comment",
+		"",
+		"target/",
+		"*.log",
+		"/build",
+		"docs/**/*.md",
+		"!keep.log",
+	)
+	NewWithT(t).Expect(err).NotTo(HaveOccurred())
+
+	tests := []struct {
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{path: "target", isDir: true, expected: true},
+		{path: "nested/target", isDir: true, expected: true},
+		{path: "target", isDir: false, expected: false},
+		{path: "debug.log", expected: true},
+		{path: "nested/debug.log", expected: true},
+		{path: "keep.log", expected: false},
+		{path: "build", isDir: true, expected: true},
+		{path: "nested/build", isDir: true, expected: false},
+		{path: "docs/readme.md", expected: true},
+		{path: "docs/a/b/readme.md", expected: true},
+		{path: "readme.md", expected: false},
+		{path: "src/lib.rs", expected: false},
+		{path: "../outside.log", expected: false},
+		{path: "..inside.log", expected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(ignore.Match(filepath.Join(base, test.path), test.isDir)).To(Equal(test.expected))
+		})
+	}
+}
+
+func TestLoadGitignores(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	repo := t.TempDir()
+	app := filepath.Join(repo, "apps", "api")
+	g.Expect(os.MkdirAll(app, 0755)).To(Succeed())
+
+	// outside of git only the directory's .gitignore is loaded
+	g.Expect(os.WriteFile(filepath.Join(repo, ".gitignore"), []byte("target/\n*.log\n"), 0644)).To(Succeed())
+	g.Expect(os.WriteFile(filepath.Join(app, ".gitignore"), []byte("!keep.log\n"), 0644)).To(Succeed())
+	ignore, err := LoadGitignores(ctx, app)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ignore.Match(filepath.Join(app, "target"), true)).To(BeFalse())
+
+	cmd := exec.Command("git", "init", "-q")
+	cmd.Dir = repo
+	out, err := cmd.CombinedOutput()
+	g.Expect(err).NotTo(HaveOccurred(), string(out))
+
+	// the .gitignore files from the repository root down apply, deeper ones taking precedence
+	ignore, err = LoadGitignores(ctx, app)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ignore.Match(filepath.Join(app, "target"), true)).To(BeTrue())
+	g.Expect(ignore.Match(filepath.Join(app, "debug.log"), false)).To(BeTrue())
+	g.Expect(ignore.Match(filepath.Join(app, "keep.log"), false)).To(BeFalse())
+	g.Expect(ignore.Match(filepath.Join(app, "src", "lib.rs"), false)).To(BeFalse())
+}
+
+func TestHashDirectoriesFiltered(t *testing.T) {
+	g := NewWithT(t)
+
+	hash, err := HashDirectoriesFiltered(func(string, bool) bool { return false }, "testdata")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(hash).To(Equal(testdataSha))
+
+	hash, err = HashDirectoriesFiltered(func(path string, _ bool) bool { return filepath.Base(path) == "testfile.txt" }, "testdata")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(hash).NotTo(Equal(testdataSha))
+}
@@ -0,0 +1,320 @@
+// Package wasm reads the imports and exports of built wasm modules and checks them against the host interfaces the
+// containerd-shim-spin on AKS provides
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	magic = []byte{0x00, 'a', 's', 'm'}
+	// moduleVersion is the binary version of core wasm modules
+	moduleVersion = []byte{0x01, 0x00, 0x00, 0x00}
+	// componentVersion is the binary version and layer of wasm components
+	componentVersion = []byte{0x0d, 0x00, 0x01, 0x00}
+
+	NotWasmErr = errors.New("not a wasm binary")
+)
+
+const (
+	importSection = 2
+	exportSection = 7
+)
+
+// Kind is the kind of an import or export
+type Kind byte
+
+const (
+	Func   Kind = 0x00
+	Table  Kind = 0x01
+	Memory Kind = 0x02
+	Global Kind = 0x03
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Func:
+		return "func"
+	case Table:
+		return "table"
+	case Memory:
+		return "memory"
+	case Global:
+		return "global"
+	default:
+		return fmt.Sprintf("kind(%d)", byte(k))
+	}
+}
+
+// Import is an import of a wasm module like wasi_snapshot_preview1.fd_write
+type Import struct {
+	Module string
+	Name   string
+	Kind   Kind
+}
+
+// Export is an export of a wasm module like handle-http-request
+type Export struct {
+	Name string
+	Kind Kind
+}
+
+// Module is the imports and exports of a wasm binary
+type Module struct {
+	// Component is true for wasm components. Their imports and exports aren't read.
+	Component bool
+	Imports   []Import
+	Exports   []Export
+}
+
+// ImportModules returns the distinct modules imported in the order they're first imported
+func (m Module) ImportModules() []string {
+	seen := map[string]bool{}
+	var modules []string
+	for _, i := range m.Imports {
+		if !seen[i.Module] {
+			seen[i.Module] = true
+			modules = append(modules, i.Module)
+		}
+	}
+
+	return modules
+}
+
+// ExportsFunc returns true if the module exports a function with the name
+func (m Module) ExportsFunc(name string) bool {
+	for _, e := range m.Exports {
+		if e.Kind == Func && e.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseFile reads the imports and exports of the wasm file
+func ParseFile(path string) (Module, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Module{}, fmt.Errorf("reading wasm file: %w", err)
+	}
+
+	m, err := Parse(b)
+	if err != nil {
+		return Module{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	return m, nil
+}
+
+// Parse reads the imports and exports of a wasm binary. Only the import and export sections are decoded.
+func Parse(b []byte) (Module, error) {
+	if len(b) < 8 || !bytes.Equal(b[:4], magic) {
+		return Module{}, NotWasmErr
+	}
+
+	switch version := b[4:8]; {
+	case bytes.Equal(version, componentVersion):
+		return Module{Component: true}, nil
+	case !bytes.Equal(version, moduleVersion):
+		return Module{}, fmt.Errorf("unsupported wasm version %x", version)
+	}
+
+	m := Module{}
+	r := &reader{b: b[8:]}
+	for !r.done() {
+		id, err := r.byte()
+		if err != nil {
+			return Module{}, fmt.Errorf("reading section id: %w", err)
+		}
+
+		size, err := r.u32()
+		if err != nil {
+			return Module{}, fmt.Errorf("reading section size: %w", err)
+		}
+
+		content, err := r.bytes(int(size))
+		if err != nil {
+			return Module{}, fmt.Errorf("reading section %d: %w", id, err)
+		}
+
+		switch id {
+		case importSection:
+			if m.Imports, err = parseImports(&reader{b: content}); err != nil {
+				return Module{}, fmt.Errorf("parsing import section: %w", err)
+			}
+		case exportSection:
+			if m.Exports, err = parseExports(&reader{b: content}); err != nil {
+				return Module{}, fmt.Errorf("parsing export section: %w", err)
+			}
+		}
+	}
+
+	return m, nil
+}
+
+func parseImports(r *reader) ([]Import, error) {
+	n, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	imports := make([]Import, 0, n)
+	for i := uint32(0); i < n; i++ {
+		module, err := r.name()
+		if err != nil {
+			return nil, fmt.Errorf("reading import %d module: %w", i, err)
+		}
+
+		name, err := r.name()
+		if err != nil {
+			return nil, fmt.Errorf("reading import %d name: %w", i, err)
+		}
+
+		kind, err := r.byte()
+		if err != nil {
+			return nil, fmt.Errorf("reading import %d kind: %w", i, err)
+		}
+
+		if err := r.skipImportDesc(Kind(kind)); err != nil {
+			return nil, fmt.Errorf("reading import %d description: %w", i, err)
+		}
+
+		imports = append(imports, Import{Module: module, Name: name, Kind: Kind(kind)})
+	}
+
+	return imports, nil
+}
+
+func parseExports(r *reader) ([]Export, error) {
+	n, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	exports := make([]Export, 0, n)
+	for i := uint32(0); i < n; i++ {
+		name, err := r.name()
+		if err != nil {
+			return nil, fmt.Errorf("reading export %d name: %w", i, err)
+		}
+
+		kind, err := r.byte()
+		if err != nil {
+			return nil, fmt.Errorf("reading export %d kind: %w", i, err)
+		}
+
+		if _, err := r.u32(); err != nil {
+			return nil, fmt.Errorf("reading export %d index: %w", i, err)
+		}
+
+		exports = append(exports, Export{Name: name, Kind: Kind(kind)})
+	}
+
+	return exports, nil
+}
+
+// reader reads the wasm binary format
+type reader struct {
+	b   []byte
+	pos int
+}
+
+func (r *reader) done() bool {
+	return r.pos >= len(r.b)
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.done() {
+		return 0, errors.New("unexpected end of wasm binary")
+	}
+
+	b := r.b[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *reader) bytes(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.b) {
+		return nil, errors.New("unexpected end of wasm binary")
+	}
+
+	b := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// u32 reads an unsigned LEB128 encoded 32 bit integer
+func (r *reader) u32() (uint32, error) {
+	var result uint32
+	for shift := 0; shift < 35; shift += 7 {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+
+		result |= uint32(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return result, nil
+		}
+	}
+
+	return 0, errors.New("integer too long")
+}
+
+func (r *reader) name() (string, error) {
+	n, err := r.u32()
+	if err != nil {
+		return "", err
+	}
+
+	b, err := r.bytes(int(n))
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// skipImportDesc skips the type of an import
+func (r *reader) skipImportDesc(k Kind) error {
+	switch k {
+	case Func:
+		_, err := r.u32()
+		return err
+	case Table:
+		if _, err := r.byte(); err != nil {
+			return err
+		}
+		return r.skipLimits()
+	case Memory:
+		return r.skipLimits()
+	case Global:
+		_, err := r.bytes(2)
+		return err
+	default:
+		return fmt.Errorf("unknown import kind %d", byte(k))
+	}
+}
+
+func (r *reader) skipLimits() error {
+	flags, err := r.byte()
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.u32(); err != nil {
+		return err
+	}
+
+	if flags&0x01 != 0 {
+		if _, err := r.u32(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package wasm
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+// testImport is an import written by buildModule
+type testImport struct {
+	module, name string
+	// desc is the encoded kind and type of the import
+	desc []byte
+}
+
+func name(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func section(id byte, content []byte) []byte {
+	return append([]byte{id, byte(len(content))}, content...)
+}
+
+// buildModule encodes a wasm module with an import and export section
+func buildModule(imports []testImport, exports []Export) []byte {
+	importContent := []byte{byte(len(imports))}
+	for _, i := range imports {
+		importContent = append(importContent, name(i.module)...)
+		importContent = append(importContent, name(i.name)...)
+		importContent = append(importContent, i.desc...)
+	}
+
+	exportContent := []byte{byte(len(exports))}
+	for i, e := range exports {
+		exportContent = append(exportContent, name(e.Name)...)
+		exportContent = append(exportContent, byte(e.Kind), byte(i))
+	}
+
+	b := append(append([]byte{}, magic...), moduleVersion...)
+	b = append(b, section(1, []byte{0x01, 0x60, 0x00, 0x00})...) // type section with one func type
+	b = append(b, section(importSection, importContent)...)
+	b = append(b, section(exportSection, exportContent)...)
+	return b
+}
+
+func TestParse(t *testing.T) {
+	g := NewWithT(t)
+
+	b := buildModule([]testImport{
+		{"wasi_snapshot_preview1", "fd_write", []byte{0x00, 0x00}},
+		{"env", "memory", []byte{0x02, 0x01, 0x01, 0x02}},
+		{"env", "table", []byte{0x01, 0x70, 0x00, 0x01}},
+		{"env", "global", []byte{0x03, 0x7f, 0x00}},
+		{"outbound-pg", "query", []byte{0x00, 0x00}},
+	}, []Export{
+		{Name: "memory", Kind: Memory},
+		{Name: "handle-http-request", Kind: Func},
+	})
+
+	m, err := Parse(b)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(m.Component).To(BeFalse())
+	g.Expect(m.Imports).To(Equal([]Import{
+		{Module: "wasi_snapshot_preview1", Name: "fd_write", Kind: Func},
+		{Module: "env", Name: "memory", Kind: Memory},
+		{Module: "env", Name: "table", Kind: Table},
+		{Module: "env", Name: "global", Kind: Global},
+		{Module: "outbound-pg", Name: "query", Kind: Func},
+	}))
+	g.Expect(m.ImportModules()).To(Equal([]string{"wasi_snapshot_preview1", "env", "outbound-pg"}))
+	g.Expect(m.ExportsFunc("handle-http-request")).To(BeTrue())
+	g.Expect(m.ExportsFunc("memory")).To(BeFalse())
+}
+
+func TestParseComponent(t *testing.T) {
+	g := NewWithT(t)
+
+	m, err := Parse(append(append([]byte{}, magic...), componentVersion...))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(m.Component).To(BeTrue())
+}
+
+func TestParseInvalid(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := Parse([]byte("#!/bin/sh\necho hello"))
+	g.Expect(err).To(MatchError(NotWasmErr))
+
+	_, err = Parse(append(append([]byte{}, magic...), 0x02, 0x00, 0x00, 0x00))
+	g.Expect(err).To(MatchError(ContainSubstring("unsupported wasm version")))
+
+	b := buildModule([]testImport{{"wasi_snapshot_preview1", "fd_write", []byte{0x00, 0x00}}}, nil)
+	_, err = Parse(b[:len(b)-6])
+	g.Expect(err).To(MatchError(ContainSubstring("unexpected end of wasm binary")))
+}
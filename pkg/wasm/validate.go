@@ -0,0 +1,130 @@
+package wasm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/azure/spin-aks-plugin/pkg/logger"
+	"github.com/azure/spin-aks-plugin/pkg/spin"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+)
+
+// interfaceNames describes the Spin host interfaces by the module name Spin 1.x SDKs import them with
+var interfaceNames = map[string]string{
+	"wasi_snapshot_preview1": "WASI preview 1",
+	"wasi-outbound-http":     "outbound HTTP",
+	"outbound-redis":         "outbound Redis",
+	"outbound-pg":            "outbound PostgreSQL",
+	"outbound-mysql":         "outbound MySQL",
+	"spin-config":            "variables",
+	"key-value":              "key value store",
+	"sqlite":                 "SQLite",
+	"llm":                    "Serverless AI",
+}
+
+// hostInterfaces are the modules each containerd-shim-spin version provides to wasm modules
+var hostInterfaces = map[string][]string{
+	"0.5.1": {"wasi_snapshot_preview1", "wasi-outbound-http", "outbound-redis", "spin-config", "key-value"},
+}
+
+// triggerExports are the functions each trigger type calls. A module needs to export one of them.
+var triggerExports = map[string][]string{
+	"http":  {"handle-http-request", "_start"}, // _start is called by the wagi executor
+	"redis": {"handle-redis-message"},
+}
+
+// Validate checks that the wasm file of every component of the manifest only imports host interfaces the
+// containerd-shim-spin version provides and exports what the manifest's trigger calls. Components sourced from a url
+// are skipped. Every problem is returned in a single user error.
+func Validate(ctx context.Context, manifestPath string, m spin.Manifest, shimVersion string) error {
+	lgr := logger.FromContext(ctx)
+	lgr.Debug("validating wasm modules")
+
+	provided, ok := hostInterfaces[shimVersion]
+	if !ok {
+		return fmt.Errorf("unknown host interfaces of containerd-shim-spin %s", shimVersion)
+	}
+
+	var problems []string
+	for _, c := range m.Components {
+		source := string(c.Source.StringSource)
+		if source == "" {
+			lgr.Debug("skipping component without a local wasm file", "component", c.Id)
+			continue
+		}
+
+		path := filepath.Join(filepath.Dir(manifestPath), source)
+		mod, err := ParseFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			problems = append(problems, fmt.Sprintf("component %s: %s doesn't exist, run `spin aks build`", c.Id, source))
+			continue
+		}
+		if errors.Is(err, NotWasmErr) {
+			problems = append(problems, fmt.Sprintf("component %s: %s isn't a wasm file", c.Id, source))
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("parsing wasm of component %s: %w", c.Id, err)
+		}
+
+		for _, p := range check(mod, provided, m.Trigger.T, shimVersion) {
+			problems = append(problems, fmt.Sprintf("component %s: %s", c.Id, p))
+		}
+	}
+
+	if len(problems) > 0 {
+		err := fmt.Errorf("wasm modules aren't supported by containerd-shim-spin %s: %s", shimVersion, strings.Join(problems, "; "))
+		msg := fmt.Sprintf("These components won't run on containerd-shim-spin %s, the version the AKS Wasm node pool runs:\n  %s\nStop using the unsupported interfaces and rebuild.", shimVersion, strings.Join(problems, "\n  "))
+		return usererror.New(err, msg)
+	}
+
+	lgr.Debug("finished validating wasm modules")
+	return nil
+}
+
+// check returns the problems running the module on a shim that provides the host interfaces
+func check(mod Module, provided []string, trigger, shimVersion string) []string {
+	if mod.Component {
+		return []string{fmt.Sprintf("is a wasm component but containerd-shim-spin %s only runs wasm modules", shimVersion)}
+	}
+
+	var problems []string
+	for _, module := range mod.ImportModules() {
+		if contains(provided, module) {
+			continue
+		}
+
+		name := module
+		if description, ok := interfaceNames[module]; ok {
+			name = fmt.Sprintf("%s (%s)", module, description)
+		}
+		problems = append(problems, fmt.Sprintf("imports %s which containerd-shim-spin %s doesn't provide", name, shimVersion))
+	}
+
+	if exports, ok := triggerExports[trigger]; ok {
+		exported := false
+		for _, e := range exports {
+			exported = exported || mod.ExportsFunc(e)
+		}
+
+		if !exported {
+			problems = append(problems, fmt.Sprintf("doesn't export %s which the %s trigger calls", strings.Join(exports, " or "), trigger))
+		}
+	}
+
+	return problems
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,102 @@
+package wasm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azure/spin-aks-plugin/pkg/spin"
+	"github.com/azure/spin-aks-plugin/pkg/usererror"
+	. "github.com/onsi/gomega"
+)
+
+// writeApp writes the wasm files and returns the path of a spin manifest with an http trigger and a component for each
+func writeApp(t *testing.T, wasm map[string][]byte) (string, spin.Manifest) {
+	t.Helper()
+
+	dir := t.TempDir()
+	m := spin.Manifest{Name: "app"}
+	m.Trigger.T = "http"
+	for _, id := range []string{"api", "worker", "web"} {
+		b, ok := wasm[id]
+		if !ok {
+			continue
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, id+".wasm"), b, 0644); err != nil {
+			t.Fatalf("writing wasm: %s", err)
+		}
+		m.Components = append(m.Components, spin.Component{
+			Id:     id,
+			Source: spin.ComponentSource{StringSource: spin.ComponentSourceString(id + ".wasm")},
+		})
+	}
+
+	return filepath.Join(dir, "spin.toml"), m
+}
+
+var handler = []Export{{Name: "handle-http-request", Kind: Func}}
+
+func TestValidate(t *testing.T) {
+	g := NewWithT(t)
+
+	manifest, m := writeApp(t, map[string][]byte{
+		"api": buildModule([]testImport{
+			{"wasi_snapshot_preview1", "fd_write", []byte{0x00, 0x00}},
+			{"key-value", "get", []byte{0x00, 0x00}},
+		}, handler),
+		"web": buildModule(nil, []Export{{Name: "_start", Kind: Func}}),
+	})
+	m.Components = append(m.Components, spin.Component{
+		Id:     "remote",
+		Source: spin.ComponentSource{URLSource: spin.ComponentSourceURL{Url: "https://example.com/remote.wasm"}},
+	})
+
+	g.Expect(Validate(context.Background(), manifest, m, "0.5.1")).To(Succeed())
+}
+
+func TestValidateUnsupported(t *testing.T) {
+	g := NewWithT(t)
+
+	manifest, m := writeApp(t, map[string][]byte{
+		"api": buildModule([]testImport{
+			{"wasi_snapshot_preview1", "fd_write", []byte{0x00, 0x00}},
+			{"outbound-pg", "query", []byte{0x00, 0x00}},
+			{"wasi:io/streams", "read", []byte{0x00, 0x00}},
+		}, handler),
+		"worker": buildModule(nil, []Export{{Name: "handle-redis-message", Kind: Func}}),
+		"web":    append(append([]byte{}, magic...), componentVersion...),
+	})
+
+	err := Validate(context.Background(), manifest, m, "0.5.1")
+	u, ok := usererror.Is(err)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(u.Msg()).To(ContainSubstring("component api: imports outbound-pg (outbound PostgreSQL) which containerd-shim-spin 0.5.1 doesn't provide"))
+	g.Expect(u.Msg()).To(ContainSubstring("component api: imports wasi:io/streams which containerd-shim-spin 0.5.1 doesn't provide"))
+	g.Expect(u.Msg()).To(ContainSubstring("component worker: doesn't export handle-http-request or _start which the http trigger calls"))
+	g.Expect(u.Msg()).To(ContainSubstring("component web: is a wasm component but containerd-shim-spin 0.5.1 only runs wasm modules"))
+}
+
+func TestValidateNotWasm(t *testing.T) {
+	g := NewWithT(t)
+
+	manifest, m := writeApp(t, map[string][]byte{"api": []byte("not wasm")})
+
+	err := Validate(context.Background(), manifest, m, "0.5.1")
+	u, ok := usererror.Is(err)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(u.Msg()).To(ContainSubstring("component api: api.wasm isn't a wasm file"))
+}
+
+func TestValidateMissingWasm(t *testing.T) {
+	g := NewWithT(t)
+
+	manifest, m := writeApp(t, nil)
+	m.Components = []spin.Component{{Id: "api", Source: spin.ComponentSource{StringSource: "missing.wasm"}}}
+
+	err := Validate(context.Background(), manifest, m, "0.5.1")
+	u, ok := usererror.Is(err)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(u.Msg()).To(ContainSubstring("component api: missing.wasm doesn't exist, run `spin aks build`"))
+}